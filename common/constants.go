@@ -201,9 +201,11 @@ const (
 )
 
 const (
-	TopUpStatusPending = "pending"
-	TopUpStatusSuccess = "success"
-	TopUpStatusExpired = "expired"
+	TopUpStatusPending  = "pending"
+	TopUpStatusSuccess  = "success"
+	TopUpStatusExpired  = "expired"
+	TopUpStatusFailed   = "failed"
+	TopUpStatusRefunded = "refunded"
 )
 
 // 用户组自动分配配置：登录方式 -> 用户组名称
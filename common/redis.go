@@ -325,3 +325,36 @@ func RedisHSetField(key, field string, value interface{}) error {
 	}
 	return nil
 }
+
+// RedisIncrByWithExpire 原子增减计数并刷新过期时间，返回增减后的值
+func RedisIncrByWithExpire(key string, delta int64, expiration time.Duration) (int64, error) {
+	if DebugEnabled {
+		SysLog(fmt.Sprintf("Redis INCRBY: key=%s, delta=%d, expiration=%v", key, delta, expiration))
+	}
+	ctx := context.Background()
+	txn := RDB.TxPipeline()
+	incrCmd := txn.IncrBy(ctx, key, delta)
+	txn.Expire(ctx, key, expiration)
+	if _, err := txn.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incrCmd.Val(), nil
+}
+
+// RedisMGetInt 批量读取整数值，不存在的键返回 0
+func RedisMGetInt(keys ...string) ([]int64, error) {
+	values := make([]int64, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	result, err := RDB.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range result {
+		if s, ok := value.(string); ok {
+			values[i], _ = strconv.ParseInt(s, 10, 64)
+		}
+	}
+	return values, nil
+}
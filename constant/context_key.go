@@ -47,4 +47,18 @@ const (
 	ContextKeyUserName    ContextKey = "username"
 
 	ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
+
+	// ContextKeyResponsesResult Responses API 的完整响应 JSON，用于会话存储
+	ContextKeyResponsesResult ContextKey = "responses_result"
+
+	// ContextKeyRequestFeatures 请求需要的模型能力，重试选择渠道时用于过滤
+	ContextKeyRequestFeatures ContextKey = "request_features"
+
+	// ContextKeySessionAffinityKey 会话粘性的存储键，请求成功后记录所用的渠道与密钥
+	ContextKeySessionAffinityKey ContextKey = "session_affinity_key"
+	// ContextKeySessionAffinityHit 本次请求是否按会话粘性固定到了之前的渠道
+	ContextKeySessionAffinityHit ContextKey = "session_affinity_hit"
+
+	// ContextKeyReservedChannelSlot 排队轮到请求时为选中渠道预留的并发名额，转发时使用，未使用时在请求结束后释放
+	ContextKeyReservedChannelSlot ContextKey = "reserved_channel_slot"
 )
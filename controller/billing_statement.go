@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// renderBillingStatement 按 format 参数输出账单：json（默认）、csv、html
+func renderBillingStatement(c *gin.Context, statement *model.BillingStatement) {
+	switch c.DefaultQuery("format", "json") {
+	case "csv":
+		data, err := service.RenderStatementCSV(statement)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", statement.InvoiceNumber()))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+	case "html":
+		data, err := service.RenderStatementInvoiceHTML(statement)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", data)
+	default:
+		common.ApiSuccess(c, gin.H{
+			"statement":      statement,
+			"detail":         statement.GetDetail(),
+			"invoice_number": statement.InvoiceNumber(),
+			"tax":            service.CalcStatementTax(statement),
+		})
+	}
+}
+
+// getOrGenerateBillingStatement 读取已生成的账单，不存在或账期未结束时即时生成
+func getOrGenerateBillingStatement(userId int, period string) (*model.BillingStatement, error) {
+	if !model.IsBillingPeriodClosed(period) {
+		return model.GenerateBillingStatement(userId, period)
+	}
+	statement, err := model.GetBillingStatement(userId, period)
+	if err == nil {
+		return statement, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return model.GenerateBillingStatement(userId, period)
+}
+
+// GetSelfBillingStatements 获取当前用户的账单列表
+func GetSelfBillingStatements(c *gin.Context) {
+	userId := c.GetInt("id")
+	pageInfo := common.GetPageQuery(c)
+	statements, total, err := model.GetUserBillingStatements(userId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(statements)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetSelfBillingStatement 获取当前用户指定月份的账单
+func GetSelfBillingStatement(c *gin.Context) {
+	statement, err := getOrGenerateBillingStatement(c.GetInt("id"), c.Param("period"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	renderBillingStatement(c, statement)
+}
+
+// GetAllBillingStatements 管理员按月份/用户查询账单
+func GetAllBillingStatements(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	statements, total, err := model.GetAllBillingStatements(c.Query("period"), userId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(statements)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetUserBillingStatement 管理员获取指定用户某月账单
+func GetUserBillingStatement(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	statement, err := getOrGenerateBillingStatement(userId, c.Param("period"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	renderBillingStatement(c, statement)
+}
+
+type GenerateBillingStatementRequest struct {
+	Period string `json:"period"`
+	UserId int    `json:"user_id"`
+}
+
+// GenerateBillingStatements 管理员重新生成账单，未指定 user_id 时生成该月所有有记录的用户
+func GenerateBillingStatements(c *gin.Context) {
+	var req GenerateBillingStatementRequest
+	if err := common.DecodeJson(c.Request.Body, &req); err != nil || req.Period == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.UserId != 0 {
+		statement, err := model.GenerateBillingStatement(req.UserId, req.Period)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		common.ApiSuccess(c, statement)
+		return
+	}
+	count, err := model.GenerateBillingStatements(req.Period)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"count": count,
+	})
+}
@@ -364,6 +364,21 @@ func SearchChannels(c *gin.Context) {
 	return
 }
 
+// GetChannelsInFlight 各渠道进行中的请求数与并发上限
+func GetChannelsInFlight(c *gin.Context) {
+	stats, err := model.GetChannelsInFlightStats()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, stats)
+}
+
+// GetRequestQueues 渠道并发已满时各分组模型的排队深度与等待时间
+func GetRequestQueues(c *gin.Context) {
+	common.ApiSuccess(c, model.GetRequestQueueStats())
+}
+
 func GetChannel(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/relay"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CountTokens 计算请求的输入 token 数，不预扣费也不记录消费
+func CountTokens(c *gin.Context, relayFormat types.RelayFormat) {
+	var newAPIError *types.NewAPIError
+	defer func() {
+		if newAPIError == nil {
+			return
+		}
+		newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), c.GetString(common.RequestIdKey)))
+		if relayFormat == types.RelayFormatClaude {
+			c.JSON(newAPIError.StatusCode, gin.H{
+				"type":  "error",
+				"error": newAPIError.ToClaudeError(),
+			})
+			return
+		}
+		c.JSON(newAPIError.StatusCode, gin.H{
+			"error": newAPIError.ToOpenAIError(),
+		})
+	}()
+
+	request, err := helper.GetAndValidateCountTokensRequest(c, relayFormat)
+	if err != nil {
+		newAPIError = types.NewError(err, types.ErrorCodeInvalidRequest)
+		return
+	}
+	var info *relaycommon.RelayInfo
+	if relayFormat == types.RelayFormatClaude {
+		info = relaycommon.GenRelayInfoClaude(c, request)
+	} else {
+		info = relaycommon.GenRelayInfoGemini(c, request)
+	}
+	newAPIError = relay.CountTokensHelper(c, info)
+}
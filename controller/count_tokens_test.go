@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countTokens 以指定模型调用 CountTokens，返回状态码与解析后的响应
+func countTokens(t *testing.T, format types.RelayFormat, path string, modelName string, body string) (int, map[string]any) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, modelName)
+	CountTokens(c, format)
+
+	var response map[string]any
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code, response
+}
+
+func claudeCountTokens(t *testing.T, body string) int {
+	t.Helper()
+	code, response := countTokens(t, types.RelayFormatClaude, "/v1/messages/count_tokens", "claude-sonnet-4-5", body)
+	tokens, ok := response["input_tokens"].(float64)
+	if code != http.StatusOK || !ok || len(response) != 1 {
+		t.Fatalf("status = %d, response = %v, want only input_tokens", code, response)
+	}
+	return int(tokens)
+}
+
+func TestCountTokensClaude(t *testing.T) {
+	const text = `{"model":"claude-sonnet-4-5","system":"You are terse.","messages":[{"role":"user","content":"What is the weather in Paris?"}]`
+	textTokens := claudeCountTokens(t, text+`}`)
+	var request dto.ClaudeRequest
+	if err := common.Unmarshal([]byte(text+`}`), &request); err != nil {
+		t.Fatal(err)
+	}
+	want, err := service.CountTokenClaudeRequest(request, "claude-sonnet-4-5")
+	if err != nil || textTokens != want || textTokens == 0 {
+		t.Fatalf("text tokens = %d, want %d (err %v)", textTokens, want, err)
+	}
+
+	tools := `[{"name":"get_weather","description":"Get the weather","input_schema":{"type":"object","properties":{"city":{"type":"string"}}}}]`
+	var parsedTools []dto.Tool
+	if err := common.Unmarshal([]byte(tools), &parsedTools); err != nil {
+		t.Fatal(err)
+	}
+	toolTokens, err := service.CountTokenClaudeTools(parsedTools, "claude-sonnet-4-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := claudeCountTokens(t, text+`,"tools":`+tools+`}`); got != textTokens+toolTokens {
+		t.Fatalf("with tools = %d, want %d + %d", got, textTokens, toolTokens)
+	}
+
+	// 图片按固定 1000 token 估算
+	withImage := `{"model":"claude-sonnet-4-5","system":"You are terse.","messages":[{"role":"user","content":[` +
+		`{"type":"text","text":"What is the weather in Paris?"},` +
+		`{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}}]}]}`
+	if got := claudeCountTokens(t, withImage); got != textTokens+1000 {
+		t.Fatalf("with image = %d, want %d + 1000", got, textTokens)
+	}
+}
+
+func TestCountTokensClaudeError(t *testing.T) {
+	code, response := countTokens(t, types.RelayFormatClaude, "/v1/messages/count_tokens", "claude-sonnet-4-5",
+		`{"model":"claude-sonnet-4-5","messages":[]}`)
+	claudeError, ok := response["error"].(map[string]any)
+	if code == http.StatusOK || response["type"] != "error" || !ok ||
+		!strings.Contains(claudeError["message"].(string), "messages is required") {
+		t.Fatalf("status = %d, response = %v, want a Claude error", code, response)
+	}
+}
+
+func geminiCountTokens(t *testing.T, body string) int {
+	t.Helper()
+	code, response := countTokens(t, types.RelayFormatGemini, "/v1beta/models/gemini-2.5-flash:countTokens", "gemini-2.5-flash", body)
+	tokens, ok := response["totalTokens"].(float64)
+	if code != http.StatusOK || !ok || len(response) != 1 {
+		t.Fatalf("status = %d, response = %v, want only totalTokens", code, response)
+	}
+	return int(tokens)
+}
+
+func TestCountTokensGemini(t *testing.T) {
+	const contents = `[{"role":"user","parts":[{"text":"What is the weather in Paris?"}]}]`
+	textTokens := geminiCountTokens(t, `{"contents":`+contents+`}`)
+	want := service.CountTokenGeminiRequest(dto.GeminiChatRequest{Contents: []dto.GeminiChatContent{
+		{Role: "user", Parts: []dto.GeminiPart{{Text: "What is the weather in Paris?"}}}}}, "gemini-2.5-flash")
+	if textTokens != want || textTokens == 0 {
+		t.Fatalf("text tokens = %d, want %d", textTokens, want)
+	}
+
+	// generateContentRequest 中的工具声明同样计入
+	tools := `[{"functionDeclarations":[{"name":"get_weather","description":"Get the weather"}]}]`
+	withTools := geminiCountTokens(t, `{"generateContentRequest":{"contents":`+contents+`,"tools":`+tools+`}}`)
+	if withTools <= textTokens {
+		t.Fatalf("with tools = %d, want more than %d", withTools, textTokens)
+	}
+
+	// 图片按固定 256 token 估算
+	withImage := geminiCountTokens(t, `{"contents":[{"role":"user","parts":[{"text":"What is the weather in Paris?"},`+
+		`{"inlineData":{"mimeType":"image/png","data":"iVBORw0KGgo="}}]}]}`)
+	if withImage != textTokens+256 {
+		t.Fatalf("with image = %d, want %d + 256", withImage, textTokens)
+	}
+
+	code, response := countTokens(t, types.RelayFormatGemini, "/v1beta/models/gemini-2.5-flash:countTokens", "gemini-2.5-flash", `{}`)
+	if _, ok := response["error"].(map[string]any); code == http.StatusOK || !ok {
+		t.Fatalf("status = %d, response = %v, want an error for empty contents", code, response)
+	}
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// LdapLogin 通过 LDAP / Active Directory 绑定认证登录，首次登录时自动创建用户
+func LdapLogin(c *gin.Context) {
+	settings := system_setting.GetLDAPSettings()
+	if !settings.Enabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员未开启通过 LDAP 登录",
+		})
+		return
+	}
+	var loginRequest LoginRequest
+	err := common.DecodeJson(c.Request.Body, &loginRequest)
+	if err != nil || loginRequest.Username == "" || loginRequest.Password == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	ldapUser, err := service.AuthenticateLDAP(loginRequest.Username, loginRequest.Password)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	mappedGroup := ldapUser.MappedGroup(settings.GroupMapping)
+
+	user := model.User{
+		LdapId: ldapUser.Username,
+	}
+	if model.IsLdapIdAlreadyTaken(user.LdapId) {
+		err := user.FillUserByLdapId()
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		// 每次登录按 LDAP 组同步用户分组
+		if mappedGroup != "" && mappedGroup != user.Group {
+			if err := model.UpdateUserGroup(user.Id, mappedGroup); err != nil {
+				common.ApiError(c, err)
+				return
+			}
+			user.Group = mappedGroup
+		}
+	} else {
+		if !settings.AutoRegister {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "该 LDAP 账户未开通，请联系管理员",
+			})
+			return
+		}
+		if err := provisionLdapUser(&user, ldapUser, mappedGroup); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+
+	if user.Status != common.UserStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "用户已被封禁",
+			"success": false,
+		})
+		return
+	}
+
+	if model.IsTwoFAEnabled(user.Id) {
+		session := sessions.Default(c)
+		session.Set("pending_username", user.Username)
+		session.Set("pending_user_id", user.Id)
+		if err := session.Save(); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"message": "无法保存会话信息，请重试",
+				"success": false,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "请输入两步验证码",
+			"success": true,
+			"data": map[string]interface{}{
+				"require_2fa": true,
+			},
+		})
+		return
+	}
+
+	setupLogin(&user, c)
+}
+
+func provisionLdapUser(user *model.User, ldapUser *service.LDAPUser, mappedGroup string) error {
+	user.Username = ldapUser.Username
+	// 用户名过长或已被本地账户占用时不做自动关联，改用生成的用户名
+	exist, err := model.CheckUserExistOrDeleted(user.Username, "")
+	if err != nil {
+		return err
+	}
+	if exist || len(user.Username) > 20 {
+		user.Username = "ldap_" + strconv.Itoa(model.GetMaxUserId()+1)
+	}
+	if ldapUser.Email != "" && !model.IsEmailAlreadyTaken(ldapUser.Email) {
+		user.Email = ldapUser.Email
+	}
+	user.DisplayName = ldapUser.DisplayName
+	if user.DisplayName == "" {
+		user.DisplayName = "LDAP User"
+	}
+	user.Group = mappedGroup
+	if user.Group == "" {
+		user.Group = system_setting.GetLDAPSettings().DefaultGroup
+	}
+	// 密码由 LDAP 校验，本地使用随机密码禁止直接密码登录
+	user.Password = common.GetRandomString(16)
+	if err := user.Insert(0); err != nil {
+		return err
+	}
+	if user.Id == 0 {
+		return errors.New("创建 LDAP 用户失败")
+	}
+	return nil
+}
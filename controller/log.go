@@ -157,3 +157,19 @@ func DeleteHistoryLogs(c *gin.Context) {
 	})
 	return
 }
+
+// GetLogsMargin 按渠道、模型、分组或天统计收入、上游成本与毛利
+func GetLogsMargin(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", "channel")
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	modelName := c.Query("model_name")
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	group := c.Query("group")
+	stats, err := model.GetMarginStats(groupBy, startTimestamp, endTimestamp, modelName, channel, group)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, stats)
+}
@@ -182,7 +182,7 @@ func UpdateMidjourneyTaskBulk() {
 							logger.LogError(ctx, "fail to increase user quota: "+err.Error())
 						}
 						logContent := fmt.Sprintf("构图失败 %s，补偿 %s", task.MjId, logger.LogQuota(task.Quota))
-						model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
+						model.RecordQuotaLog(task.UserId, model.LogTypeRefund, logContent, task.Quota, nil)
 					}
 				}
 			}
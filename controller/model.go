@@ -175,6 +175,9 @@ func ListModels(c *gin.Context, modelType int) {
 			}
 		}
 	}
+	for i := range userOpenAiModels {
+		fillModelCapabilities(&userOpenAiModels[i])
+	}
 	switch modelType {
 	case constant.ChannelTypeAnthropic:
 		useranthropicModels := make([]dto.AnthropicModel, len(userOpenAiModels))
@@ -213,6 +216,22 @@ func ListModels(c *gin.Context, modelType int) {
 	}
 }
 
+// fillModelCapabilities 补充模型目录中的上下文长度、模态与能力信息
+func fillModelCapabilities(oaiModel *dto.OpenAIModels) {
+	meta, ok := model.GetModelMeta(oaiModel.Id)
+	if !ok {
+		return
+	}
+	oaiModel.ContextLength = meta.ContextLength
+	oaiModel.MaxOutputTokens = meta.MaxOutputTokens
+	oaiModel.InputModalities = meta.GetInputModalities()
+	oaiModel.OutputModalities = meta.GetOutputModalities()
+	oaiModel.SupportsTools = meta.SupportsTools
+	oaiModel.SupportsVision = meta.SupportsVision
+	oaiModel.SupportsReasoning = meta.SupportsReasoning
+	oaiModel.KnowledgeCutoff = meta.KnowledgeCutoff
+}
+
 func ChannelListModels(c *gin.Context) {
 	c.JSON(200, gin.H{
 		"success": true,
@@ -246,6 +265,7 @@ func RetrieveModel(c *gin.Context, modelType int) {
 				Type:        "model",
 			})
 		default:
+			fillModelCapabilities(&aiModel)
 			c.JSON(200, aiModel)
 		}
 	} else {
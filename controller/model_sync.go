@@ -64,6 +64,56 @@ type upstreamModel struct {
 	Status      int             `json:"status"`
 	Tags        string          `json:"tags"`
 	VendorName  string          `json:"vendor_name"`
+
+	// 模型能力元数据
+	ContextLength     int                `json:"context_length"`
+	MaxOutputTokens   int                `json:"max_output_tokens"`
+	InputModalities   upstreamModalities `json:"input_modalities"`
+	OutputModalities  upstreamModalities `json:"output_modalities"`
+	SupportsTools     *bool              `json:"supports_tools"`
+	SupportsVision    *bool              `json:"supports_vision"`
+	SupportsReasoning *bool              `json:"supports_reasoning"`
+	KnowledgeCutoff   string             `json:"knowledge_cutoff"`
+}
+
+// upstreamModalities 兼容逗号分隔的字符串与字符串数组两种格式
+type upstreamModalities string
+
+func (m *upstreamModalities) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*m = upstreamModalities(strings.Join(list, ","))
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*m = upstreamModalities(value)
+	return nil
+}
+
+// applyCapabilities 将上游的模型能力写入本地模型
+func (up upstreamModel) applyCapabilities(mi *model.Model, fields []string) {
+	all := fields == nil
+	if all || containsField(fields, "context_length") {
+		mi.ContextLength = up.ContextLength
+	}
+	if all || containsField(fields, "max_output_tokens") {
+		mi.MaxOutputTokens = up.MaxOutputTokens
+	}
+	if all || containsField(fields, "modalities") {
+		mi.InputModalities = string(up.InputModalities)
+		mi.OutputModalities = string(up.OutputModalities)
+	}
+	if all || containsField(fields, "capabilities") {
+		mi.SupportsTools = up.SupportsTools
+		mi.SupportsVision = up.SupportsVision
+		mi.SupportsReasoning = up.SupportsReasoning
+	}
+	if all || containsField(fields, "knowledge_cutoff") {
+		mi.KnowledgeCutoff = up.KnowledgeCutoff
+	}
 }
 
 type upstreamVendor struct {
@@ -351,6 +401,7 @@ func SyncUpstreamModels(c *gin.Context) {
 			Status:      chooseStatus(up.Status, 1),
 			NameRule:    up.NameRule,
 		}
+		up.applyCapabilities(mi, nil)
 		if err := mi.Insert(); err == nil {
 			createdModels++
 			createdList = append(createdList, name)
@@ -407,6 +458,13 @@ func SyncUpstreamModels(c *gin.Context) {
 					local.Status = chooseStatus(up.Status, local.Status)
 					needUpdate = true
 				}
+				for _, field := range []string{"context_length", "max_output_tokens", "modalities", "capabilities", "knowledge_cutoff"} {
+					if containsField(ow.Fields, field) {
+						up.applyCapabilities(&local, ow.Fields)
+						needUpdate = true
+						break
+					}
+				}
 				if !needUpdate {
 					return nil
 				}
@@ -448,6 +506,13 @@ func containsField(fields []string, key string) bool {
 	return false
 }
 
+func equalBoolPtr(a, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
 func coalesce(a, b string) string {
 	if strings.TrimSpace(a) != "" {
 		return a
@@ -584,6 +649,26 @@ func SyncUpstreamPreview(c *gin.Context) {
 		if local.Status != chooseStatus(up.Status, local.Status) {
 			fields = append(fields, conflictField{Field: "status", Local: local.Status, Upstream: up.Status})
 		}
+		if local.ContextLength != up.ContextLength {
+			fields = append(fields, conflictField{Field: "context_length", Local: local.ContextLength, Upstream: up.ContextLength})
+		}
+		if local.MaxOutputTokens != up.MaxOutputTokens {
+			fields = append(fields, conflictField{Field: "max_output_tokens", Local: local.MaxOutputTokens, Upstream: up.MaxOutputTokens})
+		}
+		if local.InputModalities != string(up.InputModalities) || local.OutputModalities != string(up.OutputModalities) {
+			fields = append(fields, conflictField{Field: "modalities",
+				Local:    gin.H{"input": local.InputModalities, "output": local.OutputModalities},
+				Upstream: gin.H{"input": up.InputModalities, "output": up.OutputModalities}})
+		}
+		if !equalBoolPtr(local.SupportsTools, up.SupportsTools) || !equalBoolPtr(local.SupportsVision, up.SupportsVision) ||
+			!equalBoolPtr(local.SupportsReasoning, up.SupportsReasoning) {
+			fields = append(fields, conflictField{Field: "capabilities",
+				Local:    gin.H{"tools": local.SupportsTools, "vision": local.SupportsVision, "reasoning": local.SupportsReasoning},
+				Upstream: gin.H{"tools": up.SupportsTools, "vision": up.SupportsVision, "reasoning": up.SupportsReasoning}})
+		}
+		if local.KnowledgeCutoff != up.KnowledgeCutoff {
+			fields = append(fields, conflictField{Field: "knowledge_cutoff", Local: local.KnowledgeCutoff, Upstream: up.KnowledgeCutoff})
+		}
 		if len(fields) > 0 {
 			conflicts = append(conflicts, conflictItem{ModelName: local.ModelName, Fields: fields})
 		}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+)
+
+func TestUpstreamModalities(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"array", `{"input_modalities":["text","image"]}`, "text,image"},
+		{"string", `{"input_modalities":"text,image"}`, "text,image"},
+		{"empty array", `{"input_modalities":[]}`, ""},
+		{"missing", `{}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var up upstreamModel
+			if err := json.Unmarshal([]byte(tt.data), &up); err != nil {
+				t.Fatal(err)
+			}
+			if string(up.InputModalities) != tt.want {
+				t.Fatalf("input modalities = %q, want %q", up.InputModalities, tt.want)
+			}
+		})
+	}
+
+	var up upstreamModel
+	if err := json.Unmarshal([]byte(`{"input_modalities":42}`), &up); err == nil {
+		t.Fatal("a number is not a valid modalities value")
+	}
+}
+
+func newUpstreamCapabilityModel(t *testing.T) upstreamModel {
+	t.Helper()
+	var up upstreamModel
+	data := `{"model_name":"gpt-test","context_length":128000,"max_output_tokens":16384,
+		"input_modalities":["text","image"],"output_modalities":"text",
+		"supports_tools":true,"supports_vision":true,"supports_reasoning":false,"knowledge_cutoff":"2024-10"}`
+	if err := json.Unmarshal([]byte(data), &up); err != nil {
+		t.Fatal(err)
+	}
+	return up
+}
+
+func newLocalCapabilityModel() *model.Model {
+	return &model.Model{ModelName: "gpt-test", ContextLength: 8000, MaxOutputTokens: 1000,
+		InputModalities: "text", OutputModalities: "text", SupportsVision: common.GetPointer(false), KnowledgeCutoff: "2023-01"}
+}
+
+func TestApplyCapabilitiesAllFields(t *testing.T) {
+	up := newUpstreamCapabilityModel(t)
+	local := newLocalCapabilityModel()
+	up.applyCapabilities(local, nil)
+
+	if local.ContextLength != 128000 || local.MaxOutputTokens != 16384 || local.InputModalities != "text,image" ||
+		local.OutputModalities != "text" || local.KnowledgeCutoff != "2024-10" {
+		t.Fatalf("new models should take every capability: %+v", local)
+	}
+	if local.SupportsTools == nil || !*local.SupportsTools || !*local.SupportsVision || *local.SupportsReasoning {
+		t.Fatalf("unexpected capability flags: %+v", local)
+	}
+}
+
+func TestApplyCapabilitiesOverwriteFields(t *testing.T) {
+	up := newUpstreamCapabilityModel(t)
+	tests := []struct {
+		field string
+		check func(mi *model.Model) bool
+	}{
+		{"context_length", func(mi *model.Model) bool { return mi.ContextLength == 128000 }},
+		{"max_output_tokens", func(mi *model.Model) bool { return mi.MaxOutputTokens == 16384 }},
+		{"modalities", func(mi *model.Model) bool { return mi.InputModalities == "text,image" && mi.OutputModalities == "text" }},
+		{"capabilities", func(mi *model.Model) bool {
+			return mi.SupportsTools != nil && *mi.SupportsTools && *mi.SupportsVision && !*mi.SupportsReasoning
+		}},
+		{"knowledge_cutoff", func(mi *model.Model) bool { return mi.KnowledgeCutoff == "2024-10" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			local := newLocalCapabilityModel()
+			up.applyCapabilities(local, []string{"description", tt.field})
+			if !tt.check(local) {
+				t.Fatalf("field %s was not overwritten: %+v", tt.field, local)
+			}
+			// 未选择的字段保持本地值
+			for _, other := range tests {
+				if other.field != tt.field && other.check(local) {
+					t.Fatalf("field %s should be kept when overwriting %s: %+v", other.field, tt.field, local)
+				}
+			}
+		})
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/setting/console_setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
 
@@ -201,8 +203,49 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "time_pricing_setting.windows":
+		err = operation_setting.ValidatePricingWindows(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "subscription_setting.period_days":
+		days, err := strconv.ParseFloat(option.Value.(string), 64)
+		if err != nil || days < 1 || days != float64(int(days)) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "手动套餐计费周期天数必须为正整数",
+			})
+			return
+		}
+	case "channel_routing_setting.selection_mode":
+		mode := option.Value.(string)
+		if mode != operation_setting.ChannelSelectionWeightedRandom && mode != operation_setting.ChannelSelectionLeastConnections {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的渠道选择方式",
+			})
+			return
+		}
+	case "channel_routing_setting.group_priorities":
+		var priorities map[string]int
+		if err := json.Unmarshal([]byte(option.Value.(string)), &priorities); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "分组优先级格式错误：" + err.Error(),
+			})
+			return
+		}
+	}
+	if ratio_setting.IsPriceTableKey(option.Key) {
+		// 价格表的修改记录为新的价格版本
+		err = model.UpdatePriceTableOption(option.Key, option.Value.(string))
+	} else {
+		err = model.UpdateOption(option.Key, option.Value.(string))
 	}
-	err = model.UpdateOption(option.Key, option.Value.(string))
 	if err != nil {
 		common.ApiError(c, err)
 		return
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetPriceOverrides 价格覆盖列表，可按 user_id 筛选
+func GetPriceOverrides(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	overrides, total, err := model.GetPriceOverrides(userId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(overrides)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func AddPriceOverride(c *gin.Context) {
+	override := model.PriceOverride{}
+	if err := c.ShouldBindJSON(&override); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := override.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	override.Id = 0
+	if err := override.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	recordPriceOverrideLog(c, &override, "添加")
+	common.ApiSuccess(c, override)
+}
+
+func UpdatePriceOverride(c *gin.Context) {
+	override := model.PriceOverride{}
+	if err := c.ShouldBindJSON(&override); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, err := model.GetPriceOverrideById(override.Id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := override.Validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := override.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	recordPriceOverrideLog(c, &override, "修改")
+	common.ApiSuccess(c, override)
+}
+
+func DeletePriceOverride(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	override, err := model.GetPriceOverrideById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := override.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	recordPriceOverrideLog(c, override, "删除")
+	common.ApiSuccess(c, nil)
+}
+
+// recordPriceOverrideLog 在用户的管理日志中记录价格覆盖的变更，便于核对账单争议
+func recordPriceOverrideLog(c *gin.Context, override *model.PriceOverride, action string) {
+	scope := "所有令牌"
+	if override.TokenId != 0 {
+		scope = fmt.Sprintf("令牌 #%d", override.TokenId)
+	}
+	model.RecordLog(override.UserId, model.LogTypeManage, fmt.Sprintf("管理员 #%d %s价格覆盖 #%d：%s，模型 %s，类型 %s，值 %v",
+		c.GetInt("id"), action, override.Id, scope, override.ModelPattern, override.Type, override.Value))
+}
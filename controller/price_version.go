@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+type priceVersionRequest struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	EffectiveAt int64                      `json:"effective_at"`
+	Content     map[string]json.RawMessage `json:"content"`
+}
+
+// GetPriceVersions 价格版本列表
+func GetPriceVersions(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	versions, total, err := model.GetAllPriceVersions(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(versions)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// CreatePriceVersion 创建价格版本，effective_at 为空或已过去时立即生效
+func CreatePriceVersion(c *gin.Context) {
+	var req priceVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Name == "" {
+		common.ApiErrorMsg(c, "版本名称不能为空")
+		return
+	}
+	content, err := json.Marshal(req.Content)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	version := &model.PriceVersion{
+		Name:        req.Name,
+		Description: req.Description,
+		EffectiveAt: req.EffectiveAt,
+		Content:     string(content),
+	}
+	if err := version.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, version)
+}
+
+// CancelPriceVersion 取消尚未生效的价格版本
+func CancelPriceVersion(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	version, err := model.GetPriceVersionById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := version.Cancel(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, version)
+}
+
+// DiffPriceVersions 比较两个价格版本，from / to 省略时表示当前生效的配置
+func DiffPriceVersions(c *gin.Context) {
+	from, err := getPriceVersionParam(c, "from")
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	to, err := getPriceVersionParam(c, "to")
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	changes, err := model.DiffPriceVersions(from, to)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, changes)
+}
+
+func getPriceVersionParam(c *gin.Context, name string) (*model.PriceVersion, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+	return model.GetPriceVersionById(id)
+}
+
+// GetUpcomingPriceChanges 即将生效的价格调整及其相对当前价格的变化
+func GetUpcomingPriceChanges(c *gin.Context) {
+	versions, err := model.GetUpcomingPriceVersions()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	upcoming := make([]gin.H, 0, len(versions))
+	for _, version := range versions {
+		changes, err := model.DiffPriceVersions(nil, version)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		upcoming = append(upcoming, gin.H{
+			"id":           version.Id,
+			"name":         version.Name,
+			"description":  version.Description,
+			"effective_at": version.EffectiveAt,
+			"changes":      changes,
+		})
+	}
+	common.ApiSuccess(c, upcoming)
+}
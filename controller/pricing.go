@@ -1,8 +1,11 @@
 package controller
 
 import (
+	"time"
+
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 
 	"github.com/gin-gonic/gin"
@@ -17,7 +20,9 @@ func GetPricing(c *gin.Context) {
 		groupRatio[s] = f
 	}
 	var group string
+	priceOverrides := make([]*model.PriceOverride, 0)
 	if exists {
+		priceOverrides = model.GetUserPriceOverrides(userId.(int))
 		user, err := model.GetUserCache(userId.(int))
 		if err == nil {
 			group = user.Group
@@ -38,6 +43,12 @@ func GetPricing(c *gin.Context) {
 		}
 	}
 
+	now := time.Now()
+	var nextWindowChange int64
+	if next := operation_setting.NextPricingWindowChange(now); !next.IsZero() {
+		nextWindowChange = next.Unix()
+	}
+
 	c.JSON(200, gin.H{
 		"success":            true,
 		"data":               pricing,
@@ -46,12 +57,17 @@ func GetPricing(c *gin.Context) {
 		"usable_group":       usableGroup,
 		"supported_endpoint": model.GetSupportedEndpointMap(),
 		"auto_groups":        setting.AutoGroups,
+		"price_overrides":    priceOverrides,
+		"pricing_windows": gin.H{
+			"active":         operation_setting.GetActivePricingWindows(now),
+			"next_change_at": nextWindowChange,
+		},
 	})
 }
 
 func ResetModelRatio(c *gin.Context) {
 	defaultStr := ratio_setting.DefaultModelRatio2JSONString()
-	err := model.UpdateOption("ModelRatio", defaultStr)
+	err := model.UpdatePriceTableOption("ModelRatio", defaultStr)
 	if err != nil {
 		c.JSON(200, gin.H{
 			"success": false,
@@ -41,10 +41,39 @@ func valuesEqual(a, b interface{}) bool {
 	if aok && bok {
 		return nearlyEqual(af, bf)
 	}
-	return a == b
+	// 分档倍率等复合值按 JSON 比较
+	aj, aerr := json.Marshal(a)
+	bj, berr := json.Marshal(b)
+	if aerr != nil || berr != nil {
+		return false
+	}
+	return string(aj) == string(bj)
 }
 
-var ratioTypes = []string{"model_ratio", "completion_ratio", "cache_ratio", "model_price"}
+var ratioTypes = []string{"model_ratio", "completion_ratio", "cache_ratio", "model_price", "tiered_ratio"}
+
+// localRatioValues 取本地某类倍率的 模型 -> 值 映射，复合值转换为与上游 JSON 解码结果相同的结构便于比较
+func localRatioValues(localData map[string]any, ratioType string) map[string]any {
+	values := make(map[string]any)
+	switch ratio := localData[ratioType].(type) {
+	case map[string]float64:
+		for modelName, val := range ratio {
+			values[modelName] = val
+		}
+	case map[string][]ratio_setting.PriceTier:
+		for modelName, tiers := range ratio {
+			data, err := json.Marshal(tiers)
+			if err != nil {
+				continue
+			}
+			var val any
+			if err := json.Unmarshal(data, &val); err == nil {
+				values[modelName] = val
+			}
+		}
+	}
+	return values
+}
 
 type upstreamResult struct {
 	Name string         `json:"name"`
@@ -235,6 +264,7 @@ func FetchUpstreamRatios(c *gin.Context) {
 				ModelRatio      float64 `json:"model_ratio"`
 				ModelPrice      float64 `json:"model_price"`
 				CompletionRatio float64 `json:"completion_ratio"`
+				PriceTiers      []any   `json:"price_tiers"`
 			}
 			if err := json.Unmarshal(body.Data, &pricingItems); err != nil {
 				logger.LogWarn(c.Request.Context(), "unrecognized data format from "+chItem.Name+": "+err.Error())
@@ -245,6 +275,7 @@ func FetchUpstreamRatios(c *gin.Context) {
 			modelRatioMap := make(map[string]float64)
 			completionRatioMap := make(map[string]float64)
 			modelPriceMap := make(map[string]float64)
+			tieredRatioMap := make(map[string]any)
 
 			for _, item := range pricingItems {
 				if item.QuotaType == 1 {
@@ -253,6 +284,9 @@ func FetchUpstreamRatios(c *gin.Context) {
 					modelRatioMap[item.ModelName] = item.ModelRatio
 					// completionRatio 可能为 0，此时也直接赋值，保持与上游一致
 					completionRatioMap[item.ModelName] = item.CompletionRatio
+					if len(item.PriceTiers) > 0 {
+						tieredRatioMap[item.ModelName] = item.PriceTiers
+					}
 				}
 			}
 
@@ -282,6 +316,10 @@ func FetchUpstreamRatios(c *gin.Context) {
 				converted["model_price"] = priceAny
 			}
 
+			if len(tieredRatioMap) > 0 {
+				converted["tiered_ratio"] = tieredRatioMap
+			}
+
 			ch <- upstreamResult{Name: uniqueName, Data: converted}
 		}(chn)
 	}
@@ -335,13 +373,11 @@ func buildDifferences(localData map[string]any, successfulChannels []struct {
 
 	allModels := make(map[string]struct{})
 
+	localValues := make(map[string]map[string]any, len(ratioTypes))
 	for _, ratioType := range ratioTypes {
-		if localRatioAny, ok := localData[ratioType]; ok {
-			if localRatio, ok := localRatioAny.(map[string]float64); ok {
-				for modelName := range localRatio {
-					allModels[modelName] = struct{}{}
-				}
-			}
+		localValues[ratioType] = localRatioValues(localData, ratioType)
+		for modelName := range localValues[ratioType] {
+			allModels[modelName] = struct{}{}
 		}
 	}
 
@@ -395,12 +431,8 @@ func buildDifferences(localData map[string]any, successfulChannels []struct {
 	for modelName := range allModels {
 		for _, ratioType := range ratioTypes {
 			var localValue interface{} = nil
-			if localRatioAny, ok := localData[ratioType]; ok {
-				if localRatio, ok := localRatioAny.(map[string]float64); ok {
-					if val, exists := localRatio[modelName]; exists {
-						localValue = val
-					}
-				}
+			if val, exists := localValues[ratioType][modelName]; exists {
+				localValue = val
 			}
 
 			upstreamValues := make(map[string]interface{})
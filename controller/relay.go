@@ -2,6 +2,7 @@ package controller
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -20,6 +21,7 @@ import (
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/bytedance/gopkg/util/gopool"
@@ -132,6 +134,16 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	relayInfo.SetPromptTokens(tokens)
 
+	newAPIError = helper.CheckModelCapabilities(relayInfo, meta, tokens)
+	if newAPIError != nil {
+		return
+	}
+
+	newAPIError = ensureChannelSupportsRequest(c, group, originalModel, helper.GetRequestFeatures(request, meta, tokens))
+	if newAPIError != nil {
+		return
+	}
+
 	priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeModelPriceError)
@@ -165,24 +177,39 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 
 		addUsedChannel(c, channel.Id)
+		slot, ok := acquireChannelSlot(c, channel.Id)
+		if !ok {
+			// 选择渠道后并发被其他请求占满，不计入渠道错误，按 429 决定是否换渠道重试
+			newAPIError = types.NewErrorWithStatusCode(fmt.Errorf("渠道 #%d 并发已满", channel.Id), types.ErrorCodeConcurrencyLimited, http.StatusTooManyRequests)
+			if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+				break
+			}
+			continue
+		}
 		requestBody, _ := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
-		switch relayFormat {
-		case types.RelayFormatOpenAIRealtime:
-			newAPIError = relay.WssHelper(c, relayInfo)
-		case types.RelayFormatClaude:
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
-		case types.RelayFormatGemini:
-			newAPIError = geminiRelayHandler(c, relayInfo)
-		default:
-			newAPIError = relayHandler(c, relayInfo)
-		}
+		newAPIError = func() *types.NewAPIError {
+			// 处理过程中 panic 也要释放并发名额
+			defer slot.Release()
+			switch relayFormat {
+			case types.RelayFormatOpenAIRealtime:
+				return relay.WssHelper(c, relayInfo)
+			case types.RelayFormatClaude:
+				return relay.ClaudeHelper(c, relayInfo)
+			case types.RelayFormatGemini:
+				return geminiRelayHandler(c, relayInfo)
+			default:
+				return relayHandler(c, relayInfo)
+			}
+		}()
 
 		if newAPIError == nil {
+			service.RecordSessionAffinity(c)
 			return
 		}
 
+		service.ReleaseSessionAffinity(c, channel.Id)
 		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
 
 		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
@@ -210,6 +237,53 @@ func addUsedChannel(c *gin.Context, channelId int) {
 	c.Set("use_channel", useChannel)
 }
 
+// acquireChannelSlot 优先使用排队时为该渠道预留的名额，否则占用新的名额
+func acquireChannelSlot(c *gin.Context, channelId int) (*model.ChannelSlot, bool) {
+	keyIndex := common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	slot := model.TakeReservedChannelSlot(c, channelId)
+	if slot == nil {
+		return model.AcquireChannelSlot(channelId, keyIndex)
+	}
+	if !slot.AcquireKey(keyIndex) {
+		slot.Release()
+		return nil, false
+	}
+	return slot, true
+}
+
+// ensureChannelSupportsRequest 将请求需要的能力（图片、音频、函数调用、json_schema、输入长度）记录到上下文供重试时过滤渠道，
+// 并在分发阶段选中的渠道不满足时重新选择
+func ensureChannelSupportsRequest(c *gin.Context, group, originalModel string, features types.RequestFeatures) *types.NewAPIError {
+	if !model_setting.GetGlobalSettings().ModelCapabilityCheckEnabled || features.IsEmpty() {
+		return nil
+	}
+	common.SetContextKey(c, constant.ContextKeyRequestFeatures, features)
+	current, err := model.CacheGetChannel(common.GetContextKeyInt(c, constant.ContextKeyChannelId))
+	if err == nil && current.SupportsFeatures(originalModel, features) {
+		return nil
+	}
+	// 令牌指定了渠道时不能改用其他渠道
+	if _, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId); ok {
+		return types.NewErrorWithStatusCode(fmt.Errorf("令牌指定的渠道不满足请求需要的能力（%s）", features), types.ErrorCodeModelCapabilityUnsupported, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	channel, selectGroup, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, 0)
+	if err != nil {
+		var unsupported *model.UnsupportedFeaturesError
+		if errors.As(err, &unsupported) {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeModelCapabilityUnsupported, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		var saturated *model.ChannelsSaturatedError
+		if errors.As(err, &saturated) {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeChannelsSaturated, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+		}
+		return types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败: %s", selectGroup, originalModel, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+	}
+	if channel == nil {
+		return types.NewError(fmt.Errorf("分组 %s 下模型 %s 的可用渠道不存在", selectGroup, originalModel), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+	}
+	return middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+}
+
 func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*model.Channel, *types.NewAPIError) {
 	if retryCount == 0 {
 		autoBan := c.GetBool("auto_ban")
@@ -225,6 +299,10 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 		}, nil
 	}
 	channel, selectGroup, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount)
+	var saturated *model.ChannelsSaturatedError
+	if errors.As(err, &saturated) {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeChannelsSaturated, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+	}
 	if err != nil {
 		return nil, types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败（retry）: %s", selectGroup, originalModel, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
@@ -346,9 +424,13 @@ func RelayMidjourney(c *gin.Context) {
 	case relayconstant.RelayModeMidjourneyTaskImageSeed:
 		mjErr = relay.RelayMidjourneyTaskImageSeed(c)
 	case relayconstant.RelayModeSwapFace:
-		mjErr = relay.RelaySwapFace(c, relayInfo)
+		mjErr = midjourneyRelayWithSlot(c, func() *dto.MidjourneyResponse {
+			return relay.RelaySwapFace(c, relayInfo)
+		})
 	default:
-		mjErr = relay.RelayMidjourneySubmit(c, relayInfo)
+		mjErr = midjourneyRelayWithSlot(c, func() *dto.MidjourneyResponse {
+			return relay.RelayMidjourneySubmit(c, relayInfo)
+		})
 	}
 	//err = relayMidjourneySubmit(c, relayMode)
 	log.Println(mjErr)
@@ -368,6 +450,20 @@ func RelayMidjourney(c *gin.Context) {
 	}
 }
 
+// midjourneyRelayWithSlot 提交任务期间占用所选渠道的并发名额，并发已满时按负载饱和返回
+func midjourneyRelayWithSlot(c *gin.Context, submit func() *dto.MidjourneyResponse) *dto.MidjourneyResponse {
+	channelId := c.GetInt("channel_id")
+	slot, ok := acquireChannelSlot(c, channelId)
+	if !ok {
+		return &dto.MidjourneyResponse{
+			Code:        30,
+			Description: fmt.Sprintf("渠道 #%d 并发已满", channelId),
+		}
+	}
+	defer slot.Release()
+	return submit()
+}
+
 func RelayNotImplemented(c *gin.Context) {
 	err := dto.OpenAIError{
 		Message: "API not implemented",
@@ -443,6 +539,13 @@ func taskRelayHandler(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dto.Tas
 	case relayconstant.RelayModeSunoFetch, relayconstant.RelayModeSunoFetchByID, relayconstant.RelayModeVideoFetchByID:
 		err = relay.RelayTaskFetch(c, relayInfo.RelayMode)
 	default:
+		// 提交任务期间占用所选渠道的并发名额，并发已满时按 429 换渠道重试
+		channelId := c.GetInt("channel_id")
+		slot, ok := acquireChannelSlot(c, channelId)
+		if !ok {
+			return service.TaskErrorWrapperLocal(fmt.Errorf("渠道 #%d 并发已满", channelId), string(types.ErrorCodeConcurrencyLimited), http.StatusTooManyRequests)
+		}
+		defer slot.Release()
 		err = relay.RelayTaskSubmit(c, relayInfo)
 	}
 	return err
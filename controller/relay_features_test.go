@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newFeatureTestContext 模型目录中 text-model 不支持函数调用，返回已选中指定设置渠道的请求上下文
+func newFeatureTestContext(t *testing.T, setting string) (*gin.Context, int) {
+	t.Helper()
+	setupTestDB(t)
+	settings := model_setting.GetGlobalSettings()
+	saved := settings.ModelCapabilityCheckEnabled
+	t.Cleanup(func() { settings.ModelCapabilityCheckEnabled = saved })
+	settings.ModelCapabilityCheckEnabled = true
+
+	meta := &model.Model{ModelName: "text-model", Status: 1, SupportsTools: common.GetPointer(false)}
+	if err := meta.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	model.RefreshPricing()
+	channel := &model.Channel{Name: "text", Key: "sk-test", Status: common.ChannelStatusEnabled, Models: "text-model",
+		Group: "default", Setting: common.GetPointer(setting)}
+	if err := model.DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	common.SetContextKey(c, constant.ContextKeyChannelId, channel.Id)
+	return c, channel.Id
+}
+
+func TestEnsureChannelSupportsRequestWithEmulation(t *testing.T) {
+	c, channelId := newFeatureTestContext(t,
+		`{"tool_call_emulation":true,"json_schema_emulation":true,"capabilities":{"json_schema":false}}`)
+
+	features := types.RequestFeatures{Tools: true, JsonSchema: true}
+	if apiErr := ensureChannelSupportsRequest(c, "default", "text-model", features); apiErr != nil {
+		t.Fatalf("emulating channel should serve tools and json_schema: %v", apiErr)
+	}
+	if got := common.GetContextKeyInt(c, constant.ContextKeyChannelId); got != channelId {
+		t.Fatalf("channel id = %d, want the selected channel %d kept", got, channelId)
+	}
+	if got, _ := common.GetContextKeyType[types.RequestFeatures](c, constant.ContextKeyRequestFeatures); got != features {
+		t.Fatalf("request features = %+v, want them stored for retries", got)
+	}
+}
+
+func TestEnsureChannelSupportsRequestWithoutEmulation(t *testing.T) {
+	c, _ := newFeatureTestContext(t, `{}`)
+	common.SetContextKey(c, constant.ContextKeyTokenSpecificChannelId, "1")
+
+	apiErr := ensureChannelSupportsRequest(c, "default", "text-model", types.RequestFeatures{Tools: true})
+	if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeModelCapabilityUnsupported || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("err = %v, want the token-pinned channel rejected for tools", apiErr)
+	}
+	// 普通请求不受影响
+	if apiErr := ensureChannelSupportsRequest(c, "default", "text-model", types.RequestFeatures{}); apiErr != nil {
+		t.Fatalf("a request without features should pass: %v", apiErr)
+	}
+}
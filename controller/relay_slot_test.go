@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newSlotTestContext 创建并发上限为 1 的渠道，返回已选中该渠道的请求上下文
+func newSlotTestContext(t *testing.T) (*gin.Context, int) {
+	t.Helper()
+	setupTestDB(t)
+	channel := &model.Channel{Name: "limited", Key: "sk-test", Status: common.ChannelStatusEnabled,
+		Setting: common.GetPointer(`{"max_concurrency":1}`)}
+	if err := model.DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/mj/submit/imagine", nil)
+	c.Set("channel_id", channel.Id)
+	return c, channel.Id
+}
+
+func TestMidjourneySubmitHoldsChannelSlot(t *testing.T) {
+	c, channelId := newSlotTestContext(t)
+
+	var inFlight int64
+	mjErr := midjourneyRelayWithSlot(c, func() *dto.MidjourneyResponse {
+		inFlight = model.GetChannelsInFlight([]int{channelId})[channelId]
+		return nil
+	})
+	if mjErr != nil || inFlight != 1 {
+		t.Fatalf("mjErr = %v, in flight during submit = %d, want 1", mjErr, inFlight)
+	}
+	if after := model.GetChannelsInFlight([]int{channelId})[channelId]; after != 0 {
+		t.Fatalf("in flight after submit = %d, want 0", after)
+	}
+
+	held, ok := model.AcquireChannelSlot(channelId, -1)
+	if !ok {
+		t.Fatal("channel should have a free slot")
+	}
+	defer held.Release()
+	submitted := false
+	mjErr = midjourneyRelayWithSlot(c, func() *dto.MidjourneyResponse {
+		submitted = true
+		return nil
+	})
+	if submitted || mjErr == nil || mjErr.Code != 30 {
+		t.Fatalf("saturated channel: submitted = %t, mjErr = %+v, want code 30", submitted, mjErr)
+	}
+}
+
+func TestTaskSubmitRespectsChannelSlot(t *testing.T) {
+	c, channelId := newSlotTestContext(t)
+	held, ok := model.AcquireChannelSlot(channelId, -1)
+	if !ok {
+		t.Fatal("channel should have a free slot")
+	}
+	defer held.Release()
+
+	relayInfo := &relaycommon.RelayInfo{RelayMode: relayconstant.RelayModeSunoSubmit}
+	taskErr := taskRelayHandler(c, relayInfo)
+	if taskErr == nil || taskErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("taskErr = %+v, want 429", taskErr)
+	}
+	// 并发已满按 429 换渠道重试
+	if !shouldRetryTaskRelay(c, channelId, taskErr, 1) {
+		t.Fatal("a saturated channel should be retried on another channel")
+	}
+	if inFlight := model.GetChannelsInFlight([]int{channelId})[channelId]; inFlight != 1 {
+		t.Fatalf("in flight = %d, want only the held slot", inFlight)
+	}
+}
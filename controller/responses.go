@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func responsesError(c *gin.Context, err error, responseId string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": dto.OpenAIError{
+				Message: fmt.Sprintf("Response with id '%s' not found.", responseId),
+				Type:    "invalid_request_error",
+				Param:   "response_id",
+				Code:    "response_not_found",
+			},
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": dto.OpenAIError{
+			Message: err.Error(),
+			Type:    "server_error",
+		},
+	})
+}
+
+// GetResponse 查询网关保存的 Responses API 响应
+func GetResponse(c *gin.Context) {
+	responseId := c.Param("id")
+	stored, err := model.GetStoredResponse(responseId, c.GetInt("id"))
+	if err != nil {
+		responsesError(c, err, responseId)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", []byte(stored.Response))
+}
+
+func DeleteResponse(c *gin.Context) {
+	responseId := c.Param("id")
+	if err := model.DeleteStoredResponse(responseId, c.GetInt("id")); err != nil {
+		responsesError(c, err, responseId)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      responseId,
+		"object":  "response.deleted",
+		"deleted": true,
+	})
+}
+
+// GetResponseInputItems 返回该响应本轮的输入项，支持 limit、order 和 after 分页
+func GetResponseInputItems(c *gin.Context) {
+	responseId := c.Param("id")
+	stored, err := model.GetStoredResponse(responseId, c.GetInt("id"))
+	if err != nil {
+		responsesError(c, err, responseId)
+		return
+	}
+	var items []map[string]any
+	_ = common.UnmarshalJsonStr(string(stored.Input), &items)
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if c.DefaultQuery("order", "desc") == "desc" {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	if after := c.Query("after"); after != "" {
+		for i, item := range items {
+			if common.Interface2String(item["id"]) == after {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+	}
+
+	var firstId, lastId any
+	if len(items) > 0 {
+		firstId, lastId = items[0]["id"], items[len(items)-1]["id"]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     items,
+		"first_id": firstId,
+		"last_id":  lastId,
+		"has_more": hasMore,
+	})
+}
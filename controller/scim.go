@@ -0,0 +1,869 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const scimMaxPageSize = 200
+
+// scimMaxNameLength 本地用户名与显示名的最大长度，与 model.User 的校验规则一致
+const scimMaxNameLength = 20
+
+var scimEqFilterRegex = regexp.MustCompile(`(?i)^\s*([\w.]+)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+var scimMemberPathRegex = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]*)"\s*\]$`)
+
+func scimJSON(c *gin.Context, statusCode int, obj any) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(statusCode, obj)
+}
+
+func scimError(c *gin.Context, statusCode int, scimType string, detail string) {
+	scimJSON(c, statusCode, dto.SCIMError{
+		Schemas:  []string{dto.SCIMSchemaError},
+		Status:   strconv.Itoa(statusCode),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+func scimLocation(resource string, id int) string {
+	return fmt.Sprintf("%s/scim/v2/%s/%d", strings.TrimSuffix(system_setting.ServerAddress, "/"), resource, id)
+}
+
+func scimTime(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+// parseSCIMFilter 仅支持 `attr eq "value"` 形式的过滤条件
+func parseSCIMFilter(filter string) (string, string, error) {
+	if strings.TrimSpace(filter) == "" {
+		return "", "", nil
+	}
+	matches := scimEqFilterRegex.FindStringSubmatch(filter)
+	if matches == nil {
+		return "", "", errors.New("only `attribute eq \"value\"` filters are supported")
+	}
+	return matches[1], strings.ReplaceAll(matches[2], `\"`, `"`), nil
+}
+
+func parseSCIMPagination(c *gin.Context) (startIndex int, count int) {
+	startIndex, _ = strconv.Atoi(c.Query("startIndex"))
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.Query("count"))
+	if err != nil || count < 0 {
+		count = 100
+	}
+	if count > scimMaxPageSize {
+		count = scimMaxPageSize
+	}
+	return startIndex, count
+}
+
+func parseSCIMBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := common.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	// 部分 IdP（如 Entra ID）会以字符串 "True"/"False" 发送布尔值
+	var s string
+	if err := common.Unmarshal(raw, &s); err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(strings.ToLower(s))
+}
+
+func scimListResponse(startIndex int, total int64, resources []any) dto.SCIMListResponse {
+	return dto.SCIMListResponse{
+		Schemas:      []string{dto.SCIMSchemaListResponse},
+		TotalResults: int(total),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+func scimDefaultGroup() string {
+	group := system_setting.GetSCIMSettings().DefaultGroup
+	if group == "" {
+		return "default"
+	}
+	return group
+}
+
+func buildSCIMUser(user *model.User) dto.SCIMUser {
+	active := user.Status == common.UserStatusEnabled
+	scimUser := dto.SCIMUser{
+		Schemas:     []string{dto.SCIMSchemaUser},
+		Id:          strconv.Itoa(user.Id),
+		ExternalId:  user.ExternalId,
+		UserName:    scimUserName(user),
+		DisplayName: user.DisplayName,
+		Name:        &dto.SCIMName{Formatted: user.DisplayName},
+		Active:      &active,
+		Meta: &dto.SCIMMeta{
+			ResourceType: "User",
+			Location:     scimLocation("Users", user.Id),
+		},
+	}
+	if user.Email != "" {
+		scimUser.Emails = []dto.SCIMMultiValue{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	if group, err := model.GetUserGroupByName(user.Group); err == nil {
+		scimUser.Groups = []dto.SCIMMultiValue{{
+			Value:   strconv.Itoa(group.Id),
+			Display: group.DisplayName,
+			Ref:     scimLocation("Groups", group.Id),
+		}}
+	}
+	return scimUser
+}
+
+func buildSCIMGroup(group *model.UserGroup, includeMembers bool) (dto.SCIMGroup, error) {
+	scimGroup := dto.SCIMGroup{
+		Schemas:     []string{dto.SCIMSchemaGroup},
+		Id:          strconv.Itoa(group.Id),
+		ExternalId:  group.ExternalId,
+		DisplayName: group.DisplayName,
+		Meta: &dto.SCIMMeta{
+			ResourceType: "Group",
+			Created:      scimTime(group.CreatedTime),
+			LastModified: scimTime(group.UpdatedTime),
+			Location:     scimLocation("Groups", group.Id),
+		},
+	}
+	if !includeMembers {
+		return scimGroup, nil
+	}
+	users, err := model.GetUsersByGroup(group.Name)
+	if err != nil {
+		return scimGroup, err
+	}
+	for _, user := range users {
+		scimGroup.Members = append(scimGroup.Members, dto.SCIMMultiValue{
+			Value:   strconv.Itoa(user.Id),
+			Display: user.Username,
+			Ref:     scimLocation("Users", user.Id),
+		})
+	}
+	return scimGroup, nil
+}
+
+// scimUserName 返回 IdP 使用的 userName，非 SCIM 创建的用户使用本地用户名
+func scimUserName(user *model.User) string {
+	if user.ScimUserName != "" {
+		return user.ScimUserName
+	}
+	return user.Username
+}
+
+// scimLocalUsername 本地用户名最长 20 个字符，IdP 的 userName 过长或已被占用时改用 scim_<id>
+func scimLocalUsername(userName string) (string, error) {
+	if utf8.RuneCountInString(userName) <= scimMaxNameLength {
+		exist, err := model.CheckUserExistOrDeleted(userName, "")
+		if err != nil {
+			return "", err
+		}
+		if !exist {
+			return userName, nil
+		}
+	}
+	return "scim_" + strconv.Itoa(model.GetMaxUserId()+1), nil
+}
+
+// scimUserDisplayName 显示名超过本地长度限制时截断
+func scimUserDisplayName(scimUser *dto.SCIMUser) string {
+	displayName := scimUser.DisplayName
+	if displayName == "" && scimUser.Name != nil {
+		displayName = scimUser.Name.Formatted
+		if displayName == "" {
+			displayName = strings.TrimSpace(scimUser.Name.GivenName + " " + scimUser.Name.FamilyName)
+		}
+	}
+	if displayName == "" {
+		displayName = scimUser.UserName
+	}
+	if runes := []rune(displayName); len(runes) > scimMaxNameLength {
+		displayName = string(runes[:scimMaxNameLength])
+	}
+	return displayName
+}
+
+func getSCIMUserByParam(c *gin.Context) (*model.User, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", "user not found")
+		return nil, false
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			scimError(c, http.StatusNotFound, "", "user not found")
+		} else {
+			scimError(c, http.StatusInternalServerError, "", err.Error())
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+func getSCIMGroupByParam(c *gin.Context) (*model.UserGroup, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", "group not found")
+		return nil, false
+	}
+	group, err := model.GetUserGroupById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			scimError(c, http.StatusNotFound, "", "group not found")
+		} else {
+			scimError(c, http.StatusInternalServerError, "", err.Error())
+		}
+		return nil, false
+	}
+	return group, true
+}
+
+// saveSCIMUser 将 SCIM 表示写回 model.User，active 变化时同步用户与令牌状态
+func saveSCIMUser(c *gin.Context, user *model.User, scimUser *dto.SCIMUser) bool {
+	userName := strings.TrimSpace(scimUser.UserName)
+	if userName == "" {
+		scimError(c, http.StatusBadRequest, "invalidValue", "userName is required")
+		return false
+	}
+	if userName != scimUserName(user) {
+		exist, err := model.IsSCIMUserNameTaken(userName, user.Id)
+		if err != nil {
+			scimError(c, http.StatusInternalServerError, "", err.Error())
+			return false
+		}
+		if exist {
+			scimError(c, http.StatusConflict, "uniqueness", "userName already exists")
+			return false
+		}
+	}
+	// IdP 修改 userName 时只更新 scim_user_name，本地用户名保持不变
+	user.ScimUserName = userName
+	user.DisplayName = scimUserDisplayName(scimUser)
+	user.Email = scimUser.PrimaryEmail()
+	user.ExternalId = scimUser.ExternalId
+	if err := validateSCIMUserFields(user); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return false
+	}
+	if err := model.UpdateSCIMUser(user); err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return false
+	}
+	if scimUser.Active != nil {
+		status := common.UserStatusEnabled
+		if !*scimUser.Active {
+			status = common.UserStatusDisabled
+		}
+		if status != user.Status {
+			if status == common.UserStatusDisabled && user.Role == common.RoleRootUser {
+				scimError(c, http.StatusBadRequest, "mutability", "cannot deactivate root user")
+				return false
+			}
+			if err := model.SetUserStatus(user.Id, status); err != nil {
+				scimError(c, http.StatusInternalServerError, "", err.Error())
+				return false
+			}
+			user.Status = status
+		}
+	}
+	return true
+}
+
+// validateSCIMUserFields 只校验 SCIM 可修改的字段。userName 存在 scim_user_name 中，显示名已截断，
+// 密码由系统随机生成，均不按本地注册的规则校验
+func validateSCIMUserFields(user *model.User) error {
+	return common.Validate.StructPartial(user, "DisplayName", "Email")
+}
+
+func applySCIMUserPatch(scimUser *dto.SCIMUser, op string, path string, value json.RawMessage) error {
+	if path == "" {
+		// 无 path 时 value 为属性对象，逐个属性应用
+		var attrs map[string]json.RawMessage
+		if err := common.Unmarshal(value, &attrs); err != nil {
+			return err
+		}
+		for attr, attrValue := range attrs {
+			if err := applySCIMUserPatch(scimUser, op, attr, attrValue); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	remove := op == "remove"
+	lowerPath := strings.ToLower(path)
+	switch {
+	case lowerPath == "active":
+		if remove {
+			return nil
+		}
+		active, err := parseSCIMBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for active: %w", err)
+		}
+		scimUser.Active = &active
+	case lowerPath == "username":
+		if remove {
+			return errors.New("userName cannot be removed")
+		}
+		return common.Unmarshal(value, &scimUser.UserName)
+	case lowerPath == "displayname":
+		if remove {
+			scimUser.DisplayName = ""
+			return nil
+		}
+		return common.Unmarshal(value, &scimUser.DisplayName)
+	case lowerPath == "externalid":
+		if remove {
+			scimUser.ExternalId = ""
+			return nil
+		}
+		return common.Unmarshal(value, &scimUser.ExternalId)
+	case strings.HasPrefix(lowerPath, "name"):
+		if scimUser.Name == nil {
+			scimUser.Name = &dto.SCIMName{}
+		}
+		if remove {
+			scimUser.Name = &dto.SCIMName{}
+			return nil
+		}
+		switch lowerPath {
+		case "name":
+			return common.Unmarshal(value, scimUser.Name)
+		case "name.formatted":
+			return common.Unmarshal(value, &scimUser.Name.Formatted)
+		case "name.givenname":
+			return common.Unmarshal(value, &scimUser.Name.GivenName)
+		case "name.familyname":
+			return common.Unmarshal(value, &scimUser.Name.FamilyName)
+		}
+	case strings.HasPrefix(lowerPath, "emails"):
+		if remove {
+			scimUser.Emails = nil
+			return nil
+		}
+		var emails []dto.SCIMMultiValue
+		if err := common.Unmarshal(value, &emails); err == nil {
+			scimUser.Emails = emails
+			return nil
+		}
+		// emails[type eq "work"].value 形式直接给出邮箱字符串
+		var email string
+		if err := common.Unmarshal(value, &email); err != nil {
+			return fmt.Errorf("invalid value for emails: %w", err)
+		}
+		scimUser.Emails = []dto.SCIMMultiValue{{Value: email, Type: "work", Primary: true}}
+	}
+	// 其余属性（如企业扩展属性）不在 new-api 中存储，直接忽略
+	return nil
+}
+
+// SCIMServiceProviderConfig 返回 SCIM 服务能力声明
+func SCIMServiceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":        []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": scimMaxPageSize},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication scheme using the SCIM bearer token configured in new-api",
+		}},
+	})
+}
+
+// ListSCIMUsers GET /scim/v2/Users
+func ListSCIMUsers(c *gin.Context) {
+	attr, value, err := parseSCIMFilter(c.Query("filter"))
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	startIndex, count := parseSCIMPagination(c)
+	users, total, err := model.SearchSCIMUsers(attr, value, startIndex-1, count)
+	if err != nil {
+		if errors.Is(err, model.ErrSCIMUnsupportedFilter) {
+			scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+			return
+		}
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	resources := make([]any, 0, len(users))
+	for _, user := range users {
+		resources = append(resources, buildSCIMUser(user))
+	}
+	scimJSON(c, http.StatusOK, scimListResponse(startIndex, total, resources))
+}
+
+// GetSCIMUser GET /scim/v2/Users/:id
+func GetSCIMUser(c *gin.Context) {
+	user, ok := getSCIMUserByParam(c)
+	if !ok {
+		return
+	}
+	scimJSON(c, http.StatusOK, buildSCIMUser(user))
+}
+
+// CreateSCIMUser POST /scim/v2/Users
+func CreateSCIMUser(c *gin.Context) {
+	var scimUser dto.SCIMUser
+	if err := common.DecodeJson(c.Request.Body, &scimUser); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	userName := strings.TrimSpace(scimUser.UserName)
+	if userName == "" {
+		scimError(c, http.StatusBadRequest, "invalidValue", "userName is required")
+		return
+	}
+	email := scimUser.PrimaryEmail()
+	exist, err := model.IsSCIMUserNameTaken(userName, 0)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	if !exist && email != "" {
+		exist = model.IsEmailAlreadyTaken(email)
+	}
+	if exist {
+		scimError(c, http.StatusConflict, "uniqueness", "user with the same userName or email already exists")
+		return
+	}
+	username, err := scimLocalUsername(userName)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	user := model.User{
+		Username:     username,
+		ScimUserName: userName,
+		// SCIM 用户通过 SSO 登录，忽略 IdP 下发的密码，使用随机密码禁止直接密码登录
+		Password:    common.GetRandomString(16),
+		DisplayName: scimUserDisplayName(&scimUser),
+		Email:       email,
+		ExternalId:  scimUser.ExternalId,
+		Role:        common.RoleCommonUser,
+		Status:      common.UserStatusEnabled,
+		Group:       scimDefaultGroup(),
+	}
+	if scimUser.Active != nil && !*scimUser.Active {
+		user.Status = common.UserStatusDisabled
+	}
+	if err := validateSCIMUserFields(&user); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	if err := user.Insert(0); err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	scimJSON(c, http.StatusCreated, buildSCIMUser(&user))
+}
+
+// ReplaceSCIMUser PUT /scim/v2/Users/:id
+func ReplaceSCIMUser(c *gin.Context) {
+	user, ok := getSCIMUserByParam(c)
+	if !ok {
+		return
+	}
+	var scimUser dto.SCIMUser
+	if err := common.DecodeJson(c.Request.Body, &scimUser); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if !saveSCIMUser(c, user, &scimUser) {
+		return
+	}
+	scimJSON(c, http.StatusOK, buildSCIMUser(user))
+}
+
+// PatchSCIMUser PATCH /scim/v2/Users/:id
+func PatchSCIMUser(c *gin.Context) {
+	user, ok := getSCIMUserByParam(c)
+	if !ok {
+		return
+	}
+	var patch dto.SCIMPatchRequest
+	if err := common.DecodeJson(c.Request.Body, &patch); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	scimUser := buildSCIMUser(user)
+	for _, operation := range patch.Operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			scimError(c, http.StatusBadRequest, "invalidSyntax", "unsupported patch op: "+operation.Op)
+			return
+		}
+		if err := applySCIMUserPatch(&scimUser, op, operation.Path, operation.Value); err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+	if !saveSCIMUser(c, user, &scimUser) {
+		return
+	}
+	scimJSON(c, http.StatusOK, buildSCIMUser(user))
+}
+
+// DeleteSCIMUser DELETE /scim/v2/Users/:id，禁用用户后软删除
+func DeleteSCIMUser(c *gin.Context) {
+	user, ok := getSCIMUserByParam(c)
+	if !ok {
+		return
+	}
+	if user.Role == common.RoleRootUser {
+		scimError(c, http.StatusBadRequest, "mutability", "cannot delete root user")
+		return
+	}
+	if err := model.SetUserStatus(user.Id, common.UserStatusDisabled); err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	if err := user.Delete(); err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListSCIMGroups GET /scim/v2/Groups
+func ListSCIMGroups(c *gin.Context) {
+	attr, value, err := parseSCIMFilter(c.Query("filter"))
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	startIndex, count := parseSCIMPagination(c)
+	groups, total, err := model.SearchSCIMGroups(attr, value, startIndex-1, count)
+	if err != nil {
+		if errors.Is(err, model.ErrSCIMUnsupportedFilter) {
+			scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+			return
+		}
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	includeMembers := !strings.Contains(c.Query("excludedAttributes"), "members")
+	resources := make([]any, 0, len(groups))
+	for _, group := range groups {
+		scimGroup, err := buildSCIMGroup(group, includeMembers)
+		if err != nil {
+			scimError(c, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+		resources = append(resources, scimGroup)
+	}
+	scimJSON(c, http.StatusOK, scimListResponse(startIndex, total, resources))
+}
+
+// GetSCIMGroup GET /scim/v2/Groups/:id
+func GetSCIMGroup(c *gin.Context) {
+	group, ok := getSCIMGroupByParam(c)
+	if !ok {
+		return
+	}
+	scimGroup, err := buildSCIMGroup(group, true)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	scimJSON(c, http.StatusOK, scimGroup)
+}
+
+// setSCIMGroupMembers 将成员加入或移出用户组，移出的成员回到默认分组
+func setSCIMGroupMembers(group *model.UserGroup, members []dto.SCIMMultiValue, add bool) error {
+	for _, member := range members {
+		userId, err := strconv.Atoi(member.Value)
+		if err != nil {
+			return fmt.Errorf("invalid member id: %s", member.Value)
+		}
+		user, err := model.GetUserById(userId, false)
+		if err != nil {
+			return fmt.Errorf("member not found: %s", member.Value)
+		}
+		if add {
+			err = model.UpdateUserGroup(user.Id, group.Name)
+		} else if user.Group == group.Name {
+			err = model.UpdateUserGroup(user.Id, scimDefaultGroup())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renameSCIMGroup(group *model.UserGroup, displayName string) error {
+	displayName = strings.TrimSpace(displayName)
+	if displayName == "" {
+		return errors.New("displayName is required")
+	}
+	if displayName == group.DisplayName {
+		return nil
+	}
+	oldName := group.Name
+	group.Name = displayName
+	group.DisplayName = displayName
+	if err := group.Update(); err != nil {
+		return err
+	}
+	return model.MoveUsersToGroup(oldName, group.Name)
+}
+
+// CreateSCIMGroup POST /scim/v2/Groups
+func CreateSCIMGroup(c *gin.Context) {
+	var scimGroup dto.SCIMGroup
+	if err := common.DecodeJson(c.Request.Body, &scimGroup); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	displayName := strings.TrimSpace(scimGroup.DisplayName)
+	if displayName == "" {
+		scimError(c, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return
+	}
+	if _, err := model.GetUserGroupByName(displayName); err == nil {
+		scimError(c, http.StatusConflict, "uniqueness", "group already exists")
+		return
+	}
+	group := model.UserGroup{
+		Name:        displayName,
+		DisplayName: displayName,
+		ExternalId:  scimGroup.ExternalId,
+		Status:      1,
+	}
+	if err := group.Insert(); err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	if err := setSCIMGroupMembers(&group, scimGroup.Members, true); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	result, err := buildSCIMGroup(&group, true)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	scimJSON(c, http.StatusCreated, result)
+}
+
+// ReplaceSCIMGroup PUT /scim/v2/Groups/:id
+func ReplaceSCIMGroup(c *gin.Context) {
+	group, ok := getSCIMGroupByParam(c)
+	if !ok {
+		return
+	}
+	var scimGroup dto.SCIMGroup
+	if err := common.DecodeJson(c.Request.Body, &scimGroup); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if err := renameSCIMGroup(group, scimGroup.DisplayName); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	if scimGroup.ExternalId != group.ExternalId {
+		group.ExternalId = scimGroup.ExternalId
+		if err := group.Update(); err != nil {
+			scimError(c, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+	}
+	if !replaceSCIMGroupMembers(c, group, scimGroup.Members) {
+		return
+	}
+	result, err := buildSCIMGroup(group, true)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	scimJSON(c, http.StatusOK, result)
+}
+
+func replaceSCIMGroupMembers(c *gin.Context, group *model.UserGroup, members []dto.SCIMMultiValue) bool {
+	current, err := model.GetUsersByGroup(group.Name)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return false
+	}
+	keep := make(map[string]bool, len(members))
+	for _, member := range members {
+		keep[member.Value] = true
+	}
+	var removed []dto.SCIMMultiValue
+	for _, user := range current {
+		if !keep[strconv.Itoa(user.Id)] {
+			removed = append(removed, dto.SCIMMultiValue{Value: strconv.Itoa(user.Id)})
+		}
+	}
+	if err := setSCIMGroupMembers(group, removed, false); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return false
+	}
+	if err := setSCIMGroupMembers(group, members, true); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return false
+	}
+	return true
+}
+
+// PatchSCIMGroup PATCH /scim/v2/Groups/:id
+func PatchSCIMGroup(c *gin.Context) {
+	group, ok := getSCIMGroupByParam(c)
+	if !ok {
+		return
+	}
+	var patch dto.SCIMPatchRequest
+	if err := common.DecodeJson(c.Request.Body, &patch); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	for _, operation := range patch.Operations {
+		op := strings.ToLower(operation.Op)
+		path := operation.Path
+		value := operation.Value
+		if path == "" {
+			// 无 path 时 value 为属性对象
+			var attrs map[string]json.RawMessage
+			if err := common.Unmarshal(value, &attrs); err != nil {
+				scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+				return
+			}
+			for attr, attrValue := range attrs {
+				if !applySCIMGroupPatch(c, group, op, attr, attrValue) {
+					return
+				}
+			}
+			continue
+		}
+		if !applySCIMGroupPatch(c, group, op, path, value) {
+			return
+		}
+	}
+	if c.Query("attributes") == "" && c.Query("excludedAttributes") == "" {
+		// 大多数 IdP 仅需要 204 响应，避免成员较多时构造完整响应
+		c.Status(http.StatusNoContent)
+		return
+	}
+	result, err := buildSCIMGroup(group, !strings.Contains(c.Query("excludedAttributes"), "members"))
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	scimJSON(c, http.StatusOK, result)
+}
+
+func applySCIMGroupPatch(c *gin.Context, group *model.UserGroup, op string, path string, value json.RawMessage) bool {
+	lowerPath := strings.ToLower(path)
+	switch {
+	case lowerPath == "displayname":
+		var displayName string
+		if err := common.Unmarshal(value, &displayName); err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return false
+		}
+		if err := renameSCIMGroup(group, displayName); err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return false
+		}
+	case lowerPath == "externalid":
+		if err := common.Unmarshal(value, &group.ExternalId); err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return false
+		}
+		if err := group.Update(); err != nil {
+			scimError(c, http.StatusInternalServerError, "", err.Error())
+			return false
+		}
+	case lowerPath == "members":
+		var members []dto.SCIMMultiValue
+		if len(value) > 0 {
+			if err := common.Unmarshal(value, &members); err != nil {
+				scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+				return false
+			}
+		}
+		switch op {
+		case "add":
+			if err := setSCIMGroupMembers(group, members, true); err != nil {
+				scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+				return false
+			}
+		case "remove":
+			if len(members) == 0 {
+				// 未指定成员时移除全部成员
+				return replaceSCIMGroupMembers(c, group, nil)
+			}
+			if err := setSCIMGroupMembers(group, members, false); err != nil {
+				scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+				return false
+			}
+		case "replace":
+			return replaceSCIMGroupMembers(c, group, members)
+		default:
+			scimError(c, http.StatusBadRequest, "invalidSyntax", "unsupported patch op: "+op)
+			return false
+		}
+	default:
+		matches := scimMemberPathRegex.FindStringSubmatch(path)
+		if matches == nil || op != "remove" {
+			scimError(c, http.StatusBadRequest, "invalidPath", "unsupported path: "+path)
+			return false
+		}
+		if err := setSCIMGroupMembers(group, []dto.SCIMMultiValue{{Value: matches[1]}}, false); err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteSCIMGroup DELETE /scim/v2/Groups/:id，组内成员回到默认分组
+func DeleteSCIMGroup(c *gin.Context) {
+	group, ok := getSCIMGroupByParam(c)
+	if !ok {
+		return
+	}
+	if err := model.MoveUsersToGroup(group.Name, scimDefaultGroup()); err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	if err := group.Delete(); err != nil {
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveSCIM 调用 SCIM 处理函数，返回状态码与解析后的用户
+func serveSCIM(t *testing.T, handler gin.HandlerFunc, method string, id int, body string) (int, dto.SCIMUser) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, "/scim/v2/Users", strings.NewReader(body))
+	if id != 0 {
+		c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(id)}}
+	}
+	handler(c)
+	var user dto.SCIMUser
+	if recorder.Code < http.StatusMultipleChoices && recorder.Body.Len() > 0 {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &user); err != nil {
+			t.Fatalf("invalid SCIM response %s: %v", recorder.Body.String(), err)
+		}
+	}
+	return c.Writer.Status(), user
+}
+
+func TestValidateSCIMUserFieldsIgnoresPassword(t *testing.T) {
+	// 已有用户读取时不含密码，停用等更新不应因密码校验失败
+	user := &model.User{Id: 1, Username: "alice", DisplayName: "Alice", Email: "alice@example.com"}
+	if err := validateSCIMUserFields(user); err != nil {
+		t.Fatalf("expected user without password to pass, got %v", err)
+	}
+}
+
+func TestValidateSCIMUserFieldsRejectsInvalidFields(t *testing.T) {
+	cases := map[string]*model.User{
+		"display_name": {Username: "alice", DisplayName: strings.Repeat("a", 21)},
+		"email":        {Username: "alice", Email: strings.Repeat("a", 40) + "@example.com"},
+	}
+	for name, user := range cases {
+		if err := validateSCIMUserFields(user); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
+
+func TestCreateSCIMUserWithLongUserName(t *testing.T) {
+	setupTestDB(t)
+	userName := "jonathan.alexander.smit@corp.example.com"
+	if len(userName) != 40 {
+		t.Fatalf("userName should be 40 characters, got %d", len(userName))
+	}
+	body := `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"` + userName + `",
+		"name":{"givenName":"Jonathan Alexander","familyName":"Smith"},
+		"emails":[{"value":"jas@example.com","primary":true}],"active":true}`
+	status, created := serveSCIM(t, CreateSCIMUser, http.MethodPost, 0, body)
+	if status != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", status)
+	}
+	if created.UserName != userName {
+		t.Fatalf("userName = %q, want %q", created.UserName, userName)
+	}
+	id, _ := strconv.Atoi(created.Id)
+	user, err := model.GetUserById(id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 本地用户名按 scim_<id> 生成，显示名截断到本地长度限制，密码随机生成
+	if user.Username != "scim_"+created.Id || user.ScimUserName != userName {
+		t.Fatalf("username = %q, scim userName = %q", user.Username, user.ScimUserName)
+	}
+	if utf8.RuneCountInString(user.DisplayName) != scimMaxNameLength || user.Password == "" {
+		t.Fatalf("display name = %q, password empty = %t", user.DisplayName, user.Password == "")
+	}
+
+	// IdP 按 userName 查询已存在的用户
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName eq "`+userName+`"`), nil)
+	ListSCIMUsers(c)
+	var list struct {
+		TotalResults int            `json:"totalResults"`
+		Resources    []dto.SCIMUser `json:"Resources"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.TotalResults != 1 || list.Resources[0].Id != created.Id {
+		t.Fatalf("unexpected filter result: %s", recorder.Body.String())
+	}
+
+	// 同一 userName 重复创建返回冲突
+	if status, _ := serveSCIM(t, CreateSCIMUser, http.MethodPost, 0, `{"userName":"`+userName+`"}`); status != http.StatusConflict {
+		t.Fatalf("duplicate userName status = %d, want 409", status)
+	}
+}
+
+func TestCreateSCIMUserIgnoresPassword(t *testing.T) {
+	setupTestDB(t)
+	for i, password := range []string{"", "short", strings.Repeat("p", 64)} {
+		body, _ := json.Marshal(map[string]any{"userName": "user" + strconv.Itoa(i) + "@example.com", "password": password})
+		if status, _ := serveSCIM(t, CreateSCIMUser, http.MethodPost, 0, string(body)); status != http.StatusCreated {
+			t.Fatalf("password %q: status = %d, want 201", password, status)
+		}
+	}
+}
+
+func TestSCIMUserNameChangeKeepsLocalUsername(t *testing.T) {
+	setupTestDB(t)
+	status, created := serveSCIM(t, CreateSCIMUser, http.MethodPost, 0, `{"userName":"bob"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("status = %d", status)
+	}
+	id, _ := strconv.Atoi(created.Id)
+	newName := "robert.builder.long@corp.example.com"
+	patch := `{"Operations":[{"op":"replace","path":"userName","value":"` + newName + `"}]}`
+	status, patched := serveSCIM(t, PatchSCIMUser, http.MethodPatch, id, patch)
+	if status != http.StatusOK || patched.UserName != newName {
+		t.Fatalf("status = %d, userName = %q", status, patched.UserName)
+	}
+	user, _ := model.GetUserById(id, false)
+	if user.Username != "bob" {
+		t.Fatalf("local username changed to %q", user.Username)
+	}
+}
+
+func TestSCIMDeactivateKeepsTokens(t *testing.T) {
+	setupTestDB(t)
+	status, created := serveSCIM(t, CreateSCIMUser, http.MethodPost, 0, `{"userName":"carol@example.com"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("status = %d", status)
+	}
+	id, _ := strconv.Atoi(created.Id)
+	tokens := []*model.Token{
+		{UserId: id, Key: strings.Repeat("a", 48), Name: "enabled", Status: common.TokenStatusEnabled},
+		{UserId: id, Key: strings.Repeat("b", 48), Name: "disabled", Status: common.TokenStatusDisabled},
+	}
+	for _, token := range tokens {
+		if err := model.DB.Create(token).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, active := range []bool{false, true} {
+		body := `{"Operations":[{"op":"replace","value":{"active":` + strconv.FormatBool(active) + `}}]}`
+		status, patched := serveSCIM(t, PatchSCIMUser, http.MethodPatch, id, body)
+		if status != http.StatusOK || patched.Active == nil || *patched.Active != active {
+			t.Fatalf("active %t: status = %d, response %+v", active, status, patched)
+		}
+	}
+	// 停用再启用后，令牌保持原来的状态
+	for _, token := range tokens {
+		var saved model.Token
+		model.DB.First(&saved, token.Id)
+		if saved.Status != token.Status {
+			t.Fatalf("token %s status = %d, want %d", token.Name, saved.Status, token.Status)
+		}
+	}
+	user, _ := model.GetUserById(id, false)
+	if user.Status != common.UserStatusEnabled {
+		t.Fatalf("user status = %d", user.Status)
+	}
+}
@@ -0,0 +1,347 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thanhpk/randstr"
+	"gorm.io/gorm"
+)
+
+type SubscriptionPlanRequest struct {
+	PlanId int `json:"plan_id"`
+}
+
+type AssignSubscriptionRequest struct {
+	UserId int `json:"user_id"`
+	PlanId int `json:"plan_id"`
+	// Periods 开通的周期数，0 表示持续续期直到取消
+	Periods int `json:"periods"`
+}
+
+func subscriptionDisabled(c *gin.Context) bool {
+	if operation_setting.GetSubscriptionSetting().Enabled {
+		return false
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": false,
+		"message": "管理员未开启订阅套餐",
+	})
+	return true
+}
+
+// GetSubscriptionPlans 获取可订阅的套餐列表
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+// GetSelfSubscription 获取当前用户生效中的订阅
+func GetSelfSubscription(c *gin.Context) {
+	sub, err := model.GetLiveUserSubscription(c.GetInt("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.ApiSuccess(c, nil)
+			return
+		}
+		common.ApiError(c, err)
+		return
+	}
+	plan, _ := model.GetSubscriptionPlanById(sub.PlanId)
+	common.ApiSuccess(c, gin.H{
+		"subscription": sub,
+		"plan":         plan,
+	})
+}
+
+// RequestStripeSubscription 拉起 Stripe 订阅支付
+func RequestStripeSubscription(c *gin.Context) {
+	if subscriptionDisabled(c) {
+		return
+	}
+	var req SubscriptionPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || !plan.Enabled {
+		c.JSON(200, gin.H{"message": "error", "data": "套餐不存在"})
+		return
+	}
+	if plan.StripePriceId == "" {
+		c.JSON(200, gin.H{"message": "error", "data": "该套餐不支持在线订阅"})
+		return
+	}
+	id := c.GetInt("id")
+	if _, err := model.GetLiveUserSubscription(id); err == nil {
+		c.JSON(200, gin.H{"message": "error", "data": "已有生效中的订阅，请变更套餐"})
+		return
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "获取用户失败"})
+		return
+	}
+	reference := fmt.Sprintf("new-api-sub-%d-%d-%s", user.Id, time.Now().UnixMilli(), randstr.String(4))
+	referenceId := "sub_" + common.Sha1([]byte(reference))
+	payLink, err := genStripeSubscriptionLink(referenceId, user, plan)
+	if err != nil {
+		log.Println("获取Stripe订阅支付链接失败", err)
+		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "success",
+		"data": gin.H{
+			"pay_link": payLink,
+		},
+	})
+}
+
+// ChangeSelfSubscription 用户变更 Stripe 订阅套餐，升级时按剩余时间补差价
+func ChangeSelfSubscription(c *gin.Context) {
+	if subscriptionDisabled(c) {
+		return
+	}
+	var req SubscriptionPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	sub, err := model.GetLiveUserSubscription(c.GetInt("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "没有生效中的订阅")
+		return
+	}
+	if sub.Provider != model.SubscriptionProviderStripe {
+		common.ApiErrorMsg(c, "当前套餐由管理员开通，请联系管理员变更")
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || !plan.Enabled {
+		common.ApiErrorMsg(c, "套餐不存在")
+		return
+	}
+	if err := changeStripeSubscriptionPlan(sub, plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// CancelSelfSubscription 用户取消订阅，当前周期结束后失效
+func CancelSelfSubscription(c *gin.Context) {
+	sub, err := model.GetLiveUserSubscription(c.GetInt("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "没有生效中的订阅")
+		return
+	}
+	if sub.Provider == model.SubscriptionProviderStripe {
+		if err := cancelStripeSubscription(sub, false); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	if err := model.SetSubscriptionCancelAtPeriodEnd(sub.Id, true); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetAllSubscriptionPlans 管理员获取全部套餐
+func GetAllSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+func validateSubscriptionPlan(plan *model.SubscriptionPlan) error {
+	if plan.Name == "" || len(plan.Name) > 64 {
+		return errors.New("套餐名称长度必须在1-64之间")
+	}
+	if plan.Quota < 0 || plan.Price < 0 {
+		return errors.New("套餐额度和价格不能为负数")
+	}
+	// 未关联 Stripe 价格的套餐按手动套餐周期续期
+	if plan.StripePriceId == "" && operation_setting.GetSubscriptionSetting().PeriodDays <= 0 {
+		return errors.New("手动套餐计费周期天数必须大于 0")
+	}
+	return nil
+}
+
+func AddSubscriptionPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := validateSubscriptionPlan(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan.Id = 0
+	if err := plan.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plan)
+}
+
+func UpdateSubscriptionPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, err := model.GetSubscriptionPlanById(plan.Id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := validateSubscriptionPlan(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := plan.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plan)
+}
+
+func DeleteSubscriptionPlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeleteSubscriptionPlanById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetAllSubscriptions 管理员按用户/状态查询订阅
+func GetAllSubscriptions(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	subs, total, err := model.GetUserSubscriptions(userId, c.Query("status"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(subs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// AssignSubscription 管理员为用户开通手动套餐，适用于未接入 Stripe 的部署
+func AssignSubscription(c *gin.Context) {
+	setting := operation_setting.GetSubscriptionSetting()
+	if !setting.ManualModeEnabled {
+		common.ApiErrorMsg(c, "未开启手动套餐模式")
+		return
+	}
+	// 周期为 0 时每次定时任务都会续期并发放额度
+	if setting.PeriodDays <= 0 {
+		common.ApiErrorMsg(c, "手动套餐计费周期天数必须大于 0")
+		return
+	}
+	var req AssignSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserId == 0 || req.Periods < 0 {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil {
+		common.ApiErrorMsg(c, "套餐不存在")
+		return
+	}
+	now := common.GetTimestamp()
+	periodSeconds := int64(setting.PeriodDays) * 86400
+	sub := &model.UserSubscription{
+		UserId:             req.UserId,
+		PlanId:             plan.Id,
+		Provider:           model.SubscriptionProviderManual,
+		CurrentPeriodStart: now,
+		CurrentPeriodEnd:   now + periodSeconds,
+	}
+	if req.Periods > 0 {
+		sub.ExpireTime = now + periodSeconds*int64(req.Periods)
+	}
+	if err := model.CreateUserSubscription(sub); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.GrantSubscriptionPeriod(sub.Id, sub.CurrentPeriodStart, sub.CurrentPeriodEnd); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(req.UserId, model.LogTypeManage, fmt.Sprintf("管理员开通订阅套餐 %s", plan.Name))
+	common.ApiSuccess(c, sub)
+}
+
+// AdminChangeSubscriptionPlan 管理员变更用户套餐
+func AdminChangeSubscriptionPlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req SubscriptionPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	sub, err := model.GetUserSubscriptionById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil {
+		common.ApiErrorMsg(c, "套餐不存在")
+		return
+	}
+	if sub.Provider == model.SubscriptionProviderStripe {
+		err = changeStripeSubscriptionPlan(sub, plan)
+	} else {
+		err = model.ChangeSubscriptionPlan(sub.Id, plan.Id, operation_setting.GetSubscriptionSetting().ProrationEnabled)
+	}
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// AdminCancelSubscription 管理员立即终止订阅并恢复用户分组
+func AdminCancelSubscription(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	sub, err := model.GetUserSubscriptionById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if sub.Provider == model.SubscriptionProviderStripe && sub.IsLive() {
+		if err := cancelStripeSubscription(sub, true); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	if err := model.EndUserSubscription(sub.Id, model.SubscriptionStatusCanceled); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
@@ -0,0 +1,278 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service/payment"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/subscription"
+	"gorm.io/gorm"
+)
+
+func genStripeSubscriptionLink(referenceId string, user *model.User, plan *model.SubscriptionPlan) (string, error) {
+	if err := payment.SetupStripeKey(); err != nil {
+		return "", err
+	}
+	params := &stripe.CheckoutSessionParams{
+		ClientReferenceID: stripe.String(referenceId),
+		SuccessURL:        stripe.String(system_setting.ServerAddress + "/console/log"),
+		CancelURL:         stripe.String(system_setting.ServerAddress + "/topup"),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(plan.StripePriceId),
+				Quantity: stripe.Int64(1),
+			},
+		},
+		Mode: stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: map[string]string{
+				"user_id": strconv.Itoa(user.Id),
+				"plan_id": strconv.Itoa(plan.Id),
+			},
+		},
+		AllowPromotionCodes: stripe.Bool(setting.StripePromotionCodesEnabled),
+	}
+	// 订阅模式下 Stripe 总会创建客户，不能指定 customer_creation
+	if user.StripeCustomer != "" {
+		params.Customer = stripe.String(user.StripeCustomer)
+	} else if user.Email != "" {
+		params.CustomerEmail = stripe.String(user.Email)
+	}
+	result, err := session.New(params)
+	if err != nil {
+		return "", err
+	}
+	return result.URL, nil
+}
+
+func stripeSubscriptionPriceId(s *stripe.Subscription) string {
+	if s.Items == nil || len(s.Items.Data) == 0 || s.Items.Data[0].Price == nil {
+		return ""
+	}
+	return s.Items.Data[0].Price.ID
+}
+
+// ensureLocalStripeSubscription 根据 Stripe 订阅找到或创建本地订阅记录
+func ensureLocalStripeSubscription(s *stripe.Subscription) (*model.UserSubscription, error) {
+	local, err := model.GetUserSubscriptionByStripeId(s.ID)
+	if err == nil {
+		return local, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	userId, _ := strconv.Atoi(s.Metadata["user_id"])
+	if userId == 0 {
+		return nil, fmt.Errorf("Stripe订阅 %s 缺少用户信息", s.ID)
+	}
+	plan, err := model.GetSubscriptionPlanByStripePriceId(stripeSubscriptionPriceId(s))
+	if err != nil {
+		planId, _ := strconv.Atoi(s.Metadata["plan_id"])
+		plan, err = model.GetSubscriptionPlanById(planId)
+		if err != nil {
+			return nil, fmt.Errorf("Stripe订阅 %s 对应的套餐不存在", s.ID)
+		}
+	}
+	local = &model.UserSubscription{
+		UserId:               userId,
+		PlanId:               plan.Id,
+		Provider:             model.SubscriptionProviderStripe,
+		StripeSubscriptionId: s.ID,
+		CurrentPeriodStart:   s.CurrentPeriodStart,
+		CurrentPeriodEnd:     s.CurrentPeriodEnd,
+	}
+	if err := model.CreateUserSubscription(local); err != nil {
+		// checkout.session.completed 与 invoice.paid 可能并发到达
+		if existing, getErr := model.GetUserSubscriptionByStripeId(s.ID); getErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return local, nil
+}
+
+// syncStripeSubscription 将 Stripe 订阅的套餐、状态与取消标记同步到本地，不发放周期额度
+func syncStripeSubscription(s *stripe.Subscription) (*model.UserSubscription, error) {
+	local, err := ensureLocalStripeSubscription(s)
+	if err != nil {
+		return nil, err
+	}
+	if !local.IsLive() {
+		return local, nil
+	}
+	switch s.Status {
+	case stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusIncompleteExpired:
+		return local, model.EndUserSubscription(local.Id, model.SubscriptionStatusCanceled)
+	case stripe.SubscriptionStatusPastDue, stripe.SubscriptionStatusUnpaid:
+		if err := model.MarkSubscriptionPastDue(local.Id); err != nil {
+			return nil, err
+		}
+	}
+	if plan, err := model.GetSubscriptionPlanByStripePriceId(stripeSubscriptionPriceId(s)); err == nil && plan.Id != local.PlanId {
+		err = model.ChangeSubscriptionPlan(local.Id, plan.Id, operation_setting.GetSubscriptionSetting().ProrationEnabled)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.CancelAtPeriodEnd != local.CancelAtPeriodEnd {
+		if err := model.SetSubscriptionCancelAtPeriodEnd(local.Id, s.CancelAtPeriodEnd); err != nil {
+			return nil, err
+		}
+	}
+	return model.GetUserSubscriptionById(local.Id)
+}
+
+func subscriptionSessionCompleted(event stripe.Event) {
+	customerId := event.GetObjectValue("customer")
+	subscriptionId := event.GetObjectValue("subscription")
+	if err := payment.SetupStripeKey(); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	s, err := subscription.Get(subscriptionId, nil)
+	if err != nil {
+		log.Println("获取Stripe订阅失败", subscriptionId, err)
+		return
+	}
+	local, err := syncStripeSubscription(s)
+	if err != nil {
+		log.Println("同步Stripe订阅失败", subscriptionId, err)
+		return
+	}
+	if customerId != "" {
+		if err := model.UpdateUserStripeCustomer(local.UserId, customerId); err != nil {
+			log.Println("更新Stripe客户失败", local.UserId, err)
+		}
+	}
+	log.Printf("Stripe订阅已创建：%s, 用户 %d", subscriptionId, local.UserId)
+}
+
+func stripeInvoicePaid(event stripe.Event) {
+	var invoice stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+		log.Println("解析Stripe发票失败", err)
+		return
+	}
+	if invoice.Subscription == nil || invoice.Subscription.ID == "" {
+		return
+	}
+	if err := payment.SetupStripeKey(); err != nil {
+		log.Println(err.Error())
+		return
+	}
+	s, err := subscription.Get(invoice.Subscription.ID, nil)
+	if err != nil {
+		log.Println("获取Stripe订阅失败", invoice.Subscription.ID, err)
+		return
+	}
+	local, err := syncStripeSubscription(s)
+	if err != nil {
+		log.Println("同步Stripe订阅失败", s.ID, err)
+		return
+	}
+	switch invoice.BillingReason {
+	case stripe.InvoiceBillingReasonSubscriptionCreate, stripe.InvoiceBillingReasonSubscriptionCycle, stripe.InvoiceBillingReasonSubscription:
+		if err := model.GrantSubscriptionPeriod(local.Id, s.CurrentPeriodStart, s.CurrentPeriodEnd); err != nil {
+			log.Println("发放订阅额度失败", s.ID, err)
+			return
+		}
+	}
+	log.Printf("收到订阅款项：%s, %.2f(%s)", s.ID, float64(invoice.AmountPaid)/100, strings.ToUpper(string(invoice.Currency)))
+}
+
+func stripeInvoicePaymentFailed(event stripe.Event) {
+	subscriptionId := event.GetObjectValue("subscription")
+	local, err := model.GetUserSubscriptionByStripeId(subscriptionId)
+	if err != nil {
+		return
+	}
+	if err := model.MarkSubscriptionPastDue(local.Id); err != nil {
+		log.Println("更新订阅宽限期失败", subscriptionId, err)
+	}
+}
+
+func stripeSubscriptionUpdated(event stripe.Event) {
+	var s stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		log.Println("解析Stripe订阅失败", err)
+		return
+	}
+	if _, err := syncStripeSubscription(&s); err != nil {
+		log.Println("同步Stripe订阅失败", s.ID, err)
+	}
+}
+
+func stripeSubscriptionDeleted(event stripe.Event) {
+	subscriptionId := event.GetObjectValue("id")
+	local, err := model.GetUserSubscriptionByStripeId(subscriptionId)
+	if err != nil {
+		return
+	}
+	if err := model.EndUserSubscription(local.Id, model.SubscriptionStatusCanceled); err != nil {
+		log.Println("结束Stripe订阅失败", subscriptionId, err)
+	}
+}
+
+// changeStripeSubscriptionPlan 在 Stripe 侧切换价格并立即开具差价发票，付款成功后才生效
+func changeStripeSubscriptionPlan(local *model.UserSubscription, plan *model.SubscriptionPlan) error {
+	if plan.StripePriceId == "" {
+		return errors.New("该套餐未配置 Stripe 价格")
+	}
+	if err := payment.SetupStripeKey(); err != nil {
+		return err
+	}
+	s, err := subscription.Get(local.StripeSubscriptionId, nil)
+	if err != nil {
+		return err
+	}
+	if s.Items == nil || len(s.Items.Data) == 0 {
+		return errors.New("Stripe订阅缺少订阅项")
+	}
+	prorationBehavior := "none"
+	if operation_setting.GetSubscriptionSetting().ProrationEnabled {
+		prorationBehavior = "always_invoice"
+	}
+	s, err = subscription.Update(s.ID, &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:    stripe.String(s.Items.Data[0].ID),
+				Price: stripe.String(plan.StripePriceId),
+			},
+		},
+		ProrationBehavior: stripe.String(prorationBehavior),
+		PaymentBehavior:   stripe.String("pending_if_incomplete"),
+	})
+	if err != nil {
+		return err
+	}
+	if s.PendingUpdate != nil {
+		return errors.New("差价付款未完成，套餐将在付款成功后变更")
+	}
+	_, err = syncStripeSubscription(s)
+	return err
+}
+
+func cancelStripeSubscription(local *model.UserSubscription, immediately bool) error {
+	if err := payment.SetupStripeKey(); err != nil {
+		return err
+	}
+	if immediately {
+		_, err := subscription.Cancel(local.StripeSubscriptionId, nil)
+		return err
+	}
+	_, err := subscription.Update(local.StripeSubscriptionId, &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(true),
+	})
+	return err
+}
@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+func TestValidateSubscriptionPlanRequiresPeriodDays(t *testing.T) {
+	setting := operation_setting.GetSubscriptionSetting()
+	saved := setting.PeriodDays
+	t.Cleanup(func() {
+		setting.PeriodDays = saved
+	})
+
+	setting.PeriodDays = 0
+	if err := validateSubscriptionPlan(&model.SubscriptionPlan{Name: "basic", Quota: 100}); err == nil {
+		t.Fatal("manual plan should be rejected when period days is 0")
+	}
+	// 关联 Stripe 价格的套餐由 Stripe 决定周期
+	if err := validateSubscriptionPlan(&model.SubscriptionPlan{Name: "basic", Quota: 100, StripePriceId: "price_1"}); err != nil {
+		t.Fatalf("stripe plan should be accepted, got %v", err)
+	}
+
+	setting.PeriodDays = 30
+	if err := validateSubscriptionPlan(&model.SubscriptionPlan{Name: "basic", Quota: 100}); err != nil {
+		t.Fatalf("manual plan should be accepted, got %v", err)
+	}
+}
@@ -169,7 +169,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 						logger.LogError(ctx, "fail to increase user quota: "+err.Error())
 					}
 					logContent := fmt.Sprintf("异步任务执行失败 %s，补偿 %s", task.TaskID, logger.LogQuota(quota))
-					model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
+					model.RecordQuotaLog(task.UserId, model.LogTypeRefund, logContent, quota, nil)
 				}
 			}
 		}
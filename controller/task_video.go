@@ -242,7 +242,7 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 					logger.LogWarn(ctx, "Failed to increase user quota: "+err.Error())
 				}
 				logContent := fmt.Sprintf("Video async task failed %s, refund %s", task.TaskID, logger.LogQuota(quota))
-				model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
+				model.RecordQuotaLog(task.UserId, model.LogTypeRefund, logContent, quota, nil)
 			} else {
 				logger.LogWarn(ctx, fmt.Sprintf("Task %s already in failure status, skip refund", task.TaskID))
 			}
@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TokenizeRequest struct {
+	Model     string `json:"model"`
+	Tokenizer string `json:"tokenizer"`
+	Text      string `json:"text"`
+}
+
+// Tokenize 调试用，查看模型实际使用的分词器与分词结果
+func Tokenize(c *gin.Context) {
+	var req TokenizeRequest
+	if err := common.DecodeJson(c.Request.Body, &req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Model == "" && req.Tokenizer == "" {
+		common.ApiErrorMsg(c, "模型名称与分词器不能同时为空")
+		return
+	}
+	result, err := service.Tokenize(req.Model, req.Tokenizer, req.Text)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, result)
+}
+
+// ReloadTokenizers 清空分词器缓存，更新词表文件或目录后下次使用时重新加载
+func ReloadTokenizers(c *gin.Context) {
+	service.ResetTokenizerCache()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -3,31 +3,29 @@ package controller
 import (
 	"fmt"
 	"log"
-	"net/url"
+	"net/http"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
-	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/service/payment"
 	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
 
-	"github.com/Calcium-Ion/go-epay/epay"
 	"github.com/gin-gonic/gin"
-	"github.com/samber/lo"
 	"github.com/shopspring/decimal"
 )
 
 func GetTopUpInfo(c *gin.Context) {
 	// 获取支付方式
-	payMethods := operation_setting.PayMethods
+	payMethods := append([]map[string]string{}, operation_setting.PayMethods...)
 
 	// 如果启用了 Stripe 支付，添加到支付方法列表
-	if setting.StripeApiSecret != "" && setting.StripeWebhookSecret != "" && setting.StripePriceId != "" {
+	if payment.IsEnabled(payment.ProviderStripe) {
 		// 检查是否已经包含 Stripe
 		hasStripe := false
 		for _, method := range payMethods {
@@ -48,9 +46,20 @@ func GetTopUpInfo(c *gin.Context) {
 		}
 	}
 
+	// 直连支付宝、微信支付与易支付共用充值入口与价格设置
+	directMethods := []map[string]string{
+		{"name": "支付宝", "type": payment.ProviderAlipayDirect, "color": "rgba(var(--semi-blue-5), 1)"},
+		{"name": "微信支付", "type": payment.ProviderWechatNative, "color": "rgba(var(--semi-green-5), 1)"},
+	}
+	for _, method := range directMethods {
+		if payment.IsEnabled(method["type"]) {
+			payMethods = append(payMethods, method)
+		}
+	}
+
 	data := gin.H{
-		"enable_online_topup": operation_setting.PayAddress != "" && operation_setting.EpayId != "" && operation_setting.EpayKey != "",
-		"enable_stripe_topup": setting.StripeApiSecret != "" && setting.StripeWebhookSecret != "" && setting.StripePriceId != "",
+		"enable_online_topup": payment.IsEnabled(payment.ProviderEpay) || payment.IsEnabled(payment.ProviderAlipayDirect) || payment.IsEnabled(payment.ProviderWechatNative),
+		"enable_stripe_topup": payment.IsEnabled(payment.ProviderStripe),
 		"pay_methods":         payMethods,
 		"min_topup":           operation_setting.MinTopUp,
 		"stripe_min_topup":    setting.StripeMinTopUp,
@@ -71,20 +80,6 @@ type AmountRequest struct {
 	TopUpCode string `json:"top_up_code"`
 }
 
-func GetEpayClient() *epay.Client {
-	if operation_setting.PayAddress == "" || operation_setting.EpayId == "" || operation_setting.EpayKey == "" {
-		return nil
-	}
-	withUrl, err := epay.NewClient(&epay.Config{
-		PartnerID: operation_setting.EpayId,
-		Key:       operation_setting.EpayKey,
-	}, operation_setting.PayAddress)
-	if err != nil {
-		return nil
-	}
-	return withUrl
-}
-
 func getPayMoney(amount int64, group string) float64 {
 	dAmount := decimal.NewFromInt(amount)
 	// 充值金额以“展示类型”为准：
@@ -132,6 +127,18 @@ func RequestEpay(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
 		return
 	}
+	providerName := payment.ProviderEpay
+	if req.PaymentMethod == payment.ProviderAlipayDirect || req.PaymentMethod == payment.ProviderWechatNative {
+		providerName = req.PaymentMethod
+	} else if !operation_setting.ContainsPayMethod(req.PaymentMethod) {
+		c.JSON(200, gin.H{"message": "error", "data": "支付方式不存在"})
+		return
+	}
+	provider, err := payment.GetProvider(providerName)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
+		return
+	}
 	if req.Amount < getMinTopup() {
 		c.JSON(200, gin.H{"message": "error", "data": fmt.Sprintf("充值数量不能小于 %d", getMinTopup())})
 		return
@@ -149,31 +156,20 @@ func RequestEpay(c *gin.Context) {
 		return
 	}
 
-	if !operation_setting.ContainsPayMethod(req.PaymentMethod) {
-		c.JSON(200, gin.H{"message": "error", "data": "支付方式不存在"})
-		return
-	}
-
-	callBackAddress := service.GetCallbackAddress()
-	returnUrl, _ := url.Parse(system_setting.ServerAddress + "/console/log")
-	notifyUrl, _ := url.Parse(callBackAddress + "/api/user/epay/notify")
 	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
 	tradeNo = fmt.Sprintf("USR%dNO%s", id, tradeNo)
-	client := GetEpayClient()
-	if client == nil {
-		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
-		return
-	}
-	uri, params, err := client.Purchase(&epay.PurchaseArgs{
-		Type:           req.PaymentMethod,
-		ServiceTradeNo: tradeNo,
-		Name:           fmt.Sprintf("TUC%d", req.Amount),
-		Money:          strconv.FormatFloat(payMoney, 'f', 2, 64),
-		Device:         epay.PC,
-		NotifyUrl:      notifyUrl,
-		ReturnUrl:      returnUrl,
+	order, err := provider.CreateOrder(&payment.CreateOrderRequest{
+		TradeNo:   tradeNo,
+		Subject:   fmt.Sprintf("TUC%d", req.Amount),
+		Money:     payMoney,
+		Amount:    req.Amount,
+		PayMethod: req.PaymentMethod,
+		NotifyUrl: service.GetCallbackAddress() + "/api/payment/" + provider.Name() + "/notify",
+		ReturnUrl: system_setting.ServerAddress + "/console/log",
+		ClientIp:  c.ClientIP(),
 	})
 	if err != nil {
+		log.Println("拉起支付失败", provider.Name(), err)
 		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
 		return
 	}
@@ -184,20 +180,34 @@ func RequestEpay(c *gin.Context) {
 		amount = dAmount.Div(dQuotaPerUnit).IntPart()
 	}
 	topUp := &model.TopUp{
-		UserId:        id,
-		Amount:        amount,
-		Money:         payMoney,
-		TradeNo:       tradeNo,
-		PaymentMethod: req.PaymentMethod,
-		CreateTime:    time.Now().Unix(),
-		Status:        "pending",
+		UserId:          id,
+		Amount:          amount,
+		Money:           payMoney,
+		TradeNo:         tradeNo,
+		PaymentMethod:   req.PaymentMethod,
+		Provider:        provider.Name(),
+		ProviderTradeNo: order.ProviderTradeNo,
+		CreateTime:      time.Now().Unix(),
+		Status:          common.TopUpStatusPending,
 	}
 	err = topUp.Insert()
 	if err != nil {
 		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "success", "data": params, "url": uri})
+	if order.Params == nil {
+		c.JSON(200, gin.H{
+			"message": "success",
+			"data": gin.H{
+				"pay_link": order.PayLink,
+				"qr_code":  order.QRCode,
+				"trade_no": tradeNo,
+			},
+			"url": order.PayLink,
+		})
+		return
+	}
+	c.JSON(200, gin.H{"message": "success", "data": order.Params, "url": order.PayLink})
 }
 
 // tradeNo lock
@@ -227,67 +237,44 @@ func UnlockOrder(tradeNo string) {
 	}
 }
 
-func EpayNotify(c *gin.Context) {
-	params := lo.Reduce(lo.Keys(c.Request.URL.Query()), func(r map[string]string, t string, i int) map[string]string {
-		r[t] = c.Request.URL.Query().Get(t)
-		return r
-	}, map[string]string{})
-	client := GetEpayClient()
-	if client == nil {
-		log.Println("易支付回调失败 未找到配置信息")
-		_, err := c.Writer.Write([]byte("fail"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-		}
+// paymentNotify 验证并处理支付回调，按提供方要求的格式应答
+func paymentNotify(c *gin.Context, providerName string) {
+	provider, err := payment.GetProvider(providerName)
+	if err != nil {
+		log.Println("支付回调失败", providerName, err)
+		c.String(http.StatusBadRequest, "fail")
 		return
 	}
-	verifyInfo, err := client.Verify(params)
-	if err == nil && verifyInfo.VerifyStatus {
-		_, err := c.Writer.Write([]byte("success"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-		}
-	} else {
-		_, err := c.Writer.Write([]byte("fail"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-		}
-		log.Println("易支付回调签名验证失败")
+	result, err := provider.VerifyNotify(c.Request)
+	if err != nil {
+		log.Println("支付回调验证失败", providerName, err)
+		provider.AckNotify(c.Writer, err)
+		return
+	}
+	if err := handlePaymentNotify(result); err != nil {
+		log.Println("支付回调处理失败", providerName, result.TradeNo, err)
+		provider.AckNotify(c.Writer, err)
 		return
 	}
+	provider.AckNotify(c.Writer, nil)
+}
 
-	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
-		log.Println(verifyInfo)
-		LockOrder(verifyInfo.ServiceTradeNo)
-		defer UnlockOrder(verifyInfo.ServiceTradeNo)
-		topUp := model.GetTopUpByTradeNo(verifyInfo.ServiceTradeNo)
-		if topUp == nil {
-			log.Printf("易支付回调未找到订单: %v", verifyInfo)
-			return
-		}
-		if topUp.Status == "pending" {
-			topUp.Status = "success"
-			err := topUp.Update()
-			if err != nil {
-				log.Printf("易支付回调更新订单失败: %v", topUp)
-				return
-			}
-			//user, _ := model.GetUserById(topUp.UserId, false)
-			//user.Quota += topUp.Amount * 500000
-			dAmount := decimal.NewFromInt(int64(topUp.Amount))
-			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
-			quotaToAdd := int(dAmount.Mul(dQuotaPerUnit).IntPart())
-			err = model.IncreaseUserQuota(topUp.UserId, quotaToAdd, true)
-			if err != nil {
-				log.Printf("易支付回调更新用户失败: %v", topUp)
-				return
-			}
-			log.Printf("易支付回调更新用户成功 %v", topUp)
-			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", logger.LogQuota(quotaToAdd), topUp.Money))
-		}
-	} else {
-		log.Printf("易支付异常回调: %v", verifyInfo)
+func handlePaymentNotify(result *payment.NotifyResult) error {
+	if result.TradeNo == "" {
+		return nil
 	}
+	LockOrder(result.TradeNo)
+	defer UnlockOrder(result.TradeNo)
+	return payment.ApplyNotify(result)
+}
+
+func EpayNotify(c *gin.Context) {
+	paymentNotify(c, payment.ProviderEpay)
+}
+
+// PaymentNotify 通用支付回调入口：/api/payment/:provider/notify
+func PaymentNotify(c *gin.Context) {
+	paymentNotify(c, c.Param("provider"))
 }
 
 func RequestAmount(c *gin.Context) {
@@ -388,3 +375,52 @@ func AdminCompleteTopUp(c *gin.Context) {
 	}
 	common.ApiSuccess(c, nil)
 }
+
+type AdminRefundTopUpRequest struct {
+	TradeNo string  `json:"trade_no"`
+	Money   float64 `json:"money"`
+	Reason  string  `json:"reason"`
+}
+
+// AdminRefundTopUp 管理员发起退款，原路退回并扣除对应额度
+func AdminRefundTopUp(c *gin.Context) {
+	var req AdminRefundTopUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TradeNo == "" || req.Money <= 0 {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+
+	LockOrder(req.TradeNo)
+	defer UnlockOrder(req.TradeNo)
+
+	quota, err := payment.RefundOrder(req.TradeNo, req.Money, req.Reason)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{"quota": quota})
+}
+
+// AdminSyncTopUp 管理员主动查询第三方订单并同步状态
+func AdminSyncTopUp(c *gin.Context) {
+	var req AdminCompleteTopupRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TradeNo == "" {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+
+	LockOrder(req.TradeNo)
+	defer UnlockOrder(req.TradeNo)
+
+	topUp := model.GetTopUpByTradeNo(req.TradeNo)
+	if topUp == nil {
+		common.ApiErrorMsg(c, "充值订单不存在")
+		return
+	}
+	status, err := payment.SyncOrder(topUp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{"status": status})
+}
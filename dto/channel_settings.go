@@ -7,6 +7,45 @@ type ChannelSettings struct {
 	PassThroughBodyEnabled bool   `json:"pass_through_body_enabled,omitempty"`
 	SystemPrompt           string `json:"system_prompt,omitempty"`
 	SystemPromptOverride   bool   `json:"system_prompt_override,omitempty"`
+	// 上游不支持 response_format 时，通过提示词注入 + 校验 + 重试模拟结构化输出
+	JsonSchemaEmulation  bool `json:"json_schema_emulation,omitempty"`
+	JsonSchemaMaxRetries int  `json:"json_schema_max_retries,omitempty"` // 校验失败后的最大重试次数，默认 2
+	// 上游不支持 tools 时，通过提示词模板模拟函数调用并将输出解析回 tool_calls
+	ToolCallEmulation bool `json:"tool_call_emulation,omitempty"`
+	// OpenAI 格式请求转换为 Claude（含 Bedrock、Vertex）时自动插入 cache_control 断点
+	PromptCache *PromptCacheSettings `json:"prompt_cache,omitempty"`
+	// 渠道的上游成本，用于记录每次请求的成本并统计毛利
+	UpstreamCost *UpstreamCostSettings `json:"upstream_cost,omitempty"`
+	// 渠道能力声明，选择渠道时跳过不满足请求的渠道，未声明的能力按模型目录判断
+	Capabilities *ChannelCapabilities `json:"capabilities,omitempty"`
+	// 上游账号的并发上限，达到上限的渠道或密钥在选择时跳过，0 表示不限制
+	MaxConcurrency    int `json:"max_concurrency,omitempty"`     // 整个渠道的最大进行中请求数
+	KeyMaxConcurrency int `json:"key_max_concurrency,omitempty"` // 多密钥渠道中每个密钥的最大进行中请求数
+}
+
+// ChannelCapabilities 渠道能力声明，nil 表示未声明
+type ChannelCapabilities struct {
+	Vision           *bool `json:"vision,omitempty"`             // 图片输入
+	Audio            *bool `json:"audio,omitempty"`              // 音频输入
+	Tools            *bool `json:"tools,omitempty"`              // 函数调用
+	JsonSchema       *bool `json:"json_schema,omitempty"`        // response_format json_schema
+	MaxContextTokens int   `json:"max_context_tokens,omitempty"` // 最大输入 token 数，0 表示按模型目录的上下文长度
+}
+
+// PromptCacheSettings 自动缓存策略，Claude 单个请求最多 4 个断点，按工具、系统提示、最近对话的顺序分配
+type PromptCacheSettings struct {
+	System    bool   `json:"system,omitempty"`     // 缓存系统提示
+	Tools     bool   `json:"tools,omitempty"`      // 缓存工具定义
+	LastTurns int    `json:"last_turns,omitempty"` // 缓存到最近 N 条 user 消息为止的对话
+	TTL       string `json:"ttl,omitempty"`        // 5m 或 1h，默认 5m
+}
+
+// UpstreamCostSettings 上游成本倍率，按上游模型名配置；未配置的模型按公开倍率乘以 Multiplier 计算
+type UpstreamCostSettings struct {
+	Multiplier      float64            `json:"multiplier,omitempty"`       // 默认 1
+	ModelRatio      map[string]float64 `json:"model_ratio,omitempty"`      // 成本模型倍率
+	CompletionRatio map[string]float64 `json:"completion_ratio,omitempty"` // 成本补全倍率，未配置时沿用公开补全倍率
+	ModelPrice      map[string]float64 `json:"model_price,omitempty"`      // 按次计费模型的单次成本（美元）
 }
 
 type VertexKeyType string
@@ -31,6 +70,7 @@ type ChannelOtherSettings struct {
 	DisableStore          bool          `json:"disable_store,omitempty"`           // 是否禁用 store 透传（默认允许透传，禁用后可能导致 Codex 无法使用）
 	AllowSafetyIdentifier bool          `json:"allow_safety_identifier,omitempty"` // 是否允许 safety_identifier 透传（默认过滤以保护用户隐私）
 	AwsKeyType            AwsKeyType    `json:"aws_key_type,omitempty"`
+	ResponsesToChat       bool          `json:"responses_to_chat,omitempty"` // 上游不支持 Responses API 时，将 /v1/responses 请求转换为 Chat Completions 发送
 }
 
 func (s *ChannelOtherSettings) IsOpenRouterEnterprise() bool {
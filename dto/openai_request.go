@@ -232,6 +232,26 @@ func (r *GeneralOpenAIRequest) GetSystemRoleName() string {
 	return "system"
 }
 
+// AppendSystemPrompt 将提示追加到系统消息末尾，没有系统消息时在开头新增一条
+func (r *GeneralOpenAIRequest) AppendSystemPrompt(prompt string) {
+	systemRole := r.GetSystemRoleName()
+	for i, message := range r.Messages {
+		if message.Role != systemRole && message.Role != "system" {
+			continue
+		}
+		if message.IsStringContent() {
+			r.Messages[i].SetStringContent(message.StringContent() + "\n\n" + prompt)
+		} else {
+			r.Messages[i].Content = append(message.ParseContent(), MediaContent{
+				Type: ContentTypeText,
+				Text: prompt,
+			})
+		}
+		return
+	}
+	r.Messages = append([]Message{{Role: systemRole, Content: prompt}}, r.Messages...)
+}
+
 type ToolCallRequest struct {
 	ID       string          `json:"id,omitempty"`
 	Type     string          `json:"type"`
@@ -284,6 +304,7 @@ type Message struct {
 	Reasoning        string          `json:"reasoning,omitempty"`
 	ToolCalls        json.RawMessage `json:"tool_calls,omitempty"`
 	ToolCallId       string          `json:"tool_call_id,omitempty"`
+	Refusal          *string         `json:"refusal,omitempty"`
 	parsedContent    []MediaContent
 	//parsedStringContent *string
 }
@@ -799,7 +820,7 @@ type OpenAIResponsesRequest struct {
 	Store          json.RawMessage `json:"store,omitempty"`
 	PromptCacheKey json.RawMessage `json:"prompt_cache_key,omitempty"`
 	Stream         bool            `json:"stream,omitempty"`
-	Temperature    float64         `json:"temperature,omitempty"`
+	Temperature    *float64        `json:"temperature,omitempty"`
 	Text           json.RawMessage `json:"text,omitempty"`
 	ToolChoice     json.RawMessage `json:"tool_choice,omitempty"`
 	Tools          json.RawMessage `json:"tools,omitempty"` // 需要处理的参数很少，MCP 参数太多不确定，所以用 map
@@ -9,6 +9,16 @@ type OpenAIModels struct {
 	Created                int                     `json:"created"`
 	OwnedBy                string                  `json:"owned_by"`
 	SupportedEndpointTypes []constant.EndpointType `json:"supported_endpoint_types"`
+
+	// 扩展字段：模型目录中配置的能力信息
+	ContextLength     int      `json:"context_length,omitempty"`
+	MaxOutputTokens   int      `json:"max_output_tokens,omitempty"`
+	InputModalities   []string `json:"input_modalities,omitempty"`
+	OutputModalities  []string `json:"output_modalities,omitempty"`
+	SupportsTools     *bool    `json:"supports_tools,omitempty"`
+	SupportsVision    *bool    `json:"supports_vision,omitempty"`
+	SupportsReasoning *bool    `json:"supports_reasoning,omitempty"`
+	KnowledgeCutoff   string   `json:"knowledge_cutoff,omitempty"`
 }
 
 type AnthropicModel struct {
@@ -19,6 +19,7 @@ import (
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/router"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/service/payment"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 
 	"github.com/bytedance/gopkg/util/gopool"
@@ -89,9 +90,24 @@ func main() {
 	// 热更新配置
 	go model.SyncOptions(common.SyncFrequency)
 
+	// 用户价格覆盖
+	model.InitPriceOverrideCache()
+	go model.SyncPriceOverrideCache(common.SyncFrequency)
+
 	// 数据看板
 	go model.UpdateQuotaData()
 
+	// 月度账单
+	go model.AutoGenerateBillingStatements()
+	// 订阅套餐续期
+	go model.AutoProcessSubscriptions()
+	// 充值订单对账
+	go payment.AutoReconcileTopUps()
+	// 清理过期的 Responses 会话
+	go model.AutoCleanStoredResponses()
+	// 定时启用价格版本
+	go model.AutoActivatePriceVersions()
+
 	if os.Getenv("CHANNEL_UPDATE_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_UPDATE_FREQUENCY"))
 		if err != nil {
@@ -16,6 +16,7 @@ import (
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/types"
 
@@ -29,7 +30,10 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		// 排队时预留的并发名额未被转发使用（如请求提前失败）时在请求结束后释放
+		defer model.ReleaseReservedChannelSlot(c)
 		var channel *model.Channel
+		pinnedKeyIndex := -1
 		channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId)
 		modelRequest, shouldSelectChannel, err := getModelRequest(c)
 		if err != nil {
@@ -152,12 +156,23 @@ func Distribute() func(c *gin.Context) {
 					}
 				}
 
-				channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, 0)
+				channel, selectGroup, pinnedKeyIndex = getResponsesPinnedChannel(c, userGroup, modelRequest.Model)
+				if channel == nil {
+					channel, selectGroup, pinnedKeyIndex = getSessionPinnedChannel(c, userGroup, modelRequest.Model)
+				}
+				if channel == nil {
+					channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, 0)
+				}
 				if err != nil {
 					showGroup := userGroup
 					if userGroup == "auto" {
 						showGroup = fmt.Sprintf("auto(%s)", selectGroup)
 					}
+					var saturated *model.ChannelsSaturatedError
+					if errors.As(err, &saturated) {
+						abortWithOpenAiMessage(c, http.StatusTooManyRequests, err.Error(), string(types.ErrorCodeChannelsSaturated))
+						return
+					}
 					message := fmt.Sprintf("获取分组 %s 下模型 %s 的可用渠道失败（distributor）: %s", showGroup, modelRequest.Model, err.Error())
 					// 如果错误，但是渠道不为空，说明是数据库一致性问题
 					//if channel != nil {
@@ -175,10 +190,81 @@ func Distribute() func(c *gin.Context) {
 		}
 		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
 		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		if pinnedKeyIndex >= 0 {
+			pinChannelKey(c, channel, pinnedKeyIndex)
+		}
 		c.Next()
 	}
 }
 
+// getResponsesPinnedChannel 上游保存了 previous_response_id 对应的响应时，固定到原渠道，返回渠道、分组与密钥下标
+func getResponsesPinnedChannel(c *gin.Context, group string, modelName string) (*model.Channel, string, int) {
+	if c.Request.Method != http.MethodPost || c.Request.URL.Path != "/v1/responses" || !model_setting.GetResponsesSettings().StoreEnabled {
+		return nil, group, -1
+	}
+	var request struct {
+		PreviousResponseId string `json:"previous_response_id"`
+	}
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil || request.PreviousResponseId == "" {
+		return nil, group, -1
+	}
+	stored, err := model.GetStoredResponse(request.PreviousResponseId, common.GetContextKeyInt(c, constant.ContextKeyUserId))
+	if err != nil || !stored.Upstream {
+		return nil, group, -1
+	}
+	channel, selectGroup, err := model.CacheGetSatisfiedChannelById(c, group, modelName, stored.ChannelId)
+	if err != nil {
+		// 原渠道不可用时由网关回放会话
+		logger.LogInfo(c, fmt.Sprintf("previous response %s can not be pinned to channel #%d: %s", stored.ResponseId, stored.ChannelId, err.Error()))
+		return nil, group, -1
+	}
+	return channel, selectGroup, stored.ChannelKeyIndex
+}
+
+// getSessionPinnedChannel 开启会话粘性时，同一会话固定到上次成功的渠道与密钥，原渠道不可用时重新选择
+func getSessionPinnedChannel(c *gin.Context, group string, modelName string) (*model.Channel, string, int) {
+	key := service.GetSessionAffinityKey(c, group, modelName)
+	if key == "" {
+		return nil, group, -1
+	}
+	common.SetContextKey(c, constant.ContextKeySessionAffinityKey, key)
+	affinity, ok := service.GetSessionAffinity(key)
+	if !ok {
+		return nil, group, -1
+	}
+	channel, selectGroup, err := model.CacheGetSatisfiedChannelById(c, group, modelName, affinity.ChannelId)
+	if err != nil {
+		logger.LogInfo(c, fmt.Sprintf("session can not be pinned to channel #%d: %s", affinity.ChannelId, err.Error()))
+		// 并发已满只是暂时不可用，保留绑定，本次请求成功后会改绑到新选择的渠道
+		var saturatedErr *model.ChannelsSaturatedError
+		if !errors.As(err, &saturatedErr) {
+			_ = service.DeleteSessionAffinity(key)
+		}
+		return nil, group, -1
+	}
+	common.SetContextKey(c, constant.ContextKeySessionAffinityHit, true)
+	return channel, selectGroup, affinity.KeyIndex
+}
+
+// pinChannelKey 多密钥渠道使用指定下标的密钥，密钥已禁用或并发已满时保持原选择
+func pinChannelKey(c *gin.Context, channel *model.Channel, index int) {
+	if !channel.ChannelInfo.IsMultiKey {
+		return
+	}
+	keys := channel.GetKeys()
+	if index >= len(keys) {
+		return
+	}
+	if status, ok := channel.ChannelInfo.MultiKeyStatusList[index]; ok && status != common.ChannelStatusEnabled {
+		return
+	}
+	if channel.IsKeySaturated(index) {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyChannelMultiKeyIndex, index)
+	common.SetContextKey(c, constant.ContextKeyChannelKey, keys[index])
+}
+
 func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	var modelRequest ModelRequest
 	shouldSelectChannel := true
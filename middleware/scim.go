@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func abortWithSCIMError(c *gin.Context, statusCode int, detail string) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(statusCode, dto.SCIMError{
+		Schemas: []string{dto.SCIMSchemaError},
+		Status:  strconv.Itoa(statusCode),
+		Detail:  detail,
+	})
+	c.Abort()
+}
+
+// SCIMAuth 校验 IdP 使用的 SCIM Bearer Token，与用户 access token 相互独立
+func SCIMAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		settings := system_setting.GetSCIMSettings()
+		if !settings.Enabled || settings.Token == "" {
+			abortWithSCIMError(c, http.StatusNotFound, "SCIM provisioning is disabled")
+			return
+		}
+		authorization := c.Request.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			abortWithSCIMError(c, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(settings.Token)) != 1 {
+			abortWithSCIMError(c, http.StatusUnauthorized, "invalid bearer token")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,330 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"gorm.io/gorm"
+)
+
+const BillingStatementPeriodLayout = "2006-01"
+
+// BillingStatement 用户月度账单，按 用户+月份 唯一，重复生成会覆盖
+type BillingStatement struct {
+	Id               int     `json:"id"`
+	UserId           int     `json:"user_id" gorm:"uniqueIndex:idx_statement_user_period,priority:1"`
+	Username         string  `json:"username" gorm:"type:varchar(64);default:''"`
+	Period           string  `json:"period" gorm:"type:varchar(7);uniqueIndex:idx_statement_user_period,priority:2;index"`
+	PeriodStart      int64   `json:"period_start" gorm:"bigint"`
+	PeriodEnd        int64   `json:"period_end" gorm:"bigint"`
+	TopUpCount       int     `json:"top_up_count" gorm:"default:0"`
+	TopUpAmount      int64   `json:"top_up_amount" gorm:"default:0"`
+	TopUpMoney       float64 `json:"top_up_money" gorm:"default:0"`
+	RedemptionCount  int     `json:"redemption_count" gorm:"default:0"`
+	RedemptionQuota  int64   `json:"redemption_quota" gorm:"default:0"`
+	RefundCount      int     `json:"refund_count" gorm:"default:0"`
+	RefundQuota      int64   `json:"refund_quota" gorm:"default:0"`
+	ConsumeCount     int64   `json:"consume_count" gorm:"default:0"`
+	ConsumeQuota     int64   `json:"consume_quota" gorm:"default:0"`
+	PromptTokens     int64   `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens int64   `json:"completion_tokens" gorm:"default:0"`
+	Detail           string  `json:"-" gorm:"type:text"` // JSON，充值明细与按模型消费明细
+	CreatedTime      int64   `json:"created_time" gorm:"bigint"`
+	UpdatedTime      int64   `json:"updated_time" gorm:"bigint"`
+}
+
+// BillingStatementDetail 账单明细
+type BillingStatementDetail struct {
+	TopUps []StatementTopUp      `json:"top_ups"`
+	Models []StatementModelUsage `json:"models"`
+	// Source 为消费数据来源：logs（消费日志）或 quota_data（数据看板）
+	Source string `json:"source"`
+}
+
+type StatementTopUp struct {
+	TradeNo       string  `json:"trade_no"`
+	PaymentMethod string  `json:"payment_method"`
+	Amount        int64   `json:"amount"`
+	Money         float64 `json:"money"`
+	CompleteTime  int64   `json:"complete_time"`
+}
+
+type StatementModelUsage struct {
+	ModelName        string `json:"model_name"`
+	Count            int64  `json:"count"`
+	Quota            int64  `json:"quota"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+}
+
+func (s *BillingStatement) GetDetail() BillingStatementDetail {
+	detail := BillingStatementDetail{}
+	if s.Detail != "" {
+		if err := json.Unmarshal([]byte(s.Detail), &detail); err != nil {
+			common.SysLog("failed to unmarshal statement detail: " + err.Error())
+		}
+	}
+	return detail
+}
+
+func (s *BillingStatement) SetDetail(detail BillingStatementDetail) {
+	detailBytes, err := json.Marshal(detail)
+	if err != nil {
+		common.SysLog("failed to marshal statement detail: " + err.Error())
+		return
+	}
+	s.Detail = string(detailBytes)
+}
+
+// InvoiceNumber 发票编号，形如 INV-202601-000001
+func (s *BillingStatement) InvoiceNumber() string {
+	prefix := operation_setting.GetInvoiceSetting().InvoicePrefix
+	period := s.Period
+	if t, err := time.ParseInLocation(BillingStatementPeriodLayout, s.Period, time.Local); err == nil {
+		period = t.Format("200601")
+	}
+	if prefix == "" {
+		return fmt.Sprintf("%s-%06d", period, s.UserId)
+	}
+	return fmt.Sprintf("%s-%s-%06d", prefix, period, s.UserId)
+}
+
+// ParseBillingPeriod 解析 2006-01 格式的账期，返回账期起止时间戳（左闭右开）
+func ParseBillingPeriod(period string) (int64, int64, error) {
+	start, err := time.ParseInLocation(BillingStatementPeriodLayout, period, time.Local)
+	if err != nil {
+		return 0, 0, errors.New("账期格式错误，应为 YYYY-MM")
+	}
+	if start.After(time.Now()) {
+		return 0, 0, errors.New("不能生成未来月份的账单")
+	}
+	return start.Unix(), start.AddDate(0, 1, 0).Unix(), nil
+}
+
+// IsBillingPeriodClosed 账期结束后才视为已结账，未结束的账期仍会产生新的充值和消费
+func IsBillingPeriodClosed(period string) bool {
+	_, end, err := ParseBillingPeriod(period)
+	return err == nil && end <= common.GetTimestamp()
+}
+
+// GenerateBillingStatement 汇总指定用户某月的充值、兑换、退款及按模型消费，生成或覆盖账单；
+// 未结束的账期只即时汇总，不写入数据库，避免之后读取到过期的账单
+func GenerateBillingStatement(userId int, period string) (*BillingStatement, error) {
+	start, end, err := ParseBillingPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	username, err := GetUsernameById(userId, true)
+	if err != nil {
+		return nil, err
+	}
+	statement := &BillingStatement{
+		UserId:      userId,
+		Username:    username,
+		Period:      period,
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+	detail := BillingStatementDetail{}
+
+	// 在线充值以 TopUp 表为准
+	var topUps []*TopUp
+	err = DB.Where("user_id = ? AND status = ? AND complete_time >= ? AND complete_time < ?",
+		userId, common.TopUpStatusSuccess, start, end).Order("complete_time asc").Find(&topUps).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, topUp := range topUps {
+		statement.TopUpCount++
+		statement.TopUpAmount += topUp.Amount
+		statement.TopUpMoney += topUp.Money
+		detail.TopUps = append(detail.TopUps, StatementTopUp{
+			TradeNo:       topUp.TradeNo,
+			PaymentMethod: topUp.PaymentMethod,
+			Amount:        topUp.Amount,
+			Money:         topUp.Money,
+			CompleteTime:  topUp.CompleteTime,
+		})
+	}
+
+	// 兑换码充值与退款来自带额度的日志
+	var quotaLogStats []struct {
+		Type  int
+		Count int
+		Quota int64
+	}
+	err = LOG_DB.Model(&Log{}).Select("type, count(*) as count, sum(quota) as quota").
+		Where("user_id = ? AND created_at >= ? AND created_at < ? AND quota > 0 AND type IN ?",
+			userId, start, end, []int{LogTypeTopup, LogTypeRefund}).
+		Group("type").Scan(&quotaLogStats).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, stat := range quotaLogStats {
+		switch stat.Type {
+		case LogTypeTopup:
+			statement.RedemptionCount = stat.Count
+			statement.RedemptionQuota = stat.Quota
+		case LogTypeRefund:
+			statement.RefundCount = stat.Count
+			statement.RefundQuota = stat.Quota
+		}
+	}
+
+	// 按模型汇总消费，未开启消费日志时退回到数据看板的 QuotaData
+	if common.LogConsumeEnabled {
+		detail.Source = "logs"
+		err = LOG_DB.Model(&Log{}).
+			Select("model_name, count(*) as count, sum(quota) as quota, sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens").
+			Where("user_id = ? AND type = ? AND created_at >= ? AND created_at < ?", userId, LogTypeConsume, start, end).
+			Group("model_name").Order("quota desc").Scan(&detail.Models).Error
+	} else {
+		detail.Source = "quota_data"
+		err = DB.Model(&QuotaData{}).
+			Select("model_name, sum(count) as count, sum(quota) as quota, sum(token_used) as total_tokens").
+			Where("user_id = ? AND created_at >= ? AND created_at < ?", userId, start, end).
+			Group("model_name").Order("quota desc").Scan(&detail.Models).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range detail.Models {
+		usage := &detail.Models[i]
+		if usage.TotalTokens == 0 {
+			usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+		}
+		statement.ConsumeCount += usage.Count
+		statement.ConsumeQuota += usage.Quota
+		statement.PromptTokens += usage.PromptTokens
+		statement.CompletionTokens += usage.CompletionTokens
+	}
+	statement.SetDetail(detail)
+
+	now := common.GetTimestamp()
+	statement.UpdatedTime = now
+	if end > now {
+		statement.CreatedTime = now
+		return statement, nil
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var existing BillingStatement
+		err := tx.Where("user_id = ? AND period = ?", userId, period).First(&existing).Error
+		if err == nil {
+			statement.Id = existing.Id
+			statement.CreatedTime = existing.CreatedTime
+			return tx.Save(statement).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		statement.CreatedTime = now
+		return tx.Create(statement).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+// GenerateBillingStatements 为指定月份有充值或消费记录的所有用户生成账单
+func GenerateBillingStatements(period string) (int, error) {
+	start, end, err := ParseBillingPeriod(period)
+	if err != nil {
+		return 0, err
+	}
+	if end > common.GetTimestamp() {
+		return 0, errors.New("账期尚未结束，不能生成账单")
+	}
+	userIds := make(map[int]bool)
+	var logUserIds []int
+	err = LOG_DB.Model(&Log{}).Distinct("user_id").
+		Where("created_at >= ? AND created_at < ? AND type IN ?", start, end, []int{LogTypeTopup, LogTypeConsume, LogTypeRefund}).
+		Pluck("user_id", &logUserIds).Error
+	if err != nil {
+		return 0, err
+	}
+	var topUpUserIds []int
+	err = DB.Model(&TopUp{}).Distinct("user_id").
+		Where("status = ? AND complete_time >= ? AND complete_time < ?", common.TopUpStatusSuccess, start, end).
+		Pluck("user_id", &topUpUserIds).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range append(logUserIds, topUpUserIds...) {
+		if id > 0 {
+			userIds[id] = true
+		}
+	}
+	count := 0
+	for userId := range userIds {
+		if _, err := GenerateBillingStatement(userId, period); err != nil {
+			common.SysLog(fmt.Sprintf("failed to generate statement for user %d period %s: %s", userId, period, err.Error()))
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+func GetBillingStatement(userId int, period string) (*BillingStatement, error) {
+	var statement BillingStatement
+	err := DB.Where("user_id = ? AND period = ?", userId, period).First(&statement).Error
+	if err != nil {
+		return nil, err
+	}
+	return &statement, nil
+}
+
+func GetUserBillingStatements(userId int, startIdx int, num int) (statements []*BillingStatement, total int64, err error) {
+	// 只列出已结束的账期，未结束的账期在查看时即时汇总
+	tx := DB.Model(&BillingStatement{}).Where("user_id = ? AND period_end <= ?", userId, common.GetTimestamp())
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("period desc").Limit(num).Offset(startIdx).Find(&statements).Error
+	return statements, total, err
+}
+
+func GetAllBillingStatements(period string, userId int, startIdx int, num int) (statements []*BillingStatement, total int64, err error) {
+	tx := DB.Model(&BillingStatement{}).Where("period_end <= ?", common.GetTimestamp())
+	if period != "" {
+		tx = tx.Where("period = ?", period)
+	}
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("period desc, user_id asc").Limit(num).Offset(startIdx).Find(&statements).Error
+	return statements, total, err
+}
+
+// AutoGenerateBillingStatements 每月初自动生成上月账单，仅在主节点运行
+func AutoGenerateBillingStatements() {
+	lastPeriod := ""
+	for {
+		if common.IsMasterNode && operation_setting.GetInvoiceSetting().AutoGenerateEnabled {
+			now := time.Now()
+			firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+			period := firstOfMonth.AddDate(0, -1, 0).Format(BillingStatementPeriodLayout)
+			if period != lastPeriod {
+				common.SysLog("generating billing statements for " + period)
+				count, err := GenerateBillingStatements(period)
+				if err != nil {
+					common.SysLog("failed to generate billing statements: " + err.Error())
+				} else {
+					common.SysLog(fmt.Sprintf("generated %d billing statements for %s", count, period))
+					lastPeriod = period
+				}
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// setupBillingTestUser 创建账单测试用户，并开启消费日志
+func setupBillingTestUser(t *testing.T) *User {
+	t.Helper()
+	setupTestDB(t, &User{}, &TopUp{}, &Log{}, &QuotaData{}, &BillingStatement{})
+	savedLogConsume := common.LogConsumeEnabled
+	t.Cleanup(func() { common.LogConsumeEnabled = savedLogConsume })
+	common.LogConsumeEnabled = true
+	user := &User{Username: "statement-user", Password: "password123", Status: common.UserStatusEnabled}
+	if err := DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func createStatementRecords(t *testing.T, userId int, at int64, tradeNo string) {
+	t.Helper()
+	records := []any{
+		&TopUp{UserId: userId, Amount: 10, Money: 7.5, TradeNo: tradeNo, PaymentMethod: "alipay",
+			Status: common.TopUpStatusSuccess, CompleteTime: at},
+		&Log{UserId: userId, CreatedAt: at, Type: LogTypeTopup, Quota: 500},
+		&Log{UserId: userId, CreatedAt: at, Type: LogTypeRefund, Quota: 200},
+		&Log{UserId: userId, CreatedAt: at, Type: LogTypeConsume, ModelName: "gpt-4o", Quota: 300,
+			PromptTokens: 100, CompletionTokens: 20},
+	}
+	for _, record := range records {
+		if err := DB.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGenerateBillingStatementPeriodBoundaries(t *testing.T) {
+	user := setupBillingTestUser(t)
+	start, end, err := ParseBillingPeriod("2025-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 账期左闭右开：起点计入，终点归下一个月
+	createStatementRecords(t, user.Id, start-1, "before")
+	createStatementRecords(t, user.Id, start, "first")
+	createStatementRecords(t, user.Id, end-1, "last")
+	createStatementRecords(t, user.Id, end, "after")
+	// 失败的充值不计入
+	if err := DB.Create(&TopUp{UserId: user.Id, Amount: 99, Money: 99, TradeNo: "pending",
+		Status: common.TopUpStatusPending, CompleteTime: start}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	statement, err := GenerateBillingStatement(user.Id, "2025-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statement.PeriodStart != start || statement.PeriodEnd != end || statement.Username != user.Username {
+		t.Fatalf("statement = %+v, want period [%d, %d) for %s", statement, start, end, user.Username)
+	}
+	if statement.TopUpCount != 2 || statement.TopUpAmount != 20 || statement.TopUpMoney != 15 {
+		t.Fatalf("top ups = %d/%d/%v, want 2/20/15", statement.TopUpCount, statement.TopUpAmount, statement.TopUpMoney)
+	}
+	if statement.RedemptionCount != 2 || statement.RedemptionQuota != 1000 ||
+		statement.RefundCount != 2 || statement.RefundQuota != 400 {
+		t.Fatalf("credits = %+v, want 2 redemptions of 1000 and 2 refunds of 400", statement)
+	}
+	if statement.ConsumeCount != 2 || statement.ConsumeQuota != 600 ||
+		statement.PromptTokens != 200 || statement.CompletionTokens != 40 {
+		t.Fatalf("consume = %+v, want 2 requests, 600 quota, 200/40 tokens", statement)
+	}
+	detail := statement.GetDetail()
+	if len(detail.TopUps) != 2 || detail.TopUps[0].TradeNo != "first" || detail.TopUps[1].TradeNo != "last" {
+		t.Fatalf("top up detail = %+v, want first and last", detail.TopUps)
+	}
+	if detail.Source != "logs" || len(detail.Models) != 1 || detail.Models[0].TotalTokens != 240 {
+		t.Fatalf("model detail = %+v, want one model from logs with 240 tokens", detail)
+	}
+}
+
+func TestGenerateBillingStatementFallsBackToQuotaData(t *testing.T) {
+	user := setupBillingTestUser(t)
+	common.LogConsumeEnabled = false
+	start, _, err := ParseBillingPeriod("2025-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Create(&QuotaData{UserID: user.Id, ModelName: "gpt-4o", CreatedAt: start,
+		TokenUsed: 1500, Count: 3, Quota: 900}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	statement, err := GenerateBillingStatement(user.Id, "2025-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	detail := statement.GetDetail()
+	if detail.Source != "quota_data" || len(detail.Models) != 1 || detail.Models[0].TotalTokens != 1500 {
+		t.Fatalf("detail = %+v, want one model from quota_data with 1500 tokens", detail)
+	}
+	if statement.ConsumeCount != 3 || statement.ConsumeQuota != 900 {
+		t.Fatalf("consume = %d/%d, want 3/900", statement.ConsumeCount, statement.ConsumeQuota)
+	}
+}
+
+func TestGenerateBillingStatementPersistsOnlyClosedPeriods(t *testing.T) {
+	user := setupBillingTestUser(t)
+	current := time.Now().Format(BillingStatementPeriodLayout)
+	if IsBillingPeriodClosed(current) || !IsBillingPeriodClosed("2025-03") {
+		t.Fatal("only the past period should be closed")
+	}
+
+	// 未结束的账期即时汇总，不写入数据库
+	statement, err := GenerateBillingStatement(user.Id, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statement.Id != 0 {
+		t.Fatalf("open period statement saved with id %d", statement.Id)
+	}
+	if _, err := GetBillingStatement(user.Id, current); err == nil {
+		t.Fatal("open period statement should not be persisted")
+	}
+	if _, err := GenerateBillingStatements(current); err == nil {
+		t.Fatal("batch generation should reject an open period")
+	}
+
+	// 已结束的账期写入数据库，重复生成覆盖同一条记录
+	first, err := GenerateBillingStatement(user.Id, "2025-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, _, _ := ParseBillingPeriod("2025-03")
+	createStatementRecords(t, user.Id, start, "late")
+	second, err := GenerateBillingStatement(user.Id, "2025-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Id == 0 || second.Id != first.Id || second.CreatedTime != first.CreatedTime {
+		t.Fatalf("regenerated statement = %+v, want it to overwrite #%d", second, first.Id)
+	}
+	saved, err := GetBillingStatement(user.Id, "2025-03")
+	if err != nil || saved.TopUpCount != 1 {
+		t.Fatalf("saved = %+v, err = %v, want the regenerated statement", saved, err)
+	}
+	statements, total, err := GetUserBillingStatements(user.Id, 0, 10)
+	if err != nil || total != 1 || statements[0].Period != "2025-03" {
+		t.Fatalf("listed %d statements, err = %v, want only the closed period", total, err)
+	}
+
+	future := time.Now().AddDate(0, 2, 0).Format(BillingStatementPeriodLayout)
+	if _, err := GenerateBillingStatement(user.Id, future); err == nil {
+		t.Fatal("future period should be rejected")
+	}
+	if _, err := GenerateBillingStatement(user.Id, "2025/03"); err == nil {
+		t.Fatal("malformed period should be rejected")
+	}
+}
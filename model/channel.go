@@ -12,6 +12,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/samber/lo"
@@ -54,7 +55,8 @@ type Channel struct {
 	OtherSettings string `json:"settings" gorm:"column:settings"` // 其他设置，存储azure版本等不需要检索的信息，详见dto.ChannelOtherSettings
 
 	// cache info
-	Keys []string `json:"-" gorm:"-"`
+	Keys    []string        `json:"-" gorm:"-"`
+	routing *channelRouting // 构建渠道缓存时解析，见 getRouting
 }
 
 type ChannelInfo struct {
@@ -143,6 +145,40 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		return keys[0], 0, nil
 	}
 
+	// 跳过并发已满的密钥，全部已满时保持原选择，由占用名额时拒绝
+	_, keyMaxConcurrency := channel.getConcurrencyLimits()
+	leastConnections := operation_setting.GetChannelRoutingSetting().IsLeastConnections()
+	saturated := make(map[int]bool)
+	if keyMaxConcurrency > 0 || leastConnections {
+		inFlight := GetChannelKeysInFlight(channel.Id, len(keys))
+		available := make([]int, 0, len(enabledIdx))
+		for _, idx := range enabledIdx {
+			if keyMaxConcurrency > 0 && inFlight[idx] >= int64(keyMaxConcurrency) {
+				saturated[idx] = true
+			} else {
+				available = append(available, idx)
+			}
+		}
+		if len(available) == 0 {
+			saturated = map[int]bool{}
+		} else {
+			enabledIdx = available
+		}
+		if leastConnections {
+			// 选择进行中请求最少的密钥，相同时随机选择
+			best := make([]int, 0, len(enabledIdx))
+			for _, idx := range enabledIdx {
+				if len(best) == 0 || inFlight[idx] < inFlight[best[0]] {
+					best = append(best[:0], idx)
+				} else if inFlight[idx] == inFlight[best[0]] {
+					best = append(best, idx)
+				}
+			}
+			selectedIdx := best[rand.Intn(len(best))]
+			return keys[selectedIdx], selectedIdx, nil
+		}
+	}
+
 	switch channel.ChannelInfo.MultiKeyMode {
 	case constant.MultiKeyModeRandom:
 		// Randomly pick one enabled key
@@ -173,7 +209,7 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		}
 		for i := 0; i < len(keys); i++ {
 			idx := (start + i) % len(keys)
-			if getStatus(idx) == common.ChannelStatusEnabled {
+			if getStatus(idx) == common.ChannelStatusEnabled && !saturated[idx] {
 				// update polling index for next call (point to the next position)
 				channel.ChannelInfo.MultiKeyPollingIndex = (idx + 1) % len(keys)
 				return keys[idx], idx, nil
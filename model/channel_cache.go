@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -12,7 +13,9 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,6 +23,7 @@ import (
 var group2model2channels map[string]map[string][]int // enabled channel
 var channelsIDM map[int]*Channel                     // all channels include disabled
 var channelSyncLock sync.RWMutex
+var channelContextLimitDeclared bool // 是否有渠道声明了最大上下文长度
 
 func InitChannelCache() {
 	if !common.MemoryCacheEnabled {
@@ -28,8 +32,13 @@ func InitChannelCache() {
 	newChannelId2channel := make(map[int]*Channel)
 	var channels []*Channel
 	DB.Find(&channels)
+	contextLimitDeclared := false
 	for _, channel := range channels {
+		channel.routing = channel.parseRouting()
 		newChannelId2channel[channel.Id] = channel
+		if channel.routing.capabilities.MaxContextTokens > 0 {
+			contextLimitDeclared = true
+		}
 	}
 	var abilities []*Ability
 	DB.Find(&abilities)
@@ -84,6 +93,7 @@ func InitChannelCache() {
 		}
 	}
 	channelsIDM = newChannelId2channel
+	channelContextLimitDeclared = contextLimitDeclared
 	channelSyncLock.Unlock()
 	common.SysLog("channels synced from database")
 }
@@ -96,19 +106,62 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
+// CacheGetRandomSatisfiedChannel 选择渠道，满足请求的渠道并发都已满时按优先级排队等待空闲名额
 func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string, retry int) (*Channel, string, error) {
+	routingSetting := operation_setting.GetChannelRoutingSetting()
+	wait := routingSetting.GetSaturationWait()
+	try := func() (*Channel, string, error) {
+		return cacheGetRandomSatisfiedChannel(c, group, model, retry)
+	}
+	if wait <= 0 {
+		return try()
+	}
+	features, _ := common.GetContextKeyType[types.RequestFeatures](c, constant.ContextKeyRequestFeatures)
+	// 已有请求排队时新请求排在后面，避免插队
+	if !hasQueuedRequests(group, model, features) {
+		channel, selectGroup, err := try()
+		var saturated *ChannelsSaturatedError
+		if !errors.As(err, &saturated) {
+			return channel, selectGroup, err
+		}
+	}
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	priority := routingSetting.GetGroupPriority(common.GetContextKeyString(c, constant.ContextKeyUserGroup),
+		common.GetContextKeyString(c, constant.ContextKeyUsingGroup))
+	// 轮到请求时在出队前占用名额，名额被其他请求抢先占用时继续排队
+	tryReserve := func() (*Channel, string, error) {
+		channel, selectGroup, err := try()
+		if err == nil && channel != nil && !reserveChannelSlotForRequest(c, channel.Id) {
+			return nil, selectGroup, &ChannelsSaturatedError{Group: group, Model: model}
+		}
+		return channel, selectGroup, err
+	}
+	return waitInRequestQueue(ctx, group, model, common.GetContextKeyInt(c, constant.ContextKeyUserId), priority, features,
+		time.Now().Add(wait), routingSetting.GetQueueMaxSize(), tryReserve)
+}
+
+func cacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string, retry int) (*Channel, string, error) {
 	var channel *Channel
 	var err error
 	selectGroup := group
+	// 请求需要的能力（图片、音频、函数调用等），只在能满足的渠道中选择
+	features, _ := common.GetContextKeyType[types.RequestFeatures](c, constant.ContextKeyRequestFeatures)
 	if group == "auto" {
 		if len(setting.AutoGroups) == 0 {
 			return nil, selectGroup, errors.New("auto groups is not enabled")
 		}
+		var lastErr error
 		for _, autoGroup := range setting.AutoGroups {
 			if common.DebugEnabled {
 				println("autoGroup:", autoGroup)
 			}
-			channel, _ = getRandomSatisfiedChannel(autoGroup, model, retry)
+			channel, err = getRandomSatisfiedChannel(autoGroup, model, retry, features)
+			if err != nil {
+				lastErr = err
+			}
 			if channel == nil {
 				continue
 			} else {
@@ -120,8 +173,12 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 				break
 			}
 		}
+		// 所有自动分组都没有可用渠道时返回原因（如渠道能力不满足请求）
+		if channel == nil && lastErr != nil {
+			return nil, selectGroup, lastErr
+		}
 	} else {
-		channel, err = getRandomSatisfiedChannel(group, model, retry)
+		channel, err = getRandomSatisfiedChannel(group, model, retry, features)
 		if err != nil {
 			return nil, group, err
 		}
@@ -129,42 +186,114 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 	return channel, selectGroup, nil
 }
 
-func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
-	// if memory cache is disabled, get channel directly from database
-	if !common.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, retry)
+// CacheGetSatisfiedChannelById 检查指定渠道是否启用、在分组下提供该模型且并发未满，用于将请求固定到之前使用的渠道
+func CacheGetSatisfiedChannelById(c *gin.Context, group string, model string, channelId int) (*Channel, string, error) {
+	channel, err := CacheGetChannel(channelId)
+	if err != nil {
+		return nil, group, err
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		return nil, group, fmt.Errorf("渠道# %d 已被禁用", channelId)
+	}
+	if !common.StringsContains(channel.GetModels(), model) {
+		return nil, group, fmt.Errorf("渠道# %d 不提供模型 %s", channelId, model)
+	}
+	// 固定的渠道并发已满时由调用方重新选择渠道，不绕过并发上限
+	maxConcurrency, keyMaxConcurrency := channel.getConcurrencyLimits()
+	if channel.isSaturated(maxConcurrency, keyMaxConcurrency, GetChannelsInFlight([]int{channelId})[channelId]) {
+		return nil, group, &ChannelsSaturatedError{Group: group, Model: model}
+	}
+	groups := channel.GetGroups()
+	if group != "auto" {
+		if !common.StringsContains(groups, group) {
+			return nil, group, fmt.Errorf("渠道# %d 不属于分组 %s", channelId, group)
+		}
+		return channel, group, nil
+	}
+	for _, autoGroup := range setting.AutoGroups {
+		if common.StringsContains(groups, autoGroup) {
+			c.Set("auto_group", autoGroup)
+			return channel, autoGroup, nil
+		}
 	}
+	return nil, group, fmt.Errorf("渠道# %d 不属于任何自动分组", channelId)
+}
 
-	channelSyncLock.RLock()
-	defer channelSyncLock.RUnlock()
+// UnsupportedFeaturesError 分组下有该模型的渠道，但都不满足请求需要的能力
+type UnsupportedFeaturesError struct {
+	Group    string
+	Model    string
+	Features types.RequestFeatures
+}
 
-	// First, try to find channels with the exact model name.
-	channels := group2model2channels[group][model]
+func (e *UnsupportedFeaturesError) Error() string {
+	return fmt.Sprintf("分组 %s 下模型 %s 没有满足请求能力（%s）的渠道", e.Group, e.Model, e.Features)
+}
 
-	// If no channels found, try to find channels with the normalized model name.
-	if len(channels) == 0 {
-		normalizedModel := ratio_setting.FormatMatchingModelName(model)
-		channels = group2model2channels[group][normalizedModel]
+func unsupportedFeaturesError(group string, model string, features types.RequestFeatures) error {
+	return &UnsupportedFeaturesError{Group: group, Model: model, Features: features}
+}
+
+func getRandomSatisfiedChannel(group string, model string, retry int, features types.RequestFeatures) (*Channel, error) {
+	// if memory cache is disabled, get channel directly from database
+	if !common.MemoryCacheEnabled {
+		channel, err := GetRandomSatisfiedChannel(group, model, retry)
+		if err != nil || channel == nil {
+			return channel, err
+		}
+		if !channel.SupportsFeatures(model, features) {
+			return nil, unsupportedFeaturesError(group, model, features)
+		}
+		maxConcurrency, keyMaxConcurrency := channel.getConcurrencyLimits()
+		if channel.isSaturated(maxConcurrency, keyMaxConcurrency, GetChannelsInFlight([]int{channel.Id})[channel.Id]) {
+			return nil, &ChannelsSaturatedError{Group: group, Model: model}
+		}
+		return channel, nil
 	}
 
-	if len(channels) == 0 {
-		return nil, nil
+	channels, err := getCachedSatisfiedChannels(group, model, features)
+	if err != nil || len(channels) == 0 {
+		return nil, err
 	}
 
-	if len(channels) == 1 {
-		if channel, ok := channelsIDM[channels[0]]; ok {
-			return channel, nil
+	// 跳过并发已满的渠道，按最少连接选择时也需要进行中的请求数。查询计数（可能访问 Redis）时不持有渠道缓存锁
+	leastConnections := operation_setting.GetChannelRoutingSetting().IsLeastConnections()
+	limited := false
+	for _, channel := range channels {
+		if maxConcurrency, keyMaxConcurrency := channel.getConcurrencyLimits(); maxConcurrency > 0 || keyMaxConcurrency > 0 {
+			limited = true
+			break
 		}
-		return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channels[0])
+	}
+	var inFlight map[int]int64
+	if limited || leastConnections {
+		channelIds := make([]int, len(channels))
+		for i, channel := range channels {
+			channelIds[i] = channel.Id
+		}
+		inFlight = GetChannelsInFlight(channelIds)
+	}
+	if limited {
+		available := make([]*Channel, 0, len(channels))
+		for _, channel := range channels {
+			maxConcurrency, keyMaxConcurrency := channel.getConcurrencyLimits()
+			if !channel.isSaturated(maxConcurrency, keyMaxConcurrency, inFlight[channel.Id]) {
+				available = append(available, channel)
+			}
+		}
+		if len(available) == 0 {
+			return nil, &ChannelsSaturatedError{Group: group, Model: model}
+		}
+		channels = available
+	}
+
+	if len(channels) == 1 {
+		return channels[0], nil
 	}
 
 	uniquePriorities := make(map[int]bool)
-	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			uniquePriorities[int(channel.GetPriority())] = true
-		} else {
-			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
-		}
+	for _, channel := range channels {
+		uniquePriorities[int(channel.GetPriority())] = true
 	}
 	var sortedUniquePriorities []int
 	for priority := range uniquePriorities {
@@ -179,18 +308,17 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 
 	// get the priority for the given retry number
 	var targetChannels []*Channel
-	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			if channel.GetPriority() == targetPriority {
-				targetChannels = append(targetChannels, channel)
-			}
-		} else {
-			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+	for _, channel := range channels {
+		if channel.GetPriority() == targetPriority {
+			targetChannels = append(targetChannels, channel)
 		}
 	}
 
 	// 平滑系数
 	smoothingFactor := 10
+	if leastConnections {
+		return leastConnectionsChannel(targetChannels, inFlight, smoothingFactor), nil
+	}
 	// Calculate the total weight of all channels up to endIdx
 	totalWeight := 0
 	for _, channel := range targetChannels {
@@ -210,6 +338,61 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	return nil, errors.New("channel not found")
 }
 
+// getCachedSatisfiedChannels 从渠道缓存中取出分组下提供该模型且满足请求能力的渠道
+func getCachedSatisfiedChannels(group string, model string, features types.RequestFeatures) ([]*Channel, error) {
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+
+	// First, try to find channels with the exact model name.
+	channelIds := group2model2channels[group][model]
+
+	// If no channels found, try to find channels with the normalized model name.
+	if len(channelIds) == 0 {
+		normalizedModel := ratio_setting.FormatMatchingModelName(model)
+		channelIds = group2model2channels[group][normalizedModel]
+	}
+
+	channels := make([]*Channel, 0, len(channelIds))
+	for _, channelId := range channelIds {
+		channel, ok := channelsIDM[channelId]
+		if !ok {
+			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+		}
+		if channel.SupportsFeatures(model, features) {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) == 0 && len(channelIds) > 0 && !features.IsEmpty() {
+		return nil, unsupportedFeaturesError(group, model, features)
+	}
+	return channels, nil
+}
+
+// ChannelContextLimitDeclared 是否有渠道声明了最大上下文长度，没有时选择渠道无需考虑输入长度
+func ChannelContextLimitDeclared() bool {
+	if !common.MemoryCacheEnabled {
+		return true
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	return channelContextLimitDeclared
+}
+
+// leastConnectionsChannel 选择 进行中请求数 / 权重 最小的渠道，相同时随机选择
+func leastConnectionsChannel(channels []*Channel, inFlight map[int]int64, smoothingFactor int) *Channel {
+	var best []*Channel
+	bestScore := 0.0
+	for _, channel := range channels {
+		score := float64(inFlight[channel.Id]+1) / float64(channel.GetWeight()+smoothingFactor)
+		if len(best) == 0 || score < bestScore {
+			best, bestScore = []*Channel{channel}, score
+		} else if score == bestScore {
+			best = append(best, channel)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
+
 func CacheGetChannel(id int) (*Channel, error) {
 	if !common.MemoryCacheEnabled {
 		return GetChannelById(id, true)
@@ -278,6 +461,10 @@ func CacheUpdateChannel(channel *Channel) {
 	}
 
 	println("CacheUpdateChannel:", channel.Id, channel.Name, channel.Status, channel.ChannelInfo.MultiKeyPollingIndex)
+	channel.routing = channel.parseRouting()
+	if channel.routing.capabilities.MaxContextTokens > 0 {
+		channelContextLimitDeclared = true
+	}
 
 	println("before:", channelsIDM[channel.Id].ChannelInfo.MultiKeyPollingIndex)
 	channelsIDM[channel.Id] = channel
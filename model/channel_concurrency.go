@@ -0,0 +1,355 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 渠道进行中的请求计数：未启用 Redis 时在进程内计数，启用后通过 Redis 在多个节点间共享
+var (
+	channelInFlight     = make(map[int]int64)         // channel id -> in flight
+	channelKeyInFlight  = make(map[int]map[int]int64) // channel id -> key index -> in flight
+	channelInFlightLock sync.Mutex
+	channelSlotReleased = make(chan struct{})
+)
+
+// Redis 计数的过期时间，每次增减都会续期，节点异常退出未释放的计数在渠道空闲后自动清除
+const channelInFlightExpiration = 10 * time.Minute
+
+// ChannelsSaturatedError 分组下有满足请求的渠道，但并发都已达到上限
+type ChannelsSaturatedError struct {
+	Group     string
+	Model     string
+	QueueFull bool // 排队等待的请求数已达上限
+}
+
+func (e *ChannelsSaturatedError) Error() string {
+	if e.QueueFull {
+		return fmt.Sprintf("分组 %s 下模型 %s 的渠道并发已满且排队请求过多，请稍后重试", e.Group, e.Model)
+	}
+	return fmt.Sprintf("分组 %s 下模型 %s 的渠道并发已满，请稍后重试", e.Group, e.Model)
+}
+
+// ChannelSlot 占用的并发名额，请求结束后需要释放
+type ChannelSlot struct {
+	ChannelId int
+	KeyIndex  int // -1 表示未按密钥计数
+}
+
+// ChannelInFlight 渠道进行中的请求数，供管理员查看
+type ChannelInFlight struct {
+	ChannelId         int           `json:"channel_id"`
+	Name              string        `json:"name"`
+	InFlight          int64         `json:"in_flight"`
+	MaxConcurrency    int           `json:"max_concurrency"`
+	KeyMaxConcurrency int           `json:"key_max_concurrency"`
+	Keys              map[int]int64 `json:"keys,omitempty"` // 多密钥渠道各密钥进行中的请求数
+}
+
+func channelInFlightKey(channelId int) string {
+	return fmt.Sprintf("channel_inflight:%d", channelId)
+}
+
+func channelKeyInFlightKey(channelId int, keyIndex int) string {
+	return fmt.Sprintf("channel_inflight:%d:%d", channelId, keyIndex)
+}
+
+// getConcurrencyLimits 渠道与单个密钥的并发上限，0 表示不限制
+func (channel *Channel) getConcurrencyLimits() (int, int) {
+	routing := channel.getRouting()
+	return routing.maxConcurrency, routing.keyMaxConcurrency
+}
+
+// isSaturated 渠道进行中的请求数是否已达上限，多密钥渠道所有启用的密钥都达到上限时也视为已满
+func (channel *Channel) isSaturated(maxConcurrency int, keyMaxConcurrency int, inFlight int64) bool {
+	if maxConcurrency > 0 && inFlight >= int64(maxConcurrency) {
+		return true
+	}
+	if keyMaxConcurrency > 0 {
+		enabledKeys := channel.ChannelInfo.MultiKeySize
+		for i, status := range channel.ChannelInfo.MultiKeyStatusList {
+			if i < channel.ChannelInfo.MultiKeySize && status != common.ChannelStatusEnabled {
+				enabledKeys--
+			}
+		}
+		// 单个密钥的计数不会超过上限，总数达到 上限 × 密钥数 时所有密钥都已满
+		return enabledKeys > 0 && inFlight >= int64(keyMaxConcurrency*enabledKeys)
+	}
+	return false
+}
+
+// IsKeySaturated 多密钥渠道中指定密钥的并发是否已满
+func (channel *Channel) IsKeySaturated(keyIndex int) bool {
+	_, keyMaxConcurrency := channel.getConcurrencyLimits()
+	if keyMaxConcurrency <= 0 || keyIndex < 0 || keyIndex >= channel.ChannelInfo.MultiKeySize {
+		return false
+	}
+	return GetChannelKeysInFlight(channel.Id, channel.ChannelInfo.MultiKeySize)[keyIndex] >= int64(keyMaxConcurrency)
+}
+
+// GetChannelsInFlight 批量查询渠道进行中的请求数
+func GetChannelsInFlight(channelIds []int) map[int]int64 {
+	counts := make(map[int]int64, len(channelIds))
+	if common.RedisEnabled {
+		keys := make([]string, len(channelIds))
+		for i, channelId := range channelIds {
+			keys[i] = channelInFlightKey(channelId)
+		}
+		values, err := common.RedisMGetInt(keys...)
+		if err != nil {
+			common.SysError("failed to get channel in-flight counts: " + err.Error())
+			return counts
+		}
+		for i, channelId := range channelIds {
+			counts[channelId] = max(values[i], 0)
+		}
+		return counts
+	}
+	channelInFlightLock.Lock()
+	defer channelInFlightLock.Unlock()
+	for _, channelId := range channelIds {
+		counts[channelId] = channelInFlight[channelId]
+	}
+	return counts
+}
+
+// GetChannelKeysInFlight 查询多密钥渠道各密钥进行中的请求数
+func GetChannelKeysInFlight(channelId int, keyCount int) []int64 {
+	counts := make([]int64, keyCount)
+	if common.RedisEnabled {
+		keys := make([]string, keyCount)
+		for i := range keys {
+			keys[i] = channelKeyInFlightKey(channelId, i)
+		}
+		values, err := common.RedisMGetInt(keys...)
+		if err != nil {
+			common.SysError("failed to get channel key in-flight counts: " + err.Error())
+			return counts
+		}
+		for i, value := range values {
+			counts[i] = max(value, 0)
+		}
+		return counts
+	}
+	channelInFlightLock.Lock()
+	defer channelInFlightLock.Unlock()
+	for i := range counts {
+		counts[i] = channelKeyInFlight[channelId][i]
+	}
+	return counts
+}
+
+// AcquireChannelSlot 占用渠道（及密钥）的并发名额，达到上限时返回 false
+func AcquireChannelSlot(channelId int, keyIndex int) (*ChannelSlot, bool) {
+	slot, ok := reserveChannelSlot(channelId)
+	if !ok {
+		return nil, false
+	}
+	if !slot.AcquireKey(keyIndex) {
+		slot.Release()
+		return nil, false
+	}
+	return slot, true
+}
+
+// reserveChannelSlot 只占用渠道的并发名额，密钥选定后再通过 AcquireKey 占用
+func reserveChannelSlot(channelId int) (*ChannelSlot, bool) {
+	slot := &ChannelSlot{ChannelId: channelId, KeyIndex: -1}
+	maxConcurrency := 0
+	if channel, err := CacheGetChannel(channelId); err == nil {
+		maxConcurrency, _ = channel.getConcurrencyLimits()
+	}
+	if common.RedisEnabled {
+		count, err := common.RedisIncrByWithExpire(channelInFlightKey(channelId), 1, channelInFlightExpiration)
+		if err != nil {
+			// 计数不可用时不限制并发
+			common.SysError("failed to increase channel in-flight count: " + err.Error())
+			slot.ChannelId = 0
+			return slot, true
+		}
+		if maxConcurrency > 0 && count > int64(maxConcurrency) {
+			_, _ = common.RedisIncrByWithExpire(channelInFlightKey(channelId), -1, channelInFlightExpiration)
+			return nil, false
+		}
+		return slot, true
+	}
+	channelInFlightLock.Lock()
+	defer channelInFlightLock.Unlock()
+	if maxConcurrency > 0 && channelInFlight[channelId] >= int64(maxConcurrency) {
+		return nil, false
+	}
+	channelInFlight[channelId]++
+	return slot, true
+}
+
+// AcquireKey 占用多密钥渠道中选定密钥的并发名额，密钥已满时返回 false，已占用的渠道名额仍需调用方释放
+func (slot *ChannelSlot) AcquireKey(keyIndex int) bool {
+	if slot == nil || slot.ChannelId == 0 || slot.KeyIndex >= 0 || keyIndex < 0 {
+		return true
+	}
+	channel, err := CacheGetChannel(slot.ChannelId)
+	if err != nil || !channel.ChannelInfo.IsMultiKey {
+		return true
+	}
+	_, keyMaxConcurrency := channel.getConcurrencyLimits()
+	if common.RedisEnabled {
+		count, err := common.RedisIncrByWithExpire(channelKeyInFlightKey(slot.ChannelId, keyIndex), 1, channelInFlightExpiration)
+		if err != nil {
+			common.SysError("failed to increase channel key in-flight count: " + err.Error())
+			return true
+		}
+		if keyMaxConcurrency > 0 && count > int64(keyMaxConcurrency) {
+			_, _ = common.RedisIncrByWithExpire(channelKeyInFlightKey(slot.ChannelId, keyIndex), -1, channelInFlightExpiration)
+			return false
+		}
+		slot.KeyIndex = keyIndex
+		return true
+	}
+	channelInFlightLock.Lock()
+	defer channelInFlightLock.Unlock()
+	if keyMaxConcurrency > 0 && channelKeyInFlight[slot.ChannelId][keyIndex] >= int64(keyMaxConcurrency) {
+		return false
+	}
+	if channelKeyInFlight[slot.ChannelId] == nil {
+		channelKeyInFlight[slot.ChannelId] = make(map[int]int64)
+	}
+	channelKeyInFlight[slot.ChannelId][keyIndex]++
+	slot.KeyIndex = keyIndex
+	return true
+}
+
+// reserveChannelSlotForRequest 排队轮到请求时立即占用选中渠道的名额并保存到上下文，
+// 避免同时被唤醒的请求选中同一渠道后在转发时才发现并发已满
+func reserveChannelSlotForRequest(c *gin.Context, channelId int) bool {
+	slot, ok := reserveChannelSlot(channelId)
+	if !ok {
+		return false
+	}
+	ReleaseReservedChannelSlot(c)
+	common.SetContextKey(c, constant.ContextKeyReservedChannelSlot, slot)
+	return true
+}
+
+// TakeReservedChannelSlot 取出排队时为该渠道预留的名额，预留的是其他渠道时释放并返回 nil
+func TakeReservedChannelSlot(c *gin.Context, channelId int) *ChannelSlot {
+	slot, ok := common.GetContextKeyType[*ChannelSlot](c, constant.ContextKeyReservedChannelSlot)
+	if !ok || slot == nil {
+		return nil
+	}
+	common.SetContextKey(c, constant.ContextKeyReservedChannelSlot, (*ChannelSlot)(nil))
+	if slot.ChannelId != channelId {
+		slot.Release()
+		return nil
+	}
+	return slot
+}
+
+// ReleaseReservedChannelSlot 释放预留后未被使用的名额
+func ReleaseReservedChannelSlot(c *gin.Context) {
+	slot, ok := common.GetContextKeyType[*ChannelSlot](c, constant.ContextKeyReservedChannelSlot)
+	if !ok || slot == nil {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyReservedChannelSlot, (*ChannelSlot)(nil))
+	slot.Release()
+}
+
+// Release 释放并发名额，并唤醒等待空闲渠道的请求
+func (slot *ChannelSlot) Release() {
+	if slot == nil || slot.ChannelId == 0 {
+		return
+	}
+	if common.RedisEnabled {
+		decreaseRedisInFlight(channelInFlightKey(slot.ChannelId))
+		if slot.KeyIndex >= 0 {
+			decreaseRedisInFlight(channelKeyInFlightKey(slot.ChannelId, slot.KeyIndex))
+		}
+	} else {
+		channelInFlightLock.Lock()
+		if channelInFlight[slot.ChannelId]--; channelInFlight[slot.ChannelId] <= 0 {
+			delete(channelInFlight, slot.ChannelId)
+		}
+		if keys := channelKeyInFlight[slot.ChannelId]; slot.KeyIndex >= 0 && keys != nil {
+			if keys[slot.KeyIndex]--; keys[slot.KeyIndex] <= 0 {
+				delete(keys, slot.KeyIndex)
+			}
+			if len(keys) == 0 {
+				delete(channelKeyInFlight, slot.ChannelId)
+			}
+		}
+		channelInFlightLock.Unlock()
+	}
+	notifyChannelSlotReleased()
+	slot.ChannelId = 0
+}
+
+func decreaseRedisInFlight(key string) {
+	count, err := common.RedisIncrByWithExpire(key, -1, channelInFlightExpiration)
+	if err != nil {
+		common.SysError("failed to decrease channel in-flight count: " + err.Error())
+		return
+	}
+	if count < 0 {
+		// 计数过期后释放会得到负数
+		_ = common.RedisSet(key, "0", channelInFlightExpiration)
+	}
+}
+
+func notifyChannelSlotReleased() {
+	channelInFlightLock.Lock()
+	defer channelInFlightLock.Unlock()
+	close(channelSlotReleased)
+	channelSlotReleased = make(chan struct{})
+}
+
+// channelSlotReleasedSignal 本节点下一次释放并发名额时关闭的通道
+func channelSlotReleasedSignal() <-chan struct{} {
+	channelInFlightLock.Lock()
+	defer channelInFlightLock.Unlock()
+	return channelSlotReleased
+}
+
+// GetChannelsInFlightStats 有并发上限或进行中请求的渠道
+func GetChannelsInFlightStats() ([]*ChannelInFlight, error) {
+	var channels []*Channel
+	if err := DB.Select("id", "name", "setting", "channel_info").Where("status = ?", common.ChannelStatusEnabled).
+		Order("id asc").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(channels))
+	for i, channel := range channels {
+		ids[i] = channel.Id
+	}
+	counts := GetChannelsInFlight(ids)
+	stats := make([]*ChannelInFlight, 0)
+	for _, channel := range channels {
+		maxConcurrency, keyMaxConcurrency := channel.getConcurrencyLimits()
+		inFlight := counts[channel.Id]
+		if inFlight == 0 && maxConcurrency == 0 && keyMaxConcurrency == 0 {
+			continue
+		}
+		stat := &ChannelInFlight{
+			ChannelId:         channel.Id,
+			Name:              channel.Name,
+			InFlight:          inFlight,
+			MaxConcurrency:    maxConcurrency,
+			KeyMaxConcurrency: keyMaxConcurrency,
+		}
+		if channel.ChannelInfo.IsMultiKey {
+			stat.Keys = make(map[int]int64)
+			for i, count := range GetChannelKeysInFlight(channel.Id, channel.ChannelInfo.MultiKeySize) {
+				if count > 0 {
+					stat.Keys[i] = count
+				}
+			}
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
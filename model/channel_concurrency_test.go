@@ -0,0 +1,200 @@
+package model
+
+import (
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheTestChannels 将渠道放入内存缓存供 CacheGetChannel 读取，测试结束后恢复缓存与并发计数
+func cacheTestChannels(t *testing.T, channels ...*Channel) {
+	t.Helper()
+	channelSyncLock.Lock()
+	savedIDM := channelsIDM
+	channelsIDM = make(map[int]*Channel)
+	for _, channel := range channels {
+		channelsIDM[channel.Id] = channel
+	}
+	channelSyncLock.Unlock()
+	savedMemoryCache, savedRedis := common.MemoryCacheEnabled, common.RedisEnabled
+	common.MemoryCacheEnabled, common.RedisEnabled = true, false
+	t.Cleanup(func() {
+		channelSyncLock.Lock()
+		channelsIDM = savedIDM
+		channelSyncLock.Unlock()
+		common.MemoryCacheEnabled, common.RedisEnabled = savedMemoryCache, savedRedis
+		channelInFlightLock.Lock()
+		for _, channel := range channels {
+			delete(channelInFlight, channel.Id)
+			delete(channelKeyInFlight, channel.Id)
+		}
+		channelInFlightLock.Unlock()
+	})
+}
+
+func newConcurrencyTestChannel(id int, maxConcurrency int, keyMaxConcurrency int, keys int) *Channel {
+	channel := &Channel{Id: id, Status: common.ChannelStatusEnabled,
+		routing: &channelRouting{maxConcurrency: maxConcurrency, keyMaxConcurrency: keyMaxConcurrency}}
+	if keys > 0 {
+		channel.ChannelInfo = ChannelInfo{IsMultiKey: true, MultiKeySize: keys}
+	}
+	return channel
+}
+
+func TestAcquireChannelSlotUnderContention(t *testing.T) {
+	cacheTestChannels(t, newConcurrencyTestChannel(1, 3, 0, 0))
+
+	var mu sync.Mutex
+	var slots []*ChannelSlot
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if slot, ok := AcquireChannelSlot(1, -1); ok {
+				mu.Lock()
+				slots = append(slots, slot)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(slots) != 3 {
+		t.Fatalf("acquired %d slots, want 3", len(slots))
+	}
+	if inFlight := GetChannelsInFlight([]int{1})[1]; inFlight != 3 {
+		t.Fatalf("in flight = %d, want 3", inFlight)
+	}
+	released := channelSlotReleasedSignal()
+	slots[0].Release()
+	select {
+	case <-released:
+	default:
+		t.Fatal("release should wake queued requests")
+	}
+	// 重复释放不会让计数变为负数
+	slots[0].Release()
+	if inFlight := GetChannelsInFlight([]int{1})[1]; inFlight != 2 {
+		t.Fatalf("in flight = %d, want 2", inFlight)
+	}
+	slot, ok := AcquireChannelSlot(1, -1)
+	if !ok {
+		t.Fatal("a released slot should be available again")
+	}
+	slot.Release()
+	for _, slot := range slots[1:] {
+		slot.Release()
+	}
+	if inFlight := GetChannelsInFlight([]int{1})[1]; inFlight != 0 {
+		t.Fatalf("in flight = %d, want 0", inFlight)
+	}
+}
+
+func TestAcquireChannelSlotPerKey(t *testing.T) {
+	channel := newConcurrencyTestChannel(2, 0, 1, 2)
+	cacheTestChannels(t, channel)
+
+	first, ok := AcquireChannelSlot(2, 0)
+	if !ok {
+		t.Fatal("key 0 should have a free slot")
+	}
+	// 密钥已满时同时退回渠道名额
+	if _, ok := AcquireChannelSlot(2, 0); ok {
+		t.Fatal("key 0 should be saturated")
+	}
+	if inFlight := GetChannelsInFlight([]int{2})[2]; inFlight != 1 {
+		t.Fatalf("in flight = %d, want 1", inFlight)
+	}
+	if !channel.IsKeySaturated(0) || channel.IsKeySaturated(1) {
+		t.Fatal("only key 0 should be saturated")
+	}
+	second, ok := AcquireChannelSlot(2, 1)
+	if !ok {
+		t.Fatal("key 1 should have a free slot")
+	}
+	first.Release()
+	second.Release()
+	if keys := GetChannelKeysInFlight(2, 2); keys[0] != 0 || keys[1] != 0 {
+		t.Fatalf("key in flight = %v, want all released", keys)
+	}
+}
+
+func TestChannelIsSaturatedWithMultiKey(t *testing.T) {
+	channel := newConcurrencyTestChannel(3, 0, 2, 3)
+
+	// 3 个密钥每个最多 2 个并发
+	if channel.isSaturated(0, 2, 5) {
+		t.Fatal("5 of 6 key slots in use should not be saturated")
+	}
+	if !channel.isSaturated(0, 2, 6) {
+		t.Fatal("all key slots in use should be saturated")
+	}
+	// 禁用的密钥不计入容量
+	channel.ChannelInfo.MultiKeyStatusList = map[int]int{2: common.ChannelStatusManuallyDisabled}
+	if !channel.isSaturated(0, 2, 4) {
+		t.Fatal("all enabled key slots in use should be saturated")
+	}
+	// 渠道总并发上限先于密钥上限生效
+	if !channel.isSaturated(3, 2, 3) {
+		t.Fatal("channel limit reached should be saturated")
+	}
+	channel.ChannelInfo.MultiKeyStatusList = map[int]int{0: common.ChannelStatusManuallyDisabled,
+		1: common.ChannelStatusManuallyDisabled, 2: common.ChannelStatusManuallyDisabled}
+	if channel.isSaturated(0, 2, 10) {
+		t.Fatal("a channel without enabled keys is not limited by key concurrency")
+	}
+}
+
+func TestLeastConnectionsChannel(t *testing.T) {
+	weight := func(w uint) *uint { return &w }
+	light := &Channel{Id: 1, Weight: weight(1)}
+	heavy := &Channel{Id: 2, Weight: weight(9)}
+	channels := []*Channel{light, heavy}
+
+	// 空闲时按权重：(0+1)/(1+1) > (0+1)/(9+1)
+	if got := leastConnectionsChannel(channels, map[int]int64{}, 1); got != heavy {
+		t.Fatalf("selected channel #%d, want #2", got.Id)
+	}
+	// 高权重渠道进行中的请求多时改选低权重渠道：(0+1)/2 < (5+1)/10
+	if got := leastConnectionsChannel(channels, map[int]int64{2: 5}, 1); got != light {
+		t.Fatalf("selected channel #%d, want #1", got.Id)
+	}
+	// 得分相同时在并列的渠道中选择
+	seen := make(map[int]bool)
+	for range 100 {
+		seen[leastConnectionsChannel(channels, map[int]int64{1: 0, 2: 4}, 1).Id] = true
+	}
+	if !seen[1] || !seen[2] {
+		t.Fatalf("tied channels should both be selected, got %v", seen)
+	}
+}
+
+func TestCacheGetSatisfiedChannelByIdSaturated(t *testing.T) {
+	channel := newConcurrencyTestChannel(1, 1, 0, 0)
+	channel.Models, channel.Group = "gpt-4o", "default"
+	cacheTestChannels(t, channel)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	if got, _, err := CacheGetSatisfiedChannelById(c, "default", "gpt-4o", 1); err != nil || got != channel {
+		t.Fatalf("idle pinned channel should be returned, err = %v", err)
+	}
+	slot, ok := AcquireChannelSlot(1, -1)
+	if !ok {
+		t.Fatal("failed to acquire slot")
+	}
+	// 并发已满时不返回固定的渠道，由调用方重新选择
+	var saturatedErr *ChannelsSaturatedError
+	if _, _, err := CacheGetSatisfiedChannelById(c, "default", "gpt-4o", 1); !errors.As(err, &saturatedErr) {
+		t.Fatalf("err = %v, want ChannelsSaturatedError", err)
+	}
+	slot.Release()
+	if _, _, err := CacheGetSatisfiedChannelById(c, "default", "gpt-4o", 1); err != nil {
+		t.Fatalf("released channel should be pinned again, err = %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// channelRouting 选择渠道时用到的渠道设置，构建渠道缓存时解析一次，避免每次选择渠道都解析 JSON
+type channelRouting struct {
+	maxConcurrency      int
+	keyMaxConcurrency   int
+	capabilities        dto.ChannelCapabilities
+	toolCallEmulation   bool
+	jsonSchemaEmulation bool
+	modelMapping        map[string]string
+}
+
+func (channel *Channel) parseRouting() *channelRouting {
+	setting := channel.GetSetting()
+	routing := &channelRouting{
+		maxConcurrency:      setting.MaxConcurrency,
+		toolCallEmulation:   setting.ToolCallEmulation,
+		jsonSchemaEmulation: setting.JsonSchemaEmulation,
+	}
+	if channel.ChannelInfo.IsMultiKey {
+		routing.keyMaxConcurrency = setting.KeyMaxConcurrency
+	}
+	if setting.Capabilities != nil {
+		routing.capabilities = *setting.Capabilities
+	}
+	if mapping := channel.GetModelMapping(); mapping != "" && mapping != "{}" {
+		modelMap := make(map[string]string)
+		if err := common.Unmarshal([]byte(mapping), &modelMap); err == nil {
+			routing.modelMapping = modelMap
+		}
+	}
+	return routing
+}
+
+// getRouting 缓存中的渠道使用构建缓存时解析的设置，未缓存的渠道（如直接从数据库读取）现场解析
+func (channel *Channel) getRouting() *channelRouting {
+	if channel.routing != nil {
+		return channel.routing
+	}
+	return channel.parseRouting()
+}
+
+// SupportsFeatures 渠道能否处理需要这些能力的请求。优先使用渠道的能力声明，未声明时按渠道模型重定向后的
+// 上游模型在模型目录中的能力判断，目录中没有该模型或能力未知时视为支持
+func (channel *Channel) SupportsFeatures(modelName string, features types.RequestFeatures) bool {
+	if features.IsEmpty() {
+		return true
+	}
+	routing := channel.getRouting()
+	declared := routing.capabilities
+
+	upstreamModel := modelName
+	if mapped := routing.modelMapping[modelName]; mapped != "" {
+		upstreamModel = mapped
+	}
+	meta, _ := GetCachedModelMeta(upstreamModel)
+
+	supports := func(declared *bool, unsupported func(*Model) bool) bool {
+		if declared != nil {
+			return *declared
+		}
+		return meta == nil || !unsupported(meta)
+	}
+	if features.ImageInput && !supports(declared.Vision, (*Model).ImageInputUnsupported) {
+		return false
+	}
+	if features.AudioInput && !supports(declared.Audio, (*Model).AudioInputUnsupported) {
+		return false
+	}
+	// 开启函数调用模拟或结构化输出模拟的渠道不依赖上游能力
+	if features.Tools && !routing.toolCallEmulation && !supports(declared.Tools, (*Model).ToolsUnsupported) {
+		return false
+	}
+	if features.JsonSchema && !routing.jsonSchemaEmulation && declared.JsonSchema != nil && !*declared.JsonSchema {
+		return false
+	}
+	if features.PromptTokens > 0 {
+		limit := declared.MaxContextTokens
+		if limit == 0 && meta != nil {
+			limit = meta.ContextLength
+		}
+		if limit > 0 && features.PromptTokens > limit {
+			return false
+		}
+	}
+	return true
+}
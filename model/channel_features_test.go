@@ -0,0 +1,120 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// setTestModelMeta 替换模型目录缓存，测试结束后恢复
+func setTestModelMeta(t *testing.T, metas ...*Model) {
+	t.Helper()
+	modelEnableGroupsLock.Lock()
+	saved := modelMetaMap
+	modelMetaMap = make(map[string]*Model)
+	for _, meta := range metas {
+		modelMetaMap[meta.ModelName] = meta
+	}
+	modelEnableGroupsLock.Unlock()
+	t.Cleanup(func() {
+		modelEnableGroupsLock.Lock()
+		modelMetaMap = saved
+		modelEnableGroupsLock.Unlock()
+	})
+}
+
+func newFeatureTestChannel(setting string, modelMapping string) *Channel {
+	channel := &Channel{Id: 1, Setting: common.GetPointer(setting), ModelMapping: common.GetPointer(modelMapping)}
+	channel.routing = channel.parseRouting()
+	return channel
+}
+
+func TestSupportsFeaturesCatalogueFallback(t *testing.T) {
+	setTestModelMeta(t,
+		&Model{ModelName: "text-model", InputModalities: "text", SupportsTools: common.GetPointer(false), ContextLength: 1000},
+		&Model{ModelName: "vision-model", SupportsVision: common.GetPointer(true)},
+	)
+	channel := newFeatureTestChannel(`{}`, "")
+
+	tests := []struct {
+		name     string
+		model    string
+		features types.RequestFeatures
+		want     bool
+	}{
+		{"no features", "text-model", types.RequestFeatures{}, true},
+		{"image on text model", "text-model", types.RequestFeatures{ImageInput: true}, false},
+		{"audio on text model", "text-model", types.RequestFeatures{AudioInput: true}, false},
+		{"tools on text model", "text-model", types.RequestFeatures{Tools: true}, false},
+		{"within context", "text-model", types.RequestFeatures{PromptTokens: 1000}, true},
+		{"over context", "text-model", types.RequestFeatures{PromptTokens: 1001}, false},
+		{"image on vision model", "vision-model", types.RequestFeatures{ImageInput: true, Tools: true}, true},
+		// 目录中没有的模型视为支持
+		{"unknown model", "unknown-model", types.RequestFeatures{ImageInput: true, Tools: true, PromptTokens: 1 << 20}, true},
+		// 目录不声明 json_schema 能力，只按渠道声明判断
+		{"json schema", "text-model", types.RequestFeatures{JsonSchema: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := channel.SupportsFeatures(tt.model, tt.features); got != tt.want {
+				t.Fatalf("SupportsFeatures = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupportsFeaturesDeclaredCapabilities(t *testing.T) {
+	setTestModelMeta(t, &Model{ModelName: "text-model", InputModalities: "text", SupportsTools: common.GetPointer(false), ContextLength: 1000})
+
+	// 渠道声明优先于模型目录
+	declared := newFeatureTestChannel(`{"capabilities":{"vision":true,"tools":true,"max_context_tokens":4000}}`, "")
+	if !declared.SupportsFeatures("text-model", types.RequestFeatures{ImageInput: true, Tools: true, PromptTokens: 4000}) {
+		t.Fatal("declared capabilities should override the catalogue")
+	}
+	if declared.SupportsFeatures("text-model", types.RequestFeatures{PromptTokens: 4001}) {
+		t.Fatal("declared max_context_tokens should limit the prompt")
+	}
+	denied := newFeatureTestChannel(`{"capabilities":{"vision":false,"json_schema":false}}`, "")
+	if denied.SupportsFeatures("unknown-model", types.RequestFeatures{ImageInput: true}) {
+		t.Fatal("declared vision=false should reject image input even for unknown models")
+	}
+	if denied.SupportsFeatures("unknown-model", types.RequestFeatures{JsonSchema: true}) {
+		t.Fatal("declared json_schema=false should reject json_schema requests")
+	}
+}
+
+func TestSupportsFeaturesModelMapping(t *testing.T) {
+	setTestModelMeta(t,
+		&Model{ModelName: "upstream-text", InputModalities: "text"},
+		&Model{ModelName: "alias", InputModalities: "text,image"},
+	)
+	features := types.RequestFeatures{ImageInput: true}
+
+	// 按重定向后的上游模型查找能力
+	mapped := newFeatureTestChannel(`{}`, `{"alias":"upstream-text"}`)
+	if mapped.SupportsFeatures("alias", features) {
+		t.Fatal("the mapped upstream model does not support images")
+	}
+	if !newFeatureTestChannel(`{}`, "").SupportsFeatures("alias", features) {
+		t.Fatal("without mapping the requested model's capabilities apply")
+	}
+	// 未缓存的渠道现场解析设置
+	uncached := &Channel{Id: 2, Setting: common.GetPointer(`{}`), ModelMapping: common.GetPointer(`{"alias":"upstream-text"}`)}
+	if uncached.SupportsFeatures("alias", features) {
+		t.Fatal("an uncached channel should parse its model mapping")
+	}
+}
+
+func TestSupportsFeaturesEmulation(t *testing.T) {
+	setTestModelMeta(t, &Model{ModelName: "text-model", InputModalities: "text", SupportsTools: common.GetPointer(false)})
+
+	emulating := newFeatureTestChannel(`{"tool_call_emulation":true,"json_schema_emulation":true,"capabilities":{"tools":false,"json_schema":false}}`, "")
+	if !emulating.SupportsFeatures("text-model", types.RequestFeatures{Tools: true, JsonSchema: true}) {
+		t.Fatal("emulation should not depend on upstream tools or json_schema support")
+	}
+	// 模拟不影响其他能力
+	if emulating.SupportsFeatures("text-model", types.RequestFeatures{Tools: true, ImageInput: true}) {
+		t.Fatal("emulation does not add image support")
+	}
+}
@@ -32,12 +32,13 @@ type Log struct {
 	UseTime          int    `json:"use_time" gorm:"default:0"`
 	IsStream         bool   `json:"is_stream"`
 	// 彻底不返回渠道相关字段
-	ChannelId   int    `json:"-" gorm:"index"`
-	ChannelName string `json:"-" gorm:"->"`
-	TokenId     int    `json:"token_id" gorm:"default:0;index"`
-	Group       string `json:"group" gorm:"index"`
-	Ip          string `json:"ip" gorm:"index;default:''"`
-	Other       string `json:"other"`
+	ChannelId    int    `json:"-" gorm:"index"`
+	ChannelName  string `json:"-" gorm:"->"`
+	UpstreamCost int    `json:"-" gorm:"default:0"` // 上游成本，仅用于毛利统计
+	TokenId      int    `json:"token_id" gorm:"default:0;index"`
+	Group        string `json:"group" gorm:"index"`
+	Ip           string `json:"ip" gorm:"index;default:''"`
+	Other        string `json:"other"`
 	// 衍生字段：用户组（仅用于响应，不入库）
 	UserGroup string `json:"user_group" gorm:"-"`
 }
@@ -50,6 +51,7 @@ const (
 	LogTypeSystem
 	LogTypeRefund
 	LogTypeError
+	LogTypeSubscription // 订阅套餐发放额度，不计入充值
 )
 
 func formatUserLogs(logs []*Log) {
@@ -81,6 +83,11 @@ func GetLogByKey(key string) (logs []*Log, err error) {
 }
 
 func RecordLog(userId int, logType int, content string) {
+	RecordQuotaLog(userId, logType, content, 0, nil)
+}
+
+// RecordQuotaLog 记录带额度变动的日志（如兑换码充值、退款），供账单统计使用
+func RecordQuotaLog(userId int, logType int, content string, quota int, other map[string]interface{}) {
 	if logType == LogTypeConsume && !common.LogConsumeEnabled {
 		return
 	}
@@ -91,6 +98,10 @@ func RecordLog(userId int, logType int, content string) {
 		CreatedAt: common.GetTimestamp(),
 		Type:      logType,
 		Content:   content,
+		Quota:     quota,
+	}
+	if other != nil {
+		log.Other = common.MapToJsonStr(other)
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -162,6 +173,7 @@ type RecordConsumeLogParams struct {
 	UseTimeSeconds   int                    `json:"use_time_seconds"`
 	IsStream         bool                   `json:"is_stream"`
 	Group            string                 `json:"group"`
+	UpstreamCost     int                    `json:"upstream_cost"`
 	Other            map[string]interface{} `json:"other"`
 }
 
@@ -205,6 +217,7 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 		ModelName:        modelName,
 		Quota:            params.Quota,
 		ChannelId:        params.ChannelId,
+		UpstreamCost:     params.UpstreamCost,
 		TokenId:          params.TokenId,
 		UseTime:          params.UseTimeSeconds,
 		IsStream:         params.IsStream,
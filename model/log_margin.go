@@ -0,0 +1,101 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// MarginStat 收入、上游成本与毛利统计，Margin 只统计记录了成本的请求
+type MarginStat struct {
+	Key            string `json:"key"`
+	Name           string `json:"name,omitempty"`
+	Count          int64  `json:"count"`
+	Revenue        int64  `json:"revenue"`
+	TrackedRevenue int64  `json:"tracked_revenue"`
+	Cost           int64  `json:"cost"`
+	Margin         int64  `json:"margin"`
+	TrackedCount   int64  `json:"tracked_count"`
+}
+
+// GetMarginStats 按渠道、模型、分组或天汇总消费日志中的收入与上游成本
+func GetMarginStats(groupBy string, startTimestamp int64, endTimestamp int64, modelName string, channel int, group string) ([]*MarginStat, error) {
+	var keyExpr string
+	switch groupBy {
+	case "channel":
+		keyExpr = "channel_id"
+	case "model":
+		keyExpr = "model_name"
+	case "group":
+		keyExpr = logGroupCol
+	case "day":
+		keyExpr = "created_at - created_at % 86400"
+	default:
+		return nil, fmt.Errorf("invalid group_by: %s", groupBy)
+	}
+
+	var rows []struct {
+		GroupKey       string
+		Count          int64
+		Revenue        int64
+		TrackedRevenue int64
+		Cost           int64
+		TrackedCount   int64
+	}
+	tx := LOG_DB.Table("logs").Select(keyExpr+" as group_key, count(*) as count, sum(quota) as revenue, "+
+		"sum(case when upstream_cost > 0 then quota else 0 end) as tracked_revenue, "+
+		"sum(upstream_cost) as cost, sum(case when upstream_cost > 0 then 1 else 0 end) as tracked_count").
+		Where("type = ?", LogTypeConsume)
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	if channel != 0 {
+		tx = tx.Where("channel_id = ?", channel)
+	}
+	if group != "" {
+		tx = tx.Where(logGroupCol+" = ?", group)
+	}
+	if err := tx.Group("group_key").Order("group_key").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make([]*MarginStat, 0, len(rows))
+	channelIds := make([]int, 0)
+	for _, row := range rows {
+		stats = append(stats, &MarginStat{
+			Key:            row.GroupKey,
+			Count:          row.Count,
+			Revenue:        row.Revenue,
+			TrackedRevenue: row.TrackedRevenue,
+			Cost:           row.Cost,
+			Margin:         row.TrackedRevenue - row.Cost,
+			TrackedCount:   row.TrackedCount,
+		})
+		if groupBy == "channel" {
+			if id, err := strconv.Atoi(row.GroupKey); err == nil {
+				channelIds = append(channelIds, id)
+			}
+		}
+	}
+	if len(channelIds) > 0 {
+		var channels []struct {
+			Id   int
+			Name string
+		}
+		if err := DB.Table("channels").Select("id, name").Where("id IN ?", channelIds).Find(&channels).Error; err == nil {
+			names := make(map[string]string, len(channels))
+			for _, ch := range channels {
+				names[strconv.Itoa(ch.Id)] = ch.Name
+			}
+			for _, stat := range stats {
+				stat.Name = names[stat.Key]
+			}
+		}
+	}
+	return stats, nil
+}
@@ -271,6 +271,12 @@ func migrateDB() error {
 		&CheckinConfig{},
 		&UserGroup{},
 		&UserGroupEnableGroups{},
+		&BillingStatement{},
+		&SubscriptionPlan{},
+		&UserSubscription{},
+		&StoredResponse{},
+		&PriceVersion{},
+		&PriceOverride{},
 	)
 	if err != nil {
 		return err
@@ -309,6 +315,12 @@ func migrateDBFast() error {
 		{&CheckinConfig{}, "CheckinConfig"},
 		{&UserGroup{}, "UserGroup"},
 		{&UserGroupEnableGroups{}, "UserGroupEnableGroups"},
+		{&BillingStatement{}, "BillingStatement"},
+		{&SubscriptionPlan{}, "SubscriptionPlan"},
+		{&UserSubscription{}, "UserSubscription"},
+		{&StoredResponse{}, "StoredResponse"},
+		{&PriceVersion{}, "PriceVersion"},
+		{&PriceOverride{}, "PriceOverride"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupTestDB 使用内存 SQLite 替换 DB 与 LOG_DB，测试结束后恢复
+func setupTestDB(t *testing.T, models ...any) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	savedDB, savedLogDB, savedRedis := DB, LOG_DB, common.RedisEnabled
+	t.Cleanup(func() {
+		DB, LOG_DB, common.RedisEnabled = savedDB, savedLogDB, savedRedis
+	})
+	DB, LOG_DB = db, db
+	common.RedisEnabled = false
+	initCol()
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/QuantumNous/new-api/common"
 
@@ -39,10 +40,62 @@ type Model struct {
 	QuotaTypes    []int          `json:"quota_types,omitempty" gorm:"-"`
 	NameRule      int            `json:"name_rule" gorm:"default:0"`
 
+	// 模型能力，0 / 空 / nil 表示未知，未知时不做限制
+	ContextLength     int    `json:"context_length,omitempty" gorm:"default:0"`
+	MaxOutputTokens   int    `json:"max_output_tokens,omitempty" gorm:"default:0"`
+	InputModalities   string `json:"input_modalities,omitempty" gorm:"type:varchar(255)"`  // 逗号分隔，如 text,image,audio
+	OutputModalities  string `json:"output_modalities,omitempty" gorm:"type:varchar(255)"` // 逗号分隔
+	SupportsTools     *bool  `json:"supports_tools,omitempty"`
+	SupportsVision    *bool  `json:"supports_vision,omitempty"`
+	SupportsReasoning *bool  `json:"supports_reasoning,omitempty"`
+	KnowledgeCutoff   string `json:"knowledge_cutoff,omitempty" gorm:"type:varchar(32)"`
+
 	MatchedModels []string `json:"matched_models,omitempty" gorm:"-"`
 	MatchedCount  int      `json:"matched_count,omitempty" gorm:"-"`
 }
 
+// GetInputModalities 输入模态列表，未配置时返回 nil
+func (mi *Model) GetInputModalities() []string {
+	return splitModalities(mi.InputModalities)
+}
+
+func (mi *Model) GetOutputModalities() []string {
+	return splitModalities(mi.OutputModalities)
+}
+
+func splitModalities(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	modalities := make([]string, 0)
+	for _, m := range strings.Split(value, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			modalities = append(modalities, m)
+		}
+	}
+	return modalities
+}
+
+// ImageInputUnsupported 模型明确不支持图片输入：supports_vision 为 false，或输入模态已配置但不含 image
+func (mi *Model) ImageInputUnsupported() bool {
+	if mi.SupportsVision != nil {
+		return !*mi.SupportsVision
+	}
+	modalities := mi.GetInputModalities()
+	return len(modalities) > 0 && !common.StringsContains(modalities, "image")
+}
+
+// AudioInputUnsupported 模型明确不支持音频输入：输入模态已配置但不含 audio
+func (mi *Model) AudioInputUnsupported() bool {
+	modalities := mi.GetInputModalities()
+	return len(modalities) > 0 && !common.StringsContains(modalities, "audio")
+}
+
+// ToolsUnsupported 模型明确不支持函数调用
+func (mi *Model) ToolsUnsupported() bool {
+	return mi.SupportsTools != nil && !*mi.SupportsTools
+}
+
 func (mi *Model) Insert() error {
 	now := common.GetTimestamp()
 	mi.CreatedTime = now
@@ -0,0 +1,196 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// PriceOverride 用户或令牌级别的模型价格覆盖，用于企业客户单独协商的折扣。
+// ModelPattern 支持精确模型名、以 * 结尾的前缀匹配以及 * 匹配所有模型
+type PriceOverride struct {
+	Id           int     `json:"id"`
+	UserId       int     `json:"user_id" gorm:"index"`
+	TokenId      int     `json:"token_id" gorm:"index;default:0"` // 0 表示对用户的所有令牌生效
+	ModelPattern string  `json:"model_pattern" gorm:"type:varchar(255)"`
+	Type         string  `json:"type" gorm:"type:varchar(16)"`
+	Value        float64 `json:"value"`
+	Remark       string  `json:"remark" gorm:"type:varchar(255);default:''"`
+	CreatedTime  int64   `json:"created_time" gorm:"bigint"`
+	UpdatedTime  int64   `json:"updated_time" gorm:"bigint"`
+}
+
+var (
+	priceOverrideCache     map[int][]*PriceOverride // user id -> overrides
+	priceOverrideCacheLock sync.RWMutex
+)
+
+func (o *PriceOverride) Validate() error {
+	if o.UserId == 0 {
+		return errors.New("用户不能为空")
+	}
+	o.ModelPattern = strings.TrimSpace(o.ModelPattern)
+	if o.ModelPattern == "" {
+		return errors.New("模型匹配规则不能为空")
+	}
+	if strings.Contains(strings.TrimSuffix(o.ModelPattern, "*"), "*") {
+		return errors.New("模型匹配规则只支持以 * 结尾的前缀匹配")
+	}
+	switch o.Type {
+	case types.PriceOverrideTypePrice:
+		if o.Value < 0 {
+			return errors.New("固定价格不能为负数")
+		}
+	case types.PriceOverrideTypeRatio:
+		if o.Value < 0 {
+			return errors.New("价格系数不能为负数")
+		}
+	default:
+		return errors.New("无效的价格覆盖类型")
+	}
+	if o.TokenId != 0 {
+		token, err := GetTokenById(o.TokenId)
+		if err != nil {
+			return err
+		}
+		if token.UserId != o.UserId {
+			return errors.New("令牌不属于该用户")
+		}
+	}
+	return nil
+}
+
+// Info 记录到计价数据中的覆盖信息
+func (o *PriceOverride) Info() *types.PriceOverrideInfo {
+	return &types.PriceOverrideInfo{
+		Id:           o.Id,
+		TokenId:      o.TokenId,
+		ModelPattern: o.ModelPattern,
+		Type:         o.Type,
+		Value:        o.Value,
+	}
+}
+
+func (o *PriceOverride) Insert() error {
+	now := common.GetTimestamp()
+	o.CreatedTime = now
+	o.UpdatedTime = now
+	if err := DB.Create(o).Error; err != nil {
+		return err
+	}
+	InitPriceOverrideCache()
+	return nil
+}
+
+func (o *PriceOverride) Update() error {
+	o.UpdatedTime = common.GetTimestamp()
+	err := DB.Model(o).Select("user_id", "token_id", "model_pattern", "type", "value", "remark", "updated_time").
+		Updates(o).Error
+	if err != nil {
+		return err
+	}
+	InitPriceOverrideCache()
+	return nil
+}
+
+func (o *PriceOverride) Delete() error {
+	if err := DB.Delete(o).Error; err != nil {
+		return err
+	}
+	InitPriceOverrideCache()
+	return nil
+}
+
+// matchLength 返回匹配的优先级，精确匹配最高，前缀越长优先级越高，-1 表示不匹配
+func (o *PriceOverride) matchLength(modelName string) int {
+	if o.ModelPattern == modelName {
+		return len(modelName) + 1
+	}
+	if prefix, ok := strings.CutSuffix(o.ModelPattern, "*"); ok && strings.HasPrefix(modelName, prefix) {
+		return len(prefix)
+	}
+	return -1
+}
+
+func GetPriceOverrideById(id int) (*PriceOverride, error) {
+	var override PriceOverride
+	if err := DB.First(&override, id).Error; err != nil {
+		return nil, err
+	}
+	return &override, nil
+}
+
+func GetPriceOverrides(userId int, startIdx int, num int) (overrides []*PriceOverride, total int64, err error) {
+	tx := DB.Model(&PriceOverride{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&overrides).Error
+	return overrides, total, err
+}
+
+func InitPriceOverrideCache() {
+	var overrides []*PriceOverride
+	if err := DB.Find(&overrides).Error; err != nil {
+		common.SysLog("failed to load price overrides: " + err.Error())
+		return
+	}
+	cache := make(map[int][]*PriceOverride)
+	for _, override := range overrides {
+		cache[override.UserId] = append(cache[override.UserId], override)
+	}
+	priceOverrideCacheLock.Lock()
+	priceOverrideCache = cache
+	priceOverrideCacheLock.Unlock()
+}
+
+func SyncPriceOverrideCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitPriceOverrideCache()
+	}
+}
+
+// GetUserPriceOverrides 用户的全部价格覆盖（含令牌级别）
+func GetUserPriceOverrides(userId int) []*PriceOverride {
+	priceOverrideCacheLock.RLock()
+	defer priceOverrideCacheLock.RUnlock()
+	overrides := make([]*PriceOverride, len(priceOverrideCache[userId]))
+	copy(overrides, priceOverrideCache[userId])
+	return overrides
+}
+
+// GetPriceOverride 查找请求适用的价格覆盖：令牌级别优先于用户级别，
+// 同一级别中精确匹配优先，其次为最长的前缀匹配
+func GetPriceOverride(userId int, tokenId int, modelName string) (*PriceOverride, bool) {
+	priceOverrideCacheLock.RLock()
+	defer priceOverrideCacheLock.RUnlock()
+	var best *PriceOverride
+	bestLength := -1
+	for _, override := range priceOverrideCache[userId] {
+		if override.TokenId != 0 && override.TokenId != tokenId {
+			continue
+		}
+		length := override.matchLength(modelName)
+		if length < 0 {
+			continue
+		}
+		if best != nil {
+			if best.TokenId != 0 && override.TokenId == 0 {
+				continue
+			}
+			if (best.TokenId == 0) == (override.TokenId == 0) && length <= bestLength {
+				continue
+			}
+		}
+		best, bestLength = override, length
+	}
+	return best, best != nil
+}
@@ -0,0 +1,338 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PriceVersionStatusPending    = 1 // 等待生效
+	PriceVersionStatusActive     = 2 // 当前生效
+	PriceVersionStatusSuperseded = 3 // 已被后续版本替代
+	PriceVersionStatusCancelled  = 4 // 已取消
+)
+
+// PriceVersion 带生效时间的价格表版本，Content 为 配置项 -> 价格表 的 JSON。
+// 等待生效时价格表只包含要修改的模型（值为 null 表示删除），生效时与当时的配置合并，
+// 并保存全部价格表的完整快照，便于追溯历史日志
+type PriceVersion struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(128)"`
+	Description string `json:"description" gorm:"type:text"`
+	Content     string `json:"content" gorm:"type:text"`
+	EffectiveAt int64  `json:"effective_at" gorm:"bigint;index"`
+	Status      int    `json:"status" gorm:"default:1;index"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint"`
+	ActivatedAt int64  `json:"activated_at" gorm:"bigint"`
+}
+
+// PriceChange 单个模型在某个价格表中的变化，值为 nil 表示未配置
+type PriceChange struct {
+	Key   string `json:"key"`
+	Model string `json:"model"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+func (v *PriceVersion) GetContent() (map[string]json.RawMessage, error) {
+	content := make(map[string]json.RawMessage)
+	if v.Content == "" {
+		return content, nil
+	}
+	err := json.Unmarshal([]byte(v.Content), &content)
+	return content, err
+}
+
+// Insert 创建价格版本，生效时间已到时立即生效
+func (v *PriceVersion) Insert() error {
+	content, err := v.GetContent()
+	if err != nil {
+		return fmt.Errorf("价格表格式错误：%w", err)
+	}
+	if len(content) == 0 {
+		return errors.New("价格版本至少需要包含一个价格表")
+	}
+	for key, value := range content {
+		merged, err := mergePriceTable(key, value)
+		if err != nil {
+			return err
+		}
+		if err := ratio_setting.ValidatePriceTable(key, merged); err != nil {
+			return err
+		}
+	}
+	v.Id = 0
+	v.Status = PriceVersionStatusPending
+	v.CreatedAt = common.GetTimestamp()
+	v.ActivatedAt = 0
+	if v.EffectiveAt == 0 {
+		v.EffectiveAt = v.CreatedAt
+	}
+	if err := DB.Create(v).Error; err != nil {
+		return err
+	}
+	if v.EffectiveAt <= common.GetTimestamp() {
+		return v.Activate()
+	}
+	return nil
+}
+
+// Cancel 取消尚未生效的版本
+func (v *PriceVersion) Cancel() error {
+	if v.Status != PriceVersionStatusPending {
+		return errors.New("只能取消尚未生效的价格版本")
+	}
+	v.Status = PriceVersionStatusCancelled
+	return DB.Model(v).Update("status", v.Status).Error
+}
+
+// Activate 将版本中的价格表写入配置并记录为当前版本，所有价格表在同一事务中保存
+func (v *PriceVersion) Activate() error {
+	content, err := v.GetContent()
+	if err != nil {
+		return err
+	}
+	tables := make(map[string]string)
+	for _, key := range ratio_setting.PriceTableKeys {
+		patch, ok := content[key]
+		if !ok {
+			// 未修改的价格表记录当前值，作为该版本的完整快照
+			content[key] = json.RawMessage(currentPriceTable(key))
+			continue
+		}
+		merged, err := mergePriceTable(key, patch)
+		if err != nil {
+			return err
+		}
+		tables[key] = string(merged)
+		content[key] = merged
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	now := common.GetTimestamp()
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		for key, value := range tables {
+			if err := tx.Save(&Option{Key: key, Value: value}).Error; err != nil {
+				return fmt.Errorf("failed to apply %s: %w", key, err)
+			}
+		}
+		if err := tx.Model(&PriceVersion{}).Where("status = ? AND id <> ?", PriceVersionStatusActive, v.Id).
+			Update("status", PriceVersionStatusSuperseded).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(v).Updates(map[string]any{"content": string(data), "status": PriceVersionStatusActive, "activated_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.Save(&Option{Key: "PriceVersionId", Value: strconv.Itoa(v.Id)}).Error
+	})
+	if err != nil {
+		return err
+	}
+	v.Content = string(data)
+	v.Status = PriceVersionStatusActive
+	v.ActivatedAt = now
+	for key, value := range tables {
+		if err := updateOptionMap(key, value); err != nil {
+			return fmt.Errorf("failed to apply %s: %w", key, err)
+		}
+	}
+	return updateOptionMap("PriceVersionId", strconv.Itoa(v.Id))
+}
+
+// UpdatePriceTableOption 直接修改整张价格表时生成一个立即生效的隐式版本，
+// 使日志中记录的价格版本始终对应实际使用的价格
+func UpdatePriceTableOption(key string, value string) error {
+	table := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(value), &table); err != nil {
+		return fmt.Errorf("%s 格式错误：%w", key, err)
+	}
+	current := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(currentPriceTable(key)), &current); err != nil {
+		return fmt.Errorf("当前 %s 格式错误：%w", key, err)
+	}
+	var newTable, currentTable map[string]any
+	_ = json.Unmarshal([]byte(value), &newTable)
+	_ = json.Unmarshal([]byte(currentPriceTable(key)), &currentTable)
+	if len(newTable) == 0 && len(currentTable) == 0 || reflect.DeepEqual(newTable, currentTable) {
+		// 价格表没有变化时不生成新版本
+		return nil
+	}
+	// 新价格表中不存在的模型需显式删除，合并后与提交的价格表一致
+	for name := range current {
+		if _, ok := table[name]; !ok {
+			table[name] = json.RawMessage("null")
+		}
+	}
+	patch, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(map[string]json.RawMessage{key: patch})
+	if err != nil {
+		return err
+	}
+	version := &PriceVersion{
+		Name:    "修改 " + key,
+		Content: string(content),
+	}
+	return version.Insert()
+}
+
+func currentPriceTable(key string) string {
+	common.OptionMapRWMutex.RLock()
+	defer common.OptionMapRWMutex.RUnlock()
+	value := common.OptionMap[key]
+	if value == "" || value == "null" {
+		return "{}"
+	}
+	return value
+}
+
+// mergePriceTable 将按模型修改的价格表合并到当前配置，值为 null 的模型会被删除
+func mergePriceTable(key string, patch json.RawMessage) (json.RawMessage, error) {
+	changes := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, fmt.Errorf("%s 格式错误：%w", key, err)
+	}
+	table := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(currentPriceTable(key)), &table); err != nil {
+		return nil, fmt.Errorf("当前 %s 格式错误：%w", key, err)
+	}
+	for name, value := range changes {
+		if string(value) == "null" {
+			delete(table, name)
+			continue
+		}
+		table[name] = value
+	}
+	return json.Marshal(table)
+}
+
+func GetPriceVersionById(id int) (*PriceVersion, error) {
+	var version PriceVersion
+	if err := DB.First(&version, id).Error; err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+func GetAllPriceVersions(startIdx int, num int) (versions []*PriceVersion, total int64, err error) {
+	err = DB.Model(&PriceVersion{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&versions).Error
+	return versions, total, err
+}
+
+// GetUpcomingPriceVersions 尚未生效的价格版本，按生效时间排序
+func GetUpcomingPriceVersions() ([]*PriceVersion, error) {
+	var versions []*PriceVersion
+	err := DB.Where("status = ?", PriceVersionStatusPending).Order("effective_at asc, id asc").Find(&versions).Error
+	return versions, err
+}
+
+// DiffPriceVersions 比较两个版本的价格表，from 或 to 为 nil 时使用当前生效的配置，
+// 等待生效的版本按合并到当前配置后的结果比较
+func DiffPriceVersions(from *PriceVersion, to *PriceVersion) ([]PriceChange, error) {
+	fromTables, err := resolvePriceTables(from)
+	if err != nil {
+		return nil, err
+	}
+	toTables, err := resolvePriceTables(to)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]PriceChange, 0)
+	for _, key := range ratio_setting.PriceTableKeys {
+		oldTable, newTable := fromTables[key], toTables[key]
+		models := make([]string, 0, len(oldTable)+len(newTable))
+		for name := range oldTable {
+			models = append(models, name)
+		}
+		for name := range newTable {
+			if _, ok := oldTable[name]; !ok {
+				models = append(models, name)
+			}
+		}
+		sort.Strings(models)
+		for _, name := range models {
+			oldValue, newValue := oldTable[name], newTable[name]
+			if reflect.DeepEqual(oldValue, newValue) {
+				continue
+			}
+			changes = append(changes, PriceChange{Key: key, Model: name, Old: oldValue, New: newValue})
+		}
+	}
+	return changes, nil
+}
+
+func resolvePriceTables(version *PriceVersion) (map[string]map[string]any, error) {
+	content := make(map[string]json.RawMessage)
+	if version != nil {
+		var err error
+		if content, err = version.GetContent(); err != nil {
+			return nil, err
+		}
+	}
+	tables := make(map[string]map[string]any, len(ratio_setting.PriceTableKeys))
+	for _, key := range ratio_setting.PriceTableKeys {
+		value, ok := content[key]
+		if !ok {
+			value = json.RawMessage(currentPriceTable(key))
+		} else if version.Status == PriceVersionStatusPending || version.Status == PriceVersionStatusCancelled {
+			merged, err := mergePriceTable(key, value)
+			if err != nil {
+				return nil, err
+			}
+			value = merged
+		}
+		table := make(map[string]any)
+		if err := json.Unmarshal(value, &table); err != nil {
+			return nil, fmt.Errorf("%s 格式错误：%w", key, err)
+		}
+		tables[key] = table
+	}
+	return tables, nil
+}
+
+// ActivateDuePriceVersions 按生效时间依次启用已到期的版本
+func ActivateDuePriceVersions() error {
+	var versions []*PriceVersion
+	err := DB.Where("status = ? AND effective_at <= ?", PriceVersionStatusPending, common.GetTimestamp()).
+		Order("effective_at asc, id asc").Find(&versions).Error
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if err := version.Activate(); err != nil {
+			return fmt.Errorf("failed to activate price version %d: %w", version.Id, err)
+		}
+		common.SysLog(fmt.Sprintf("price version %d (%s) activated", version.Id, version.Name))
+	}
+	return nil
+}
+
+// AutoActivatePriceVersions 定期启用到期的价格版本，仅在主节点运行
+func AutoActivatePriceVersions() {
+	for {
+		if common.IsMasterNode {
+			if err := ActivateDuePriceVersions(); err != nil {
+				common.SysLog(err.Error())
+			}
+		}
+		time.Sleep(time.Minute)
+	}
+}
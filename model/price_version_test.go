@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+)
+
+func setupPriceVersionDB(t *testing.T) {
+	t.Helper()
+	setupTestDB(t, &Option{}, &PriceVersion{})
+	savedOptions, savedVersion := common.OptionMap, ratio_setting.GetPriceVersionId()
+	savedRatio := ratio_setting.ModelRatio2JSONString()
+	t.Cleanup(func() {
+		common.OptionMap = savedOptions
+		ratio_setting.SetPriceVersionId(savedVersion)
+		_ = ratio_setting.UpdateModelRatioByJSONString(savedRatio)
+	})
+	common.OptionMap = map[string]string{"ModelRatio": `{"gpt-4o":1.25,"gpt-4":15}`}
+}
+
+func TestUpdatePriceTableOptionCreatesVersion(t *testing.T) {
+	setupPriceVersionDB(t)
+
+	if err := UpdatePriceTableOption("ModelRatio", `{"gpt-4o":2}`); err != nil {
+		t.Fatal(err)
+	}
+	var versions []*PriceVersion
+	DB.Find(&versions)
+	if len(versions) != 1 || versions[0].Status != PriceVersionStatusActive {
+		t.Fatalf("expected one active version, got %+v", versions)
+	}
+	if got := ratio_setting.GetPriceVersionId(); got != versions[0].Id {
+		t.Fatalf("price version id = %d, want %d", got, versions[0].Id)
+	}
+	// 提交的价格表中删除的模型不再保留
+	if got := common.OptionMap["ModelRatio"]; got != `{"gpt-4o":2}` {
+		t.Fatalf("ModelRatio = %s", got)
+	}
+	var option Option
+	DB.First(&option, "key = ?", "ModelRatio")
+	if option.Value != `{"gpt-4o":2}` {
+		t.Fatalf("saved ModelRatio = %s", option.Value)
+	}
+
+	// 内容未变化时不生成新版本
+	if err := UpdatePriceTableOption("ModelRatio", `{ "gpt-4o": 2.0 }`); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	DB.Model(&PriceVersion{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected 1 version, got %d", count)
+	}
+}
+
+func TestActivateRollsBackOnFailure(t *testing.T) {
+	setupPriceVersionDB(t)
+
+	version := &PriceVersion{Name: "v1", Content: `{"ModelRatio":{"gpt-4o":3}}`, Status: PriceVersionStatusPending}
+	if err := DB.Create(version).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 保存配置失败时整个版本不生效
+	if err := DB.Migrator().DropTable(&Option{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := version.Activate(); err == nil {
+		t.Fatal("expected activation to fail")
+	}
+	reloaded, err := GetPriceVersionById(version.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Status != PriceVersionStatusPending || common.OptionMap["ModelRatio"] != `{"gpt-4o":1.25,"gpt-4":15}` {
+		t.Fatalf("activation was partially applied: status %d, ModelRatio %s", reloaded.Status, common.OptionMap["ModelRatio"])
+	}
+}
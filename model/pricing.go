@@ -15,18 +15,27 @@ import (
 )
 
 type Pricing struct {
-	ModelName              string                  `json:"model_name"`
-	Description            string                  `json:"description,omitempty"`
-	Icon                   string                  `json:"icon,omitempty"`
-	Tags                   string                  `json:"tags,omitempty"`
-	VendorID               int                     `json:"vendor_id,omitempty"`
-	QuotaType              int                     `json:"quota_type"`
-	ModelRatio             float64                 `json:"model_ratio"`
-	ModelPrice             float64                 `json:"model_price"`
-	OwnerBy                string                  `json:"owner_by"`
-	CompletionRatio        float64                 `json:"completion_ratio"`
-	EnableGroup            []string                `json:"enable_groups"`
-	SupportedEndpointTypes []constant.EndpointType `json:"supported_endpoint_types"`
+	ModelName              string                    `json:"model_name"`
+	Description            string                    `json:"description,omitempty"`
+	Icon                   string                    `json:"icon,omitempty"`
+	Tags                   string                    `json:"tags,omitempty"`
+	VendorID               int                       `json:"vendor_id,omitempty"`
+	QuotaType              int                       `json:"quota_type"`
+	ModelRatio             float64                   `json:"model_ratio"`
+	ModelPrice             float64                   `json:"model_price"`
+	OwnerBy                string                    `json:"owner_by"`
+	CompletionRatio        float64                   `json:"completion_ratio"`
+	EnableGroup            []string                  `json:"enable_groups"`
+	SupportedEndpointTypes []constant.EndpointType   `json:"supported_endpoint_types"`
+	PriceTiers             []ratio_setting.PriceTier `json:"price_tiers,omitempty"` // 按输入 token 数分档的倍率
+	ContextLength          int                       `json:"context_length,omitempty"`
+	MaxOutputTokens        int                       `json:"max_output_tokens,omitempty"`
+	InputModalities        []string                  `json:"input_modalities,omitempty"`
+	OutputModalities       []string                  `json:"output_modalities,omitempty"`
+	SupportsTools          *bool                     `json:"supports_tools,omitempty"`
+	SupportsVision         *bool                     `json:"supports_vision,omitempty"`
+	SupportsReasoning      *bool                     `json:"supports_reasoning,omitempty"`
+	KnowledgeCutoff        string                    `json:"knowledge_cutoff,omitempty"`
 }
 
 type PricingVendor struct {
@@ -47,6 +56,9 @@ var (
 	modelEnableGroups     = make(map[string][]string)
 	modelQuotaTypeMap     = make(map[string]int)
 	modelEnableGroupsLock = sync.RWMutex{}
+
+	// 模型名 -> 元数据（含按名称规则匹配到的模型）
+	modelMetaMap = make(map[string]*Model)
 )
 
 var (
@@ -280,16 +292,31 @@ func updatePricing() {
 			pricing.Icon = meta.Icon
 			pricing.Tags = meta.Tags
 			pricing.VendorID = meta.VendorID
+			pricing.ContextLength = meta.ContextLength
+			pricing.MaxOutputTokens = meta.MaxOutputTokens
+			pricing.InputModalities = meta.GetInputModalities()
+			pricing.OutputModalities = meta.GetOutputModalities()
+			pricing.SupportsTools = meta.SupportsTools
+			pricing.SupportsVision = meta.SupportsVision
+			pricing.SupportsReasoning = meta.SupportsReasoning
+			pricing.KnowledgeCutoff = meta.KnowledgeCutoff
 		}
 		modelPrice, findPrice := ratio_setting.GetModelPrice(model, false)
 		if findPrice {
 			pricing.ModelPrice = modelPrice
 			pricing.QuotaType = 1
 		} else {
-			modelRatio, _, _ := ratio_setting.GetModelRatio(model)
+			modelRatio, success, _ := ratio_setting.GetModelRatio(model)
 			pricing.ModelRatio = modelRatio
 			pricing.CompletionRatio = ratio_setting.GetCompletionRatio(model)
 			pricing.QuotaType = 0
+			if tiers, ok := ratio_setting.GetTieredRatio(model); ok && len(tiers) > 0 {
+				pricing.PriceTiers = tiers
+				// 未单独配置倍率时以第一档作为展示的基础倍率
+				if !success {
+					pricing.ModelRatio, pricing.CompletionRatio, _, _ = tiers[0].Ratios(pricing.CompletionRatio, 0, 0)
+				}
+			}
 		}
 		pricingMap = append(pricingMap, pricing)
 	}
@@ -302,11 +329,26 @@ func updatePricing() {
 		modelEnableGroups[p.ModelName] = p.EnableGroup
 		modelQuotaTypeMap[p.ModelName] = p.QuotaType
 	}
+	modelMetaMap = metaMap
 	modelEnableGroupsLock.Unlock()
 
 	lastGetPricingTime = time.Now()
 }
 
+// GetModelMeta 获取模型元数据，缓存随定价信息每分钟刷新
+func GetModelMeta(modelName string) (*Model, bool) {
+	GetPricing()
+	return GetCachedModelMeta(modelName)
+}
+
+// GetCachedModelMeta 只读取缓存，不触发刷新，可在持有渠道缓存锁时调用
+func GetCachedModelMeta(modelName string) (*Model, bool) {
+	modelEnableGroupsLock.RLock()
+	defer modelEnableGroupsLock.RUnlock()
+	meta, ok := modelMetaMap[modelName]
+	return meta, ok
+}
+
 // GetSupportedEndpointMap 返回全局端点到路径的映射
 func GetSupportedEndpointMap() map[string]common.EndpointInfo {
 	return supportedEndpointMap
@@ -0,0 +1,229 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/types"
+)
+
+// 渠道并发已满时，请求按 分组/模型 排队等待空闲渠道。
+// 优先级高的请求先获得渠道；同一优先级按用户轮流调度，避免单个用户的大量请求占满队列。
+// 请求需要的能力不同时可用的渠道也不同，只有排在前面且能力要求不高于自己的请求才会挡住自己，
+// 避免需要图片等能力的请求排在队首时，空闲的普通渠道无法服务后面的请求
+var (
+	requestQueues    = make(map[string]*requestQueue)
+	requestQueueLock sync.Mutex
+	requestQueueSeq  uint64
+)
+
+type requestQueue struct {
+	group     string
+	model     string
+	waiters   []*queueWaiter
+	changed   chan struct{}  // 队列变化时关闭，唤醒等待的请求重新判断是否轮到自己
+	servedAt  map[int]uint64 // 用户最近一次获得渠道的序号，队列清空时重置
+	serveTick uint64
+	stats     requestQueueCounters
+}
+
+type queueWaiter struct {
+	userId     int
+	priority   int
+	features   types.RequestFeatures
+	seq        uint64
+	enqueuedAt time.Time
+}
+
+type requestQueueCounters struct {
+	served      int64
+	timedOut    int64
+	cancelled   int64
+	rejected    int64
+	totalWaitMs int64
+	maxWaitMs   int64
+}
+
+// RequestQueueStats 排队的深度与等待时间，供管理员查看
+type RequestQueueStats struct {
+	Group      string      `json:"group"`
+	Model      string      `json:"model"`
+	Depth      int         `json:"depth"`
+	Priorities map[int]int `json:"priorities"` // 优先级 -> 排队中的请求数
+	Served     int64       `json:"served"`     // 排队后获得渠道的请求数
+	TimedOut   int64       `json:"timed_out"`  // 等待超时
+	Cancelled  int64       `json:"cancelled"`  // 等待中客户端断开
+	Rejected   int64       `json:"rejected"`   // 队列已满被拒绝
+	AvgWaitMs  int64       `json:"avg_wait_ms"`
+	MaxWaitMs  int64       `json:"max_wait_ms"`
+}
+
+func getRequestQueue(group string, model string) *requestQueue {
+	key := group + "/" + model
+	q, ok := requestQueues[key]
+	if !ok {
+		q = &requestQueue{group: group, model: model, changed: make(chan struct{}), servedAt: make(map[int]uint64)}
+		requestQueues[key] = q
+	}
+	return q
+}
+
+// hasQueuedRequests 是否已有能力要求不高于新请求的请求在排队，新请求需要排在它们之后
+func hasQueuedRequests(group string, model string, features types.RequestFeatures) bool {
+	requestQueueLock.Lock()
+	defer requestQueueLock.Unlock()
+	q, ok := requestQueues[group+"/"+model]
+	if !ok {
+		return false
+	}
+	for _, w := range q.waiters {
+		if w.features.Within(features) {
+			return true
+		}
+	}
+	return false
+}
+
+// before a 是否先于 b 获得渠道：优先级高的优先，同优先级中最久没有获得渠道的用户优先，最后按入队顺序
+func (q *requestQueue) before(a *queueWaiter, b *queueWaiter) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	if q.servedAt[a.userId] != q.servedAt[b.userId] {
+		return q.servedAt[a.userId] < q.servedAt[b.userId]
+	}
+	return a.seq < b.seq
+}
+
+// blocked 排在 w 前面的请求中有能力要求不高于 w 的，w 能用的渠道它们也能用，需要等它们先获得渠道
+func (q *requestQueue) blocked(w *queueWaiter) bool {
+	for _, other := range q.waiters {
+		if other != w && q.before(other, w) && other.features.Within(w.features) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *requestQueue) remove(w *queueWaiter) {
+	for i, waiter := range q.waiters {
+		if waiter == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			break
+		}
+	}
+	if len(q.waiters) == 0 {
+		q.servedAt = make(map[int]uint64)
+	}
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// waitInRequestQueue 排队直到轮到该请求且 try 不再返回并发已满，超过 deadline、队列已满或客户端断开时返回错误。
+// try 成功时需要已占用渠道名额，否则同时被唤醒的请求会选中同一渠道
+func waitInRequestQueue(ctx context.Context, group string, model string, userId int, priority int, features types.RequestFeatures,
+	deadline time.Time, maxSize int, try func() (*Channel, string, error)) (*Channel, string, error) {
+	requestQueueLock.Lock()
+	q := getRequestQueue(group, model)
+	if len(q.waiters) >= maxSize {
+		q.stats.rejected++
+		requestQueueLock.Unlock()
+		return nil, group, &ChannelsSaturatedError{Group: group, Model: model, QueueFull: true}
+	}
+	requestQueueSeq++
+	w := &queueWaiter{userId: userId, priority: priority, features: features, seq: requestQueueSeq, enqueuedAt: time.Now()}
+	q.waiters = append(q.waiters, w)
+	requestQueueLock.Unlock()
+
+	leave := func(counter *int64, served bool) {
+		requestQueueLock.Lock()
+		defer requestQueueLock.Unlock()
+		if served {
+			q.serveTick++
+			q.servedAt[w.userId] = q.serveTick
+		}
+		q.remove(w)
+		if counter != nil {
+			*counter++
+		}
+		if served {
+			waitMs := time.Since(w.enqueuedAt).Milliseconds()
+			q.stats.totalWaitMs += waitMs
+			q.stats.maxWaitMs = max(q.stats.maxWaitMs, waitMs)
+		}
+	}
+
+	var saturated *ChannelsSaturatedError
+	for {
+		released := channelSlotReleasedSignal()
+		requestQueueLock.Lock()
+		isNext := !q.blocked(w)
+		changed := q.changed
+		requestQueueLock.Unlock()
+		if isNext {
+			channel, selectGroup, err := try()
+			if !errors.As(err, &saturated) {
+				if err == nil && channel != nil {
+					leave(&q.stats.served, true)
+				} else {
+					leave(nil, false)
+				}
+				return channel, selectGroup, err
+			}
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			leave(&q.stats.timedOut, false)
+			return nil, group, &ChannelsSaturatedError{Group: group, Model: model}
+		}
+		// 其他节点释放的名额无法通知到本节点，最多等待 200ms 后重新检查
+		timer := time.NewTimer(min(remaining, 200*time.Millisecond))
+		select {
+		case <-released:
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			leave(&q.stats.cancelled, false)
+			return nil, group, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// GetRequestQueueStats 各分组模型的排队情况
+func GetRequestQueueStats() []*RequestQueueStats {
+	requestQueueLock.Lock()
+	defer requestQueueLock.Unlock()
+	stats := make([]*RequestQueueStats, 0, len(requestQueues))
+	for _, q := range requestQueues {
+		stat := &RequestQueueStats{
+			Group:      q.group,
+			Model:      q.model,
+			Depth:      len(q.waiters),
+			Priorities: make(map[int]int),
+			Served:     q.stats.served,
+			TimedOut:   q.stats.timedOut,
+			Cancelled:  q.stats.cancelled,
+			Rejected:   q.stats.rejected,
+			MaxWaitMs:  q.stats.maxWaitMs,
+		}
+		for _, w := range q.waiters {
+			stat.Priorities[w.priority]++
+		}
+		if q.stats.served > 0 {
+			stat.AvgWaitMs = q.stats.totalWaitMs / q.stats.served
+		}
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Group != stats[j].Group {
+			return stats[i].Group < stats[j].Group
+		}
+		return stats[i].Model < stats[j].Model
+	})
+	return stats
+}
@@ -0,0 +1,275 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/types"
+)
+
+// fakeCapacity 模拟渠道空闲名额，名额用完时 try 返回并发已满
+type fakeCapacity struct {
+	mu    sync.Mutex
+	free  int
+	order []string
+}
+
+func (f *fakeCapacity) try(name string) func() (*Channel, string, error) {
+	return func() (*Channel, string, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.free <= 0 {
+			return nil, "default", &ChannelsSaturatedError{Group: "default", Model: "gpt-4o"}
+		}
+		f.free--
+		f.order = append(f.order, name)
+		return &Channel{Id: len(f.order)}, "default", nil
+	}
+}
+
+// release 释放一个名额并等待某个排队请求拿到它
+func (f *fakeCapacity) release(t *testing.T) {
+	t.Helper()
+	f.mu.Lock()
+	served := len(f.order)
+	f.free++
+	f.mu.Unlock()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		done := len(f.order) > served
+		f.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no waiter took the released slot")
+}
+
+func (f *fakeCapacity) served() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.order...)
+}
+
+// testQueueGroup 每个测试使用独立的分组，结束时清理排队状态
+func testQueueGroup(t *testing.T) string {
+	t.Helper()
+	group := t.Name()
+	t.Cleanup(func() {
+		requestQueueLock.Lock()
+		delete(requestQueues, group+"/gpt-4o")
+		requestQueueLock.Unlock()
+	})
+	return group
+}
+
+func queueDepth(group string) int {
+	requestQueueLock.Lock()
+	defer requestQueueLock.Unlock()
+	if q, ok := requestQueues[group+"/gpt-4o"]; ok {
+		return len(q.waiters)
+	}
+	return 0
+}
+
+func waitForQueueDepth(t *testing.T, group string, depth int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if queueDepth(group) == depth {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("queue depth did not reach %d", depth)
+}
+
+type queuedRequest struct {
+	name     string
+	userId   int
+	priority int
+	features types.RequestFeatures
+}
+
+// enqueue 依次入队，确保入队顺序确定
+func enqueue(t *testing.T, ctx context.Context, group string, capacity *fakeCapacity, requests []queuedRequest) *sync.WaitGroup {
+	t.Helper()
+	var wg sync.WaitGroup
+	depth := queueDepth(group)
+	for i, r := range requests {
+		wg.Add(1)
+		go func(r queuedRequest) {
+			defer wg.Done()
+			_, _, _ = waitInRequestQueue(ctx, group, "gpt-4o", r.userId, r.priority, r.features,
+				time.Now().Add(5*time.Second), 10, capacity.try(r.name))
+		}(r)
+		waitForQueueDepth(t, group, depth+i+1)
+	}
+	return &wg
+}
+
+func TestRequestQueueServesHigherPriorityFirst(t *testing.T) {
+	group := testQueueGroup(t)
+	capacity := &fakeCapacity{}
+	wg := enqueue(t, context.Background(), group, capacity, []queuedRequest{
+		{name: "low", userId: 1, priority: 0},
+		{name: "high", userId: 2, priority: 10},
+		{name: "low-2", userId: 3, priority: 0},
+	})
+	for range 3 {
+		capacity.release(t)
+	}
+	wg.Wait()
+
+	want := []string{"high", "low", "low-2"}
+	if got := capacity.served(); len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("served order = %v, want %v", got, want)
+	}
+}
+
+func TestRequestQueueRotatesUsersWithinPriority(t *testing.T) {
+	group := testQueueGroup(t)
+	capacity := &fakeCapacity{}
+	wg := enqueue(t, context.Background(), group, capacity, []queuedRequest{
+		{name: "u1-a", userId: 1},
+		{name: "u1-b", userId: 1},
+		{name: "u1-c", userId: 1},
+		{name: "u2-a", userId: 2},
+	})
+	for range 4 {
+		capacity.release(t)
+	}
+	wg.Wait()
+
+	// 用户 1 先入队，但获得一次渠道后让给用户 2
+	want := []string{"u1-a", "u2-a", "u1-b", "u1-c"}
+	got := capacity.served()
+	for i := range want {
+		if i >= len(got) || got[i] != want[i] {
+			t.Fatalf("served order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRequestQueueDoesNotBlockOnStricterHead(t *testing.T) {
+	group := testQueueGroup(t)
+	plain := &fakeCapacity{}
+	var imageTries int
+	var imageMu sync.Mutex
+	// 支持图片输入的渠道一直满载
+	imageTry := func() (*Channel, string, error) {
+		imageMu.Lock()
+		imageTries++
+		imageMu.Unlock()
+		return nil, group, &ChannelsSaturatedError{Group: group, Model: "gpt-4o"}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	imageFeatures := types.RequestFeatures{ImageInput: true}
+	imageDone := make(chan struct{})
+	go func() {
+		defer close(imageDone)
+		_, _, _ = waitInRequestQueue(ctx, group, "gpt-4o", 1, 0, imageFeatures, time.Now().Add(5*time.Second), 10, imageTry)
+	}()
+	waitForQueueDepth(t, group, 1)
+
+	if !hasQueuedRequests(group, "gpt-4o", imageFeatures) {
+		t.Fatal("a request with the same features must queue behind the head")
+	}
+	if hasQueuedRequests(group, "gpt-4o", types.RequestFeatures{}) {
+		t.Fatal("a plain request is not blocked by a queued image request")
+	}
+
+	// 排在后面的普通请求在普通渠道空闲时直接获得渠道
+	wg := enqueue(t, ctx, group, plain, []queuedRequest{{name: "plain", userId: 2}})
+	plain.release(t)
+	wg.Wait()
+	if got := plain.served(); len(got) != 1 || got[0] != "plain" {
+		t.Fatalf("served = %v, want [plain]", got)
+	}
+
+	cancel()
+	<-imageDone
+	imageMu.Lock()
+	defer imageMu.Unlock()
+	if imageTries == 0 {
+		t.Fatal("the image request at the head should have tried")
+	}
+}
+
+func TestRequestQueueTimesOut(t *testing.T) {
+	group := testQueueGroup(t)
+	capacity := &fakeCapacity{}
+	start := time.Now()
+	_, _, err := waitInRequestQueue(context.Background(), group, "gpt-4o", 1, 0, types.RequestFeatures{},
+		time.Now().Add(50*time.Millisecond), 10, capacity.try("late"))
+
+	var saturated *ChannelsSaturatedError
+	if !errors.As(err, &saturated) || saturated.QueueFull {
+		t.Fatalf("err = %v, want saturated error", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Fatalf("returned after %v, before the deadline", waited)
+	}
+	stats := findRequestQueueStats(t, group)
+	if stats.Depth != 0 || stats.TimedOut != 1 {
+		t.Fatalf("stats = %+v, want empty queue with one timeout", stats)
+	}
+}
+
+func TestRequestQueueCancelled(t *testing.T) {
+	group := testQueueGroup(t)
+	capacity := &fakeCapacity{}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := waitInRequestQueue(ctx, group, "gpt-4o", 1, 0, types.RequestFeatures{},
+			time.Now().Add(5*time.Second), 10, capacity.try("cancelled"))
+		errCh <- err
+	}()
+	waitForQueueDepth(t, group, 1)
+	cancel()
+
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	stats := findRequestQueueStats(t, group)
+	if stats.Depth != 0 || stats.Cancelled != 1 {
+		t.Fatalf("stats = %+v, want empty queue with one cancellation", stats)
+	}
+}
+
+func TestRequestQueueRejectsWhenFull(t *testing.T) {
+	group := testQueueGroup(t)
+	capacity := &fakeCapacity{}
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := enqueue(t, ctx, group, capacity, []queuedRequest{{name: "first", userId: 1}})
+
+	_, _, err := waitInRequestQueue(context.Background(), group, "gpt-4o", 2, 0, types.RequestFeatures{},
+		time.Now().Add(5*time.Second), 1, capacity.try("second"))
+	var saturated *ChannelsSaturatedError
+	if !errors.As(err, &saturated) || !saturated.QueueFull {
+		t.Fatalf("err = %v, want queue full error", err)
+	}
+	cancel()
+	wg.Wait()
+	if stats := findRequestQueueStats(t, group); stats.Rejected != 1 {
+		t.Fatalf("rejected = %d, want 1", stats.Rejected)
+	}
+}
+
+func findRequestQueueStats(t *testing.T, group string) *RequestQueueStats {
+	t.Helper()
+	for _, stats := range GetRequestQueueStats() {
+		if stats.Group == group {
+			return stats
+		}
+	}
+	t.Fatalf("no stats for group %s", group)
+	return nil
+}
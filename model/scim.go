@@ -0,0 +1,131 @@
+package model
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// SCIM 过滤属性到数据库列的映射，仅支持 eq 过滤
+var scimUserFilterColumns = map[string]string{
+	"id":           "id",
+	"externalid":   "external_id",
+	"displayname":  "display_name",
+	"emails":       "email",
+	"emails.value": "email",
+}
+
+var scimGroupFilterColumns = map[string]string{
+	"id":          "id",
+	"displayname": "name",
+	"externalid":  "external_id",
+}
+
+var ErrSCIMUnsupportedFilter = errors.New("unsupported filter attribute")
+
+// SearchSCIMUsers 按 SCIM 过滤条件查询用户，attr 为空时返回全部用户
+func SearchSCIMUsers(attr string, value string, startIdx int, num int) ([]*User, int64, error) {
+	var users []*User
+	var total int64
+	query := DB.Model(&User{})
+	if attr != "" {
+		if strings.ToLower(attr) == "username" {
+			// 非 SCIM 创建的用户没有 scim_user_name，按本地用户名匹配
+			query = query.Where("scim_user_name = ? OR ((scim_user_name = '' OR scim_user_name IS NULL) AND username = ?)", value, value)
+		} else {
+			column, ok := scimUserFilterColumns[strings.ToLower(attr)]
+			if !ok {
+				return nil, 0, ErrSCIMUnsupportedFilter
+			}
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Omit("password").Order("id asc").Limit(num).Offset(startIdx).Find(&users).Error
+	return users, total, err
+}
+
+// SearchSCIMGroups 按 SCIM 过滤条件查询用户组，attr 为空时返回全部用户组
+func SearchSCIMGroups(attr string, value string, startIdx int, num int) ([]*UserGroup, int64, error) {
+	var groups []*UserGroup
+	var total int64
+	query := DB.Model(&UserGroup{})
+	if attr != "" {
+		column, ok := scimGroupFilterColumns[strings.ToLower(attr)]
+		if !ok {
+			return nil, 0, ErrSCIMUnsupportedFilter
+		}
+		query = query.Where(column+" = ?", value)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id asc").Limit(num).Offset(startIdx).Find(&groups).Error
+	return groups, total, err
+}
+
+// GetUsersByGroup 获取属于指定分组的所有用户
+func GetUsersByGroup(group string) ([]*User, error) {
+	var users []*User
+	err := DB.Omit("password").Where(commonGroupCol+" = ?", group).Find(&users).Error
+	return users, err
+}
+
+// UpdateUserGroup 修改用户所属分组并刷新缓存
+func UpdateUserGroup(userId int, group string) error {
+	if err := DB.Model(&User{}).Where("id = ?", userId).Update("group", group).Error; err != nil {
+		return err
+	}
+	gopool.Go(func() {
+		if err := updateUserGroupCache(userId, group); err != nil {
+			common.SysLog("failed to update user group cache: " + err.Error())
+		}
+	})
+	return nil
+}
+
+// MoveUsersToGroup 将 from 分组下的所有用户移动到 to 分组
+func MoveUsersToGroup(from string, to string) error {
+	users, err := GetUsersByGroup(from)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := UpdateUserGroup(user.Id, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetUserStatus 修改用户状态并刷新缓存。令牌保持原状态，TokenAuth 会拒绝已禁用用户的令牌，
+// 重新启用用户后令牌随之恢复可用
+func SetUserStatus(userId int, status int) error {
+	if err := DB.Model(&User{}).Where("id = ?", userId).Update("status", status).Error; err != nil {
+		return err
+	}
+	return invalidateUserCache(userId)
+}
+
+// IsSCIMUserNameTaken 检查 SCIM userName 是否已被其他用户（包括已删除用户）使用
+func IsSCIMUserNameTaken(userName string, exceptUserId int) (bool, error) {
+	var count int64
+	err := DB.Unscoped().Model(&User{}).
+		Where("id <> ? AND (scim_user_name = ? OR ((scim_user_name = '' OR scim_user_name IS NULL) AND username = ?))", exceptUserId, userName, userName).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// UpdateSCIMUser 更新 SCIM 管理的基础资料字段并刷新缓存
+func UpdateSCIMUser(user *User) error {
+	err := DB.Model(user).Select("scim_user_name", "display_name", "email", "external_id").Updates(user).Error
+	if err != nil {
+		return err
+	}
+	return invalidateUserCache(user.Id)
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// LongText 大文本列，MySQL 的 text 上限只有 64KB，需使用 longtext，其他数据库使用 text
+type LongText string
+
+func (LongText) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "mysql" {
+		return "longtext"
+	}
+	return "text"
+}
+
+// StoredResponse 网关保存的 Responses API 响应，用于 previous_response_id 续接与查询
+type StoredResponse struct {
+	Id                 int      `json:"-"`
+	ResponseId         string   `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId             int      `json:"-" gorm:"index"`
+	ChannelId          int      `json:"-"`
+	ChannelKeyIndex    int      `json:"-"`
+	Upstream           bool     `json:"-"` // 上游同时保存了该响应，固定到原渠道和密钥时可直接透传 previous_response_id
+	Model              string   `json:"model" gorm:"type:varchar(255)"`
+	PreviousResponseId string   `json:"previous_response_id" gorm:"type:varchar(64)"`
+	Input              LongText `json:"-"` // JSON 数组，本轮输入项
+	Output             LongText `json:"-"` // JSON 数组，本轮输出项
+	Response           LongText `json:"-"` // 完整响应 JSON
+	CreatedAt          int64    `json:"created_at" gorm:"bigint"`
+	ExpiresAt          int64    `json:"expires_at" gorm:"bigint;index"`
+}
+
+func (r *StoredResponse) Insert() error {
+	now := common.GetTimestamp()
+	r.CreatedAt = now
+	r.ExpiresAt = now + int64(model_setting.GetResponsesSettings().RetentionDays)*24*3600
+	return DB.Create(r).Error
+}
+
+// GetStoredResponse 按响应 ID 查询用户自己未过期的响应
+func GetStoredResponse(responseId string, userId int) (*StoredResponse, error) {
+	var response StoredResponse
+	err := DB.Where("response_id = ? AND user_id = ? AND expires_at > ?", responseId, userId, common.GetTimestamp()).
+		First(&response).Error
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// GetStoredResponseChain 沿 previous_response_id 回溯整个会话，按时间正序返回
+func GetStoredResponseChain(responseId string, userId int, maxDepth int) ([]*StoredResponse, error) {
+	chain := make([]*StoredResponse, 0)
+	for responseId != "" {
+		if len(chain) >= maxDepth {
+			return nil, fmt.Errorf("conversation exceeds %d turns", maxDepth)
+		}
+		response, err := GetStoredResponse(responseId, userId)
+		if err != nil {
+			return nil, err
+		}
+		chain = append([]*StoredResponse{response}, chain...)
+		responseId = response.PreviousResponseId
+	}
+	return chain, nil
+}
+
+func DeleteStoredResponse(responseId string, userId int) error {
+	result := DB.Where("response_id = ? AND user_id = ?", responseId, userId).Delete(&StoredResponse{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func DeleteExpiredStoredResponses() (int64, error) {
+	result := DB.Where("expires_at <= ?", common.GetTimestamp()).Delete(&StoredResponse{})
+	return result.RowsAffected, result.Error
+}
+
+// AutoCleanStoredResponses 定期清理过期的响应，仅在主节点运行
+func AutoCleanStoredResponses() {
+	for {
+		if common.IsMasterNode {
+			count, err := DeleteExpiredStoredResponses()
+			if err != nil {
+				common.SysLog("failed to clean stored responses: " + err.Error())
+			} else if count > 0 {
+				common.SysLog(fmt.Sprintf("cleaned %d expired stored responses", count))
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
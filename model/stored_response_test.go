@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+func insertStoredResponse(t *testing.T, responseId string, userId int, previous string) *StoredResponse {
+	t.Helper()
+	response := &StoredResponse{ResponseId: responseId, UserId: userId, PreviousResponseId: previous, Input: "[]", Output: "[]"}
+	if err := response.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestStoredResponseRetention(t *testing.T) {
+	setupTestDB(t, &StoredResponse{})
+	setting := model_setting.GetResponsesSettings()
+	saved := *setting
+	t.Cleanup(func() {
+		*setting = saved
+	})
+	setting.RetentionDays = 7
+
+	active := insertStoredResponse(t, "resp_active", 1, "")
+	if active.ExpiresAt-active.CreatedAt != 7*24*3600 {
+		t.Fatalf("expires_at - created_at = %d", active.ExpiresAt-active.CreatedAt)
+	}
+	expired := insertStoredResponse(t, "resp_expired", 1, "")
+	DB.Model(expired).Update("expires_at", common.GetTimestamp()-1)
+
+	// 过期与其他用户的响应都不可读取
+	if _, err := GetStoredResponse("resp_expired", 1); err == nil {
+		t.Fatal("expired response should not be returned")
+	}
+	if _, err := GetStoredResponse("resp_active", 2); err == nil {
+		t.Fatal("response of another user should not be returned")
+	}
+	if _, err := GetStoredResponse("resp_active", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := DeleteExpiredStoredResponses()
+	if err != nil || count != 1 {
+		t.Fatalf("deleted %d, err %v", count, err)
+	}
+	var remaining []string
+	DB.Model(&StoredResponse{}).Pluck("response_id", &remaining)
+	if len(remaining) != 1 || remaining[0] != "resp_active" {
+		t.Fatalf("remaining responses: %v", remaining)
+	}
+}
+
+func TestGetStoredResponseChain(t *testing.T) {
+	setupTestDB(t, &StoredResponse{})
+	insertStoredResponse(t, "resp_1", 1, "")
+	insertStoredResponse(t, "resp_2", 1, "resp_1")
+	insertStoredResponse(t, "resp_3", 1, "resp_2")
+
+	chain, err := GetStoredResponseChain("resp_3", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[0].ResponseId != "resp_1" || chain[2].ResponseId != "resp_3" {
+		t.Fatalf("unexpected chain order: %+v", chain)
+	}
+	// 超过回放深度时拒绝
+	if _, err := GetStoredResponseChain("resp_3", 1, 2); err == nil {
+		t.Fatal("expected replay depth error")
+	}
+	// 会话中缺少某一轮时拒绝
+	DB.Where("response_id = ?", "resp_2").Delete(&StoredResponse{})
+	if _, err := GetStoredResponseChain("resp_3", 1, 10); err == nil {
+		t.Fatal("expected error for broken chain")
+	}
+}
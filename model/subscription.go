@@ -0,0 +1,427 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SubscriptionProviderStripe = "stripe"
+	SubscriptionProviderManual = "manual"
+)
+
+const (
+	SubscriptionStatusActive   = "active"
+	SubscriptionStatusPastDue  = "past_due"
+	SubscriptionStatusCanceled = "canceled"
+	SubscriptionStatusExpired  = "expired"
+)
+
+// SubscriptionPlan 管理员定义的订阅套餐，每个计费周期发放 Quota 并将用户切换到 Group
+type SubscriptionPlan struct {
+	Id            int     `json:"id"`
+	Name          string  `json:"name" gorm:"type:varchar(64);not null"`
+	Description   string  `json:"description" gorm:"type:varchar(255);default:''"`
+	Price         float64 `json:"price" gorm:"default:0"`
+	Currency      string  `json:"currency" gorm:"type:varchar(8);default:'USD'"`
+	Quota         int     `json:"quota" gorm:"default:0"`
+	Group         string  `json:"group" gorm:"type:varchar(64);default:''"`
+	StripePriceId string  `json:"stripe_price_id" gorm:"type:varchar(128);index;default:''"`
+	SortOrder     int     `json:"sort_order" gorm:"default:0"`
+	Enabled       bool    `json:"enabled" gorm:"default:true"`
+	CreatedTime   int64   `json:"created_time" gorm:"bigint"`
+	UpdatedTime   int64   `json:"updated_time" gorm:"bigint"`
+}
+
+// UserSubscription 用户订阅记录，同一用户同时最多一条 active/past_due 记录
+type UserSubscription struct {
+	Id                   int    `json:"id"`
+	UserId               int    `json:"user_id" gorm:"index"`
+	PlanId               int    `json:"plan_id" gorm:"index"`
+	Provider             string `json:"provider" gorm:"type:varchar(16)"`
+	Status               string `json:"status" gorm:"type:varchar(16);index"`
+	StripeSubscriptionId string `json:"stripe_subscription_id" gorm:"type:varchar(128);index;default:''"`
+	// PreviousGroup 开通套餐前的用户分组，套餐结束后恢复
+	PreviousGroup      string `json:"previous_group" gorm:"type:varchar(64);default:''"`
+	CurrentPeriodStart int64  `json:"current_period_start" gorm:"bigint"`
+	CurrentPeriodEnd   int64  `json:"current_period_end" gorm:"bigint"`
+	// LastGrantedPeriod 最近一次已发放额度的周期开始时间，用于防止重复发放
+	LastGrantedPeriod int64 `json:"last_granted_period" gorm:"bigint;default:0"`
+	GraceUntil        int64 `json:"grace_until" gorm:"bigint;default:0"`
+	CancelAtPeriodEnd bool  `json:"cancel_at_period_end" gorm:"default:false"`
+	// ExpireTime 手动套餐的结束时间，0 表示持续续期直到取消
+	ExpireTime  int64 `json:"expire_time" gorm:"bigint;default:0"`
+	CreatedTime int64 `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64 `json:"updated_time" gorm:"bigint"`
+}
+
+func (s *UserSubscription) IsLive() bool {
+	return s.Status == SubscriptionStatusActive || s.Status == SubscriptionStatusPastDue
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	now := common.GetTimestamp()
+	plan.CreatedTime = now
+	plan.UpdatedTime = now
+	return DB.Create(plan).Error
+}
+
+func (plan *SubscriptionPlan) Update() error {
+	plan.UpdatedTime = common.GetTimestamp()
+	return DB.Model(plan).Select("name", "description", "price", "currency", "quota", "group",
+		"stripe_price_id", "sort_order", "enabled", "updated_time").Updates(plan).Error
+}
+
+func GetSubscriptionPlans(enabledOnly bool) (plans []*SubscriptionPlan, err error) {
+	tx := DB.Model(&SubscriptionPlan{})
+	if enabledOnly {
+		tx = tx.Where("enabled = ?", true)
+	}
+	err = tx.Order("sort_order asc, id asc").Find(&plans).Error
+	return plans, err
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	plan := &SubscriptionPlan{}
+	err := DB.First(plan, "id = ?", id).Error
+	return plan, err
+}
+
+func GetSubscriptionPlanByStripePriceId(priceId string) (*SubscriptionPlan, error) {
+	if priceId == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	plan := &SubscriptionPlan{}
+	err := DB.First(plan, "stripe_price_id = ?", priceId).Error
+	return plan, err
+}
+
+func DeleteSubscriptionPlanById(id int) error {
+	var count int64
+	err := DB.Model(&UserSubscription{}).Where("plan_id = ? AND status IN ?", id,
+		[]string{SubscriptionStatusActive, SubscriptionStatusPastDue}).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("该套餐仍有生效中的订阅，无法删除，请先停用")
+	}
+	return DB.Delete(&SubscriptionPlan{}, "id = ?", id).Error
+}
+
+func GetUserSubscriptionById(id int) (*UserSubscription, error) {
+	sub := &UserSubscription{}
+	err := DB.First(sub, "id = ?", id).Error
+	return sub, err
+}
+
+func GetUserSubscriptionByStripeId(stripeSubscriptionId string) (*UserSubscription, error) {
+	if stripeSubscriptionId == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	sub := &UserSubscription{}
+	err := DB.First(sub, "stripe_subscription_id = ?", stripeSubscriptionId).Error
+	return sub, err
+}
+
+// GetLiveUserSubscription 获取用户当前生效（含宽限期）的订阅
+func GetLiveUserSubscription(userId int) (*UserSubscription, error) {
+	sub := &UserSubscription{}
+	err := DB.Where("user_id = ? AND status IN ?", userId,
+		[]string{SubscriptionStatusActive, SubscriptionStatusPastDue}).Order("id desc").First(sub).Error
+	return sub, err
+}
+
+func GetUserSubscriptions(userId int, status string, startIdx int, num int) (subs []*UserSubscription, total int64, err error) {
+	tx := DB.Model(&UserSubscription{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&subs).Error
+	return subs, total, err
+}
+
+// CreateUserSubscription 为用户创建订阅记录并记下当前分组，额度由 GrantSubscriptionPeriod 发放
+func CreateUserSubscription(sub *UserSubscription) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&UserSubscription{}).Where("user_id = ? AND status IN ?", sub.UserId,
+			[]string{SubscriptionStatusActive, SubscriptionStatusPastDue}).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("用户已有生效中的订阅")
+		}
+		user := &User{}
+		if err := tx.Select("id", commonGroupCol).First(user, "id = ?", sub.UserId).Error; err != nil {
+			return err
+		}
+		now := common.GetTimestamp()
+		sub.PreviousGroup = user.Group
+		sub.Status = SubscriptionStatusActive
+		sub.CreatedTime = now
+		sub.UpdatedTime = now
+		return tx.Create(sub).Error
+	})
+}
+
+// GrantSubscriptionPeriod 进入新计费周期：发放套餐额度、切换分组并清除宽限期，同一周期重复调用不会重复发放
+func GrantSubscriptionPeriod(subId int, periodStart int64, periodEnd int64) error {
+	var sub UserSubscription
+	var plan *SubscriptionPlan
+	granted := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Set("gorm:query_option", "FOR UPDATE").First(&sub, "id = ?", subId).Error
+		if err != nil {
+			return err
+		}
+		plan = &SubscriptionPlan{}
+		if err := tx.First(plan, "id = ?", sub.PlanId).Error; err != nil {
+			return err
+		}
+		sub.Status = SubscriptionStatusActive
+		sub.GraceUntil = 0
+		if periodEnd > sub.CurrentPeriodEnd {
+			sub.CurrentPeriodStart = periodStart
+			sub.CurrentPeriodEnd = periodEnd
+		}
+		if periodStart > sub.LastGrantedPeriod {
+			sub.LastGrantedPeriod = periodStart
+			granted = true
+		}
+		sub.UpdatedTime = common.GetTimestamp()
+		if err := tx.Save(&sub).Error; err != nil {
+			return err
+		}
+		updates := map[string]interface{}{}
+		if granted && plan.Quota > 0 {
+			updates["quota"] = gorm.Expr("quota + ?", plan.Quota)
+		}
+		if plan.Group != "" {
+			updates["group"] = plan.Group
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(&User{}).Where("id = ?", sub.UserId).Updates(updates).Error
+	})
+	if err != nil {
+		return err
+	}
+	if granted {
+		RecordQuotaLog(sub.UserId, LogTypeSubscription, fmt.Sprintf("订阅套餐 %s 续期，发放额度 %s，有效期至 %s",
+			plan.Name, logger.FormatQuota(plan.Quota), time.Unix(sub.CurrentPeriodEnd, 0).Format("2006-01-02 15:04:05")),
+			plan.Quota, map[string]interface{}{"subscription_id": sub.Id, "plan_id": plan.Id})
+	}
+	return invalidateUserCache(sub.UserId)
+}
+
+// ChangeSubscriptionPlan 切换订阅套餐，prorate 为 true 且新套餐额度更高时按本周期剩余时间补发额度差
+func ChangeSubscriptionPlan(subId int, planId int, prorate bool) error {
+	var sub UserSubscription
+	var oldPlan, newPlan SubscriptionPlan
+	prorated := 0
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Set("gorm:query_option", "FOR UPDATE").First(&sub, "id = ?", subId).Error
+		if err != nil {
+			return err
+		}
+		if !sub.IsLive() {
+			return errors.New("订阅未生效")
+		}
+		if sub.PlanId == planId {
+			return nil
+		}
+		if err := tx.First(&oldPlan, "id = ?", sub.PlanId).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&newPlan, "id = ?", planId).Error; err != nil {
+			return err
+		}
+		now := common.GetTimestamp()
+		if prorate && newPlan.Quota > oldPlan.Quota && sub.CurrentPeriodEnd > now && sub.CurrentPeriodEnd > sub.CurrentPeriodStart {
+			remaining := float64(sub.CurrentPeriodEnd-now) / float64(sub.CurrentPeriodEnd-sub.CurrentPeriodStart)
+			if remaining > 1 {
+				remaining = 1
+			}
+			prorated = int(float64(newPlan.Quota-oldPlan.Quota) * remaining)
+		}
+		sub.PlanId = newPlan.Id
+		sub.UpdatedTime = now
+		if err := tx.Save(&sub).Error; err != nil {
+			return err
+		}
+		updates := map[string]interface{}{}
+		if prorated > 0 {
+			updates["quota"] = gorm.Expr("quota + ?", prorated)
+		}
+		if newPlan.Group != "" {
+			updates["group"] = newPlan.Group
+		} else if oldPlan.Group != "" {
+			updates["group"] = sub.PreviousGroup
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(&User{}).Where("id = ?", sub.UserId).Updates(updates).Error
+	})
+	if err != nil {
+		return err
+	}
+	if newPlan.Id == 0 {
+		// 套餐未变化
+		return nil
+	}
+	RecordQuotaLog(sub.UserId, LogTypeSubscription, fmt.Sprintf("订阅套餐由 %s 变更为 %s，按剩余时间补发额度 %s",
+		oldPlan.Name, newPlan.Name, logger.FormatQuota(prorated)),
+		prorated, map[string]interface{}{"subscription_id": sub.Id, "plan_id": newPlan.Id})
+	return invalidateUserCache(sub.UserId)
+}
+
+// MarkSubscriptionPastDue 续费失败，进入宽限期，宽限期内保留套餐分组
+func MarkSubscriptionPastDue(subId int) error {
+	sub, err := GetUserSubscriptionById(subId)
+	if err != nil {
+		return err
+	}
+	if !sub.IsLive() {
+		return nil
+	}
+	graceUntil := sub.CurrentPeriodEnd
+	if now := common.GetTimestamp(); graceUntil < now {
+		graceUntil = now
+	}
+	graceUntil += int64(operation_setting.GetSubscriptionSetting().GracePeriodDays) * 86400
+	if sub.Status == SubscriptionStatusPastDue && sub.GraceUntil != 0 && sub.GraceUntil < graceUntil {
+		// 已在宽限期内，不重复延长
+		return nil
+	}
+	return DB.Model(sub).Updates(map[string]interface{}{
+		"status":       SubscriptionStatusPastDue,
+		"grace_until":  graceUntil,
+		"updated_time": common.GetTimestamp(),
+	}).Error
+}
+
+func SetSubscriptionCancelAtPeriodEnd(subId int, cancel bool) error {
+	return DB.Model(&UserSubscription{}).Where("id = ?", subId).Updates(map[string]interface{}{
+		"cancel_at_period_end": cancel,
+		"updated_time":         common.GetTimestamp(),
+	}).Error
+}
+
+// EndUserSubscription 结束订阅，若用户仍处于套餐分组则恢复为开通前的分组
+func EndUserSubscription(subId int, status string) error {
+	var sub UserSubscription
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Set("gorm:query_option", "FOR UPDATE").First(&sub, "id = ?", subId).Error
+		if err != nil {
+			return err
+		}
+		if !sub.IsLive() {
+			return nil
+		}
+		sub.Status = status
+		sub.UpdatedTime = common.GetTimestamp()
+		if err := tx.Save(&sub).Error; err != nil {
+			return err
+		}
+		plan := &SubscriptionPlan{}
+		if err := tx.First(plan, "id = ?", sub.PlanId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if plan.Group == "" || sub.PreviousGroup == "" {
+			return nil
+		}
+		return tx.Model(&User{}).Where("id = ? AND "+commonGroupCol+" = ?", sub.UserId, plan.Group).
+			Update("group", sub.PreviousGroup).Error
+	})
+	if err != nil {
+		return err
+	}
+	RecordLog(sub.UserId, LogTypeSystem, fmt.Sprintf("订阅 #%d 已结束（%s）", sub.Id, status))
+	return invalidateUserCache(sub.UserId)
+}
+
+// processSubscriptions 处理到期订阅：手动套餐自动续期，Stripe 订阅超过宽限期未续费则结束
+func processSubscriptions() {
+	now := common.GetTimestamp()
+	setting := operation_setting.GetSubscriptionSetting()
+	grace := int64(setting.GracePeriodDays) * 86400
+	var subs []*UserSubscription
+	err := DB.Where("status IN ? AND current_period_end <= ?",
+		[]string{SubscriptionStatusActive, SubscriptionStatusPastDue}, now).Find(&subs).Error
+	if err != nil {
+		common.SysLog("failed to query due subscriptions: " + err.Error())
+		return
+	}
+	for _, sub := range subs {
+		var err error
+		switch sub.Provider {
+		case SubscriptionProviderManual:
+			if sub.CancelAtPeriodEnd || (sub.ExpireTime > 0 && sub.ExpireTime <= now) {
+				err = EndUserSubscription(sub.Id, SubscriptionStatusExpired)
+				break
+			}
+			if setting.PeriodDays <= 0 {
+				common.SysLog(fmt.Sprintf("invalid subscription period days %d, skip renewing subscription #%d", setting.PeriodDays, sub.Id))
+				continue
+			}
+			start := sub.CurrentPeriodEnd
+			periodSeconds := int64(setting.PeriodDays) * 86400
+			if start+periodSeconds <= now {
+				// 长时间停机后不补发历史周期，从当前时间重新开始计费周期
+				start = now
+			}
+			end := start + periodSeconds
+			if sub.ExpireTime > 0 && end > sub.ExpireTime {
+				end = sub.ExpireTime
+			}
+			err = GrantSubscriptionPeriod(sub.Id, start, end)
+		default:
+			graceUntil := sub.GraceUntil
+			if graceUntil == 0 {
+				graceUntil = sub.CurrentPeriodEnd + grace
+			}
+			if sub.CancelAtPeriodEnd || graceUntil <= now {
+				err = EndUserSubscription(sub.Id, SubscriptionStatusExpired)
+			} else if sub.Status == SubscriptionStatusActive {
+				err = MarkSubscriptionPastDue(sub.Id)
+			}
+		}
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to process subscription #%d: %s", sub.Id, err.Error()))
+		}
+	}
+}
+
+// AutoProcessSubscriptions 定时处理订阅续期与到期，仅在主节点运行
+func AutoProcessSubscriptions() {
+	for {
+		if common.IsMasterNode && operation_setting.GetSubscriptionSetting().Enabled {
+			processSubscriptions()
+		}
+		time.Sleep(10 * time.Minute)
+	}
+}
+
+func UpdateUserStripeCustomer(userId int, customerId string) error {
+	return DB.Model(&User{}).Where("id = ?", userId).Update("stripe_customer", customerId).Error
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/logger"
 
+	"github.com/bytedance/gopkg/util/gopool"
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
@@ -18,9 +19,52 @@ type TopUp struct {
 	Money         float64 `json:"money"`
 	TradeNo       string  `json:"trade_no" gorm:"unique;type:varchar(255);index"`
 	PaymentMethod string  `json:"payment_method" gorm:"type:varchar(50)"`
-	CreateTime    int64   `json:"create_time"`
-	CompleteTime  int64   `json:"complete_time"`
-	Status        string  `json:"status"`
+	// Provider 支付提供方（epay/stripe/alipay_direct/wechat_native），旧订单为空
+	Provider        string  `json:"provider" gorm:"type:varchar(32);default:''"`
+	ProviderTradeNo string  `json:"provider_trade_no" gorm:"type:varchar(128);default:''"`
+	RefundMoney     float64 `json:"refund_money" gorm:"default:0"`
+	CreateTime      int64   `json:"create_time"`
+	CompleteTime    int64   `json:"complete_time"`
+	Status          string  `json:"status"`
+}
+
+// topUpTransitions 充值订单状态机：已过期的订单在对账时发现已支付仍可完成，已完成的订单只能退款
+var topUpTransitions = map[string][]string{
+	common.TopUpStatusPending: {common.TopUpStatusSuccess, common.TopUpStatusExpired, common.TopUpStatusFailed},
+	common.TopUpStatusExpired: {common.TopUpStatusSuccess},
+	common.TopUpStatusFailed:  {common.TopUpStatusSuccess},
+	common.TopUpStatusSuccess: {common.TopUpStatusRefunded},
+}
+
+func CanTransitTopUpStatus(from string, to string) bool {
+	for _, status := range topUpTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// GetProvider 返回订单的支付提供方，兼容未记录提供方的旧订单
+func (topUp *TopUp) GetProvider() string {
+	if topUp.Provider != "" {
+		return topUp.Provider
+	}
+	if topUp.PaymentMethod == "stripe" {
+		return "stripe"
+	}
+	return "epay"
+}
+
+// getTopUpQuota 计算订单对应的充值额度：
+// - Stripe 订单：Money 代表经分组倍率换算后的美元数量，直接 * QuotaPerUnit
+// - 其他订单（如易支付）：Amount 为美元数量，* QuotaPerUnit
+func getTopUpQuota(topUp *TopUp) int {
+	dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+	if topUp.GetProvider() == "stripe" {
+		return int(decimal.NewFromFloat(topUp.Money).Mul(dQuotaPerUnit).IntPart())
+	}
+	return int(decimal.NewFromInt(topUp.Amount).Mul(dQuotaPerUnit).IntPart())
 }
 
 func (topUp *TopUp) Insert() error {
@@ -55,54 +99,144 @@ func GetTopUpByTradeNo(tradeNo string) *TopUp {
 	return topUp
 }
 
-func Recharge(referenceId string, customerId string) (err error) {
-	if referenceId == "" {
+// CompleteTopUp 支付成功后完成订单并增加用户额度，重复调用不会重复入账
+func CompleteTopUp(tradeNo string, providerTradeNo string, customerId string) (err error) {
+	if tradeNo == "" {
 		return errors.New("未提供支付单号")
 	}
 
-	var quota float64
+	var quota int
+	completed := false
 	topUp := &TopUp{}
 
-	refCol := "`trade_no`"
-	if common.UsingPostgreSQL {
-		refCol = `"trade_no"`
-	}
-
 	err = DB.Transaction(func(tx *gorm.DB) error {
-		err := tx.Set("gorm:query_option", "FOR UPDATE").Where(refCol+" = ?", referenceId).First(topUp).Error
+		err := tx.Where("trade_no = ?", tradeNo).First(topUp).Error
 		if err != nil {
 			return errors.New("充值订单不存在")
 		}
-
-		if topUp.Status != common.TopUpStatusPending {
+		if topUp.Status == common.TopUpStatusSuccess || topUp.Status == common.TopUpStatusRefunded {
+			return nil
+		}
+		if !CanTransitTopUpStatus(topUp.Status, common.TopUpStatusSuccess) {
 			return errors.New("充值订单状态错误")
 		}
+		quota = getTopUpQuota(topUp)
+		if quota <= 0 {
+			return errors.New("无效的充值额度")
+		}
 
-		topUp.CompleteTime = common.GetTimestamp()
-		topUp.Status = common.TopUpStatusSuccess
-		err = tx.Save(topUp).Error
-		if err != nil {
-			return err
+		// 以状态为条件更新，并发回调与对账只有一方能够成功
+		updates := map[string]interface{}{
+			"status":        common.TopUpStatusSuccess,
+			"complete_time": common.GetTimestamp(),
+		}
+		if providerTradeNo != "" {
+			updates["provider_trade_no"] = providerTradeNo
+		}
+		result := tx.Model(&TopUp{}).Where("id = ? AND status = ?", topUp.Id, topUp.Status).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
 		}
 
-		quota = topUp.Money * common.QuotaPerUnit
-		err = tx.Model(&User{}).Where("id = ?", topUp.UserId).Updates(map[string]interface{}{"stripe_customer": customerId, "quota": gorm.Expr("quota + ?", quota)}).Error
-		if err != nil {
+		userUpdates := map[string]interface{}{"quota": gorm.Expr("quota + ?", quota)}
+		if customerId != "" {
+			userUpdates["stripe_customer"] = customerId
+		}
+		if err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Updates(userUpdates).Error; err != nil {
 			return err
 		}
-
+		completed = true
 		return nil
 	})
 
 	if err != nil {
 		return errors.New("充值失败，" + err.Error())
 	}
+	if !completed {
+		return nil
+	}
 
-	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%d", logger.FormatQuota(int(quota)), topUp.Amount))
+	gopool.Go(func() {
+		if err := cacheIncrUserQuota(topUp.UserId, int64(quota)); err != nil {
+			common.SysLog("failed to increase user quota cache: " + err.Error())
+		}
+	})
+	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%.2f", logger.FormatQuota(quota), topUp.Money))
 
 	return nil
 }
 
+// UpdateTopUpStatus 按状态机将订单流转到过期或失败状态
+func UpdateTopUpStatus(tradeNo string, status string) error {
+	topUp := GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		return errors.New("充值订单不存在")
+	}
+	if topUp.Status == status {
+		return nil
+	}
+	if !CanTransitTopUpStatus(topUp.Status, status) {
+		return fmt.Errorf("充值订单状态不能由 %s 变更为 %s", topUp.Status, status)
+	}
+	return DB.Model(&TopUp{}).Where("id = ? AND status = ?", topUp.Id, topUp.Status).Update("status", status).Error
+}
+
+// RefundTopUp 记录订单退款并按退款比例扣回额度，全额退款后订单变为已退款
+func RefundTopUp(tradeNo string, money float64) (quota int, err error) {
+	topUp := &TopUp{}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("trade_no = ?", tradeNo).First(topUp).Error; err != nil {
+			return errors.New("充值订单不存在")
+		}
+		if topUp.Status != common.TopUpStatusSuccess {
+			return errors.New("只有已完成的订单可以退款")
+		}
+		dRemaining := decimal.NewFromFloat(topUp.Money).Sub(decimal.NewFromFloat(topUp.RefundMoney))
+		dMoney := decimal.NewFromFloat(money)
+		if money <= 0 || dMoney.GreaterThan(dRemaining) {
+			return errors.New("退款金额无效")
+		}
+		quota = int(decimal.NewFromInt(int64(getTopUpQuota(topUp))).Mul(dMoney).Div(decimal.NewFromFloat(topUp.Money)).IntPart())
+
+		status := common.TopUpStatusSuccess
+		if dMoney.Equal(dRemaining) {
+			status = common.TopUpStatusRefunded
+		}
+		result := tx.Model(&TopUp{}).Where("id = ? AND refund_money = ?", topUp.Id, topUp.RefundMoney).Updates(map[string]interface{}{
+			"refund_money": dMoney.Add(decimal.NewFromFloat(topUp.RefundMoney)).InexactFloat64(),
+			"status":       status,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("订单正在退款，请稍后重试")
+		}
+		return tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota - ?", quota)).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	gopool.Go(func() {
+		if err := cacheDecrUserQuota(topUp.UserId, int64(quota)); err != nil {
+			common.SysLog("failed to decrease user quota cache: " + err.Error())
+		}
+	})
+	RecordQuotaLog(topUp.UserId, LogTypeManage, fmt.Sprintf("充值订单 %s 退款 %.2f，扣除额度 %s", tradeNo, money, logger.FormatQuota(quota)),
+		-quota, map[string]interface{}{"trade_no": tradeNo})
+	return quota, nil
+}
+
+// GetPendingTopUps 获取指定时间段内创建的待支付订单，用于主动对账
+func GetPendingTopUps(createdAfter int64, createdBefore int64, limit int) (topUps []*TopUp, err error) {
+	err = DB.Where("status = ? AND create_time >= ? AND create_time < ?", common.TopUpStatusPending, createdAfter, createdBefore).
+		Order("id asc").Limit(limit).Find(&topUps).Error
+	return topUps, err
+}
+
 func GetUserTopUps(userId int, pageInfo *common.PageInfo) (topups []*TopUp, total int64, err error) {
 	// Start transaction
 	tx := DB.Begin()
@@ -265,17 +399,7 @@ func ManualCompleteTopUp(tradeNo string) error {
 			return errors.New("订单状态不是待支付，无法补单")
 		}
 
-		// 计算应充值额度：
-		// - Stripe 订单：Money 代表经分组倍率换算后的美元数量，直接 * QuotaPerUnit
-		// - 其他订单（如易支付）：Amount 为美元数量，* QuotaPerUnit
-		if topUp.PaymentMethod == "stripe" {
-			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
-			quotaToAdd = int(decimal.NewFromFloat(topUp.Money).Mul(dQuotaPerUnit).IntPart())
-		} else {
-			dAmount := decimal.NewFromInt(topUp.Amount)
-			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
-			quotaToAdd = int(dAmount.Mul(dQuotaPerUnit).IntPart())
-		}
+		quotaToAdd = getTopUpQuota(topUp)
 		if quotaToAdd <= 0 {
 			return errors.New("无效的充值额度")
 		}
@@ -47,6 +47,10 @@ type User struct {
 	Setting          string         `json:"setting" gorm:"type:text;column:setting"`
 	Remark           string         `json:"remark,omitempty" gorm:"type:varchar(255)" validate:"max=255"`
 	StripeCustomer   string         `json:"stripe_customer" gorm:"type:varchar(64);column:stripe_customer;index"`
+	LdapId           string         `json:"ldap_id" gorm:"column:ldap_id;index"`
+	ExternalId       string         `json:"external_id,omitempty" gorm:"type:varchar(128);column:external_id;index"` // SCIM externalId
+	// SCIM userName，通常为邮箱或 UPN，可能超过本地用户名长度
+	ScimUserName string `json:"scim_user_name,omitempty" gorm:"type:varchar(255);column:scim_user_name;index;default:''"`
 }
 
 func (user *User) ToBaseUser() *UserBase {
@@ -591,6 +595,17 @@ func (user *User) FillUserByDiscordId() error {
 	return nil
 }
 
+func (user *User) FillUserByLdapId() error {
+	if user.LdapId == "" {
+		return errors.New("LDAP id 为空！")
+	}
+	err := DB.Where(User{LdapId: user.LdapId}).First(user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.New("该 LDAP 账户未绑定")
+	}
+	return nil
+}
+
 func IsEmailAlreadyTaken(email string) bool {
 	return DB.Unscoped().Where("email = ?", email).Find(&User{}).RowsAffected == 1
 }
@@ -607,6 +622,10 @@ func IsOidcIdAlreadyTaken(oidcId string) bool {
 	return DB.Where("oidc_id = ?", oidcId).Find(&User{}).RowsAffected == 1
 }
 
+func IsLdapIdAlreadyTaken(ldapId string) bool {
+	return DB.Unscoped().Where("ldap_id = ?", ldapId).Find(&User{}).RowsAffected == 1
+}
+
 func IsTelegramIdAlreadyTaken(telegramId string) bool {
 	return DB.Unscoped().Where("telegram_id = ?", telegramId).Find(&User{}).RowsAffected == 1
 }
@@ -11,11 +11,12 @@ import (
 // UserGroup 用户组表
 type UserGroup struct {
 	Id          int            `json:"id" gorm:"primaryKey;autoIncrement"`
-	Name        string         `json:"name" gorm:"type:varchar(64);not null;index"`    // 用户组名称（可重复）
-	DisplayName string         `json:"display_name" gorm:"type:varchar(128);not null"` // 显示名称
-	Description string         `json:"description" gorm:"type:text"`                   // 描述
-	Config      string         `json:"config" gorm:"type:longtext"`                    // JSON配置，包含自动分配规则等
-	Status      int            `json:"status" gorm:"default:1"`                        // 状态：1启用，2禁用
+	Name        string         `json:"name" gorm:"type:varchar(64);not null;index"`          // 用户组名称（可重复）
+	DisplayName string         `json:"display_name" gorm:"type:varchar(128);not null"`       // 显示名称
+	Description string         `json:"description" gorm:"type:text"`                         // 描述
+	Config      string         `json:"config" gorm:"type:longtext"`                          // JSON配置，包含自动分配规则等
+	Status      int            `json:"status" gorm:"default:1"`                              // 状态：1启用，2禁用
+	ExternalId  string         `json:"external_id,omitempty" gorm:"type:varchar(128);index"` // SCIM externalId
 	CreatedTime int64          `json:"created_time" gorm:"bigint"`
 	UpdatedTime int64          `json:"updated_time" gorm:"bigint"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
@@ -1,6 +1,7 @@
 package channel
 
 import (
+	"errors"
 	"io"
 	"net/http"
 
@@ -12,6 +13,9 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// ErrNotImplemented 适配器不支持该类请求，如 ConvertOpenAIResponsesRequest 返回时 Responses 请求会转换为 Chat Completions
+var ErrNotImplemented = errors.New("not implemented")
+
 type Adaptor interface {
 	// Init IsStream bool
 	Init(info *relaycommon.RelayInfo)
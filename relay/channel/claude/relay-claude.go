@@ -26,6 +26,9 @@ const (
 	WebSearchMaxUsesHigh   = 10
 )
 
+// maxCacheBreakpoints Claude 单个请求允许的 cache_control 断点数量上限
+const maxCacheBreakpoints = 4
+
 func stopReasonClaude2OpenAI(reason string) string {
 	switch reason {
 	case "stop_sequence":
@@ -291,8 +294,9 @@ func RequestOpenAI2ClaudeMessage(c *gin.Context, textRequest dto.GeneralOpenAIRe
 				for _, ctx := range message.ParseContent() {
 					if ctx.Type == "text" {
 						systemMessages = append(systemMessages, dto.ClaudeMediaMessage{
-							Type: "text",
-							Text: common.GetPointer[string](ctx.Text),
+							Type:         "text",
+							Text:         common.GetPointer[string](ctx.Text),
+							CacheControl: ctx.CacheControl,
 						})
 					}
 					// 未来可以在这里扩展对图片等其他类型的支持
@@ -352,7 +356,8 @@ func RequestOpenAI2ClaudeMessage(c *gin.Context, textRequest dto.GeneralOpenAIRe
 				claudeMediaMessages := make([]dto.ClaudeMediaMessage, 0)
 				for _, mediaMessage := range message.ParseContent() {
 					claudeMediaMessage := dto.ClaudeMediaMessage{
-						Type: mediaMessage.Type,
+						Type:         mediaMessage.Type,
+						CacheControl: mediaMessage.CacheControl,
 					}
 					if mediaMessage.Type == "text" {
 						claudeMediaMessage.Text = common.GetPointer[string](mediaMessage.Text)
@@ -413,6 +418,100 @@ func RequestOpenAI2ClaudeMessage(c *gin.Context, textRequest dto.GeneralOpenAIRe
 	return &claudeRequest, nil
 }
 
+// ApplyPromptCachePolicy 按渠道策略为转换后的请求插入 cache_control 断点，请求中已有断点时尊重客户端设置不做处理
+func ApplyPromptCachePolicy(claudeRequest *dto.ClaudeRequest, policy *dto.PromptCacheSettings) {
+	if policy == nil || hasCacheControl(claudeRequest) {
+		return
+	}
+	cacheControl := json.RawMessage(`{"type":"ephemeral"}`)
+	if policy.TTL == "1h" {
+		cacheControl = json.RawMessage(`{"type":"ephemeral","ttl":"1h"}`)
+	}
+	breakpoints := maxCacheBreakpoints
+
+	if policy.Tools {
+		if tools, ok := claudeRequest.Tools.([]any); ok {
+			for i := len(tools) - 1; i >= 0; i-- {
+				if tool, ok := tools[i].(*dto.Tool); ok {
+					tool.CacheControl = cacheControl
+					breakpoints--
+					break
+				}
+			}
+		}
+	}
+
+	if policy.System {
+		if system, ok := claudeRequest.System.(string); ok && system != "" {
+			claudeRequest.System = []dto.ClaudeMediaMessage{{Type: "text", Text: common.GetPointer[string](system)}}
+		}
+		if system, ok := claudeRequest.System.([]dto.ClaudeMediaMessage); ok && len(system) > 0 {
+			system[len(system)-1].CacheControl = cacheControl
+			breakpoints--
+		}
+	}
+
+	turns := min(policy.LastTurns, breakpoints)
+	for i := len(claudeRequest.Messages) - 1; i >= 0 && turns > 0; i-- {
+		message := &claudeRequest.Messages[i]
+		if message.Role != "user" {
+			continue
+		}
+		if content, ok := message.Content.(string); ok {
+			if content == "" {
+				continue
+			}
+			message.Content = []dto.ClaudeMediaMessage{{Type: "text", Text: common.GetPointer[string](content)}}
+		}
+		content, ok := message.Content.([]dto.ClaudeMediaMessage)
+		if !ok || len(content) == 0 {
+			continue
+		}
+		last := &content[len(content)-1]
+		if last.Type == "text" && (last.Text == nil || *last.Text == "") {
+			continue
+		}
+		last.CacheControl = cacheControl
+		turns--
+	}
+}
+
+func hasCacheControl(claudeRequest *dto.ClaudeRequest) bool {
+	if tools, ok := claudeRequest.Tools.([]any); ok {
+		for _, tool := range tools {
+			if t, ok := tool.(*dto.Tool); ok && len(t.CacheControl) > 0 {
+				return true
+			}
+		}
+	}
+	if system, ok := claudeRequest.System.([]dto.ClaudeMediaMessage); ok {
+		for _, item := range system {
+			if len(item.CacheControl) > 0 {
+				return true
+			}
+		}
+	}
+	for _, message := range claudeRequest.Messages {
+		if content, ok := message.Content.([]dto.ClaudeMediaMessage); ok {
+			for _, item := range content {
+				if len(item.CacheControl) > 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// normalizeClaudeUsage Claude 的 input_tokens 不含缓存读取与写入，输出为 OpenAI / Gemini 格式时按 OpenAI 口径计入 prompt_tokens
+func normalizeClaudeUsage(info *relaycommon.RelayInfo, usage *dto.Usage) {
+	if info.RelayFormat == types.RelayFormatClaude {
+		return
+	}
+	usage.PromptTokens += usage.PromptTokensDetails.CachedTokens + usage.PromptTokensDetails.CachedCreationTokens
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+}
+
 func StreamResponseClaude2OpenAI(reqMode int, claudeResponse *dto.ClaudeResponse) *dto.ChatCompletionsStreamResponse {
 	var response dto.ChatCompletionsStreamResponse
 	response.Object = "chat.completion.chunk"
@@ -662,6 +761,19 @@ func HandleStreamResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 		if err != nil {
 			logger.LogError(c, "send_stream_response_failed: "+err.Error())
 		}
+	} else if info.RelayFormat == types.RelayFormatGemini {
+		response := StreamResponseClaude2OpenAI(requestMode, &claudeResponse)
+
+		if !FormatClaudeResponseInfo(requestMode, &claudeResponse, response, claudeInfo) || response == nil {
+			return nil
+		}
+
+		if geminiResponse := service.StreamResponseOpenAI2Gemini(response, info); geminiResponse != nil {
+			err = helper.ObjectData(c, geminiResponse)
+			if err != nil {
+				logger.LogError(c, "send_stream_response_failed: "+err.Error())
+			}
+		}
 	}
 	return nil
 }
@@ -680,6 +792,7 @@ func HandleStreamFinalResponse(c *gin.Context, info *relaycommon.RelayInfo, clau
 			}
 			claudeInfo.Usage = service.ResponseText2Usage(claudeInfo.ResponseText.String(), info.UpstreamModelName, claudeInfo.Usage.PromptTokens)
 		}
+		normalizeClaudeUsage(info, claudeInfo.Usage)
 	}
 
 	if info.RelayFormat == types.RelayFormatClaude {
@@ -693,6 +806,12 @@ func HandleStreamFinalResponse(c *gin.Context, info *relaycommon.RelayInfo, clau
 			}
 		}
 		helper.Done(c)
+	} else if info.RelayFormat == types.RelayFormatGemini {
+		response := helper.GenerateFinalUsageResponse(claudeInfo.ResponseId, claudeInfo.Created, info.UpstreamModelName, *claudeInfo.Usage)
+		err := helper.ObjectData(c, service.FinalStreamResponseOpenAI2Gemini(response, info, claudeInfo.Usage))
+		if err != nil {
+			common.SysLog("send final response failed: " + err.Error())
+		}
 	}
 }
 
@@ -740,6 +859,7 @@ func HandleClaudeResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 		claudeInfo.Usage.TotalTokens = claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens
 		claudeInfo.Usage.PromptTokensDetails.CachedTokens = claudeResponse.Usage.CacheReadInputTokens
 		claudeInfo.Usage.PromptTokensDetails.CachedCreationTokens = claudeResponse.Usage.CacheCreationInputTokens
+		normalizeClaudeUsage(info, claudeInfo.Usage)
 	}
 	var responseData []byte
 	switch info.RelayFormat {
@@ -752,6 +872,13 @@ func HandleClaudeResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 		}
 	case types.RelayFormatClaude:
 		responseData = data
+	case types.RelayFormatGemini:
+		openaiResponse := ResponseClaude2OpenAI(requestMode, &claudeResponse)
+		openaiResponse.Usage = *claudeInfo.Usage
+		responseData, err = json.Marshal(service.ResponseOpenAI2Gemini(openaiResponse, info))
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeBadResponseBody)
+		}
 	}
 
 	if claudeResponse.Usage.ServerToolUse != nil && claudeResponse.Usage.ServerToolUse.WebSearchRequests > 0 {
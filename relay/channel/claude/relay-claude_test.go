@@ -0,0 +1,269 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newGeminiRelayContext(t *testing.T) (*gin.Context, *httptest.ResponseRecorder, *relaycommon.RelayInfo) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/claude-sonnet-4:streamGenerateContent", nil)
+	info := &relaycommon.RelayInfo{
+		RelayFormat:       types.RelayFormatGemini,
+		PromptTokens:      30,
+		GeminiConvertInfo: &relaycommon.GeminiConvertInfo{},
+		ChannelMeta:       &relaycommon.ChannelMeta{UpstreamModelName: "claude-sonnet-4"},
+	}
+	return c, recorder, info
+}
+
+// geminiStreamResponses 解析写给客户端的 Gemini SSE 响应
+func geminiStreamResponses(t *testing.T, body string) []dto.GeminiChatResponse {
+	t.Helper()
+	var responses []dto.GeminiChatResponse
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var response dto.GeminiChatResponse
+		if err := json.Unmarshal([]byte(data), &response); err != nil {
+			t.Fatalf("invalid gemini stream response %q: %v", data, err)
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+func TestClaudeStreamToGemini(t *testing.T) {
+	c, recorder, info := newGeminiRelayContext(t)
+	claudeInfo := &ClaudeResponseInfo{Usage: &dto.Usage{}}
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4","content":[],"usage":{"input_tokens":30,"cache_read_input_tokens":6,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me check."}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":15}}`,
+		`{"type":"message_stop"}`,
+	}
+	for _, event := range events {
+		if apiErr := HandleStreamResponseData(c, info, claudeInfo, event, RequestModeMessage); apiErr != nil {
+			t.Fatal(apiErr)
+		}
+	}
+	HandleStreamFinalResponse(c, info, claudeInfo, RequestModeMessage)
+
+	responses := geminiStreamResponses(t, recorder.Body.String())
+	var text string
+	var functionCalls []*dto.FunctionCall
+	for _, response := range responses {
+		for _, candidate := range response.Candidates {
+			for _, part := range candidate.Content.Parts {
+				text += part.Text
+				if part.FunctionCall != nil {
+					functionCalls = append(functionCalls, part.FunctionCall)
+				}
+			}
+		}
+	}
+	if text != "Let me check." {
+		t.Fatalf("text = %q", text)
+	}
+	// 参数分片累积为完整的 functionCall
+	if len(functionCalls) != 1 || functionCalls[0].Id != "toolu_1" || functionCalls[0].FunctionName != "get_weather" {
+		t.Fatalf("unexpected function calls: %+v", functionCalls)
+	}
+	if args, _ := functionCalls[0].Arguments.(map[string]any); args["city"] != "Paris" {
+		t.Fatalf("unexpected function call args: %+v", functionCalls[0].Arguments)
+	}
+	// 最后一个响应携带完整的 usageMetadata，缓存命中计入输入
+	final := responses[len(responses)-1]
+	if final.UsageMetadata.PromptTokenCount != 36 || final.UsageMetadata.CandidatesTokenCount != 15 ||
+		final.UsageMetadata.TotalTokenCount != 51 || final.UsageMetadata.CachedContentTokenCount != 6 {
+		t.Fatalf("unexpected usageMetadata: %+v", final.UsageMetadata)
+	}
+}
+
+func TestClaudeResponseToGemini(t *testing.T) {
+	c, recorder, info := newGeminiRelayContext(t)
+	body := `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4","stop_reason":"tool_use",
+		"content":[{"type":"text","text":"Let me check."},{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"Paris"}}],
+		"usage":{"input_tokens":30,"output_tokens":15}}`
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+
+	usage, apiErr := ClaudeHandler(c, resp, info, RequestModeMessage)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if usage.PromptTokens != 30 || usage.CompletionTokens != 15 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+	var response dto.GeminiChatResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Candidates) != 1 {
+		t.Fatalf("unexpected candidates: %+v", response.Candidates)
+	}
+	parts := response.Candidates[0].Content.Parts
+	if len(parts) != 2 || parts[0].Text != "Let me check." || parts[1].FunctionCall == nil || parts[1].FunctionCall.FunctionName != "get_weather" {
+		t.Fatalf("unexpected parts: %+v", parts)
+	}
+	if response.UsageMetadata.PromptTokenCount != 30 || response.UsageMetadata.CandidatesTokenCount != 15 || response.UsageMetadata.TotalTokenCount != 45 {
+		t.Fatalf("unexpected usageMetadata: %+v", response.UsageMetadata)
+	}
+}
+
+func newCachePolicyRequest(userTurns int) *dto.ClaudeRequest {
+	request := &dto.ClaudeRequest{
+		System: "You are a helpful assistant.",
+		Tools: []any{
+			&dto.Tool{Name: "get_weather", InputSchema: map[string]any{"type": "object"}},
+			&dto.Tool{Name: "get_time", InputSchema: map[string]any{"type": "object"}},
+		},
+	}
+	for i := range userTurns {
+		request.Messages = append(request.Messages,
+			dto.ClaudeMessage{Role: "user", Content: fmt.Sprintf("question %d", i)},
+			dto.ClaudeMessage{Role: "assistant", Content: fmt.Sprintf("answer %d", i)})
+	}
+	return request
+}
+
+// cachedUserTurns 返回带有 cache_control 的 user 消息下标
+func cachedUserTurns(request *dto.ClaudeRequest) []int {
+	var turns []int
+	for i, message := range request.Messages {
+		if content, ok := message.Content.([]dto.ClaudeMediaMessage); ok && len(content[len(content)-1].CacheControl) > 0 {
+			turns = append(turns, i)
+		}
+	}
+	return turns
+}
+
+func TestApplyPromptCachePolicy(t *testing.T) {
+	request := newCachePolicyRequest(3)
+	ApplyPromptCachePolicy(request, &dto.PromptCacheSettings{System: true, Tools: true, LastTurns: 2, TTL: "1h"})
+
+	tools := request.Tools.([]any)
+	if len(tools[0].(*dto.Tool).CacheControl) > 0 || string(tools[1].(*dto.Tool).CacheControl) != `{"type":"ephemeral","ttl":"1h"}` {
+		t.Fatal("only the last tool should carry the breakpoint")
+	}
+	system, ok := request.System.([]dto.ClaudeMediaMessage)
+	if !ok || len(system) != 1 || *system[0].Text != "You are a helpful assistant." || len(system[0].CacheControl) == 0 {
+		t.Fatalf("string system should become a cached text block: %+v", request.System)
+	}
+	// 最近两条 user 消息，跳过 assistant
+	if turns := cachedUserTurns(request); len(turns) != 2 || turns[0] != 2 || turns[1] != 4 {
+		t.Fatalf("cached turns = %v, want [2 4]", turns)
+	}
+	if _, ok := request.Messages[0].Content.(string); !ok {
+		t.Fatal("older turns should be left untouched")
+	}
+
+	request = newCachePolicyRequest(1)
+	ApplyPromptCachePolicy(request, &dto.PromptCacheSettings{LastTurns: 1})
+	content := request.Messages[0].Content.([]dto.ClaudeMediaMessage)
+	if string(content[0].CacheControl) != `{"type":"ephemeral"}` || len(request.Tools.([]any)[1].(*dto.Tool).CacheControl) > 0 {
+		t.Fatal("default ttl should be 5m and disabled parts left uncached")
+	}
+	if _, ok := request.System.(string); !ok {
+		t.Fatal("system should stay a string when not cached")
+	}
+}
+
+func TestApplyPromptCachePolicyBreakpointCap(t *testing.T) {
+	request := newCachePolicyRequest(5)
+	ApplyPromptCachePolicy(request, &dto.PromptCacheSettings{System: true, Tools: true, LastTurns: 10})
+
+	// 工具与系统提示各占一个断点，最多再缓存两条 user 消息
+	turns := cachedUserTurns(request)
+	if len(turns) != 2 || turns[0] != 6 || turns[1] != 8 {
+		t.Fatalf("cached turns = %v, want the last two", turns)
+	}
+
+	request = newCachePolicyRequest(5)
+	ApplyPromptCachePolicy(request, &dto.PromptCacheSettings{LastTurns: 10})
+	if turns := cachedUserTurns(request); len(turns) != 4 {
+		t.Fatalf("cached turns = %v, want 4 breakpoints", turns)
+	}
+}
+
+func TestApplyPromptCachePolicyRespectsClient(t *testing.T) {
+	request := newCachePolicyRequest(2)
+	request.Messages[0].Content = []dto.ClaudeMediaMessage{{Type: "text", Text: common.GetPointer("question 0"),
+		CacheControl: json.RawMessage(`{"type":"ephemeral"}`)}}
+	ApplyPromptCachePolicy(request, &dto.PromptCacheSettings{System: true, Tools: true, LastTurns: 2})
+
+	if turns := cachedUserTurns(request); len(turns) != 1 || turns[0] != 0 {
+		t.Fatalf("cached turns = %v, want only the client breakpoint", turns)
+	}
+	if _, ok := request.System.(string); !ok || len(request.Tools.([]any)[1].(*dto.Tool).CacheControl) > 0 {
+		t.Fatal("policy should not add breakpoints when the client set its own")
+	}
+
+	// 空的 user 消息不设置断点
+	request = newCachePolicyRequest(2)
+	request.Messages[2].Content = ""
+	ApplyPromptCachePolicy(request, &dto.PromptCacheSettings{LastTurns: 1})
+	if turns := cachedUserTurns(request); len(turns) != 1 || turns[0] != 0 {
+		t.Fatalf("cached turns = %v, want the empty turn skipped", turns)
+	}
+}
+
+func claudeCachedResponse(t *testing.T, format types.RelayFormat) (*dto.Usage, *httptest.ResponseRecorder) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := &relaycommon.RelayInfo{RelayFormat: format, ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "claude-sonnet-4"}}
+	body := `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4","stop_reason":"end_turn",
+		"content":[{"type":"text","text":"Hi"}],
+		"usage":{"input_tokens":100,"cache_read_input_tokens":1000,"cache_creation_input_tokens":200,"output_tokens":50}}`
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+	usage, apiErr := ClaudeHandler(c, resp, info, RequestModeMessage)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	return usage, recorder
+}
+
+func TestNormalizeClaudeUsage(t *testing.T) {
+	// OpenAI 口径的 prompt_tokens 包含缓存读取与写入
+	usage, recorder := claudeCachedResponse(t, types.RelayFormatOpenAI)
+	if usage.PromptTokens != 1300 || usage.TotalTokens != 1350 || usage.CompletionTokens != 50 ||
+		usage.PromptTokensDetails.CachedTokens != 1000 || usage.PromptTokensDetails.CachedCreationTokens != 200 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+	var response dto.OpenAITextResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Usage.PromptTokens != 1300 || response.Usage.PromptTokensDetails.CachedTokens != 1000 {
+		t.Fatalf("unexpected response usage: %+v", response.Usage)
+	}
+
+	// Claude 格式保持 input_tokens 不含缓存
+	usage, _ = claudeCachedResponse(t, types.RelayFormatClaude)
+	if usage.PromptTokens != 100 || usage.TotalTokens != 150 {
+		t.Fatalf("claude usage should be untouched: %+v", usage)
+	}
+}
@@ -216,6 +216,11 @@ func CovertGemini2OpenAI(c *gin.Context, textRequest dto.GeneralOpenAIRequest, i
 					return nil, errors.New("extra_body.google.thinkingConfig is not supported, use extra_body.google.thinking_config instead")
 				}
 
+				// eg. {"google":{"cached_content":"cachedContents/xxx"}}
+				if cachedContent, ok := googleBody["cached_content"].(string); ok {
+					geminiRequest.CachedContent = cachedContent
+				}
+
 				if thinkingConfig, ok := googleBody["thinking_config"].(map[string]interface{}); ok {
 					// check error param name like thinkingBudget, should be thinking_budget
 					if _, hasErrorParam := thinkingConfig["thinkingBudget"]; hasErrorParam {
@@ -251,9 +256,9 @@ func CovertGemini2OpenAI(c *gin.Context, textRequest dto.GeneralOpenAIRequest, i
 	geminiRequest.SafetySettings = safetySettings
 
 	// openaiContent.FuncToToolCalls()
-	if textRequest.Tools != nil {
+	if textRequest.Tools != nil || textRequest.WebSearchOptions != nil {
 		functions := make([]dto.FunctionRequest, 0, len(textRequest.Tools))
-		googleSearch := false
+		googleSearch := textRequest.WebSearchOptions != nil
 		codeExecution := false
 		urlContext := false
 		for _, tool := range textRequest.Tools {
@@ -309,6 +314,10 @@ func CovertGemini2OpenAI(c *gin.Context, textRequest dto.GeneralOpenAIRequest, i
 		geminiRequest.SetTools(geminiTools)
 	}
 
+	if toolConfig := convertToolChoice2Gemini(textRequest.ToolChoice); toolConfig != nil {
+		geminiRequest.ToolConfig = toolConfig
+	}
+
 	if textRequest.ResponseFormat != nil && (textRequest.ResponseFormat.Type == "json_schema" || textRequest.ResponseFormat.Type == "json_object") {
 		geminiRequest.GenerationConfig.ResponseMimeType = "application/json"
 
@@ -493,6 +502,13 @@ func CovertGemini2OpenAI(c *gin.Context, textRequest dto.GeneralOpenAIRequest, i
 		}
 	}
 
+	// 使用上下文缓存时，系统提示与工具已包含在缓存中，上游不允许再次设置
+	if geminiRequest.CachedContent != "" {
+		geminiRequest.SystemInstructions = nil
+		geminiRequest.Tools = nil
+		geminiRequest.ToolConfig = nil
+	}
+
 	return &geminiRequest, nil
 }
 
@@ -826,6 +842,32 @@ func responseGeminiChat2OpenAI(c *gin.Context, response *dto.GeminiChatResponse)
 	return &fullTextResponse
 }
 
+// convertToolChoice2Gemini 将 OpenAI tool_choice 转换为 Gemini functionCallingConfig
+func convertToolChoice2Gemini(toolChoice any) *dto.ToolConfig {
+	var config *dto.FunctionCallingConfig
+	switch choice := toolChoice.(type) {
+	case string:
+		switch choice {
+		case "auto":
+			config = &dto.FunctionCallingConfig{Mode: "AUTO"}
+		case "required":
+			config = &dto.FunctionCallingConfig{Mode: "ANY"}
+		case "none":
+			config = &dto.FunctionCallingConfig{Mode: "NONE"}
+		}
+	case map[string]any:
+		if function, ok := choice["function"].(map[string]any); ok {
+			if name, ok := function["name"].(string); ok && name != "" {
+				config = &dto.FunctionCallingConfig{Mode: "ANY", AllowedFunctionNames: []string{name}}
+			}
+		}
+	}
+	if config == nil {
+		return nil
+	}
+	return &dto.ToolConfig{FunctionCallingConfig: config}
+}
+
 func streamResponseGeminiChat2OpenAI(geminiResponse *dto.GeminiChatResponse) (*dto.ChatCompletionsStreamResponse, bool) {
 	choices := make([]dto.ChatCompletionsStreamResponseChoice, 0, len(geminiResponse.Candidates))
 	isStop := false
@@ -957,6 +999,7 @@ func GeminiChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *
 			usage.PromptTokens = geminiResponse.UsageMetadata.PromptTokenCount
 			usage.CompletionTokens = geminiResponse.UsageMetadata.CandidatesTokenCount
 			usage.CompletionTokenDetails.ReasoningTokens = geminiResponse.UsageMetadata.ThoughtsTokenCount
+			usage.PromptTokensDetails.CachedTokens = geminiResponse.UsageMetadata.CachedContentTokenCount
 			usage.TotalTokens = geminiResponse.UsageMetadata.TotalTokenCount
 			for _, detail := range geminiResponse.UsageMetadata.PromptTokensDetails {
 				if detail.Modality == "AUDIO" {
@@ -1076,6 +1119,7 @@ func GeminiChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.R
 	}
 
 	usage.CompletionTokenDetails.ReasoningTokens = geminiResponse.UsageMetadata.ThoughtsTokenCount
+	usage.PromptTokensDetails.CachedTokens = geminiResponse.UsageMetadata.CachedContentTokenCount
 	usage.CompletionTokens = usage.TotalTokens - usage.PromptTokens
 
 	for _, detail := range geminiResponse.UsageMetadata.PromptTokensDetails {
@@ -24,13 +24,19 @@ func openAIChatToOllamaChat(c *gin.Context, r *dto.GeneralOpenAIRequest) (*Ollam
 		Think:   r.Think,
 	}
 	if r.ResponseFormat != nil {
-		if r.ResponseFormat.Type == "json" {
+		if r.ResponseFormat.Type == "json" || r.ResponseFormat.Type == "json_object" {
 			chatReq.Format = "json"
 		} else if r.ResponseFormat.Type == "json_schema" {
 			if len(r.ResponseFormat.JsonSchema) > 0 {
-				var schema any
-				_ = json.Unmarshal(r.ResponseFormat.JsonSchema, &schema)
-				chatReq.Format = schema
+				// ollama 的 format 为 schema 本身，不含 OpenAI json_schema 外层的 name/strict
+				var jsonSchema dto.FormatJsonSchema
+				if err := json.Unmarshal(r.ResponseFormat.JsonSchema, &jsonSchema); err == nil && jsonSchema.Schema != nil {
+					chatReq.Format = jsonSchema.Schema
+				} else {
+					var schema any
+					_ = json.Unmarshal(r.ResponseFormat.JsonSchema, &schema)
+					chatReq.Format = schema
+				}
 			}
 		}
 	}
@@ -12,6 +12,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel/openai"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
@@ -76,10 +77,22 @@ func ollamaStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 	var responseId = common.GetUUID()
 	var created = time.Now().Unix()
 	var toolCallIndex int
-	start := helper.GenerateStartEmptyResponse(responseId, created, model, nil)
-	if data, err := common.Marshal(start); err == nil {
-		_ = helper.StringData(c, string(data))
+	// Claude、Gemini 格式的请求由 openai 的流转换处理
+	sendChunk := func(chunk *dto.ChatCompletionsStreamResponse) {
+		data, err := common.Marshal(chunk)
+		if err != nil {
+			return
+		}
+		if info.RelayFormat == types.RelayFormatOpenAI {
+			_ = helper.StringData(c, string(data))
+			return
+		}
+		if err = openai.HandleStreamFormat(c, info, string(data), false, false); err != nil {
+			logger.LogError(c, "ollama stream format error: "+err.Error())
+		}
 	}
+	start := helper.GenerateStartEmptyResponse(responseId, created, model, nil)
+	sendChunk(start)
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -137,9 +150,7 @@ func ollamaStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 					delta.Choices[0].Delta.ToolCalls = append(delta.Choices[0].Delta.ToolCalls, tr)
 				}
 			}
-			if data, err := common.Marshal(delta); err == nil {
-				_ = helper.StringData(c, string(data))
-			}
+			sendChunk(&delta)
 			continue
 		}
 		// done frame
@@ -151,14 +162,20 @@ func ollamaStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http
 		if finishReason == "" {
 			finishReason = "stop"
 		}
+		if toolCallIndex > 0 && finishReason == "stop" {
+			finishReason = "tool_calls"
+		}
 		// emit stop delta
 		if stop := helper.GenerateStopResponse(responseId, created, model, finishReason); stop != nil {
-			if data, err := common.Marshal(stop); err == nil {
-				_ = helper.StringData(c, string(data))
-			}
+			sendChunk(stop)
 		}
 		// emit usage frame
 		if final := helper.GenerateFinalUsageResponse(responseId, created, model, *usage); final != nil {
+			if info.RelayFormat != types.RelayFormatOpenAI {
+				data, _ := common.Marshal(final)
+				openai.HandleFinalResponse(c, info, string(data), responseId, created, model, "", usage, true)
+				break
+			}
 			if data, err := common.Marshal(final); err == nil {
 				_ = helper.StringData(c, string(data))
 			}
@@ -189,6 +206,7 @@ func ollamaChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.R
 	var (
 		aggContent       strings.Builder
 		reasoningBuilder strings.Builder
+		toolCalls        []dto.ToolCallResponse
 		lastChunk        ollamaChatStreamChunk
 		parsedAny        bool
 	)
@@ -212,6 +230,16 @@ func ollamaChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.R
 				reasoningBuilder.WriteString(raw)
 			}
 		}
+		if ck.Message != nil {
+			for _, tc := range ck.Message.ToolCalls {
+				argBytes, _ := json.Marshal(tc.Function.Arguments)
+				toolCalls = append(toolCalls, dto.ToolCallResponse{
+					ID:       fmt.Sprintf("call_%d", len(toolCalls)),
+					Type:     "function",
+					Function: dto.FunctionResponse{Name: tc.Function.Name, Arguments: string(argBytes)},
+				})
+			}
+		}
 		if ck.Message != nil && ck.Message.Content != "" {
 			aggContent.WriteString(ck.Message.Content)
 		} else if ck.Response != "" {
@@ -254,6 +282,12 @@ func ollamaChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.R
 	if rc := reasoningBuilder.String(); rc != "" {
 		msg.ReasoningContent = rc
 	}
+	if len(toolCalls) > 0 {
+		msg.SetToolCalls(toolCalls)
+		if finishReason == "stop" {
+			finishReason = "tool_calls"
+		}
+	}
 	full := dto.OpenAITextResponse{
 		Id:      common.GetUUID(),
 		Model:   model,
@@ -266,7 +300,15 @@ func ollamaChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.R
 		}},
 		Usage: *usage,
 	}
-	out, _ := common.Marshal(full)
+	var out []byte
+	switch info.RelayFormat {
+	case types.RelayFormatClaude:
+		out, _ = common.Marshal(service.ResponseOpenAI2Claude(&full, info))
+	case types.RelayFormatGemini:
+		out, _ = common.Marshal(service.ResponseOpenAI2Gemini(&full, info))
+	default:
+		out, _ = common.Marshal(full)
+	}
 	service.IOCopyBytesGracefully(c, resp, out)
 	return usage, nil
 }
@@ -0,0 +1,117 @@
+package ollama
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geminiStreamResponses 解析写给客户端的 Gemini SSE 响应
+func geminiStreamResponses(t *testing.T, body string) []dto.GeminiChatResponse {
+	t.Helper()
+	var responses []dto.GeminiChatResponse
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var response dto.GeminiChatResponse
+		if err := json.Unmarshal([]byte(data), &response); err != nil {
+			t.Fatalf("invalid gemini stream response %q: %v", data, err)
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+func TestOllamaStreamToGemini(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/llama3:streamGenerateContent", nil)
+	info := &relaycommon.RelayInfo{
+		RelayFormat:       types.RelayFormatGemini,
+		PromptTokens:      20,
+		GeminiConvertInfo: &relaycommon.GeminiConvertInfo{},
+		ChannelMeta:       &relaycommon.ChannelMeta{UpstreamModelName: "llama3"},
+	}
+	body := strings.Join([]string{
+		`{"model":"llama3","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"Let me check."},"done":false}`,
+		`{"model":"llama3","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":false}`,
+		`{"model":"llama3","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":20,"eval_count":9}`,
+	}, "\n")
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}
+
+	usage, apiErr := ollamaStreamHandler(c, info, resp)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if usage.PromptTokens != 20 || usage.CompletionTokens != 9 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+
+	responses := geminiStreamResponses(t, recorder.Body.String())
+	var text string
+	var functionCalls []*dto.FunctionCall
+	for _, response := range responses {
+		for _, candidate := range response.Candidates {
+			for _, part := range candidate.Content.Parts {
+				text += part.Text
+				if part.FunctionCall != nil {
+					functionCalls = append(functionCalls, part.FunctionCall)
+				}
+			}
+		}
+	}
+	if text != "Let me check." {
+		t.Fatalf("text = %q", text)
+	}
+	if len(functionCalls) != 1 || functionCalls[0].FunctionName != "get_weather" {
+		t.Fatalf("unexpected function calls: %+v", functionCalls)
+	}
+	if args, _ := functionCalls[0].Arguments.(map[string]any); args["city"] != "Paris" {
+		t.Fatalf("unexpected function call args: %+v", functionCalls[0].Arguments)
+	}
+	final := responses[len(responses)-1]
+	if final.UsageMetadata.PromptTokenCount != 20 || final.UsageMetadata.CandidatesTokenCount != 9 || final.UsageMetadata.TotalTokenCount != 29 {
+		t.Fatalf("unexpected usageMetadata: %+v", final.UsageMetadata)
+	}
+}
+
+func TestConvertGeminiRequestToOllama(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/llama3:generateContent", nil)
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "llama3"}}
+	var request dto.GeminiChatRequest
+	if err := json.Unmarshal([]byte(`{
+		"contents": [{"role": "user", "parts": [{"text": "Summarize"}]}],
+		"generationConfig": {
+			"temperature": 0,
+			"responseMimeType": "application/json",
+			"responseSchema": {"type": "OBJECT", "properties": {"summary": {"type": "STRING"}}}
+		}
+	}`), &request); err != nil {
+		t.Fatal(err)
+	}
+	converted, err := (&Adaptor{}).ConvertGeminiRequest(c, info, &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatRequest := converted.(*OllamaChatRequest)
+	if len(chatRequest.Messages) != 1 || chatRequest.Messages[0].Role != "user" || chatRequest.Messages[0].Content != "Summarize" {
+		t.Fatalf("unexpected messages: %+v", chatRequest.Messages)
+	}
+	// ollama 的 format 为 schema 本身，不含 OpenAI json_schema 外层的 name
+	format, _ := json.Marshal(chatRequest.Format)
+	if string(format) != `{"properties":{"summary":{"type":"string"}},"type":"object"}` {
+		t.Fatalf("format = %s", format)
+	}
+}
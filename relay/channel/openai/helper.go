@@ -229,17 +229,9 @@ func HandleFinalResponse(c *gin.Context, info *relaycommon.RelayInfo, lastStream
 			return
 		}
 
-		// 这里处理的是 openai 最后一个流响应，其 delta 为空，有 finish_reason 字段
-		// 因此相比较于 google 官方的流响应，由 openai 转换而来会多一个 parts 为空，finishReason 为 STOP 的响应
-		// 而包含最后一段文本输出的响应（倒数第二个）的 finishReason 为 null
-		// 暂不知是否有程序会不兼容。
-
-		geminiResponse := service.StreamResponseOpenAI2Gemini(&streamResponse, info)
-
-		// openai 流响应开头的空数据
-		if geminiResponse == nil {
-			return
-		}
+		// 这里处理的是 openai 最后一个流响应，其 delta 为空，有 finish_reason 字段或仅包含 usage
+		// 最后一个 Gemini 响应携带尚未输出的工具调用和完整的 usageMetadata
+		geminiResponse := service.FinalStreamResponseOpenAI2Gemini(&streamResponse, info, usage)
 
 		geminiResponseStr, err := common.Marshal(geminiResponse)
 		if err != nil {
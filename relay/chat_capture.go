@@ -0,0 +1,247 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// doCapturedChatRequest 以非流式发送一次上游请求，截获渠道输出的 OpenAI 格式响应，不写给客户端
+func doCapturedChatRequest(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.GeneralOpenAIRequest) (*dto.OpenAITextResponse, *dto.Usage, *types.NewAPIError) {
+	attemptRequest, err := common.DeepCopy(request)
+	if err != nil {
+		return nil, nil, types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	info.IsStream = false
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(c, info, attemptRequest)
+	if err != nil {
+		return nil, nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return nil, nil, types.NewError(err, types.ErrorCodeJsonMarshalFailed, types.ErrOptionWithSkipRetry())
+	}
+	jsonData, err = relaycommon.RemoveDisabledFields(jsonData, info.ChannelOtherSettings)
+	if err != nil {
+		return nil, nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	if len(info.ParamOverride) > 0 {
+		jsonData, err = relaycommon.ApplyParamOverride(jsonData, info.ParamOverride)
+		if err != nil {
+			return nil, nil, types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid, types.ErrOptionWithSkipRetry())
+		}
+	}
+	logger.LogDebug(c, fmt.Sprintf("captured chat request body: %s", string(jsonData)))
+
+	resp, err := adaptor.DoRequest(c, info, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, nil, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	var httpResp *http.Response
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		info.IsStream = strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream")
+		if httpResp.StatusCode != http.StatusOK {
+			newAPIError := service.RelayErrorHandler(c.Request.Context(), httpResp, false)
+			service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+			return nil, nil, newAPIError
+		}
+	}
+
+	writer := &captureWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
+	c.Writer = writer.ResponseWriter
+	if newAPIError != nil {
+		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+		return nil, nil, newAPIError
+	}
+	response, err := parseCapturedChatResponse(writer.buffer.Bytes(), info.IsStream)
+	if err != nil {
+		return nil, nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	return response, usage.(*dto.Usage), nil
+}
+
+// parseCapturedChatResponse 解析截获的响应，上游强制流式返回时拼接各个分片的内容
+func parseCapturedChatResponse(data []byte, isStream bool) (*dto.OpenAITextResponse, error) {
+	if !isStream {
+		var response dto.OpenAITextResponse
+		if err := common.Unmarshal(data, &response); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal chat response: %w", err)
+		}
+		return &response, nil
+	}
+	response := &dto.OpenAITextResponse{Object: "chat.completion"}
+	var content strings.Builder
+	var toolCalls []dto.ToolCallResponse
+	finishReason := "stop"
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "[DONE]" {
+			continue
+		}
+		var chunk dto.ChatCompletionsStreamResponse
+		if err := common.UnmarshalJsonStr(payload, &chunk); err != nil {
+			continue
+		}
+		response.Id, response.Model, response.Created = chunk.Id, chunk.Model, chunk.Created
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.GetContentString())
+			for _, toolCall := range choice.Delta.ToolCalls {
+				index := len(toolCalls)
+				if toolCall.Index != nil {
+					index = *toolCall.Index
+				}
+				for len(toolCalls) <= index {
+					toolCalls = append(toolCalls, dto.ToolCallResponse{Type: "function"})
+				}
+				if toolCall.ID != "" {
+					toolCalls[index].ID = toolCall.ID
+				}
+				if toolCall.Function.Name != "" {
+					toolCalls[index].Function.Name = toolCall.Function.Name
+				}
+				toolCalls[index].Function.Arguments += toolCall.Function.Arguments
+			}
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				finishReason = *choice.FinishReason
+			}
+		}
+	}
+	message := dto.Message{Role: "assistant", Content: content.String()}
+	if len(toolCalls) > 0 {
+		message.SetToolCalls(toolCalls)
+	}
+	response.Choices = []dto.OpenAITextResponseChoice{{
+		Message:      message,
+		FinishReason: finishReason,
+	}}
+	return response, nil
+}
+
+// writeChatResponse 将合成的响应写给客户端，客户端要求流式时拆成 chat.completion.chunk 输出
+func writeChatResponse(c *gin.Context, info *relaycommon.RelayInfo, response *dto.OpenAITextResponse) *types.NewAPIError {
+	if !info.IsStream {
+		jsonData, err := common.Marshal(response)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeJsonMarshalFailed)
+		}
+		c.Writer.Header().Del("Transfer-Encoding")
+		c.Writer.Header().Set("Content-Type", "application/json")
+		c.Writer.Header().Set("Content-Length", fmt.Sprintf("%d", len(jsonData)))
+		c.Writer.WriteHeader(http.StatusOK)
+		_, _ = c.Writer.Write(jsonData)
+		return nil
+	}
+
+	c.Writer.Header().Del("Content-Length")
+	helper.SetEventStreamHeaders(c)
+	created := common.GetTimestamp()
+	model := info.UpstreamModelName
+	if response.Model != "" {
+		model = response.Model
+	}
+	id := response.Id
+	if id == "" {
+		id = helper.GetResponseID(c)
+	}
+
+	start := helper.GenerateStartEmptyResponse(id, created, model, nil)
+	_ = helper.ObjectData(c, start)
+	finishReason := "stop"
+	if len(response.Choices) > 0 {
+		message := response.Choices[0].Message
+		if response.Choices[0].FinishReason != "" {
+			finishReason = response.Choices[0].FinishReason
+		}
+		chunk := helper.GenerateStartEmptyResponse(id, created, model, nil)
+		chunk.Choices[0].Delta.Role = ""
+		if message.Refusal != nil {
+			chunk.Choices[0].Delta.Content = nil
+			chunk.Choices[0].Delta.Refusal = message.Refusal
+		} else {
+			chunk.Choices[0].Delta.SetContentString(message.StringContent())
+		}
+		if message.ReasoningContent != "" {
+			chunk.Choices[0].Delta.SetReasoningContent(message.ReasoningContent)
+		}
+		_ = helper.ObjectData(c, chunk)
+		for i, toolCall := range message.ParseToolCalls() {
+			toolCallChunk := helper.GenerateStartEmptyResponse(id, created, model, nil)
+			toolCallChunk.Choices[0].Delta = dto.ChatCompletionsStreamResponseChoiceDelta{
+				ToolCalls: []dto.ToolCallResponse{{
+					ID:   toolCall.ID,
+					Type: "function",
+					Function: dto.FunctionResponse{
+						Name:      toolCall.Function.Name,
+						Arguments: toolCall.Function.Arguments,
+					},
+				}},
+			}
+			toolCallChunk.Choices[0].Delta.ToolCalls[0].SetIndex(i)
+			_ = helper.ObjectData(c, toolCallChunk)
+		}
+	}
+	_ = helper.ObjectData(c, helper.GenerateStopResponse(id, created, model, finishReason))
+	if info.ShouldIncludeUsage {
+		_ = helper.ObjectData(c, helper.GenerateFinalUsageResponse(id, created, model, response.Usage))
+	}
+	helper.Done(c)
+	return nil
+}
+
+// addUsage 合并多次上游请求的用量
+func addUsage(total *dto.Usage, usage *dto.Usage) {
+	if usage == nil {
+		return
+	}
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	total.PromptTokensDetails.CachedTokens += usage.PromptTokensDetails.CachedTokens
+	total.PromptTokensDetails.CachedCreationTokens += usage.PromptTokensDetails.CachedCreationTokens
+	total.PromptTokensDetails.TextTokens += usage.PromptTokensDetails.TextTokens
+	total.PromptTokensDetails.ImageTokens += usage.PromptTokensDetails.ImageTokens
+	total.PromptTokensDetails.AudioTokens += usage.PromptTokensDetails.AudioTokens
+	total.CompletionTokenDetails.TextTokens += usage.CompletionTokenDetails.TextTokens
+	total.CompletionTokenDetails.ReasoningTokens += usage.CompletionTokenDetails.ReasoningTokens
+	total.CompletionTokenDetails.AudioTokens += usage.CompletionTokenDetails.AudioTokens
+}
+
+// captureWriter 截获渠道写出的响应，不发送给客户端
+type captureWriter struct {
+	gin.ResponseWriter
+	buffer bytes.Buffer
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	return w.buffer.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	return w.buffer.WriteString(s)
+}
+
+func (w *captureWriter) WriteHeader(int) {}
+
+func (w *captureWriter) WriteHeaderNow() {}
+
+func (w *captureWriter) Flush() {}
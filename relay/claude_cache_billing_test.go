@@ -0,0 +1,68 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay/channel/claude"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestClaudeCachedUsageBilling 不论输出为哪种格式，缓存读取与写入都按各自倍率计费一次：
+// 100 + 1000*0.1 + 200*1.25 + 50*5 = 700
+func TestClaudeCachedUsageBilling(t *testing.T) {
+	for _, format := range []types.RelayFormat{types.RelayFormatOpenAI, types.RelayFormatClaude} {
+		t.Run(string(format), func(t *testing.T) {
+			setupTestDB(t)
+			savedLogConsume := common.LogConsumeEnabled
+			t.Cleanup(func() { common.LogConsumeEnabled = savedLogConsume })
+			common.LogConsumeEnabled = true
+			user := &model.User{Username: "cache-user", Password: "password123", Status: common.UserStatusEnabled, Quota: 1000000}
+			if err := model.DB.Create(user).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			info := &relaycommon.RelayInfo{
+				UserId:          user.Id,
+				OriginModelName: "claude-sonnet-4",
+				RelayFormat:     format,
+				PriceData: types.PriceData{ModelRatio: 1, CompletionRatio: 5, CacheRatio: 0.1, CacheCreationRatio: 1.25,
+					GroupRatioInfo: types.GroupRatioInfo{GroupRatio: 1}},
+				ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "claude-sonnet-4", ChannelType: constant.ChannelTypeAnthropic},
+			}
+			body := `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4","stop_reason":"end_turn",
+				"content":[{"type":"text","text":"Hi"}],
+				"usage":{"input_tokens":100,"cache_read_input_tokens":1000,"cache_creation_input_tokens":200,"output_tokens":50}}`
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+			usage, apiErr := claude.ClaudeHandler(c, resp, info, claude.RequestModeMessage)
+			if apiErr != nil {
+				t.Fatal(apiErr)
+			}
+			if format == types.RelayFormatClaude {
+				service.PostClaudeConsumeQuota(c, info, usage)
+			} else {
+				postConsumeQuota(c, info, usage, "")
+			}
+
+			log := consumeLog(t, user.Id)
+			if log.Quota != 700 || log.CompletionTokens != 50 {
+				t.Fatalf("billed quota = %d, completion tokens = %d, want 700 and 50", log.Quota, log.CompletionTokens)
+			}
+			if used, err := model.GetUserUsedQuota(user.Id); err != nil || used != 700 {
+				t.Fatalf("used quota = %d, err = %v, want 700", used, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package common
+
+import "github.com/QuantumNous/new-api/setting/ratio_setting"
+
+// ApplyPriceTier 模型配置了分档倍率时，按实际输入 token 数（含缓存）重新选取分档并更新倍率，
+// 返回是否命中分档
+func (info *RelayInfo) ApplyPriceTier(promptTokens int) bool {
+	tier, index, ok := ratio_setting.GetPriceTier(info.OriginModelName, promptTokens)
+	if !ok {
+		return false
+	}
+	completionRatio := ratio_setting.GetCompletionRatio(info.OriginModelName)
+	cacheRatio, _ := ratio_setting.GetCacheRatio(info.OriginModelName)
+	cacheCreationRatio, _ := ratio_setting.GetCreateCacheRatio(info.OriginModelName)
+	modelRatio, completionRatio, cacheRatio, cacheCreationRatio := tier.Ratios(completionRatio, cacheRatio, cacheCreationRatio)
+	// 全局按倍率计费时，上游成本同样按新的分档估算
+	if !info.PriceData.BasePrice.UsePrice {
+		base := &info.PriceData.BasePrice
+		base.ModelRatio, base.CompletionRatio, base.CacheRatio, base.CacheCreationRatio = modelRatio, completionRatio, cacheRatio, cacheCreationRatio
+	}
+	if info.PriceData.UsePrice {
+		return false
+	}
+	info.PriceData.ModelRatio, info.PriceData.CompletionRatio, info.PriceData.CacheRatio, info.PriceData.CacheCreationRatio =
+		modelRatio, completionRatio, cacheRatio, cacheCreationRatio
+	info.PriceData.ModelRatio *= info.PriceData.PriceOverride.RatioMultiplier() * info.PriceData.PricingWindow.RatioMultiplier()
+	info.PriceData.PriceTier = index + 1
+	return true
+}
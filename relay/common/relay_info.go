@@ -35,6 +35,30 @@ type ClaudeConvertInfo struct {
 	Usage            *dto.Usage
 	FinishReason     string
 	Done             bool
+	// 当前 tool_use 块对应的 OpenAI 工具调用序号与 ID，用于拆分并行工具调用
+	ToolCallIndex int
+	ToolCallId    string
+}
+
+// ResponsesConvertInfo 将 Chat Completions 流转换为 Responses 事件时的状态
+type ResponsesConvertInfo struct {
+	ResponseId     string
+	CreatedAt      int64
+	Started        bool
+	SequenceNumber int
+	Output         []dto.ResponsesOutput
+	// OpenIndex 当前尚未结束的输出项下标，-1 表示没有
+	OpenIndex int
+	// ToolCallIndex chat tool_calls 的 index 到 output 下标的映射
+	ToolCallIndex map[int]int
+	StopReason    string
+	Completed     bool
+}
+
+// GeminiConvertInfo 将 Chat Completions 流转换为 Gemini 响应时的状态
+type GeminiConvertInfo struct {
+	// PendingToolCalls 按 index 累积的工具调用分片
+	PendingToolCalls []dto.ToolCallResponse
 }
 
 type RerankerInfo struct {
@@ -115,6 +139,8 @@ type RelayInfo struct {
 
 	ThinkingContentInfo
 	*ClaudeConvertInfo
+	*ResponsesConvertInfo
+	*GeminiConvertInfo
 	*RerankerInfo
 	*ResponsesUsageInfo
 	*ChannelMeta
@@ -343,6 +369,7 @@ func GenRelayInfoGemini(c *gin.Context, request dto.Request) *RelayInfo {
 	info := genBaseRelayInfo(c, request)
 	info.RelayFormat = types.RelayFormatGemini
 	info.ShouldIncludeUsage = false
+	info.GeminiConvertInfo = &GeminiConvertInfo{}
 
 	return info
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -74,7 +75,21 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 	adaptor.Init(info)
 	var requestBody io.Reader
 
-	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
+	passThrough := model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled
+	if !passThrough && shouldEmulateJsonSchema(info, request) {
+		return jsonSchemaEmulationHelper(c, info, adaptor, request)
+	}
+	toolCallEmulation := !passThrough && shouldEmulateToolCall(info, request)
+	if !passThrough {
+		if serverTools := resolveServerTools(info, request); serverTools != nil {
+			return serverToolHelper(c, info, adaptor, request, serverTools, toolCallEmulation)
+		}
+	}
+	if toolCallEmulation {
+		service.ApplyToolCallEmulation(request)
+	}
+
+	if passThrough {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
@@ -89,47 +104,7 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
 		}
 
-		if info.ChannelSetting.SystemPrompt != "" {
-			// 如果有系统提示，则将其添加到请求中
-			request, ok := convertedRequest.(*dto.GeneralOpenAIRequest)
-			if ok {
-				containSystemPrompt := false
-				for _, message := range request.Messages {
-					if message.Role == request.GetSystemRoleName() {
-						containSystemPrompt = true
-						break
-					}
-				}
-				if !containSystemPrompt {
-					// 如果没有系统提示，则添加系统提示
-					systemMessage := dto.Message{
-						Role:    request.GetSystemRoleName(),
-						Content: info.ChannelSetting.SystemPrompt,
-					}
-					request.Messages = append([]dto.Message{systemMessage}, request.Messages...)
-				} else if info.ChannelSetting.SystemPromptOverride {
-					common.SetContextKey(c, constant.ContextKeySystemPromptOverride, true)
-					// 如果有系统提示，且允许覆盖，则拼接到前面
-					for i, message := range request.Messages {
-						if message.Role == request.GetSystemRoleName() {
-							if message.IsStringContent() {
-								request.Messages[i].SetStringContent(info.ChannelSetting.SystemPrompt + "\n" + message.StringContent())
-							} else {
-								contents := message.ParseContent()
-								contents = append([]dto.MediaContent{
-									{
-										Type: dto.ContentTypeText,
-										Text: info.ChannelSetting.SystemPrompt,
-									},
-								}, contents...)
-								request.Messages[i].Content = contents
-							}
-							break
-						}
-					}
-				}
-			}
-		}
+		applyChannelSystemPrompt(c, info, convertedRequest)
 
 		jsonData, err := common.Marshal(convertedRequest)
 		if err != nil {
@@ -174,7 +149,18 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		}
 	}
 
+	var toolCallWriter *toolCallEmulationWriter
+	if toolCallEmulation {
+		toolCallWriter = newToolCallEmulationWriter(c, info)
+		c.Writer = toolCallWriter
+	}
 	usage, newApiErr := adaptor.DoResponse(c, httpResp, info)
+	if toolCallWriter != nil {
+		c.Writer = toolCallWriter.ResponseWriter
+		if newApiErr == nil {
+			toolCallWriter.finish()
+		}
+	}
 	if newApiErr != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newApiErr, statusCodeMappingStr)
@@ -189,6 +175,51 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 	return nil
 }
 
+// applyChannelSystemPrompt 将渠道配置的系统提示注入到转换后的请求中
+func applyChannelSystemPrompt(c *gin.Context, info *relaycommon.RelayInfo, convertedRequest any) {
+	if info.ChannelSetting.SystemPrompt != "" {
+		// 如果有系统提示，则将其添加到请求中
+		request, ok := convertedRequest.(*dto.GeneralOpenAIRequest)
+		if ok {
+			containSystemPrompt := false
+			for _, message := range request.Messages {
+				if message.Role == request.GetSystemRoleName() {
+					containSystemPrompt = true
+					break
+				}
+			}
+			if !containSystemPrompt {
+				// 如果没有系统提示，则添加系统提示
+				systemMessage := dto.Message{
+					Role:    request.GetSystemRoleName(),
+					Content: info.ChannelSetting.SystemPrompt,
+				}
+				request.Messages = append([]dto.Message{systemMessage}, request.Messages...)
+			} else if info.ChannelSetting.SystemPromptOverride {
+				common.SetContextKey(c, constant.ContextKeySystemPromptOverride, true)
+				// 如果有系统提示，且允许覆盖，则拼接到前面
+				for i, message := range request.Messages {
+					if message.Role == request.GetSystemRoleName() {
+						if message.IsStringContent() {
+							request.Messages[i].SetStringContent(info.ChannelSetting.SystemPrompt + "\n" + message.StringContent())
+						} else {
+							contents := message.ParseContent()
+							contents = append([]dto.MediaContent{
+								{
+									Type: dto.ContentTypeText,
+									Text: info.ChannelSetting.SystemPrompt,
+								},
+							}, contents...)
+							request.Messages[i].Content = contents
+						}
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
 	if usage == nil {
 		usage = &dto.Usage{
@@ -198,6 +229,8 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		}
 		extraContent += "（可能是请求出错）"
 	}
+	// 分档计价按实际输入 token 数重新选档
+	relayInfo.ApplyPriceTier(usage.PromptTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	cacheTokens := usage.PromptTokensDetails.CachedTokens
@@ -292,6 +325,9 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		dImageGenerationCallQuota = decimal.NewFromFloat(imageGenerationCallPrice).Mul(dGroupRatio).Mul(dQuotaPerUnit)
 		extraContent += fmt.Sprintf("Image Generation Call 花费 %s", dImageGenerationCallQuota.String())
 	}
+	// 网关侧工具计费，调用次数记录在 OtherRatios 中
+	dServerToolQuota, serverToolCalls, serverToolContent := calculateServerToolQuota(relayInfo, dGroupRatio, dQuotaPerUnit)
+	extraContent += serverToolContent
 
 	var quotaCalculateDecimal decimal.Decimal
 
@@ -349,6 +385,8 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 	quotaCalculateDecimal = quotaCalculateDecimal.Add(audioInputQuota)
 	// 添加 image generation call 计费
 	quotaCalculateDecimal = quotaCalculateDecimal.Add(dImageGenerationCallQuota)
+	// 添加网关侧工具调用计费
+	quotaCalculateDecimal = quotaCalculateDecimal.Add(dServerToolQuota)
 
 	quota := int(quotaCalculateDecimal.Round(0).IntPart())
 	totalTokens := promptTokens + completionTokens
@@ -448,6 +486,9 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 			other["file_search_price"] = fileSearchPrice
 		}
 	}
+	if len(serverToolCalls) > 0 {
+		other["server_tool_calls"] = serverToolCalls
+	}
 	if !audioInputQuota.IsZero() {
 		other["audio_input_seperate_price"] = true
 		other["audio_input_token_count"] = audioTokens
@@ -457,6 +498,15 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		other["image_generation_call"] = true
 		other["image_generation_call_price"] = imageGenerationCallPrice
 	}
+	upstreamCost, costTracked := service.CalculateUpstreamCost(relayInfo, service.UpstreamCostUsage{
+		PromptTokens:        promptTokens - cacheTokens - cachedCreationTokens,
+		CacheTokens:         cacheTokens,
+		CacheCreationTokens: cachedCreationTokens,
+		CompletionTokens:    completionTokens,
+	})
+	if costTracked {
+		service.CheckNegativeMargin(relayInfo, quota, upstreamCost)
+	}
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     promptTokens,
@@ -469,6 +519,31 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		UseTimeSeconds:   int(useTimeSeconds),
 		IsStream:         relayInfo.IsStream,
 		Group:            relayInfo.UsingGroup,
+		UpstreamCost:     upstreamCost,
 		Other:            other,
 	})
 }
+
+// calculateServerToolQuota 按 OtherRatios 中记录的调用次数计算网关侧工具的费用，返回费用、各工具调用次数与日志内容
+func calculateServerToolQuota(relayInfo *relaycommon.RelayInfo, dGroupRatio decimal.Decimal, dQuotaPerUnit decimal.Decimal) (decimal.Decimal, map[string]int, string) {
+	var dServerToolQuota decimal.Decimal
+	serverToolCalls := make(map[string]int)
+	serverToolNames := make([]string, 0)
+	for key := range relayInfo.PriceData.OtherRatios {
+		if strings.HasPrefix(key, service.ServerToolRatioPrefix) {
+			serverToolNames = append(serverToolNames, strings.TrimPrefix(key, service.ServerToolRatioPrefix))
+		}
+	}
+	sort.Strings(serverToolNames)
+	var content string
+	for _, name := range serverToolNames {
+		callCount := int(relayInfo.PriceData.OtherRatios[service.ServerToolRatioPrefix+name])
+		serverToolQuota := decimal.NewFromFloat(operation_setting.GetServerToolPricePerThousand(name)).
+			Mul(decimal.NewFromInt(int64(callCount))).
+			Div(decimal.NewFromInt(1000)).Mul(dGroupRatio).Mul(dQuotaPerUnit)
+		dServerToolQuota = dServerToolQuota.Add(serverToolQuota)
+		serverToolCalls[name] = callCount
+		content += fmt.Sprintf("，%s 调用 %d 次，调用花费 %s", name, callCount, serverToolQuota.String())
+	}
+	return dServerToolQuota, serverToolCalls, content
+}
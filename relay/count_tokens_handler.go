@@ -0,0 +1,125 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// CountTokensHelper 处理 Claude count_tokens 与 Gemini countTokens 请求，不扣费
+// 开启转发且选中原生渠道时使用上游的精确计数，失败时回退到本地估算
+func CountTokensHelper(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	info.InitChannelMeta(c)
+	info.IsStream = false
+
+	var request dto.Request
+	switch info.RelayFormat {
+	case types.RelayFormatClaude:
+		claudeRequest, ok := info.Request.(*dto.ClaudeRequest)
+		if !ok {
+			return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected *dto.ClaudeRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+		}
+		request = claudeRequest
+	case types.RelayFormatGemini:
+		countRequest, ok := info.Request.(*dto.GeminiCountTokensRequest)
+		if !ok {
+			return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected *dto.GeminiCountTokensRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+		}
+		request = countRequest.GetChatRequest()
+	default:
+		return types.NewErrorWithStatusCode(fmt.Errorf("count tokens is not supported for %s", info.RelayFormat), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+	}
+
+	if err := helper.ModelMappedHelper(c, info, nil); err != nil {
+		return types.NewError(err, types.ErrorCodeChannelModelMappedError)
+	}
+
+	if model_setting.GetGlobalSettings().CountTokensForwardEnabled {
+		forwarded, err := forwardCountTokens(c, info)
+		if forwarded && err == nil {
+			return nil
+		}
+		if err != nil {
+			logger.LogWarn(c, "count tokens forward failed, fallback to local: "+err.Error())
+		}
+	}
+
+	switch r := request.(type) {
+	case *dto.ClaudeRequest:
+		tokens, err := service.CountTokenClaudeRequest(*r, info.OriginModelName)
+		if err != nil {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeCountTokenFailed, http.StatusBadRequest)
+		}
+		c.JSON(http.StatusOK, gin.H{"input_tokens": tokens})
+	case *dto.GeminiChatRequest:
+		c.JSON(http.StatusOK, gin.H{"totalTokens": service.CountTokenGeminiRequest(*r, info.OriginModelName)})
+	}
+	return nil
+}
+
+// forwardCountTokens 将请求转发到原生渠道的计数接口，渠道类型不匹配时返回 false
+func forwardCountTokens(c *gin.Context, info *relaycommon.RelayInfo) (bool, error) {
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return false, err
+	}
+	var url string
+	switch {
+	case info.RelayFormat == types.RelayFormatClaude && info.ChannelType == constant.ChannelTypeAnthropic:
+		url = fmt.Sprintf("%s/v1/messages/count_tokens", info.ChannelBaseUrl)
+		body, err = sjson.SetBytes(body, "model", info.UpstreamModelName)
+	case info.RelayFormat == types.RelayFormatGemini && info.ChannelType == constant.ChannelTypeGemini:
+		url = fmt.Sprintf("%s/%s/models/%s:countTokens", info.ChannelBaseUrl, model_setting.GetGeminiVersionSetting(info.UpstreamModelName), info.UpstreamModelName)
+		if gjson.GetBytes(body, "generateContentRequest").Exists() {
+			body, err = sjson.SetBytes(body, "generateContentRequest.model", "models/"+info.UpstreamModelName)
+		}
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return true, fmt.Errorf("invalid api type: %d", info.ApiType)
+	}
+	adaptor.Init(info)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	if err = adaptor.SetupRequestHeader(c, &req.Header, info); err != nil {
+		return true, err
+	}
+	resp, err := channel.DoRequest(c, req, info)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return true, fmt.Errorf("upstream status %d: %s", resp.StatusCode, string(responseBody))
+	}
+	c.Data(http.StatusOK, "application/json", responseBody)
+	return true, nil
+}
@@ -0,0 +1,39 @@
+package helper
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// CheckModelCapabilities 按模型目录校验请求：最大输出超过模型上限、输入加最大输出超过上下文长度、
+// 模型不支持图片输入时直接拒绝，目录中未配置的能力不做限制
+func CheckModelCapabilities(info *relaycommon.RelayInfo, meta *types.TokenCountMeta, promptTokens int) *types.NewAPIError {
+	if !model_setting.GetGlobalSettings().ModelCapabilityCheckEnabled {
+		return nil
+	}
+	modelMeta, ok := model.GetModelMeta(info.OriginModelName)
+	if !ok {
+		return nil
+	}
+	if modelMeta.MaxOutputTokens > 0 && meta.MaxTokens > modelMeta.MaxOutputTokens {
+		return types.NewErrorWithStatusCode(
+			fmt.Errorf("max_tokens is too large: %d. This model supports at most %d completion tokens", meta.MaxTokens, modelMeta.MaxOutputTokens),
+			types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if modelMeta.ContextLength > 0 && promptTokens+meta.MaxTokens > modelMeta.ContextLength {
+		return types.NewErrorWithStatusCode(
+			fmt.Errorf("this model's maximum context length is %d tokens, however you requested %d tokens (%d in the messages, %d in the completion)",
+				modelMeta.ContextLength, promptTokens+meta.MaxTokens, promptTokens, meta.MaxTokens),
+			types.ErrorCodeContextLengthExceeded, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if types.GetRequestFeatures(meta).ImageInput && modelMeta.ImageInputUnsupported() {
+		return types.NewErrorWithStatusCode(fmt.Errorf("model %s does not support image input", info.OriginModelName),
+			types.ErrorCodeModelCapabilityUnsupported, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package helper
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// setupCapabilityModel 在模型目录中创建带能力限制的模型并刷新缓存
+func setupCapabilityModel(t *testing.T, mi *model.Model) {
+	t.Helper()
+	setupTestDB(t)
+	settings := model_setting.GetGlobalSettings()
+	saved := settings.ModelCapabilityCheckEnabled
+	t.Cleanup(func() {
+		settings.ModelCapabilityCheckEnabled = saved
+		model.RefreshPricing()
+	})
+	settings.ModelCapabilityCheckEnabled = true
+	mi.Status = 1
+	if err := mi.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	model.RefreshPricing()
+}
+
+func imageMeta(maxTokens int) *types.TokenCountMeta {
+	return &types.TokenCountMeta{MaxTokens: maxTokens, Files: []*types.FileMeta{{FileType: types.FileTypeImage}}}
+}
+
+func TestCheckModelCapabilities(t *testing.T) {
+	setupCapabilityModel(t, &model.Model{ModelName: "capped-model", ContextLength: 1000, MaxOutputTokens: 300,
+		SupportsVision: common.GetPointer(false)})
+	info := &relaycommon.RelayInfo{OriginModelName: "capped-model"}
+
+	tests := []struct {
+		name         string
+		meta         *types.TokenCountMeta
+		promptTokens int
+		wantCode     types.ErrorCode
+	}{
+		{"within limits", &types.TokenCountMeta{MaxTokens: 300}, 700, ""},
+		{"max output", &types.TokenCountMeta{MaxTokens: 301}, 10, types.ErrorCodeInvalidRequest},
+		{"context length", &types.TokenCountMeta{MaxTokens: 300}, 701, types.ErrorCodeContextLengthExceeded},
+		{"context without max tokens", &types.TokenCountMeta{}, 1001, types.ErrorCodeContextLengthExceeded},
+		{"vision", imageMeta(0), 10, types.ErrorCodeModelCapabilityUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := CheckModelCapabilities(info, tt.meta, tt.promptTokens)
+			if tt.wantCode == "" {
+				if apiErr != nil {
+					t.Fatalf("unexpected error: %v", apiErr)
+				}
+				return
+			}
+			if apiErr == nil || apiErr.GetErrorCode() != tt.wantCode || apiErr.StatusCode != http.StatusBadRequest {
+				t.Fatalf("err = %v, want %s with 400", apiErr, tt.wantCode)
+			}
+			// 请求本身超出模型能力，换渠道重试没有意义
+			if !types.IsSkipRetryError(apiErr) {
+				t.Fatal("capability errors should skip retry")
+			}
+		})
+	}
+
+	// 未收录的模型与关闭校验时不做限制
+	if apiErr := CheckModelCapabilities(&relaycommon.RelayInfo{OriginModelName: "unknown-model"}, imageMeta(100000), 100000); apiErr != nil {
+		t.Fatalf("unknown model should not be limited: %v", apiErr)
+	}
+	model_setting.GetGlobalSettings().ModelCapabilityCheckEnabled = false
+	if apiErr := CheckModelCapabilities(info, imageMeta(100000), 100000); apiErr != nil {
+		t.Fatalf("disabled check should not reject: %v", apiErr)
+	}
+}
+
+func TestCheckModelCapabilitiesVisionFromModalities(t *testing.T) {
+	setupCapabilityModel(t, &model.Model{ModelName: "text-only-model", InputModalities: "text"})
+	info := &relaycommon.RelayInfo{OriginModelName: "text-only-model"}
+
+	// 未设置 supports_vision 时按输入模态判断，未配置的上下文与输出不限制
+	if apiErr := CheckModelCapabilities(info, imageMeta(0), 10); apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeModelCapabilityUnsupported {
+		t.Fatalf("err = %v, want image input rejected", apiErr)
+	}
+	if apiErr := CheckModelCapabilities(info, &types.TokenCountMeta{MaxTokens: 100000}, 100000); apiErr != nil {
+		t.Fatalf("unconfigured limits should not reject: %v", apiErr)
+	}
+}
@@ -2,8 +2,10 @@ package helper
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
@@ -45,9 +47,31 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) types.
 
 func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int, meta *types.TokenCountMeta) (types.PriceData, error) {
 	modelPrice, usePrice := ratio_setting.GetModelPrice(info.OriginModelName, false)
+	basePrice := types.BasePriceData{UsePrice: usePrice, ModelPrice: modelPrice}
+
+	// 用户或令牌的价格覆盖：固定价格直接按次计费，系数作用于全局价格或倍率
+	var priceOverride *types.PriceOverrideInfo
+	if override, ok := model.GetPriceOverride(info.UserId, info.TokenId, info.OriginModelName); ok {
+		priceOverride = override.Info()
+		if override.Type == types.PriceOverrideTypePrice {
+			modelPrice, usePrice = override.Value, true
+		}
+	}
 
 	groupRatioInfo := HandleGroupRatio(c, info)
 
+	// 时段价格系数按请求开始时间确定，预扣费与结算保持一致；协商的固定价格不受时段影响
+	var pricingWindow *types.PricingWindowInfo
+	if priceOverride == nil || priceOverride.Type != types.PriceOverrideTypePrice {
+		startTime := info.StartTime
+		if startTime.IsZero() {
+			startTime = time.Now()
+		}
+		if window := operation_setting.GetActivePricingWindow(info.OriginModelName, info.UsingGroup, startTime); window != nil {
+			pricingWindow = &types.PricingWindowInfo{Name: window.Name, Multiplier: window.Multiplier}
+		}
+	}
+
 	var preConsumedQuota int
 	var modelRatio float64
 	var completionRatio float64
@@ -56,16 +80,18 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 	var cacheCreationRatio float64
 	var audioRatio float64
 	var audioCompletionRatio float64
+	var priceTier int
 	var freeModel bool
 	if !usePrice {
 		preConsumedTokens := common.Max(promptTokens, common.PreConsumedQuota)
 		if meta.MaxTokens != 0 {
 			preConsumedTokens += meta.MaxTokens
 		}
-		var success bool
+		var ok bool
 		var matchName string
-		modelRatio, success, matchName = ratio_setting.GetModelRatio(info.OriginModelName)
-		if !success {
+		// 分档计价先按预估的输入 token 数选档，结算时按实际用量重新选档
+		basePrice, priceTier, ok, matchName = globalModelRatios(info.OriginModelName, promptTokens)
+		if !ok {
 			acceptUnsetRatio := false
 			if info.UserSetting.AcceptUnsetRatioModel {
 				acceptUnsetRatio = true
@@ -74,19 +100,28 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 				return types.PriceData{}, fmt.Errorf("模型 %s 倍率或价格未配置，请联系管理员设置或开始自用模式；Model %s ratio or price not set, please set or start self-use mode", matchName, matchName)
 			}
 		}
-		completionRatio = ratio_setting.GetCompletionRatio(info.OriginModelName)
-		cacheRatio, _ = ratio_setting.GetCacheRatio(info.OriginModelName)
-		cacheCreationRatio, _ = ratio_setting.GetCreateCacheRatio(info.OriginModelName)
+		modelRatio, completionRatio = basePrice.ModelRatio, basePrice.CompletionRatio
+		cacheRatio, cacheCreationRatio = basePrice.CacheRatio, basePrice.CacheCreationRatio
 		imageRatio, _ = ratio_setting.GetImageRatio(info.OriginModelName)
 		audioRatio = ratio_setting.GetAudioRatio(info.OriginModelName)
 		audioCompletionRatio = ratio_setting.GetAudioCompletionRatio(info.OriginModelName)
+		modelRatio *= priceOverride.RatioMultiplier() * pricingWindow.RatioMultiplier()
 		ratio := modelRatio * groupRatioInfo.GroupRatio
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
 	} else {
 		if meta.ImagePriceRatio != 0 {
 			modelPrice = modelPrice * meta.ImagePriceRatio
 		}
+		modelPrice *= priceOverride.RatioMultiplier() * pricingWindow.RatioMultiplier()
 		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatioInfo.GroupRatio)
+		if basePrice.UsePrice {
+			if meta.ImagePriceRatio != 0 {
+				basePrice.ModelPrice *= meta.ImagePriceRatio
+			}
+		} else {
+			// 固定价格覆盖的是按倍率计费的模型，成本仍按全局倍率估算
+			basePrice, _, _, _ = globalModelRatios(info.OriginModelName, promptTokens)
+		}
 	}
 
 	// check if free model pre-consume is disabled
@@ -117,6 +152,11 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		AudioRatio:           audioRatio,
 		AudioCompletionRatio: audioCompletionRatio,
 		CacheCreationRatio:   cacheCreationRatio,
+		PriceTier:            priceTier,
+		PriceVersion:         ratio_setting.GetPriceVersionId(),
+		PriceOverride:        priceOverride,
+		PricingWindow:        pricingWindow,
+		BasePrice:            basePrice,
 		QuotaToPreConsume:    preConsumedQuota,
 	}
 
@@ -127,6 +167,26 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 	return priceData, nil
 }
 
+// globalModelRatios 全局配置的模型倍率，命中分档时使用分档倍率，返回的档位从 1 开始
+func globalModelRatios(modelName string, promptTokens int) (types.BasePriceData, int, bool, string) {
+	modelRatio, success, matchName := ratio_setting.GetModelRatio(modelName)
+	tier, tierIndex, tiered := ratio_setting.GetPriceTier(modelName, promptTokens)
+	completionRatio := ratio_setting.GetCompletionRatio(modelName)
+	cacheRatio, _ := ratio_setting.GetCacheRatio(modelName)
+	cacheCreationRatio, _ := ratio_setting.GetCreateCacheRatio(modelName)
+	priceTier := 0
+	if tiered {
+		modelRatio, completionRatio, cacheRatio, cacheCreationRatio = tier.Ratios(completionRatio, cacheRatio, cacheCreationRatio)
+		priceTier = tierIndex + 1
+	}
+	return types.BasePriceData{
+		ModelRatio:         modelRatio,
+		CompletionRatio:    completionRatio,
+		CacheRatio:         cacheRatio,
+		CacheCreationRatio: cacheCreationRatio,
+	}, priceTier, success || tiered, matchName
+}
+
 // ModelPriceHelperPerCall 按次计费的 PriceHelper (MJ、Task)
 func ModelPriceHelperPerCall(c *gin.Context, info *relaycommon.RelayInfo) types.PerCallPriceData {
 	groupRatioInfo := HandleGroupRatio(c, info)
@@ -142,6 +202,8 @@ func ModelPriceHelperPerCall(c *gin.Context, info *relaycommon.RelayInfo) types.
 		}
 	}
 	quota := int(modelPrice * common.QuotaPerUnit * groupRatioInfo.GroupRatio)
+	// 记录全局价格，用于估算上游成本
+	info.PriceData.BasePrice = types.BasePriceData{UsePrice: true, ModelPrice: modelPrice}
 	priceData := types.PerCallPriceData{
 		ModelPrice:     modelPrice,
 		Quota:          quota,
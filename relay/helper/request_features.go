@@ -0,0 +1,28 @@
+package helper
+
+import (
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/tidwall/gjson"
+)
+
+// GetRequestFeatures 识别请求需要的渠道能力：图片、音频输入与函数调用来自 TokenCountMeta，
+// json_schema 来自请求体。输入 token 数用于排除上下文不足的渠道，只在有渠道声明了上下文长度时记录，
+// 否则每个请求都需要逐个渠道判断
+func GetRequestFeatures(request dto.Request, meta *types.TokenCountMeta, promptTokens int) types.RequestFeatures {
+	features := types.GetRequestFeatures(meta)
+	if model.ChannelContextLimitDeclared() {
+		features.PromptTokens = promptTokens
+	}
+	switch r := request.(type) {
+	case *dto.GeneralOpenAIRequest:
+		features.JsonSchema = r.ResponseFormat != nil && r.ResponseFormat.Type == "json_schema"
+	case *dto.OpenAIResponsesRequest:
+		features.JsonSchema = len(r.Text) > 0 && gjson.GetBytes(r.Text, "format.type").String() == "json_schema"
+	case *dto.GeminiChatRequest:
+		features.JsonSchema = r.GenerationConfig.ResponseSchema != nil || len(r.GenerationConfig.ResponseJsonSchema) > 0
+	}
+	return features
+}
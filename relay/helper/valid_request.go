@@ -216,6 +216,25 @@ func GetAndValidOpenAIImageRequest(c *gin.Context, relayMode int) (*dto.ImageReq
 	return imageRequest, nil
 }
 
+// GetAndValidateCountTokensRequest 解析 Claude count_tokens 与 Gemini countTokens 请求
+func GetAndValidateCountTokensRequest(c *gin.Context, format types.RelayFormat) (dto.Request, error) {
+	switch format {
+	case types.RelayFormatClaude:
+		return GetAndValidateClaudeRequest(c)
+	case types.RelayFormatGemini:
+		request := &dto.GeminiCountTokensRequest{}
+		err := common.UnmarshalBodyReusable(c, request)
+		if err != nil {
+			return nil, err
+		}
+		if len(request.Contents) == 0 && request.GenerateContentRequest == nil {
+			return nil, errors.New("field contents or generateContentRequest is required")
+		}
+		return request, nil
+	}
+	return nil, fmt.Errorf("unsupported relay format: %s", format)
+}
+
 func GetAndValidateClaudeRequest(c *gin.Context) (textRequest *dto.ClaudeRequest, err error) {
 	textRequest = &dto.ClaudeRequest{}
 	err = c.ShouldBindJSON(textRequest)
@@ -0,0 +1,129 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultJsonSchemaMaxRetries = 2
+	maxJsonSchemaMaxRetries     = 5
+)
+
+// shouldEmulateJsonSchema 渠道开启结构化输出模拟且请求要求 JSON 输出时返回 true
+func shouldEmulateJsonSchema(info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) bool {
+	if !info.ChannelSetting.JsonSchemaEmulation || request.ResponseFormat == nil {
+		return false
+	}
+	if info.RelayMode != relayconstant.RelayModeChatCompletions || info.RelayFormat != types.RelayFormatOpenAI {
+		return false
+	}
+	return request.ResponseFormat.Type == "json_schema" || request.ResponseFormat.Type == "json_object"
+}
+
+// jsonSchemaEmulationHelper 将 schema 注入系统提示后以非流式请求上游，校验输出并在失败时带上错误信息重试，
+// 所有尝试的用量合并计费，最终仍不合规时返回 refusal
+func jsonSchemaEmulationHelper(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.GeneralOpenAIRequest) *types.NewAPIError {
+	var format *dto.FormatJsonSchema
+	if request.ResponseFormat.Type == "json_schema" {
+		format = &dto.FormatJsonSchema{}
+		if err := common.Unmarshal(request.ResponseFormat.JsonSchema, format); err != nil {
+			return types.NewErrorWithStatusCode(fmt.Errorf("invalid response_format.json_schema: %w", err), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+	}
+	maxRetries := info.ChannelSetting.JsonSchemaMaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultJsonSchemaMaxRetries
+	}
+	maxRetries = min(maxRetries, maxJsonSchemaMaxRetries)
+
+	// 上游统一按非流式请求，客户端要求流式时在校验通过后再以流式输出
+	isStream := info.IsStream
+	defer func() {
+		info.IsStream = isStream
+	}()
+	request.Stream = false
+	request.StreamOptions = nil
+	request.ResponseFormat = nil
+	applyChannelSystemPrompt(c, info, request)
+	request.AppendSystemPrompt(service.JsonSchemaPrompt(format))
+
+	var schema any
+	if format != nil {
+		schema = format.Schema
+	}
+	totalUsage := &dto.Usage{}
+	var response *dto.OpenAITextResponse
+	var validationErr error
+	attempts := 0
+	for attempts <= maxRetries {
+		attempts++
+		attemptResponse, usage, newAPIError := doCapturedChatRequest(c, info, adaptor, request)
+		if newAPIError != nil {
+			if attempts == 1 {
+				return newAPIError
+			}
+			// 之前的尝试已产生用量，按最后一次的校验错误返回 refusal
+			logger.LogWarn(c, "json schema emulation retry failed: "+newAPIError.Error())
+			attempts--
+			break
+		}
+		addUsage(totalUsage, usage)
+		response = attemptResponse
+		if len(response.Choices) == 0 {
+			validationErr = errors.New("response has no choices")
+			continue
+		}
+		content := response.Choices[0].Message.StringContent()
+		value, normalized, err := service.ParseJsonOutput(content)
+		if err == nil {
+			err = service.ValidateJsonSchema(schema, value)
+		}
+		if err == nil {
+			response.Choices[0].Message.SetStringContent(normalized)
+			validationErr = nil
+			break
+		}
+		validationErr = err
+		logger.LogDebug(c, fmt.Sprintf("json schema emulation attempt %d failed: %s", attempts, err.Error()))
+		request.Messages = append(request.Messages,
+			dto.Message{Role: "assistant", Content: content},
+			dto.Message{Role: "user", Content: service.JsonSchemaRepairPrompt(err)},
+		)
+	}
+
+	if validationErr != nil {
+		refusal := "Unable to produce a response that conforms to the requested JSON schema: " + validationErr.Error()
+		if len(response.Choices) == 0 {
+			response.Choices = []dto.OpenAITextResponseChoice{{}}
+		}
+		response.Choices = response.Choices[:1]
+		response.Choices[0].Message = dto.Message{Role: "assistant", Refusal: &refusal}
+		response.Choices[0].FinishReason = "stop"
+	}
+	response.Usage = *totalUsage
+
+	info.IsStream = isStream
+	if newAPIError := writeChatResponse(c, info, response); newAPIError != nil {
+		return newAPIError
+	}
+
+	extraContent := fmt.Sprintf("JSON Schema 模拟，共请求 %d 次", attempts)
+	if validationErr != nil {
+		extraContent += "，输出未通过校验"
+	}
+	postConsumeQuota(c, info, totalUsage, extraContent)
+	return nil
+}
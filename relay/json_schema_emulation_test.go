@@ -0,0 +1,200 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const weatherSchema = `{"name":"weather","schema":{"type":"object","properties":{"city":{"type":"string"},"temp":{"type":"number"}},"required":["city","temp"]}}`
+
+// newJsonSchemaContext 创建开启结构化输出模拟的渠道上下文，用量按 1:1 计费并记录消费日志
+func newJsonSchemaContext(t *testing.T, maxRetries int) (*gin.Context, *httptest.ResponseRecorder, *relaycommon.RelayInfo) {
+	t.Helper()
+	setupTestDB(t)
+	savedLogConsume := common.LogConsumeEnabled
+	t.Cleanup(func() { common.LogConsumeEnabled = savedLogConsume })
+	common.LogConsumeEnabled = true
+	user := &model.User{Username: "schema-user", Password: "password123", Status: common.UserStatusEnabled, Quota: 1000000}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := &relaycommon.RelayInfo{
+		UserId:          user.Id,
+		OriginModelName: "gpt-4o",
+		RelayMode:       relayconstant.RelayModeChatCompletions,
+		RelayFormat:     types.RelayFormatOpenAI,
+		PriceData: types.PriceData{ModelRatio: 1, CompletionRatio: 1,
+			GroupRatioInfo: types.GroupRatioInfo{GroupRatio: 1}},
+		ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "gpt-4o", ChannelType: constant.ChannelTypeOpenAI,
+			ChannelSetting: dto.ChannelSettings{JsonSchemaEmulation: true, JsonSchemaMaxRetries: maxRetries}},
+	}
+	return c, recorder, info
+}
+
+func newJsonSchemaRequest(schema string) *dto.GeneralOpenAIRequest {
+	return &dto.GeneralOpenAIRequest{
+		Model:          "gpt-4o",
+		Messages:       []dto.Message{{Role: "user", Content: "weather in Paris"}},
+		ResponseFormat: &dto.ResponseFormat{Type: "json_schema", JsonSchema: []byte(schema)},
+	}
+}
+
+func decodeChatResponse(t *testing.T, recorder *httptest.ResponseRecorder) dto.OpenAITextResponse {
+	t.Helper()
+	var response dto.OpenAITextResponse
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", recorder.Body.String(), err)
+	}
+	return response
+}
+
+func consumeLog(t *testing.T, userId int) model.Log {
+	t.Helper()
+	var log model.Log
+	if err := model.LOG_DB.Where("user_id = ? AND type = ?", userId, model.LogTypeConsume).First(&log).Error; err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
+
+func TestJsonSchemaEmulationPassesFirstAttempt(t *testing.T) {
+	c, recorder, info := newJsonSchemaContext(t, 2)
+	request := newJsonSchemaRequest(weatherSchema)
+	if !shouldEmulateJsonSchema(info, request) {
+		t.Fatal("json_schema request on an emulating channel should be emulated")
+	}
+	adaptor := &scriptedAdaptor{responses: []string{
+		textResponse("```json\n{\"city\":\"Paris\",\"temp\":21}\n```", 10, 5),
+	}}
+
+	if apiErr := jsonSchemaEmulationHelper(c, info, adaptor, request); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if len(adaptor.requests) != 1 {
+		t.Fatalf("upstream requests = %d, want 1", len(adaptor.requests))
+	}
+	upstream := adaptor.requests[0]
+	if upstream.ResponseFormat != nil || upstream.Stream || upstream.Messages[0].Role != "system" ||
+		!strings.Contains(upstream.Messages[0].StringContent(), `"required":["city","temp"]`) {
+		t.Fatalf("upstream request should carry the schema in the system prompt: %+v", upstream)
+	}
+	// 代码块被去掉，输出规范化后的 JSON
+	response := decodeChatResponse(t, recorder)
+	if content := response.Choices[0].Message.StringContent(); content != `{"city":"Paris","temp":21}` {
+		t.Fatalf("content = %q", content)
+	}
+	if log := consumeLog(t, info.UserId); !strings.Contains(log.Content, "共请求 1 次") || strings.Contains(log.Content, "未通过校验") {
+		t.Fatalf("log content = %q", log.Content)
+	}
+}
+
+func TestJsonSchemaEmulationRepairsOnRetry(t *testing.T) {
+	c, recorder, info := newJsonSchemaContext(t, 2)
+	adaptor := &scriptedAdaptor{responses: []string{
+		textResponse(`{"city":"Paris"}`, 10, 5),
+		textResponse(`{"city":"Paris","temp":21}`, 30, 7),
+	}}
+
+	if apiErr := jsonSchemaEmulationHelper(c, info, adaptor, newJsonSchemaRequest(weatherSchema)); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if len(adaptor.requests) != 2 {
+		t.Fatalf("upstream requests = %d, want 2", len(adaptor.requests))
+	}
+	// 重试时带上上一次的输出与校验错误
+	messages := adaptor.requests[1].Messages
+	last := messages[len(messages)-1]
+	if messages[len(messages)-2].StringContent() != `{"city":"Paris"}` || last.Role != "user" ||
+		!strings.Contains(last.StringContent(), "temp") {
+		t.Fatalf("retry messages = %+v", messages)
+	}
+	response := decodeChatResponse(t, recorder)
+	if response.Choices[0].Message.StringContent() != `{"city":"Paris","temp":21}` || response.Choices[0].Message.Refusal != nil {
+		t.Fatalf("unexpected response: %+v", response.Choices[0].Message)
+	}
+	// 所有尝试的用量合并计费
+	if response.Usage.PromptTokens != 40 || response.Usage.CompletionTokens != 12 || response.Usage.TotalTokens != 52 {
+		t.Fatalf("response usage = %+v, want 40/12/52", response.Usage)
+	}
+	log := consumeLog(t, info.UserId)
+	if log.PromptTokens != 40 || log.CompletionTokens != 12 || log.Quota != 52 || !strings.Contains(log.Content, "共请求 2 次") {
+		t.Fatalf("log = %d/%d quota %d %q, want 40/12 quota 52", log.PromptTokens, log.CompletionTokens, log.Quota, log.Content)
+	}
+}
+
+func TestJsonSchemaEmulationRefusesAfterRetries(t *testing.T) {
+	c, recorder, info := newJsonSchemaContext(t, 2)
+	adaptor := &scriptedAdaptor{responses: []string{textResponse("it is sunny", 10, 5)}}
+
+	if apiErr := jsonSchemaEmulationHelper(c, info, adaptor, newJsonSchemaRequest(weatherSchema)); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	// 首次请求加 2 次重试
+	if len(adaptor.requests) != 3 {
+		t.Fatalf("upstream requests = %d, want 3", len(adaptor.requests))
+	}
+	response := decodeChatResponse(t, recorder)
+	message := response.Choices[0].Message
+	if message.Refusal == nil || !strings.Contains(*message.Refusal, "not valid JSON") || message.StringContent() != "" ||
+		response.Choices[0].FinishReason != "stop" {
+		t.Fatalf("expected a refusal, got %+v", response.Choices[0])
+	}
+	if response.Usage.PromptTokens != 30 || response.Usage.CompletionTokens != 15 {
+		t.Fatalf("response usage = %+v, want 30/15", response.Usage)
+	}
+	log := consumeLog(t, info.UserId)
+	if log.Quota != 45 || !strings.Contains(log.Content, "共请求 3 次，输出未通过校验") {
+		t.Fatalf("log quota %d %q, want 45 for 3 failed attempts", log.Quota, log.Content)
+	}
+}
+
+func TestJsonSchemaEmulationRefCycle(t *testing.T) {
+	// 自引用的树形结构可以正常校验
+	t.Run("recursive", func(t *testing.T) {
+		tree := `{"name":"tree","schema":{"$ref":"#/$defs/node","$defs":{"node":{"type":"object",` +
+			`"properties":{"value":{"type":"integer"},"children":{"type":"array","items":{"$ref":"#/$defs/node"}}},"required":["value"]}}}}`
+		c, recorder, info := newJsonSchemaContext(t, 1)
+		adaptor := &scriptedAdaptor{responses: []string{
+			textResponse(`{"value":1,"children":[{"value":2,"children":[{"value":3}]}]}`, 10, 5),
+		}}
+		if apiErr := jsonSchemaEmulationHelper(c, info, adaptor, newJsonSchemaRequest(tree)); apiErr != nil {
+			t.Fatal(apiErr)
+		}
+		if response := decodeChatResponse(t, recorder); response.Choices[0].Message.Refusal != nil {
+			t.Fatalf("recursive schema should validate: %s", *response.Choices[0].Message.Refusal)
+		}
+	})
+
+	// 不消耗数据的引用环在校验时报错，而不是无限递归
+	t.Run("cycle", func(t *testing.T) {
+		cycle := `{"name":"cycle","schema":{"$ref":"#/$defs/a","$defs":{"a":{"$ref":"#/$defs/b"},"b":{"$ref":"#/$defs/a"}}}}`
+		c, recorder, info := newJsonSchemaContext(t, 1)
+		adaptor := &scriptedAdaptor{responses: []string{textResponse(`{"value":1}`, 10, 5)}}
+		if apiErr := jsonSchemaEmulationHelper(c, info, adaptor, newJsonSchemaRequest(cycle)); apiErr != nil {
+			t.Fatal(apiErr)
+		}
+		response := decodeChatResponse(t, recorder)
+		if refusal := response.Choices[0].Message.Refusal; refusal == nil || !strings.Contains(*refusal, "circular $ref") {
+			t.Fatalf("expected a circular $ref refusal, got %+v", response.Choices[0].Message)
+		}
+		if len(adaptor.requests) != 2 {
+			t.Fatalf("upstream requests = %d, want 2", len(adaptor.requests))
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responsesChatHelper 将 /v1/responses 请求转换为 Chat Completions 发往上游，再把响应转换回 Responses 格式
+func responsesChatHelper(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.OpenAIResponsesRequest, conversation *responsesConversation) (newAPIError *types.NewAPIError) {
+	chatRequest, err := service.ResponsesToOpenAIRequest(request, info)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeConvertRequestFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	// 上游按 Chat Completions 处理，重试时恢复原始模式
+	relayMode, relayFormat := info.RelayMode, info.RelayFormat
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RelayFormat = types.RelayFormatOpenAI
+	info.ShouldIncludeUsage = true
+	defer func() {
+		info.RelayMode, info.RelayFormat = relayMode, relayFormat
+	}()
+	info.ResponsesConvertInfo = &relaycommon.ResponsesConvertInfo{
+		ResponseId:    "resp_" + common.GetUUID(),
+		CreatedAt:     time.Now().Unix(),
+		OpenIndex:     -1,
+		ToolCallIndex: make(map[int]int),
+	}
+	adaptor.Init(info)
+
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(c, info, chatRequest)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	jsonData, err = relaycommon.RemoveDisabledFields(jsonData, info.ChannelOtherSettings)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	if len(info.ParamOverride) > 0 {
+		jsonData, err = relaycommon.ApplyParamOverride(jsonData, info.ParamOverride)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid, types.ErrOptionWithSkipRetry())
+		}
+	}
+	if common.DebugEnabled {
+		println("requestBody: ", string(jsonData))
+	}
+
+	resp, err := adaptor.DoRequest(c, info, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	var httpResp *http.Response
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		if httpResp.StatusCode != http.StatusOK {
+			newAPIError = service.RelayErrorHandler(c.Request.Context(), httpResp, false)
+			service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+			return newAPIError
+		}
+	}
+
+	writer := &responsesChatWriter{ResponseWriter: c.Writer, c: c, info: info}
+	c.Writer = writer
+	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
+	c.Writer = writer.ResponseWriter
+	if newAPIError != nil {
+		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+		return newAPIError
+	}
+	writer.finish(usage.(*dto.Usage))
+
+	postConsumeQuota(c, info, usage.(*dto.Usage), "")
+	saveStoredResponse(c, info, request, conversation, false)
+	return nil
+}
+
+// responsesChatWriter 拦截渠道输出的 Chat Completions 响应并转换为 Responses 格式
+type responsesChatWriter struct {
+	gin.ResponseWriter
+	c      *gin.Context
+	info   *relaycommon.RelayInfo
+	buffer bytes.Buffer
+}
+
+func (w *responsesChatWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if w.info.IsStream {
+		w.convertStreamLines(false)
+	}
+	return len(data), nil
+}
+
+func (w *responsesChatWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// convertStreamLines 逐行转换 SSE 数据，flush 为 true 时同时处理末尾不完整的行
+func (w *responsesChatWriter) convertStreamLines(flush bool) {
+	for {
+		index := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if index < 0 {
+			if !flush || w.buffer.Len() == 0 {
+				return
+			}
+			index = w.buffer.Len() - 1
+		}
+		line := strings.TrimSpace(string(w.buffer.Next(index + 1)))
+		switch {
+		case strings.HasPrefix(line, ":"):
+			_, _ = w.ResponseWriter.WriteString(line + "\n\n")
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "[DONE]" {
+				continue
+			}
+			var streamResponse dto.ChatCompletionsStreamResponse
+			if err := common.UnmarshalJsonStr(data, &streamResponse); err != nil {
+				logger.LogError(w.c, "failed to unmarshal chat stream response: "+err.Error())
+				continue
+			}
+			w.writeEvents(service.StreamResponseOpenAI2Responses(&streamResponse, w.info))
+		}
+	}
+}
+
+func (w *responsesChatWriter) writeEvents(events []*dto.ResponsesStreamResponse) {
+	for _, event := range events {
+		jsonData, err := common.Marshal(event)
+		if err != nil {
+			logger.LogError(w.c, "failed to marshal responses stream event: "+err.Error())
+			continue
+		}
+		_, _ = w.ResponseWriter.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, jsonData))
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *responsesChatWriter) finish(usage *dto.Usage) {
+	if w.info.IsStream {
+		w.convertStreamLines(true)
+		events := service.FinishResponsesStream(w.info, usage)
+		w.writeEvents(events)
+		if len(events) > 0 && events[len(events)-1].Response != nil {
+			if jsonData, err := common.Marshal(events[len(events)-1].Response); err == nil {
+				common.SetContextKey(w.c, constant.ContextKeyResponsesResult, string(jsonData))
+			}
+		}
+		return
+	}
+	var openAIResponse dto.OpenAITextResponse
+	if err := common.Unmarshal(w.buffer.Bytes(), &openAIResponse); err != nil {
+		logger.LogError(w.c, "failed to unmarshal chat response: "+err.Error())
+		w.ResponseWriter.Header().Del("Content-Length")
+		_, _ = w.ResponseWriter.Write(w.buffer.Bytes())
+		return
+	}
+	jsonData, err := common.Marshal(service.ResponseOpenAI2Responses(&openAIResponse, w.info, usage))
+	if err != nil {
+		logger.LogError(w.c, "failed to marshal responses response: "+err.Error())
+		return
+	}
+	common.SetContextKey(w.c, constant.ContextKeyResponsesResult, string(jsonData))
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.Header().Set("Content-Length", fmt.Sprintf("%d", len(jsonData)))
+	_, _ = w.ResponseWriter.Write(jsonData)
+}
@@ -2,6 +2,7 @@ package relay
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
@@ -41,18 +43,35 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		return types.NewError(fmt.Errorf("invalid api type: %d", info.ApiType), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
 	}
 	adaptor.Init(info)
+
+	convertedRequest, native, err := nativeResponsesRequest(c, info, adaptor, request)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+
+	// 解析 previous_response_id，上游无法续接时回放网关保存的历史会话
+	conversation, newAPIError := prepareResponsesConversation(info, request, native)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	if !native {
+		return responsesChatHelper(c, info, adaptor, request, conversation)
+	}
+	if conversation.replayed {
+		convertedRequest, err = adaptor.ConvertOpenAIResponsesRequest(c, info, *request)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+		}
+	}
+
 	var requestBody io.Reader
-	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
+	if !conversation.replayed && (model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled) {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeReadRequestBodyFailed, types.ErrOptionWithSkipRetry())
 		}
 		requestBody = bytes.NewBuffer(body)
 	} else {
-		convertedRequest, err := adaptor.ConvertOpenAIResponsesRequest(c, info, *request)
-		if err != nil {
-			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
-		}
 		jsonData, err := common.Marshal(convertedRequest)
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
@@ -109,5 +128,22 @@ func ResponsesHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 	} else {
 		postConsumeQuota(c, info, usage.(*dto.Usage), "")
 	}
+	saveStoredResponse(c, info, request, conversation, true)
 	return nil
 }
+
+// nativeResponsesRequest 优先原生转发，渠道未实现 Responses API 或配置为转换时返回 false 以转换为 Chat Completions，
+// 其他转换错误直接返回
+func nativeResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.OpenAIResponsesRequest) (any, bool, error) {
+	if info.ChannelOtherSettings.ResponsesToChat {
+		return nil, false, nil
+	}
+	convertedRequest, err := adaptor.ConvertOpenAIResponsesRequest(c, info, *request)
+	if errors.Is(err, channel.ErrNotImplemented) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return convertedRequest, true, nil
+}
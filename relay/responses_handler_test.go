@@ -0,0 +1,54 @@
+package relay
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubResponsesAdaptor 只实现 ConvertOpenAIResponsesRequest，其余方法不会被调用
+type stubResponsesAdaptor struct {
+	channel.Adaptor
+	err error
+}
+
+func (a *stubResponsesAdaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	return request, nil
+}
+
+func TestNativeResponsesRequestFallback(t *testing.T) {
+	request := &dto.OpenAIResponsesRequest{Model: "gpt-4o"}
+	newInfo := func(responsesToChat bool) *relaycommon.RelayInfo {
+		return &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{
+			ChannelOtherSettings: dto.ChannelOtherSettings{ResponsesToChat: responsesToChat},
+		}}
+	}
+
+	converted, native, err := nativeResponsesRequest(nil, newInfo(false), &stubResponsesAdaptor{}, request)
+	if err != nil || !native || converted == nil {
+		t.Fatalf("native = %t, err = %v, want native request", native, err)
+	}
+	// 渠道未实现 Responses API 时转换为 Chat Completions
+	_, native, err = nativeResponsesRequest(nil, newInfo(false), &stubResponsesAdaptor{err: channel.ErrNotImplemented}, request)
+	if err != nil || native {
+		t.Fatalf("native = %t, err = %v, want chat fallback", native, err)
+	}
+	_, native, err = nativeResponsesRequest(nil, newInfo(true), &stubResponsesAdaptor{}, request)
+	if err != nil || native {
+		t.Fatalf("native = %t, err = %v, want chat when configured", native, err)
+	}
+	// 其他转换错误不能被当作不支持而静默改走 Chat
+	convertErr := errors.New("invalid reasoning effort")
+	_, native, err = nativeResponsesRequest(nil, newInfo(false), &stubResponsesAdaptor{err: convertErr}, request)
+	if !errors.Is(err, convertErr) || native {
+		t.Fatalf("native = %t, err = %v, want the conversion error", native, err)
+	}
+}
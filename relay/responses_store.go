@@ -0,0 +1,180 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// responsesConversation 本轮请求在会话中的信息，回放历史前记录
+type responsesConversation struct {
+	previousResponseId string
+	// input 本轮输入项（JSON 数组），不含回放的历史
+	input    json.RawMessage
+	replayed bool
+}
+
+// normalizeResponsesInput 将字符串输入转换为 message 输入项数组
+func normalizeResponsesInput(input json.RawMessage) (json.RawMessage, error) {
+	switch common.GetJsonType(input) {
+	case "string":
+		var text string
+		if err := common.Unmarshal(input, &text); err != nil {
+			return nil, err
+		}
+		return common.Marshal([]map[string]any{{"type": "message", "role": "user", "content": text}})
+	case "array":
+		return input, nil
+	}
+	return json.RawMessage("[]"), nil
+}
+
+// replayOutputItems 将历史输出项转换为可回放的输入项，去掉上游 ID；原生渠道无法解析其他账号的推理项，直接丢弃
+func replayOutputItems(output string, native bool) []map[string]any {
+	var items []map[string]any
+	if err := common.UnmarshalJsonStr(output, &items); err != nil {
+		return nil
+	}
+	replay := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		switch common.Interface2String(item["type"]) {
+		case dto.ResponsesOutputItemMessage, dto.ResponsesOutputItemFunctionCall:
+		case dto.ResponsesOutputItemReasoning:
+			if native {
+				continue
+			}
+		default:
+			continue
+		}
+		delete(item, "id")
+		replay = append(replay, item)
+	}
+	return replay
+}
+
+// prepareResponsesConversation 解析 previous_response_id：上游可直接续接时保持不变，否则将历史会话回放到 input 中
+func prepareResponsesConversation(info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest, native bool) (*responsesConversation, *types.NewAPIError) {
+	input, err := normalizeResponsesInput(request.Input)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	conversation := &responsesConversation{previousResponseId: request.PreviousResponseID, input: input}
+	setting := model_setting.GetResponsesSettings()
+	if request.PreviousResponseID == "" || !setting.StoreEnabled {
+		return conversation, nil
+	}
+
+	stored, err := model.GetStoredResponse(request.PreviousResponseID, info.UserId)
+	if err != nil {
+		// 原生渠道交给上游解析，例如开启存储前产生的响应
+		if native && errors.Is(err, gorm.ErrRecordNotFound) {
+			return conversation, nil
+		}
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("previous response %s not found", request.PreviousResponseID), types.ErrorCodeInvalidRequest, http.StatusNotFound, types.ErrOptionWithSkipRetry())
+	}
+	if native && stored.Upstream && stored.ChannelId == info.ChannelId &&
+		(!info.ChannelIsMultiKey || stored.ChannelKeyIndex == info.ChannelMultiKeyIndex) {
+		return conversation, nil
+	}
+
+	chain, err := model.GetStoredResponseChain(request.PreviousResponseID, info.UserId, setting.MaxReplayDepth)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("failed to load conversation of %s: %w", request.PreviousResponseID, err), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	items := make([]any, 0)
+	for _, turn := range chain {
+		var turnInput []map[string]any
+		_ = common.UnmarshalJsonStr(string(turn.Input), &turnInput)
+		for _, item := range turnInput {
+			delete(item, "id")
+			items = append(items, item)
+		}
+		for _, item := range replayOutputItems(string(turn.Output), native) {
+			items = append(items, item)
+		}
+	}
+	var currentInput []any
+	_ = common.Unmarshal(input, &currentInput)
+	items = append(items, currentInput...)
+
+	request.Input, err = common.Marshal(items)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	request.PreviousResponseID = ""
+	conversation.replayed = true
+	return conversation, nil
+}
+
+// saveStoredResponse 保存本轮响应，store 为 false 时不保存
+func saveStoredResponse(c *gin.Context, info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest, conversation *responsesConversation, native bool) {
+	if !model_setting.GetResponsesSettings().StoreEnabled || conversation == nil {
+		return
+	}
+	if len(request.Store) > 0 {
+		var store bool
+		if err := common.Unmarshal(request.Store, &store); err == nil && !store {
+			return
+		}
+	}
+	raw := common.GetContextKeyString(c, constant.ContextKeyResponsesResult)
+	if raw == "" {
+		return
+	}
+	var result struct {
+		Type     string          `json:"type"`
+		Response json.RawMessage `json:"response"`
+	}
+	// 流式响应记录的是 response.completed 事件
+	if err := common.UnmarshalJsonStr(raw, &result); err == nil && result.Type != "" && len(result.Response) > 0 {
+		raw = string(result.Response)
+	}
+	var response struct {
+		Id     string          `json:"id"`
+		Model  string          `json:"model"`
+		Output json.RawMessage `json:"output"`
+	}
+	if err := common.UnmarshalJsonStr(raw, &response); err != nil || response.Id == "" {
+		return
+	}
+	// 为输入项补充 ID，供 input_items 分页使用，回放时会去掉
+	var inputItems []map[string]any
+	_ = common.Unmarshal(conversation.input, &inputItems)
+	for _, item := range inputItems {
+		if common.Interface2String(item["id"]) == "" {
+			item["id"] = "item_" + common.GetUUID()
+		}
+	}
+	input, err := common.Marshal(inputItems)
+	if err != nil {
+		input = conversation.input
+	}
+	stored := &model.StoredResponse{
+		ResponseId:         response.Id,
+		UserId:             info.UserId,
+		ChannelId:          info.ChannelId,
+		ChannelKeyIndex:    info.ChannelMultiKeyIndex,
+		Upstream:           native && !info.ChannelOtherSettings.DisableStore,
+		Model:              common.GetStringIfEmpty(response.Model, info.OriginModelName),
+		PreviousResponseId: conversation.previousResponseId,
+		Input:              model.LongText(input),
+		Output:             model.LongText(response.Output),
+		Response:           model.LongText(raw),
+	}
+	if err := stored.Insert(); err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to store response %s: %s", response.Id, err.Error()))
+	}
+}
@@ -0,0 +1,186 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func useResponsesStore(t *testing.T, enabled bool, maxReplayDepth int) {
+	t.Helper()
+	setupTestDB(t)
+	setting := model_setting.GetResponsesSettings()
+	saved := *setting
+	t.Cleanup(func() {
+		*setting = saved
+	})
+	setting.StoreEnabled = enabled
+	setting.RetentionDays = 1
+	setting.MaxReplayDepth = maxReplayDepth
+}
+
+func newResponsesStoreInfo(channelId int) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		UserId:          1,
+		OriginModelName: "gpt-4o",
+		ChannelMeta:     &relaycommon.ChannelMeta{ChannelId: channelId},
+	}
+}
+
+// storeTurn 模拟一轮请求完成后保存响应
+func storeTurn(t *testing.T, info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest, result string, native bool) {
+	t.Helper()
+	conversation, apiErr := prepareResponsesConversation(info, request, native)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	common.SetContextKey(c, constant.ContextKeyResponsesResult, result)
+	saveStoredResponse(c, info, request, conversation, native)
+}
+
+func TestResponsesStoreDisabledByDefault(t *testing.T) {
+	if model_setting.GetResponsesSettings().StoreEnabled {
+		t.Fatal("responses store must be opt-in")
+	}
+	useResponsesStore(t, false, 10)
+	request := &dto.OpenAIResponsesRequest{Model: "gpt-4o", Input: json.RawMessage(`"hi"`)}
+	storeTurn(t, newResponsesStoreInfo(1), request, `{"id":"resp_1","output":[]}`, false)
+	var count int64
+	model.DB.Model(&model.StoredResponse{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("stored %d responses with store disabled", count)
+	}
+}
+
+func TestResponsesStoreAndReplay(t *testing.T) {
+	useResponsesStore(t, true, 10)
+	info := newResponsesStoreInfo(1)
+
+	first := &dto.OpenAIResponsesRequest{Model: "gpt-4o", Input: json.RawMessage(`"What is 2+2?"`)}
+	storeTurn(t, info, first, `{"id":"resp_1","model":"gpt-4o","output":[
+		{"id":"rs_1","type":"reasoning","summary":[]},
+		{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"output_text","text":"4"}]}]}`, false)
+	// 流式响应记录的是 response.completed 事件
+	second := &dto.OpenAIResponsesRequest{Model: "gpt-4o", PreviousResponseID: "resp_1", Input: json.RawMessage(`"And 3+3?"`)}
+	storeTurn(t, info, second, `{"type":"response.completed","response":{"id":"resp_2","output":[
+		{"id":"msg_2","type":"message","role":"assistant","content":[{"type":"output_text","text":"6"}]}]}}`, false)
+
+	stored, err := model.GetStoredResponse("resp_2", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只保存本轮输入，不包含回放的历史
+	var input []map[string]any
+	_ = common.UnmarshalJsonStr(string(stored.Input), &input)
+	if stored.PreviousResponseId != "resp_1" || len(input) != 1 || input[0]["content"] != "And 3+3?" || input[0]["id"] == nil {
+		t.Fatalf("unexpected stored turn: previous %q, input %s", stored.PreviousResponseId, stored.Input)
+	}
+
+	// 非原生渠道回放全部历史，推理项保留，输出项去掉上游 ID
+	third := &dto.OpenAIResponsesRequest{Model: "gpt-4o", PreviousResponseID: "resp_2", Input: json.RawMessage(`"And 4+4?"`)}
+	if _, apiErr := prepareResponsesConversation(info, third, false); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	var replayed []map[string]any
+	if err := common.Unmarshal(third.Input, &replayed); err != nil {
+		t.Fatal(err)
+	}
+	kinds := make([]string, 0, len(replayed))
+	for _, item := range replayed {
+		if item["id"] != nil {
+			t.Fatalf("replayed item keeps id: %v", item)
+		}
+		kinds = append(kinds, common.Interface2String(item["type"])+":"+common.Interface2String(item["role"]))
+	}
+	want := []string{"message:user", "reasoning:", "message:assistant", "message:user", "message:assistant", "message:user"}
+	if len(kinds) != len(want) {
+		t.Fatalf("replayed items = %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("replayed items = %v, want %v", kinds, want)
+		}
+	}
+	if third.PreviousResponseID != "" {
+		t.Fatal("previous_response_id should be cleared after replay")
+	}
+
+	// store:false 的请求不保存
+	noStore := &dto.OpenAIResponsesRequest{Model: "gpt-4o", Input: json.RawMessage(`"secret"`), Store: json.RawMessage(`false`)}
+	storeTurn(t, info, noStore, `{"id":"resp_secret","output":[]}`, false)
+	if _, err := model.GetStoredResponse("resp_secret", 1); err == nil {
+		t.Fatal("store:false response was saved")
+	}
+}
+
+func TestResponsesPreviousResponseResolution(t *testing.T) {
+	useResponsesStore(t, true, 10)
+	info := newResponsesStoreInfo(1)
+	storeTurn(t, info, &dto.OpenAIResponsesRequest{Model: "gpt-4o", Input: json.RawMessage(`"hi"`)},
+		`{"id":"resp_native","output":[]}`, true)
+
+	// 原生渠道上同一渠道保存的响应直接透传 previous_response_id
+	request := &dto.OpenAIResponsesRequest{Model: "gpt-4o", PreviousResponseID: "resp_native", Input: json.RawMessage(`"again"`)}
+	if _, apiErr := prepareResponsesConversation(info, request, true); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if request.PreviousResponseID != "resp_native" || string(request.Input) != `"again"` {
+		t.Fatalf("native request should pass through, got previous %q input %s", request.PreviousResponseID, request.Input)
+	}
+
+	// 换到其他渠道时回放历史
+	request = &dto.OpenAIResponsesRequest{Model: "gpt-4o", PreviousResponseID: "resp_native", Input: json.RawMessage(`"again"`)}
+	if _, apiErr := prepareResponsesConversation(newResponsesStoreInfo(2), request, true); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if request.PreviousResponseID != "" {
+		t.Fatal("request on another channel should be replayed")
+	}
+
+	// 未知的响应：原生渠道交给上游，非原生渠道返回 404
+	request = &dto.OpenAIResponsesRequest{Model: "gpt-4o", PreviousResponseID: "resp_unknown"}
+	if _, apiErr := prepareResponsesConversation(info, request, true); apiErr != nil || request.PreviousResponseID != "resp_unknown" {
+		t.Fatalf("native unknown response: err %v, previous %q", apiErr, request.PreviousResponseID)
+	}
+	if _, apiErr := prepareResponsesConversation(info, request, false); apiErr == nil || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown response, got %v", apiErr)
+	}
+	// 其他用户的响应不可续接
+	other := newResponsesStoreInfo(1)
+	other.UserId = 2
+	request = &dto.OpenAIResponsesRequest{Model: "gpt-4o", PreviousResponseID: "resp_native"}
+	if _, apiErr := prepareResponsesConversation(other, request, false); apiErr == nil {
+		t.Fatal("expected error for response of another user")
+	}
+}
+
+func TestResponsesReplayDepthLimit(t *testing.T) {
+	useResponsesStore(t, true, 2)
+	info := newResponsesStoreInfo(1)
+	previous := ""
+	for _, id := range []string{"resp_1", "resp_2", "resp_3"} {
+		request := &dto.OpenAIResponsesRequest{Model: "gpt-4o", Input: json.RawMessage(`"hi"`)}
+		storeTurn(t, info, request, `{"id":"`+id+`","output":[]}`, false)
+		model.DB.Model(&model.StoredResponse{}).Where("response_id = ?", id).Update("previous_response_id", previous)
+		previous = id
+	}
+	request := &dto.OpenAIResponsesRequest{Model: "gpt-4o", PreviousResponseID: "resp_2"}
+	if _, apiErr := prepareResponsesConversation(info, request, false); apiErr != nil {
+		t.Fatalf("2 turns should be within the limit: %v", apiErr)
+	}
+	request = &dto.OpenAIResponsesRequest{Model: "gpt-4o", PreviousResponseID: "resp_3"}
+	if _, apiErr := prepareResponsesConversation(info, request, false); apiErr == nil || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 when exceeding replay depth, got %v", apiErr)
+	}
+}
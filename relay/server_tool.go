@@ -0,0 +1,245 @@
+package relay
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resolveServerTools 将请求中声明的网关侧工具（{"type": 工具名}）替换为函数定义，并附加自动提供的工具，
+// 返回本次请求可用的网关侧工具。网关侧工具需要以非流式请求上游，流式请求只有客户端声明了网关侧工具时才附加自动提供的工具，
+// 避免所有流式请求都被改为缓冲输出
+func resolveServerTools(info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) map[string]*operation_setting.ServerToolProvider {
+	setting := operation_setting.GetServerToolSetting()
+	if !setting.Enabled || len(setting.Providers) == 0 {
+		return nil
+	}
+	if info.RelayMode != relayconstant.RelayModeChatCompletions || info.RelayFormat != types.RelayFormatOpenAI {
+		return nil
+	}
+	serverTools := make(map[string]*operation_setting.ServerToolProvider)
+	tools := make([]dto.ToolCallRequest, 0, len(request.Tools))
+	for _, tool := range request.Tools {
+		if tool.Type != "function" && tool.Type != "" {
+			if provider := operation_setting.GetServerToolProvider(tool.Type); provider != nil {
+				if serverTools[provider.Name] == nil {
+					serverTools[provider.Name] = provider
+					tools = append(tools, service.ServerToolDefinition(provider))
+				}
+				continue
+			}
+		}
+		tools = append(tools, tool)
+	}
+	for i := range setting.Providers {
+		if info.IsStream && len(serverTools) == 0 {
+			break
+		}
+		provider := &setting.Providers[i]
+		if !provider.Enabled || !provider.AutoAttach || serverTools[provider.Name] != nil {
+			continue
+		}
+		declared := false
+		for _, tool := range tools {
+			if tool.Function.Name == provider.Name {
+				declared = true
+				break
+			}
+		}
+		if !declared {
+			serverTools[provider.Name] = provider
+			tools = append(tools, service.ServerToolDefinition(provider))
+		}
+	}
+	if len(serverTools) == 0 {
+		return nil
+	}
+	request.Tools = tools
+	return serverTools
+}
+
+// serverToolResult 网关侧工具循环的结果，用量与调用次数为所有轮次之和
+type serverToolResult struct {
+	response   *dto.OpenAITextResponse
+	usage      *dto.Usage
+	callCounts map[string]int
+	note       string
+}
+
+// serverToolHelper 以非流式请求上游，模型调用网关侧工具时由网关执行并把结果回传，直到模型给出最终回复
+// 或调用了客户端工具，所有轮次的用量与工具调用次数合并计费
+func serverToolHelper(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.GeneralOpenAIRequest, serverTools map[string]*operation_setting.ServerToolProvider, toolCallEmulation bool) *types.NewAPIError {
+	isStream := info.IsStream
+	defer func() {
+		info.IsStream = isStream
+	}()
+	applyChannelSystemPrompt(c, info, request)
+
+	result, newAPIError := runServerToolLoop(c, info, adaptor, request, serverTools, toolCallEmulation)
+	if newAPIError != nil {
+		return newAPIError
+	}
+
+	info.IsStream = isStream
+	if newAPIError := writeChatResponse(c, info, result.response); newAPIError != nil {
+		return newAPIError
+	}
+
+	totalCalls := recordServerToolCalls(info, result.callCounts)
+	postConsumeQuota(c, info, result.usage, fmt.Sprintf("网关工具调用 %d 次%s", totalCalls, result.note))
+	return nil
+}
+
+// runServerToolLoop 上游统一按非流式请求，执行模型调用的网关侧工具并回传结果，返回最后一轮的响应
+func runServerToolLoop(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.GeneralOpenAIRequest, serverTools map[string]*operation_setting.ServerToolProvider, toolCallEmulation bool) (*serverToolResult, *types.NewAPIError) {
+	maxIterations := operation_setting.GetServerToolSetting().MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = 1
+	}
+	request.Stream = false
+	request.StreamOptions = nil
+
+	result := &serverToolResult{usage: &dto.Usage{}, callCounts: make(map[string]int)}
+	var response *dto.OpenAITextResponse
+	for iteration := 1; ; iteration++ {
+		upstreamRequest := request
+		if toolCallEmulation {
+			emulated, err := common.DeepCopy(request)
+			if err != nil {
+				return nil, types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+			}
+			service.ApplyToolCallEmulation(emulated)
+			upstreamRequest = emulated
+		}
+		iterationResponse, usage, newAPIError := doCapturedChatRequest(c, info, adaptor, upstreamRequest)
+		if newAPIError != nil {
+			if iteration == 1 {
+				return nil, newAPIError
+			}
+			// 之前的轮次已产生用量与工具调用，返回上一轮的结果
+			logger.LogWarn(c, "server tool iteration failed: "+newAPIError.Error())
+			result.note = "，上游请求失败提前结束"
+			break
+		}
+		addUsage(result.usage, usage)
+		response = iterationResponse
+		if len(response.Choices) == 0 {
+			break
+		}
+		message := &response.Choices[0].Message
+		if toolCallEmulation {
+			text, toolCalls := service.ParseEmulatedToolCalls(message.StringContent())
+			if len(toolCalls) > 0 {
+				message.Content = nil
+				if text != "" {
+					message.SetStringContent(text)
+				}
+				message.SetToolCalls(toolCalls)
+				response.Choices[0].FinishReason = "tool_calls"
+			}
+		}
+		toolCalls := parseResponseToolCalls(message)
+		if len(toolCalls) == 0 {
+			break
+		}
+		// 包含客户端工具时交给客户端处理，网关侧工具调用不返回给客户端
+		if !allServerToolCalls(toolCalls, serverTools) {
+			break
+		}
+		if iteration >= maxIterations {
+			result.note = "，达到最大轮数"
+			response.Choices[0].FinishReason = "length"
+			break
+		}
+
+		request.Messages = append(request.Messages, dto.Message{Role: "assistant", Content: message.Content, ToolCalls: message.ToolCalls})
+		for _, toolCall := range toolCalls {
+			provider := serverTools[toolCall.Function.Name]
+			output, err := service.ExecuteServerTool(c.Request.Context(), provider, toolCall.Function.Arguments)
+			if err != nil {
+				logger.LogWarn(c, fmt.Sprintf("server tool %s failed: %s", provider.Name, err.Error()))
+				output = "Error: " + err.Error()
+			}
+			result.callCounts[provider.Name]++
+			request.Messages = append(request.Messages, dto.Message{Role: "tool", ToolCallId: toolCall.ID, Content: output})
+		}
+	}
+
+	if len(response.Choices) > 0 {
+		stripServerToolCalls(&response.Choices[0], serverTools)
+	}
+	response.Usage = *result.usage
+	result.response = response
+	return result, nil
+}
+
+// recordServerToolCalls 将各工具的调用次数记录到 OtherRatios 供 postConsumeQuota 计费，返回总调用次数
+func recordServerToolCalls(info *relaycommon.RelayInfo, callCounts map[string]int) int {
+	totalCalls := 0
+	if len(callCounts) == 0 {
+		return totalCalls
+	}
+	if info.PriceData.OtherRatios == nil {
+		info.PriceData.OtherRatios = make(map[string]float64)
+	}
+	for name, count := range callCounts {
+		info.PriceData.OtherRatios[service.ServerToolRatioPrefix+name] = float64(count)
+		totalCalls += count
+	}
+	return totalCalls
+}
+
+func parseResponseToolCalls(message *dto.Message) []dto.ToolCallResponse {
+	if len(message.ToolCalls) == 0 {
+		return nil
+	}
+	var toolCalls []dto.ToolCallResponse
+	if err := common.Unmarshal(message.ToolCalls, &toolCalls); err != nil {
+		return nil
+	}
+	return toolCalls
+}
+
+func allServerToolCalls(toolCalls []dto.ToolCallResponse, serverTools map[string]*operation_setting.ServerToolProvider) bool {
+	for _, toolCall := range toolCalls {
+		if serverTools[toolCall.Function.Name] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// stripServerToolCalls 从返回给客户端的结果中移除网关侧工具调用
+func stripServerToolCalls(choice *dto.OpenAITextResponseChoice, serverTools map[string]*operation_setting.ServerToolProvider) {
+	toolCalls := parseResponseToolCalls(&choice.Message)
+	if len(toolCalls) == 0 {
+		return
+	}
+	clientToolCalls := make([]dto.ToolCallResponse, 0, len(toolCalls))
+	for _, toolCall := range toolCalls {
+		if serverTools[toolCall.Function.Name] == nil {
+			clientToolCalls = append(clientToolCalls, toolCall)
+		}
+	}
+	if len(clientToolCalls) == len(toolCalls) {
+		return
+	}
+	if len(clientToolCalls) == 0 {
+		choice.Message.ToolCalls = nil
+		if choice.FinishReason == "tool_calls" {
+			choice.FinishReason = "stop"
+		}
+		return
+	}
+	choice.Message.SetToolCalls(clientToolCalls)
+}
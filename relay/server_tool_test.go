@@ -0,0 +1,255 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel/openai"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// scriptedAdaptor 按顺序返回预设的上游响应，并记录每一轮发给上游的请求
+type scriptedAdaptor struct {
+	openai.Adaptor
+	responses []string
+	requests  []dto.GeneralOpenAIRequest
+}
+
+func (a *scriptedAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	var request dto.GeneralOpenAIRequest
+	if err := json.NewDecoder(requestBody).Decode(&request); err != nil {
+		return nil, err
+	}
+	a.requests = append(a.requests, request)
+	body := a.responses[min(len(a.requests), len(a.responses))-1]
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func toolCallResponse(name string, arguments string, promptTokens int, completionTokens int) string {
+	return fmt.Sprintf(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"finish_reason":"tool_calls",
+		"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_%d","type":"function","function":{"name":%q,"arguments":%q}}]}}],
+		"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`,
+		promptTokens, name, arguments, promptTokens, completionTokens, promptTokens+completionTokens)
+}
+
+func textResponse(content string, promptTokens int, completionTokens int) string {
+	return fmt.Sprintf(`{"id":"chatcmpl-2","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"finish_reason":"stop",
+		"message":{"role":"assistant","content":%q}}],"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`,
+		content, promptTokens, completionTokens, promptTokens+completionTokens)
+}
+
+func useServerToolSetting(t *testing.T, maxIterations int, providers ...operation_setting.ServerToolProvider) {
+	t.Helper()
+	setting := operation_setting.GetServerToolSetting()
+	saved := *setting
+	t.Cleanup(func() {
+		*setting = saved
+	})
+	setting.Enabled = true
+	setting.MaxIterations = maxIterations
+	setting.Providers = providers
+}
+
+func newServerToolContext(t *testing.T, isStream bool) (*gin.Context, *relaycommon.RelayInfo) {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := &relaycommon.RelayInfo{
+		RelayMode:   relayconstant.RelayModeChatCompletions,
+		RelayFormat: types.RelayFormatOpenAI,
+		IsStream:    isStream,
+		PriceData:   types.PriceData{},
+		ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "gpt-4o", ChannelType: constant.ChannelTypeOpenAI},
+	}
+	return c, info
+}
+
+func newServerToolRequest(isStream bool, tools ...dto.ToolCallRequest) *dto.GeneralOpenAIRequest {
+	return &dto.GeneralOpenAIRequest{
+		Model:    "gpt-4o",
+		Stream:   isStream,
+		Messages: []dto.Message{{Role: "user", Content: "look it up"}},
+		Tools:    tools,
+	}
+}
+
+func TestServerToolLoopMultipleIterations(t *testing.T) {
+	useServerToolSetting(t, 5, operation_setting.ServerToolProvider{Name: "lookup", Type: operation_setting.ServerToolTypeStub, Price: 5, Enabled: true})
+	c, info := newServerToolContext(t, false)
+	request := newServerToolRequest(false, dto.ToolCallRequest{Type: "lookup"})
+	serverTools := resolveServerTools(info, request)
+	if serverTools["lookup"] == nil {
+		t.Fatalf("declared server tool should be resolved: %+v", serverTools)
+	}
+	adaptor := &scriptedAdaptor{responses: []string{
+		toolCallResponse("lookup", `{"q":"a"}`, 10, 5),
+		toolCallResponse("lookup", `{"q":"b"}`, 20, 6),
+		textResponse("done", 30, 7),
+	}}
+
+	result, apiErr := runServerToolLoop(c, info, adaptor, request, serverTools, false)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if len(adaptor.requests) != 3 {
+		t.Fatalf("upstream requests = %d, want 3", len(adaptor.requests))
+	}
+	// 最后一轮请求带上之前每次工具调用与 stub 的输出（原样返回参数）
+	var toolOutputs []string
+	for _, message := range adaptor.requests[2].Messages {
+		if message.Role == "tool" {
+			toolOutputs = append(toolOutputs, message.StringContent())
+		}
+	}
+	if strings.Join(toolOutputs, ",") != `{"q":"a"},{"q":"b"}` {
+		t.Fatalf("tool outputs = %q", toolOutputs)
+	}
+	if adaptor.requests[0].Stream {
+		t.Fatal("upstream request should not be streamed")
+	}
+
+	message := result.response.Choices[0].Message
+	if message.StringContent() != "done" || len(message.ToolCalls) != 0 || result.response.Choices[0].FinishReason != "stop" {
+		t.Fatalf("unexpected final response: %+v", result.response.Choices[0])
+	}
+	// 用量为所有轮次之和
+	if result.usage.PromptTokens != 60 || result.usage.CompletionTokens != 18 || result.usage.TotalTokens != 78 {
+		t.Fatalf("unexpected usage: %+v", result.usage)
+	}
+	if result.response.Usage.PromptTokens != 60 {
+		t.Fatalf("response usage should be the total: %+v", result.response.Usage)
+	}
+	if result.callCounts["lookup"] != 2 || result.note != "" {
+		t.Fatalf("unexpected call counts %v, note %q", result.callCounts, result.note)
+	}
+
+	if total := recordServerToolCalls(info, result.callCounts); total != 2 {
+		t.Fatalf("total calls = %d", total)
+	}
+	if info.PriceData.OtherRatios[service.ServerToolRatioPrefix+"lookup"] != 2 {
+		t.Fatalf("unexpected other ratios: %v", info.PriceData.OtherRatios)
+	}
+	// 每千次 5 美元，调用 2 次，分组倍率 2
+	quota, calls, content := calculateServerToolQuota(info, decimal.NewFromInt(2), decimal.NewFromInt(500000))
+	if !quota.Equal(decimal.NewFromInt(10000)) || calls["lookup"] != 2 || !strings.Contains(content, "lookup 调用 2 次") {
+		t.Fatalf("unexpected server tool quota %s, calls %v, content %q", quota, calls, content)
+	}
+}
+
+func TestServerToolLoopStopsAtMaxIterations(t *testing.T) {
+	useServerToolSetting(t, 2, operation_setting.ServerToolProvider{Name: "lookup", Type: operation_setting.ServerToolTypeStub, StubOutput: "nothing found", Enabled: true})
+	c, info := newServerToolContext(t, false)
+	request := newServerToolRequest(false, dto.ToolCallRequest{Type: "lookup"})
+	serverTools := resolveServerTools(info, request)
+	adaptor := &scriptedAdaptor{responses: []string{toolCallResponse("lookup", `{"q":"a"}`, 10, 5)}}
+
+	result, apiErr := runServerToolLoop(c, info, adaptor, request, serverTools, false)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if len(adaptor.requests) != 2 {
+		t.Fatalf("upstream requests = %d, want 2", len(adaptor.requests))
+	}
+	if toolMessage := adaptor.requests[1].Messages[len(adaptor.requests[1].Messages)-1]; toolMessage.StringContent() != "nothing found" {
+		t.Fatalf("unexpected tool message: %+v", toolMessage)
+	}
+	// 达到最大轮数时最后一轮的工具调用不执行，也不返回给客户端
+	choice := result.response.Choices[0]
+	if choice.FinishReason != "length" || len(choice.Message.ToolCalls) != 0 {
+		t.Fatalf("unexpected final choice: %+v", choice)
+	}
+	if result.callCounts["lookup"] != 1 || result.note != "，达到最大轮数" {
+		t.Fatalf("unexpected call counts %v, note %q", result.callCounts, result.note)
+	}
+	if result.usage.PromptTokens != 20 || result.usage.CompletionTokens != 10 {
+		t.Fatalf("unexpected usage: %+v", result.usage)
+	}
+}
+
+func TestServerToolLoopReturnsClientToolCalls(t *testing.T) {
+	useServerToolSetting(t, 5, operation_setting.ServerToolProvider{Name: "lookup", Type: operation_setting.ServerToolTypeStub, Enabled: true})
+	c, info := newServerToolContext(t, false)
+	clientTool := dto.ToolCallRequest{Type: "function", Function: dto.FunctionRequest{Name: "get_weather"}}
+	request := newServerToolRequest(false, dto.ToolCallRequest{Type: "lookup"}, clientTool)
+	serverTools := resolveServerTools(info, request)
+	adaptor := &scriptedAdaptor{responses: []string{toolCallResponse("get_weather", `{"city":"Paris"}`, 10, 5)}}
+
+	result, apiErr := runServerToolLoop(c, info, adaptor, request, serverTools, false)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if len(adaptor.requests) != 1 || len(result.callCounts) != 0 {
+		t.Fatalf("client tool calls should end the loop: requests %d, calls %v", len(adaptor.requests), result.callCounts)
+	}
+	toolCalls := parseResponseToolCalls(&result.response.Choices[0].Message)
+	if len(toolCalls) != 1 || toolCalls[0].Function.Name != "get_weather" || result.response.Choices[0].FinishReason != "tool_calls" {
+		t.Fatalf("unexpected final choice: %+v", result.response.Choices[0])
+	}
+}
+
+func toolNames(tools []dto.ToolCallRequest) []string {
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Function.Name)
+	}
+	return names
+}
+
+func TestResolveServerToolsAutoAttach(t *testing.T) {
+	useServerToolSetting(t, 5,
+		operation_setting.ServerToolProvider{Name: "lookup", Type: operation_setting.ServerToolTypeStub, Enabled: true},
+		operation_setting.ServerToolProvider{Name: "web_search", Type: operation_setting.ServerToolTypeSearch, AutoAttach: true, Enabled: true},
+	)
+	clientTool := dto.ToolCallRequest{Type: "function", Function: dto.FunctionRequest{Name: "get_weather"}}
+
+	// 非流式请求本就缓冲输出，自动附加
+	_, info := newServerToolContext(t, false)
+	request := newServerToolRequest(false, clientTool)
+	serverTools := resolveServerTools(info, request)
+	if serverTools["web_search"] == nil || strings.Join(toolNames(request.Tools), ",") != "get_weather,web_search" {
+		t.Fatalf("non-stream request should get auto-attached tools: %v, %v", serverTools, toolNames(request.Tools))
+	}
+
+	// 流式请求未声明网关侧工具时保持原样，不进入缓冲的网关工具流程
+	_, info = newServerToolContext(t, true)
+	request = newServerToolRequest(true, clientTool)
+	if serverTools := resolveServerTools(info, request); serverTools != nil {
+		t.Fatalf("stream request without server tools should not be buffered: %v", serverTools)
+	}
+	if strings.Join(toolNames(request.Tools), ",") != "get_weather" {
+		t.Fatalf("tools should be unchanged: %v", toolNames(request.Tools))
+	}
+
+	// 流式请求声明了网关侧工具时已需要缓冲，一并附加自动提供的工具
+	_, info = newServerToolContext(t, true)
+	request = newServerToolRequest(true, dto.ToolCallRequest{Type: "lookup"})
+	serverTools = resolveServerTools(info, request)
+	if serverTools["lookup"] == nil || serverTools["web_search"] == nil || strings.Join(toolNames(request.Tools), ",") != "lookup,web_search" {
+		t.Fatalf("declared server tool should attach auto tools: %v, %v", serverTools, toolNames(request.Tools))
+	}
+
+	// 客户端自行声明了同名函数时不重复附加
+	_, info = newServerToolContext(t, false)
+	request = newServerToolRequest(false, dto.ToolCallRequest{Type: "function", Function: dto.FunctionRequest{Name: "web_search"}})
+	if serverTools := resolveServerTools(info, request); serverTools != nil || len(request.Tools) != 1 {
+		t.Fatalf("client-declared function should not be replaced: %v, %v", serverTools, toolNames(request.Tools))
+	}
+}
@@ -0,0 +1,222 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shouldEmulateToolCall 渠道开启函数调用模拟且请求包含工具定义或工具调用历史时返回 true
+func shouldEmulateToolCall(info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) bool {
+	if !info.ChannelSetting.ToolCallEmulation {
+		return false
+	}
+	if info.RelayMode != relayconstant.RelayModeChatCompletions || info.RelayFormat != types.RelayFormatOpenAI {
+		return false
+	}
+	if len(request.Tools) > 0 {
+		return true
+	}
+	for _, message := range request.Messages {
+		if message.Role == "tool" || len(message.ToolCalls) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// toolCallEmulationWriter 拦截渠道输出，将模型文本中的 <tool_call> 块转换为 tool_calls
+type toolCallEmulationWriter struct {
+	gin.ResponseWriter
+	c        *gin.Context
+	info     *relaycommon.RelayInfo
+	buffer   bytes.Buffer
+	parser   service.ToolCallParser
+	id       string
+	created  int64
+	model    string
+	finished bool
+}
+
+func newToolCallEmulationWriter(c *gin.Context, info *relaycommon.RelayInfo) *toolCallEmulationWriter {
+	return &toolCallEmulationWriter{ResponseWriter: c.Writer, c: c, info: info}
+}
+
+func (w *toolCallEmulationWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if w.info.IsStream {
+		w.convertStreamLines(false)
+	}
+	return len(data), nil
+}
+
+func (w *toolCallEmulationWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// convertStreamLines 逐行处理 SSE 数据，flush 为 true 时同时处理末尾不完整的行
+func (w *toolCallEmulationWriter) convertStreamLines(flush bool) {
+	for {
+		index := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if index < 0 {
+			if !flush || w.buffer.Len() == 0 {
+				return
+			}
+			index = w.buffer.Len() - 1
+		}
+		line := strings.TrimSpace(string(w.buffer.Next(index + 1)))
+		switch {
+		case strings.HasPrefix(line, ":"):
+			_, _ = w.ResponseWriter.WriteString(line + "\n\n")
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "[DONE]" {
+				w.finishStream()
+				_, _ = w.ResponseWriter.WriteString("data: [DONE]\n\n")
+				continue
+			}
+			var streamResponse dto.ChatCompletionsStreamResponse
+			if err := common.UnmarshalJsonStr(data, &streamResponse); err != nil {
+				logger.LogError(w.c, "failed to unmarshal chat stream response: "+err.Error())
+				_, _ = w.ResponseWriter.WriteString(line + "\n\n")
+				continue
+			}
+			w.handleChunk(&streamResponse)
+		}
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *toolCallEmulationWriter) handleChunk(chunk *dto.ChatCompletionsStreamResponse) {
+	if chunk.Id != "" {
+		w.id, w.created, w.model = chunk.Id, chunk.Created, chunk.Model
+	}
+	if len(chunk.Choices) == 0 {
+		w.writeChunk(chunk)
+		return
+	}
+	choice := &chunk.Choices[0]
+	var toolCalls []dto.ToolCallResponse
+	if choice.Delta.Content != nil {
+		text, calls := w.parser.Feed(*choice.Delta.Content)
+		toolCalls = append(toolCalls, calls...)
+		choice.Delta.Content = nil
+		// 工具调用块之间的空白不再作为正文输出
+		if text != "" && (w.parser.Count == 0 || strings.TrimSpace(text) != "") {
+			choice.Delta.SetContentString(text)
+		}
+	}
+	finishReason := choice.FinishReason
+	choice.FinishReason = nil
+	if finishReason != nil && *finishReason != "" && !w.finished {
+		text, calls := w.parser.Finish()
+		toolCalls = append(toolCalls, calls...)
+		if strings.TrimSpace(text) != "" {
+			choice.Delta.SetContentString(choice.Delta.GetContentString() + text)
+		}
+	}
+
+	delta := choice.Delta
+	if delta.Content != nil || delta.ReasoningContent != nil || delta.Reasoning != nil || delta.Role != "" || len(delta.ToolCalls) > 0 || chunk.Usage != nil {
+		w.writeChunk(chunk)
+	}
+	w.writeToolCalls(toolCalls)
+	if finishReason != nil && *finishReason != "" && !w.finished {
+		w.writeFinish(*finishReason)
+	}
+}
+
+// finishStream 上游未返回 finish_reason 就结束时，输出暂存的内容和结束分片
+func (w *toolCallEmulationWriter) finishStream() {
+	if w.finished {
+		return
+	}
+	text, toolCalls := w.parser.Finish()
+	if strings.TrimSpace(text) != "" {
+		chunk := w.newChunk()
+		chunk.Choices[0].Delta.SetContentString(text)
+		w.writeChunk(chunk)
+	}
+	w.writeToolCalls(toolCalls)
+	w.writeFinish("stop")
+}
+
+func (w *toolCallEmulationWriter) writeToolCalls(toolCalls []dto.ToolCallResponse) {
+	for _, toolCall := range toolCalls {
+		chunk := w.newChunk()
+		chunk.Choices[0].Delta.ToolCalls = []dto.ToolCallResponse{toolCall}
+		w.writeChunk(chunk)
+	}
+}
+
+func (w *toolCallEmulationWriter) writeFinish(finishReason string) {
+	if w.parser.Count > 0 {
+		finishReason = "tool_calls"
+	}
+	chunk := w.newChunk()
+	chunk.Choices[0].FinishReason = &finishReason
+	w.writeChunk(chunk)
+	w.finished = true
+}
+
+func (w *toolCallEmulationWriter) newChunk() *dto.ChatCompletionsStreamResponse {
+	return &dto.ChatCompletionsStreamResponse{
+		Id:      w.id,
+		Object:  "chat.completion.chunk",
+		Created: w.created,
+		Model:   w.model,
+		Choices: []dto.ChatCompletionsStreamResponseChoice{{}},
+	}
+}
+
+func (w *toolCallEmulationWriter) writeChunk(chunk *dto.ChatCompletionsStreamResponse) {
+	jsonData, err := common.Marshal(chunk)
+	if err != nil {
+		logger.LogError(w.c, "failed to marshal chat stream response: "+err.Error())
+		return
+	}
+	_, _ = w.ResponseWriter.WriteString(fmt.Sprintf("data: %s\n\n", jsonData))
+}
+
+func (w *toolCallEmulationWriter) finish() {
+	if w.info.IsStream {
+		w.convertStreamLines(true)
+		w.ResponseWriter.Flush()
+		return
+	}
+	var response dto.OpenAITextResponse
+	if err := common.Unmarshal(w.buffer.Bytes(), &response); err != nil || len(response.Choices) == 0 {
+		_, _ = w.ResponseWriter.Write(w.buffer.Bytes())
+		return
+	}
+	choice := &response.Choices[0]
+	text, toolCalls := service.ParseEmulatedToolCalls(choice.Message.StringContent())
+	if len(toolCalls) == 0 {
+		_, _ = w.ResponseWriter.Write(w.buffer.Bytes())
+		return
+	}
+	choice.Message.Content = nil
+	if text != "" {
+		choice.Message.SetStringContent(text)
+	}
+	choice.Message.SetToolCalls(toolCalls)
+	choice.FinishReason = "tool_calls"
+	jsonData, err := common.Marshal(response)
+	if err != nil {
+		logger.LogError(w.c, "failed to marshal chat response: "+err.Error())
+		_, _ = w.ResponseWriter.Write(w.buffer.Bytes())
+		return
+	}
+	w.ResponseWriter.Header().Set("Content-Length", fmt.Sprintf("%d", len(jsonData)))
+	_, _ = w.ResponseWriter.Write(jsonData)
+}
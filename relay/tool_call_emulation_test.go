@@ -0,0 +1,138 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newToolCallWriterContext(isStream bool) (*toolCallEmulationWriter, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	return newToolCallEmulationWriter(c, &relaycommon.RelayInfo{IsStream: isStream}), recorder
+}
+
+func streamChunk(content string, finishReason string) string {
+	chunk := dto.ChatCompletionsStreamResponse{Id: "chatcmpl-1", Object: "chat.completion.chunk", Created: 1, Model: "gpt-4o",
+		Choices: []dto.ChatCompletionsStreamResponseChoice{{}}}
+	if content != "" {
+		chunk.Choices[0].Delta.SetContentString(content)
+	}
+	if finishReason != "" {
+		chunk.Choices[0].FinishReason = &finishReason
+	}
+	data, _ := common.Marshal(chunk)
+	return fmt.Sprintf("data: %s\n\n", data)
+}
+
+// decodeStreamChunks 解析写给客户端的 SSE 数据，[DONE] 以 nil 表示
+func decodeStreamChunks(t *testing.T, body string) []*dto.ChatCompletionsStreamResponse {
+	t.Helper()
+	var chunks []*dto.ChatCompletionsStreamResponse
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			chunks = append(chunks, nil)
+			continue
+		}
+		var chunk dto.ChatCompletionsStreamResponse
+		if err := common.UnmarshalJsonStr(data, &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		chunks = append(chunks, &chunk)
+	}
+	return chunks
+}
+
+func TestToolCallEmulationWriterStream(t *testing.T) {
+	writer, recorder := newToolCallWriterContext(true)
+	// SSE 行本身也可能被拆开写入
+	stream := streamChunk("Let me check.<tool", "") +
+		streamChunk("_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>\n", "") +
+		streamChunk("<tool_call>\n{\"name\": \"get_time\"}\n</tool_call>", "") +
+		streamChunk("", "stop") +
+		"data: [DONE]\n\n"
+	for i := 0; i < len(stream); i += 37 {
+		_, _ = writer.WriteString(stream[i:min(i+37, len(stream))])
+	}
+	writer.finish()
+
+	chunks := decodeStreamChunks(t, recorder.Body.String())
+	if len(chunks) != 5 {
+		t.Fatalf("chunks = %d, want text, 2 tool calls, finish and [DONE]: %s", len(chunks), recorder.Body.String())
+	}
+	if content := chunks[0].Choices[0].Delta.GetContentString(); content != "Let me check." {
+		t.Fatalf("first chunk content = %q", content)
+	}
+	for i, name := range []string{"get_weather", "get_time"} {
+		delta := chunks[i+1].Choices[0].Delta
+		if len(delta.ToolCalls) != 1 || delta.Content != nil {
+			t.Fatalf("chunk %d should carry one tool call: %+v", i+1, delta)
+		}
+		call := delta.ToolCalls[0]
+		if call.Index == nil || *call.Index != i || call.Function.Name != name || call.ID == "" || chunks[i+1].Id != "chatcmpl-1" {
+			t.Fatalf("tool call %d = %+v", i, call)
+		}
+	}
+	if arguments := chunks[1].Choices[0].Delta.ToolCalls[0].Function.Arguments; arguments != `{"city":"Paris"}` {
+		t.Fatalf("arguments = %q", arguments)
+	}
+	if finish := chunks[3].Choices[0].FinishReason; finish == nil || *finish != "tool_calls" {
+		t.Fatalf("finish reason = %v, want tool_calls", finish)
+	}
+	if chunks[4] != nil {
+		t.Fatal("stream should end with [DONE]")
+	}
+}
+
+func TestToolCallEmulationWriterStreamWithoutFinishReason(t *testing.T) {
+	writer, recorder := newToolCallWriterContext(true)
+	_, _ = writer.WriteString(streamChunk("Hello <tool_call>{\"name\": \"ping\"}", "") + "data: [DONE]\n\n")
+	writer.finish()
+
+	// 上游未返回 finish_reason 时在 [DONE] 前补上未闭合的调用与结束分片
+	chunks := decodeStreamChunks(t, recorder.Body.String())
+	if len(chunks) != 4 || chunks[0].Choices[0].Delta.GetContentString() != "Hello " ||
+		chunks[1].Choices[0].Delta.ToolCalls[0].Function.Name != "ping" ||
+		*chunks[2].Choices[0].FinishReason != "tool_calls" || chunks[3] != nil {
+		t.Fatalf("unexpected stream: %s", recorder.Body.String())
+	}
+}
+
+func TestToolCallEmulationWriterNonStream(t *testing.T) {
+	writer, recorder := newToolCallWriterContext(false)
+	_, _ = writer.WriteString(textResponse("Checking.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>", 10, 5))
+	writer.finish()
+
+	response := decodeChatResponse(t, recorder)
+	choice := response.Choices[0]
+	toolCalls := choice.Message.ParseToolCalls()
+	if choice.FinishReason != "tool_calls" || choice.Message.StringContent() != "Checking." || len(toolCalls) != 1 ||
+		toolCalls[0].Function.Name != "get_weather" || toolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Fatalf("unexpected response: %s", recorder.Body.String())
+	}
+	if response.Usage.TotalTokens != 15 {
+		t.Fatalf("usage = %+v, want it preserved", response.Usage)
+	}
+
+	// 没有工具调用时原样输出
+	writer, recorder = newToolCallWriterContext(false)
+	body := textResponse("plain answer", 10, 5)
+	_, _ = writer.WriteString(body)
+	writer.finish()
+	if recorder.Body.String() != body {
+		t.Fatalf("body = %q, want it unchanged", recorder.Body.String())
+	}
+}
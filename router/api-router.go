@@ -19,6 +19,8 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/uptime/status", controller.GetUptimeKumaStatus)
 		apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
 		apiRouter.GET("/status/test", middleware.AdminAuth(), controller.TestStatus)
+		apiRouter.POST("/tokenize", middleware.AdminAuth(), controller.Tokenize)
+		apiRouter.POST("/tokenize/reload", middleware.AdminAuth(), controller.ReloadTokenizers)
 		apiRouter.GET("/notice", controller.GetNotice)
 		apiRouter.GET("/user-agreement", controller.GetUserAgreement)
 		apiRouter.GET("/privacy-policy", controller.GetPrivacyPolicy)
@@ -26,6 +28,7 @@ func SetApiRouter(router *gin.Engine) {
 		//apiRouter.GET("/midjourney", controller.GetMidjourney)
 		apiRouter.GET("/home_page_content", controller.GetHomePageContent)
 		apiRouter.GET("/pricing", middleware.TryUserAuth(), controller.GetPricing)
+		apiRouter.GET("/pricing/upcoming", middleware.TryUserAuth(), controller.GetUpcomingPriceChanges)
 		apiRouter.GET("/verification", middleware.EmailVerificationRateLimit(), middleware.TurnstileCheck(), controller.SendEmailVerification)
 		apiRouter.GET("/reset_password", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.SendPasswordResetEmail)
 		apiRouter.POST("/user/reset", middleware.CriticalRateLimit(), controller.ResetPassword)
@@ -42,6 +45,8 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/ratio_config", middleware.CriticalRateLimit(), controller.GetRatioConfig)
 
 		apiRouter.POST("/stripe/webhook", controller.StripeWebhook)
+		apiRouter.GET("/payment/:provider/notify", controller.PaymentNotify)
+		apiRouter.POST("/payment/:provider/notify", controller.PaymentNotify)
 
 		// Universal secure verification routes
 		apiRouter.POST("/verify", middleware.UserAuth(), middleware.CriticalRateLimit(), controller.UniversalVerify)
@@ -51,6 +56,7 @@ func SetApiRouter(router *gin.Engine) {
 		{
 			userRoute.POST("/register", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Register)
 			userRoute.POST("/login", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Login)
+			userRoute.POST("/login/ldap", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.LdapLogin)
 			userRoute.POST("/login/2fa", middleware.CriticalRateLimit(), controller.Verify2FALogin)
 			userRoute.POST("/passkey/login/begin", middleware.CriticalRateLimit(), controller.PasskeyLoginBegin)
 			userRoute.POST("/passkey/login/finish", middleware.CriticalRateLimit(), controller.PasskeyLoginFinish)
@@ -85,6 +91,8 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.POST("/stripe/amount", controller.RequestStripeAmount)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
 				selfRoute.PUT("/setting", controller.UpdateUserSetting)
+				selfRoute.GET("/statement", controller.GetSelfBillingStatements)
+				selfRoute.GET("/statement/:period", controller.GetSelfBillingStatement)
 
 				// 2FA routes
 				selfRoute.GET("/2fa/status", controller.Get2FAStatus)
@@ -100,6 +108,8 @@ func SetApiRouter(router *gin.Engine) {
 				adminRoute.GET("/", controller.GetAllUsers)
 				adminRoute.GET("/topup", controller.GetAllTopUps)
 				adminRoute.POST("/topup/complete", controller.AdminCompleteTopUp)
+				adminRoute.POST("/topup/refund", controller.AdminRefundTopUp)
+				adminRoute.POST("/topup/sync", controller.AdminSyncTopUp)
 				adminRoute.GET("/search", controller.SearchUsers)
 				adminRoute.GET("/:id", controller.GetUser)
 				adminRoute.POST("/", controller.CreateUser)
@@ -127,6 +137,14 @@ func SetApiRouter(router *gin.Engine) {
 			ratioSyncRoute.GET("/channels", controller.GetSyncableChannels)
 			ratioSyncRoute.POST("/fetch", controller.FetchUpstreamRatios)
 		}
+		priceVersionRoute := apiRouter.Group("/price_version")
+		priceVersionRoute.Use(middleware.RootAuth())
+		{
+			priceVersionRoute.GET("/", controller.GetPriceVersions)
+			priceVersionRoute.POST("/", controller.CreatePriceVersion)
+			priceVersionRoute.POST("/:id/cancel", controller.CancelPriceVersion)
+			priceVersionRoute.GET("/diff", controller.DiffPriceVersions)
+		}
 		channelRoute := apiRouter.Group("/channel")
 		channelRoute.Use(middleware.AdminAuth())
 		{
@@ -134,6 +152,8 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
 			channelRoute.GET("/models_enabled", controller.EnabledListModels)
+			channelRoute.GET("/inflight", controller.GetChannelsInFlight)
+			channelRoute.GET("/queue", controller.GetRequestQueues)
 			channelRoute.GET("/:id", controller.GetChannel)
 			channelRoute.POST("/:id/key", middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
 			channelRoute.GET("/test", controller.TestAllChannels)
@@ -193,11 +213,46 @@ func SetApiRouter(router *gin.Engine) {
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/margin", middleware.RootAuth(), controller.GetLogsMargin)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
 
+		statementRoute := apiRouter.Group("/statement")
+		statementRoute.Use(middleware.AdminAuth())
+		{
+			statementRoute.GET("/", controller.GetAllBillingStatements)
+			statementRoute.GET("/:user_id/:period", controller.GetUserBillingStatement)
+			statementRoute.POST("/generate", controller.GenerateBillingStatements)
+		}
+
+		subscriptionRoute := apiRouter.Group("/subscription")
+		{
+			subscriptionRoute.GET("/plans", middleware.UserAuth(), controller.GetSubscriptionPlans)
+			subscriptionRoute.GET("/self", middleware.UserAuth(), controller.GetSelfSubscription)
+			subscriptionRoute.POST("/self/stripe/pay", middleware.UserAuth(), middleware.CriticalRateLimit(), controller.RequestStripeSubscription)
+			subscriptionRoute.POST("/self/change", middleware.UserAuth(), middleware.CriticalRateLimit(), controller.ChangeSelfSubscription)
+			subscriptionRoute.POST("/self/cancel", middleware.UserAuth(), controller.CancelSelfSubscription)
+			subscriptionRoute.GET("/plan", middleware.AdminAuth(), controller.GetAllSubscriptionPlans)
+			subscriptionRoute.POST("/plan", middleware.AdminAuth(), controller.AddSubscriptionPlan)
+			subscriptionRoute.PUT("/plan", middleware.AdminAuth(), controller.UpdateSubscriptionPlan)
+			subscriptionRoute.DELETE("/plan/:id", middleware.AdminAuth(), controller.DeleteSubscriptionPlan)
+			subscriptionRoute.GET("/", middleware.AdminAuth(), controller.GetAllSubscriptions)
+			subscriptionRoute.POST("/assign", middleware.AdminAuth(), controller.AssignSubscription)
+			subscriptionRoute.PUT("/:id/plan", middleware.AdminAuth(), controller.AdminChangeSubscriptionPlan)
+			subscriptionRoute.POST("/:id/cancel", middleware.AdminAuth(), controller.AdminCancelSubscription)
+		}
+
+		priceOverrideRoute := apiRouter.Group("/price_override")
+		priceOverrideRoute.Use(middleware.AdminAuth())
+		{
+			priceOverrideRoute.GET("/", controller.GetPriceOverrides)
+			priceOverrideRoute.POST("/", controller.AddPriceOverride)
+			priceOverrideRoute.PUT("/", controller.UpdatePriceOverride)
+			priceOverrideRoute.DELETE("/:id", controller.DeletePriceOverride)
+		}
+
 		dataRoute := apiRouter.Group("/data")
 		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
 		dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
@@ -0,0 +1,43 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRelayGeminiDispatchesCountTokens(t *testing.T) {
+	setupTestDB(t)
+	engine := gin.New()
+	engine.POST("/v1beta/models/*path", func(c *gin.Context) {
+		c.Request.Header.Set("Content-Type", "application/json")
+		common.SetContextKey(c, constant.ContextKeyOriginalModel, "gemini-2.5-flash")
+		relayGemini(c)
+	})
+	body := `{"contents":[{"role":"user","parts":[{"text":"hello"}]}]}`
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-2.5-flash:countTokens", strings.NewReader(body)))
+	var response map[string]any
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := response["totalTokens"]; recorder.Code != http.StatusOK || !ok {
+		t.Fatalf("countTokens status = %d, response = %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-2.5-flash:generateContent", strings.NewReader(body)))
+	// 其余操作转发到上游，未选中渠道时不会返回本地计数
+	response = nil
+	_ = common.Unmarshal(recorder.Body.Bytes(), &response)
+	if _, ok := response["totalTokens"]; recorder.Code == http.StatusOK || ok {
+		t.Fatalf("generateContent status = %d, response = %s, want a relay error", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,32 @@
+package router
+
+import (
+	"github.com/QuantumNous/new-api/controller"
+	"github.com/QuantumNous/new-api/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetScimRouter SCIM 2.0 用户开通接口，供企业 IdP 同步用户与用户组
+// docs: https://datatracker.ietf.org/doc/html/rfc7644
+func SetScimRouter(router *gin.Engine) {
+	scimRouter := router.Group("/scim/v2")
+	scimRouter.Use(middleware.GlobalAPIRateLimit(), middleware.SCIMAuth())
+	{
+		scimRouter.GET("/ServiceProviderConfig", controller.SCIMServiceProviderConfig)
+
+		scimRouter.GET("/Users", controller.ListSCIMUsers)
+		scimRouter.POST("/Users", controller.CreateSCIMUser)
+		scimRouter.GET("/Users/:id", controller.GetSCIMUser)
+		scimRouter.PUT("/Users/:id", controller.ReplaceSCIMUser)
+		scimRouter.PATCH("/Users/:id", controller.PatchSCIMUser)
+		scimRouter.DELETE("/Users/:id", controller.DeleteSCIMUser)
+
+		scimRouter.GET("/Groups", controller.ListSCIMGroups)
+		scimRouter.POST("/Groups", controller.CreateSCIMGroup)
+		scimRouter.GET("/Groups/:id", controller.GetSCIMGroup)
+		scimRouter.PUT("/Groups/:id", controller.ReplaceSCIMGroup)
+		scimRouter.PATCH("/Groups/:id", controller.PatchSCIMGroup)
+		scimRouter.DELETE("/Groups/:id", controller.DeleteSCIMGroup)
+	}
+}
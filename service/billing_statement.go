@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// StatementTax 按发票设置计算充值金额对应的税额与合计
+type StatementTax struct {
+	Subtotal float64 `json:"subtotal"`
+	Tax      float64 `json:"tax"`
+	Total    float64 `json:"total"`
+}
+
+func CalcStatementTax(statement *model.BillingStatement) StatementTax {
+	setting := operation_setting.GetInvoiceSetting()
+	money := statement.TopUpMoney
+	if setting.TaxRate <= 0 {
+		return StatementTax{Subtotal: money, Total: money}
+	}
+	if setting.TaxInclusive {
+		subtotal := money / (1 + setting.TaxRate)
+		return StatementTax{Subtotal: subtotal, Tax: money - subtotal, Total: money}
+	}
+	tax := money * setting.TaxRate
+	return StatementTax{Subtotal: money, Tax: tax, Total: money + tax}
+}
+
+func formatStatementTime(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).Format("2006-01-02 15:04:05")
+}
+
+// RenderStatementCSV 以 CSV 输出账单，依次为汇总、充值明细与按模型消费明细
+func RenderStatementCSV(statement *model.BillingStatement) ([]byte, error) {
+	detail := statement.GetDetail()
+	tax := CalcStatementTax(statement)
+	buf := &bytes.Buffer{}
+	// 写入 BOM，避免 Excel 打开中文乱码
+	buf.WriteString("\xEF\xBB\xBF")
+	w := csv.NewWriter(buf)
+	rows := [][]string{
+		{"section", "key", "value"},
+		{"summary", "invoice_number", statement.InvoiceNumber()},
+		{"summary", "period", statement.Period},
+		{"summary", "user_id", strconv.Itoa(statement.UserId)},
+		{"summary", "username", statement.Username},
+		{"summary", "top_up_count", strconv.Itoa(statement.TopUpCount)},
+		{"summary", "top_up_money", strconv.FormatFloat(statement.TopUpMoney, 'f', 2, 64)},
+		{"summary", "tax", strconv.FormatFloat(tax.Tax, 'f', 2, 64)},
+		{"summary", "total", strconv.FormatFloat(tax.Total, 'f', 2, 64)},
+		{"summary", "redemption_count", strconv.Itoa(statement.RedemptionCount)},
+		{"summary", "redemption_quota", logger.FormatQuota(int(statement.RedemptionQuota))},
+		{"summary", "refund_quota", logger.FormatQuota(int(statement.RefundQuota))},
+		{"summary", "consume_count", strconv.FormatInt(statement.ConsumeCount, 10)},
+		{"summary", "consume_quota", logger.FormatQuota(int(statement.ConsumeQuota))},
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	_ = w.Write([]string{})
+	_ = w.Write([]string{"top_up_trade_no", "payment_method", "amount", "money", "complete_time"})
+	for _, topUp := range detail.TopUps {
+		_ = w.Write([]string{
+			topUp.TradeNo,
+			topUp.PaymentMethod,
+			strconv.FormatInt(topUp.Amount, 10),
+			strconv.FormatFloat(topUp.Money, 'f', 2, 64),
+			formatStatementTime(topUp.CompleteTime),
+		})
+	}
+	_ = w.Write([]string{})
+	_ = w.Write([]string{"model_name", "requests", "prompt_tokens", "completion_tokens", "total_tokens", "quota", "amount"})
+	for _, usage := range detail.Models {
+		_ = w.Write([]string{
+			usage.ModelName,
+			strconv.FormatInt(usage.Count, 10),
+			strconv.FormatInt(usage.PromptTokens, 10),
+			strconv.FormatInt(usage.CompletionTokens, 10),
+			strconv.FormatInt(usage.TotalTokens, 10),
+			strconv.FormatInt(usage.Quota, 10),
+			logger.FormatQuota(int(usage.Quota)),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var statementInvoiceTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"quota": func(q int64) string { return logger.FormatQuota(int(q)) },
+	"money": func(m float64) string { return strconv.FormatFloat(m, 'f', 2, 64) },
+	"time":  formatStatementTime,
+	"pct":   func(r float64) string { return strconv.FormatFloat(r*100, 'f', -1, 64) + "%" },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.InvoiceNumber}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "PingFang SC", sans-serif; color: #222; margin: 40px; }
+h1 { font-size: 24px; margin-bottom: 4px; }
+table { width: 100%; border-collapse: collapse; margin: 16px 0; }
+th, td { border-bottom: 1px solid #ddd; padding: 6px 8px; text-align: left; font-size: 13px; }
+th { background: #f5f5f5; }
+td.num, th.num { text-align: right; }
+.header { display: flex; justify-content: space-between; }
+.muted { color: #666; font-size: 12px; }
+.footer { margin-top: 32px; color: #666; font-size: 12px; }
+@media print { body { margin: 0; } .no-print { display: none; } }
+</style>
+</head>
+<body>
+<div class="no-print" style="text-align:right"><button onclick="window.print()">Print / PDF</button></div>
+<div class="header">
+  <div>
+    <h1>{{if .Setting.CompanyName}}{{.Setting.CompanyName}}{{else}}{{.SystemName}}{{end}}</h1>
+    {{if .Setting.CompanyAddress}}<div class="muted">{{.Setting.CompanyAddress}}</div>{{end}}
+    {{if .Setting.CompanyEmail}}<div class="muted">{{.Setting.CompanyEmail}}</div>{{end}}
+    {{if .Setting.CompanyTaxId}}<div class="muted">Tax ID: {{.Setting.CompanyTaxId}}</div>{{end}}
+  </div>
+  <div style="text-align:right">
+    <h1>Invoice</h1>
+    <div class="muted">No. {{.InvoiceNumber}}</div>
+    <div class="muted">Period: {{.Statement.Period}}</div>
+    <div class="muted">Issued: {{time .Statement.UpdatedTime}}</div>
+  </div>
+</div>
+<p>Bill to: <b>{{.Statement.Username}}</b> (ID {{.Statement.UserId}})</p>
+
+<h3>Payments</h3>
+<table>
+<tr><th>Trade No.</th><th>Method</th><th>Completed</th><th class="num">Amount</th><th class="num">Paid</th></tr>
+{{range .Detail.TopUps}}<tr><td>{{.TradeNo}}</td><td>{{.PaymentMethod}}</td><td>{{time .CompleteTime}}</td><td class="num">{{.Amount}}</td><td class="num">{{money .Money}}</td></tr>
+{{else}}<tr><td colspan="5" class="muted">No payments in this period</td></tr>{{end}}
+<tr><td colspan="4" class="num">Subtotal</td><td class="num">{{money .Tax.Subtotal}}</td></tr>
+{{if .Setting.TaxRate}}<tr><td colspan="4" class="num">{{.Setting.TaxName}} ({{pct .Setting.TaxRate}}{{if .Setting.TaxInclusive}}, included{{end}})</td><td class="num">{{money .Tax.Tax}}</td></tr>{{end}}
+<tr><th colspan="4" class="num">Total</th><th class="num">{{money .Tax.Total}}</th></tr>
+</table>
+
+<h3>Credits</h3>
+<table>
+<tr><th>Item</th><th class="num">Count</th><th class="num">Amount</th></tr>
+<tr><td>Redemption codes</td><td class="num">{{.Statement.RedemptionCount}}</td><td class="num">{{quota .Statement.RedemptionQuota}}</td></tr>
+<tr><td>Refunds</td><td class="num">{{.Statement.RefundCount}}</td><td class="num">{{quota .Statement.RefundQuota}}</td></tr>
+</table>
+
+<h3>Usage</h3>
+<table>
+<tr><th>Model</th><th class="num">Requests</th><th class="num">Prompt tokens</th><th class="num">Completion tokens</th><th class="num">Amount</th></tr>
+{{range .Detail.Models}}<tr><td>{{.ModelName}}</td><td class="num">{{.Count}}</td><td class="num">{{.PromptTokens}}</td><td class="num">{{.CompletionTokens}}</td><td class="num">{{quota .Quota}}</td></tr>
+{{else}}<tr><td colspan="5" class="muted">No usage in this period</td></tr>{{end}}
+<tr><th colspan="4" class="num">Total usage</th><th class="num">{{quota .Statement.ConsumeQuota}}</th></tr>
+</table>
+
+{{if .Setting.FooterNote}}<div class="footer">{{.Setting.FooterNote}}</div>{{end}}
+</body>
+</html>
+`))
+
+// RenderStatementInvoiceHTML 渲染可打印的 HTML 发票，浏览器打印即可导出 PDF
+func RenderStatementInvoiceHTML(statement *model.BillingStatement) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := statementInvoiceTemplate.Execute(buf, map[string]any{
+		"Statement":     statement,
+		"Detail":        statement.GetDetail(),
+		"InvoiceNumber": statement.InvoiceNumber(),
+		"Setting":       operation_setting.GetInvoiceSetting(),
+		"SystemName":    common.SystemName,
+		"Tax":           CalcStatementTax(statement),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render invoice: %w", err)
+	}
+	return buf.Bytes(), nil
+}
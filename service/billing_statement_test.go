@@ -0,0 +1,151 @@
+package service_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// setupInvoiceSetting 使用 6% 外加税率，测试结束后恢复发票设置
+func setupInvoiceSetting(t *testing.T) {
+	t.Helper()
+	setting := operation_setting.GetInvoiceSetting()
+	saved := *setting
+	t.Cleanup(func() { *setting = saved })
+	setting.CompanyName = "Acme <Cloud>"
+	setting.TaxName = "VAT"
+	setting.TaxRate = 0.06
+	setting.TaxInclusive = false
+	setting.InvoicePrefix = "INV"
+}
+
+func newTestStatement() *model.BillingStatement {
+	statement := &model.BillingStatement{
+		UserId:          42,
+		Username:        "alice",
+		Period:          "2025-03",
+		TopUpCount:      1,
+		TopUpAmount:     10,
+		TopUpMoney:      100,
+		RedemptionCount: 1,
+		RedemptionQuota: 500000,
+		ConsumeCount:    3,
+		ConsumeQuota:    250000,
+	}
+	statement.SetDetail(model.BillingStatementDetail{
+		TopUps: []model.StatementTopUp{{TradeNo: "T-001", PaymentMethod: "stripe", Amount: 10, Money: 100}},
+		Models: []model.StatementModelUsage{{ModelName: "gpt-4o", Count: 3, Quota: 250000,
+			PromptTokens: 1000, CompletionTokens: 200, TotalTokens: 1200}},
+		Source: "logs",
+	})
+	return statement
+}
+
+func TestCalcStatementTax(t *testing.T) {
+	setupInvoiceSetting(t)
+	statement := newTestStatement()
+
+	if tax := service.CalcStatementTax(statement); tax.Subtotal != 100 || tax.Tax != 6 || tax.Total != 106 {
+		t.Fatalf("exclusive tax = %+v, want 100 + 6 = 106", tax)
+	}
+	operation_setting.GetInvoiceSetting().TaxInclusive = true
+	tax := service.CalcStatementTax(statement)
+	if tax.Total != 100 || tax.Subtotal+tax.Tax != 100 || tax.Tax < 5.66 || tax.Tax > 5.67 {
+		t.Fatalf("inclusive tax = %+v, want 100 including 5.66", tax)
+	}
+	operation_setting.GetInvoiceSetting().TaxRate = 0
+	if tax := service.CalcStatementTax(statement); tax.Tax != 0 || tax.Total != 100 {
+		t.Fatalf("untaxed = %+v, want 100 without tax", tax)
+	}
+}
+
+func TestRenderStatementCSV(t *testing.T) {
+	setupInvoiceSetting(t)
+	data, err := service.RenderStatementCSV(newTestStatement())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("\xEF\xBB\xBF")) {
+		t.Fatal("csv should start with a UTF-8 BOM")
+	}
+	reader := csv.NewReader(bytes.NewReader(data[3:]))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := make(map[string]string)
+	var topUpRow, modelRow []string
+	for i, row := range rows {
+		if len(row) == 3 && row[0] == "summary" {
+			summary[row[1]] = row[2]
+		}
+		if row[0] == "top_up_trade_no" && i+1 < len(rows) {
+			topUpRow = rows[i+1]
+		}
+		if row[0] == "model_name" && i+1 < len(rows) {
+			modelRow = rows[i+1]
+		}
+	}
+	want := map[string]string{
+		"invoice_number": "INV-202503-000042",
+		"period":         "2025-03",
+		"username":       "alice",
+		"top_up_money":   "100.00",
+		"tax":            "6.00",
+		"total":          "106.00",
+		"consume_count":  "3",
+	}
+	for key, value := range want {
+		if summary[key] != value {
+			t.Errorf("summary %s = %q, want %q", key, summary[key], value)
+		}
+	}
+	if len(topUpRow) != 5 || topUpRow[0] != "T-001" || topUpRow[1] != "stripe" || topUpRow[3] != "100.00" {
+		t.Errorf("top up row = %v", topUpRow)
+	}
+	if len(modelRow) != 7 || modelRow[0] != "gpt-4o" || modelRow[4] != "1200" || modelRow[5] != "250000" {
+		t.Errorf("model row = %v", modelRow)
+	}
+}
+
+func TestRenderStatementInvoiceHTML(t *testing.T) {
+	setupInvoiceSetting(t)
+	data, err := service.RenderStatementInvoiceHTML(newTestStatement())
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(data)
+	for _, want := range []string{
+		"<title>INV-202503-000042</title>",
+		"Acme &lt;Cloud&gt;",
+		"Period: 2025-03",
+		"<td>T-001</td>",
+		"<td>gpt-4o</td>",
+		"VAT (6%)",
+		`<th class="num">106.00</th>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("invoice html missing %q", want)
+		}
+	}
+
+	// 无充值与消费时显示空状态，含税时不重复加税
+	operation_setting.GetInvoiceSetting().TaxInclusive = true
+	empty := &model.BillingStatement{UserId: 42, Username: "alice", Period: "2025-03"}
+	data, err = service.RenderStatementInvoiceHTML(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html = string(data)
+	for _, want := range []string{"No payments in this period", "No usage in this period", "VAT (6%, included)"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("empty invoice html missing %q", want)
+		}
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/relay/channel/openrouter"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/tidwall/gjson"
 )
 
 func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
@@ -18,6 +20,7 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 		MaxTokens:   claudeRequest.MaxTokens,
 		Temperature: claudeRequest.Temperature,
 		TopP:        claudeRequest.TopP,
+		TopK:        claudeRequest.TopK,
 		Stream:      claudeRequest.Stream,
 	}
 
@@ -33,6 +36,8 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 				return nil, fmt.Errorf("failed to marshal reasoning: %w", err)
 			}
 			openAIRequest.Reasoning = reasoningJSON
+		} else if isOpenAIReasoningModel(info.UpstreamModelName) {
+			openAIRequest.ReasoningEffort = thinkingBudgetToReasoningEffort(claudeRequest.Thinking.GetBudgetTokens())
 		} else {
 			thinkingSuffix := "-thinking"
 			if strings.HasSuffix(info.OriginModelName, thinkingSuffix) &&
@@ -49,22 +54,71 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 		openAIRequest.Stop = claudeRequest.StopSequences
 	}
 
+	if len(claudeRequest.Metadata) > 0 {
+		openAIRequest.User = gjson.GetBytes(claudeRequest.Metadata, "user_id").String()
+	}
+
 	// Convert tools
-	tools, _ := common.Any2Type[[]dto.Tool](claudeRequest.Tools)
+	tools, _ := common.Any2Type[[]map[string]any](claudeRequest.Tools)
 	openAITools := make([]dto.ToolCallRequest, 0)
 	for _, claudeTool := range tools {
+		toolType, _ := claudeTool["type"].(string)
+		if strings.HasPrefix(toolType, "web_search") {
+			// 服务端联网搜索工具转换为 web_search_options
+			webSearchTool, _ := common.Any2Type[dto.ClaudeWebSearchTool](claudeTool)
+			openAIRequest.WebSearchOptions = &dto.WebSearchOptions{}
+			if webSearchTool.UserLocation != nil {
+				openAIRequest.WebSearchOptions.UserLocation, _ = common.Marshal(map[string]any{
+					"type": "approximate",
+					"approximate": map[string]string{
+						"country":  webSearchTool.UserLocation.Country,
+						"region":   webSearchTool.UserLocation.Region,
+						"city":     webSearchTool.UserLocation.City,
+						"timezone": webSearchTool.UserLocation.Timezone,
+					},
+				})
+			}
+			continue
+		}
+		inputSchema, ok := claudeTool["input_schema"].(map[string]any)
+		if !ok {
+			// bash、text_editor、code_execution 等 Anthropic 内置工具没有 schema，其他模型无法执行
+			continue
+		}
+		name, _ := claudeTool["name"].(string)
+		description, _ := claudeTool["description"].(string)
 		openAITool := dto.ToolCallRequest{
 			Type: "function",
 			Function: dto.FunctionRequest{
-				Name:        claudeTool.Name,
-				Description: claudeTool.Description,
-				Parameters:  claudeTool.InputSchema,
+				Name:        name,
+				Description: description,
+				Parameters:  inputSchema,
 			},
 		}
 		openAITools = append(openAITools, openAITool)
 	}
 	openAIRequest.Tools = openAITools
 
+	if claudeRequest.ToolChoice != nil {
+		toolChoice, err := common.Any2Type[dto.ClaudeToolChoice](claudeRequest.ToolChoice)
+		if err == nil {
+			switch toolChoice.Type {
+			case "auto", "none":
+				openAIRequest.ToolChoice = toolChoice.Type
+			case "any":
+				openAIRequest.ToolChoice = "required"
+			case "tool":
+				openAIRequest.ToolChoice = map[string]any{
+					"type":     "function",
+					"function": map[string]any{"name": toolChoice.Name},
+				}
+			}
+			if toolChoice.DisableParallelToolUse && len(openAITools) > 0 {
+				openAIRequest.ParallelTooCalls = common.GetPointer(false)
+			}
+		}
+	}
+
 	// Convert messages
 	openAIMessages := make([]dto.Message, 0)
 
@@ -134,14 +188,15 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 					}
 					mediaMessages = append(mediaMessages, message)
 				case "image":
-					// Handle image conversion (base64 to URL or keep as is)
-					imageData := fmt.Sprintf("data:%s;base64,%s", mediaMsg.Source.MediaType, mediaMsg.Source.Data)
-					//textContent += fmt.Sprintf("[Image: %s]", imageData)
-					mediaMessage := dto.MediaContent{
-						Type:     "image_url",
-						ImageUrl: &dto.MessageImageUrl{Url: imageData},
+					if mediaContent := claudeImageToMediaContent(mediaMsg.Source); mediaContent != nil {
+						mediaMessages = append(mediaMessages, *mediaContent)
 					}
-					mediaMessages = append(mediaMessages, mediaMessage)
+				case "document":
+					if mediaContent := claudeDocumentToMediaContent(mediaMsg.Source); mediaContent != nil {
+						mediaMessages = append(mediaMessages, *mediaContent)
+					}
+				case "thinking", "redacted_thinking":
+					// 思考块的签名只有 Anthropic 能校验，转发到其他模型时丢弃
 				case "tool_use":
 					toolCall := dto.ToolCallRequest{
 						ID:   mediaMsg.Id,
@@ -163,13 +218,22 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 						Name:       &toolName,
 						ToolCallId: mediaMsg.ToolUseId,
 					}
-					//oaiToolMessage.SetStringContent(*mediaMsg.GetMediaContent().Text)
 					if mediaMsg.IsStringContent() {
 						oaiToolMessage.SetStringContent(mediaMsg.GetStringContent())
 					} else {
-						mediaContents := mediaMsg.ParseMediaContent()
-						encodeJson, _ := common.Marshal(mediaContents)
-						oaiToolMessage.SetStringContent(string(encodeJson))
+						// tool 消息只支持文本，结果中的图片放到随后的 user 消息中
+						var texts []string
+						for _, resultContent := range mediaMsg.ParseMediaContent() {
+							switch resultContent.Type {
+							case "text":
+								texts = append(texts, resultContent.GetText())
+							case "image":
+								if mediaContent := claudeImageToMediaContent(resultContent.Source); mediaContent != nil {
+									mediaMessages = append(mediaMessages, *mediaContent)
+								}
+							}
+						}
+						oaiToolMessage.SetStringContent(strings.Join(texts, "\n"))
 					}
 					openAIMessages = append(openAIMessages, oaiToolMessage)
 				}
@@ -177,9 +241,17 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 
 			if len(toolCalls) > 0 {
 				openAIMessage.SetToolCalls(toolCalls)
-			}
-
-			if len(mediaMessages) > 0 && len(toolCalls) == 0 {
+				// 工具调用前的文本一并保留
+				var texts []string
+				for _, mediaMessage := range mediaMessages {
+					if mediaMessage.Type == dto.ContentTypeText {
+						texts = append(texts, mediaMessage.Text)
+					}
+				}
+				if len(texts) > 0 {
+					openAIMessage.SetStringContent(strings.Join(texts, "\n"))
+				}
+			} else if len(mediaMessages) > 0 {
 				openAIMessage.SetMediaContent(mediaMessages)
 			}
 		}
@@ -193,6 +265,59 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 	return &openAIRequest, nil
 }
 
+func claudeImageToMediaContent(source *dto.ClaudeMessageSource) *dto.MediaContent {
+	if source == nil {
+		return nil
+	}
+	url := source.Url
+	if source.Type != "url" {
+		url = fmt.Sprintf("data:%s;base64,%s", source.MediaType, common.Interface2String(source.Data))
+	}
+	return &dto.MediaContent{
+		Type:     dto.ContentTypeImageURL,
+		ImageUrl: &dto.MessageImageUrl{Url: url},
+	}
+}
+
+func claudeDocumentToMediaContent(source *dto.ClaudeMessageSource) *dto.MediaContent {
+	if source == nil {
+		return nil
+	}
+	switch source.Type {
+	case "base64":
+		return &dto.MediaContent{
+			Type: dto.ContentTypeFile,
+			File: &dto.MessageFile{
+				FileData: fmt.Sprintf("data:%s;base64,%s", source.MediaType, common.Interface2String(source.Data)),
+			},
+		}
+	case "text":
+		return &dto.MediaContent{
+			Type: dto.ContentTypeText,
+			Text: common.Interface2String(source.Data),
+		}
+	}
+	// OpenAI 的 file 不支持 URL
+	return nil
+}
+
+// isOpenAIReasoningModel 是否为使用 reasoning_effort 控制思考的 OpenAI 模型
+func isOpenAIReasoningModel(model string) bool {
+	return strings.HasPrefix(model, "o1") || strings.HasPrefix(model, "o3") ||
+		strings.HasPrefix(model, "o4") || strings.HasPrefix(model, "gpt-5")
+}
+
+func thinkingBudgetToReasoningEffort(budgetTokens int) string {
+	switch {
+	case budgetTokens < 4096:
+		return "low"
+	case budgetTokens < 16384:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
 func generateStopBlock(index int) *dto.ClaudeResponse {
 	return &dto.ClaudeResponse{
 		Type:  "content_block_stop",
@@ -200,6 +325,30 @@ func generateStopBlock(index int) *dto.ClaudeResponse {
 	}
 }
 
+// startContentBlock 关闭上一个内容块并开始新块
+func startContentBlock(info *relaycommon.RelayInfo, messageType string, block *dto.ClaudeMediaMessage) []*dto.ClaudeResponse {
+	var claudeResponses []*dto.ClaudeResponse
+	if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeNone {
+		claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
+		info.ClaudeConvertInfo.Index++
+	}
+	info.ClaudeConvertInfo.LastMessagesType = messageType
+	claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
+		Index:        common.GetPointer[int](info.ClaudeConvertInfo.Index),
+		Type:         "content_block_start",
+		ContentBlock: block,
+	})
+	return claudeResponses
+}
+
+func generateContentBlockDelta(info *relaycommon.RelayInfo, delta *dto.ClaudeMediaMessage) *dto.ClaudeResponse {
+	return &dto.ClaudeResponse{
+		Index: common.GetPointer[int](info.ClaudeConvertInfo.Index),
+		Type:  "content_block_delta",
+		Delta: delta,
+	}
+}
+
 func StreamResponseOpenAI2Claude(openAIResponse *dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo) []*dto.ClaudeResponse {
 	var claudeResponses []*dto.ClaudeResponse
 	if info.SendResponseCount == 1 {
@@ -218,183 +367,102 @@ func StreamResponseOpenAI2Claude(openAIResponse *dto.ChatCompletionsStreamRespon
 			Type:    "message_start",
 			Message: msg,
 		})
-		claudeResponses = append(claudeResponses)
-		//claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-		//	Type: "ping",
-		//})
-		if openAIResponse.IsToolCall() {
-			info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeTools
-			resp := &dto.ClaudeResponse{
-				Type: "content_block_start",
-				ContentBlock: &dto.ClaudeMediaMessage{
-					Id:    openAIResponse.GetFirstToolCall().ID,
-					Type:  "tool_use",
-					Name:  openAIResponse.GetFirstToolCall().Function.Name,
-					Input: map[string]interface{}{},
-				},
-			}
-			resp.SetIndex(0)
-			claudeResponses = append(claudeResponses, resp)
-		} else {
-
-		}
-		// 判断首个响应是否存在内容（非标准的 OpenAI 响应）
-		if len(openAIResponse.Choices) > 0 && len(openAIResponse.Choices[0].Delta.GetContentString()) > 0 {
-			claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-				Index: &info.ClaudeConvertInfo.Index,
-				Type:  "content_block_start",
-				ContentBlock: &dto.ClaudeMediaMessage{
-					Type: "text",
-					Text: common.GetPointer[string](""),
-				},
-			})
-			claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-				Index: &info.ClaudeConvertInfo.Index,
-				Type:  "content_block_delta",
-				Delta: &dto.ClaudeMediaMessage{
-					Type: "text_delta",
-					Text: common.GetPointer[string](openAIResponse.Choices[0].Delta.GetContentString()),
-				},
-			})
-			info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeText
-		}
-		return claudeResponses
 	}
 
-	if len(openAIResponse.Choices) == 0 {
-		// no choices
-		// 可能为非标准的 OpenAI 响应，判断是否已经完成
-		if info.Done {
-			claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-			oaiUsage := info.ClaudeConvertInfo.Usage
-			if oaiUsage != nil {
-				claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-					Type: "message_delta",
-					Usage: &dto.ClaudeUsage{
-						InputTokens:              oaiUsage.PromptTokens,
-						OutputTokens:             oaiUsage.CompletionTokens,
-						CacheCreationInputTokens: oaiUsage.PromptTokensDetails.CachedCreationTokens,
-						CacheReadInputTokens:     oaiUsage.PromptTokensDetails.CachedTokens,
-					},
-					Delta: &dto.ClaudeMediaMessage{
-						StopReason: common.GetPointer[string](stopReasonOpenAI2Claude(info.FinishReason)),
-					},
-				})
-			}
-			claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-				Type: "message_stop",
-			})
-		}
-		return claudeResponses
-	} else {
+	// 结束原因可能与最后一段内容在同一个响应中，先处理内容
+	if len(openAIResponse.Choices) > 0 {
 		chosenChoice := openAIResponse.Choices[0]
 		if chosenChoice.FinishReason != nil && *chosenChoice.FinishReason != "" {
-			// should be done
 			info.FinishReason = *chosenChoice.FinishReason
-			if !info.Done {
-				return claudeResponses
-			}
 		}
-		if info.Done {
+		claudeResponses = append(claudeResponses, streamDeltaOpenAI2Claude(&chosenChoice.Delta, info)...)
+	}
+
+	if info.Done {
+		if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeNone {
 			claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-			oaiUsage := info.ClaudeConvertInfo.Usage
-			if oaiUsage != nil {
-				claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-					Type: "message_delta",
-					Usage: &dto.ClaudeUsage{
-						InputTokens:              oaiUsage.PromptTokens,
-						OutputTokens:             oaiUsage.CompletionTokens,
-						CacheCreationInputTokens: oaiUsage.PromptTokensDetails.CachedCreationTokens,
-						CacheReadInputTokens:     oaiUsage.PromptTokensDetails.CachedTokens,
-					},
-					Delta: &dto.ClaudeMediaMessage{
-						StopReason: common.GetPointer[string](stopReasonOpenAI2Claude(info.FinishReason)),
-					},
-				})
-			}
-			claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-				Type: "message_stop",
-			})
-		} else {
-			var claudeResponse dto.ClaudeResponse
-			var isEmpty bool
-			claudeResponse.Type = "content_block_delta"
-			if len(chosenChoice.Delta.ToolCalls) > 0 {
-				if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeTools {
-					claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-					info.ClaudeConvertInfo.Index++
-					claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-						Index: &info.ClaudeConvertInfo.Index,
-						Type:  "content_block_start",
-						ContentBlock: &dto.ClaudeMediaMessage{
-							Id:    openAIResponse.GetFirstToolCall().ID,
-							Type:  "tool_use",
-							Name:  openAIResponse.GetFirstToolCall().Function.Name,
-							Input: map[string]interface{}{},
-						},
-					})
-				}
-				info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeTools
-				// tools delta
-				claudeResponse.Delta = &dto.ClaudeMediaMessage{
-					Type:        "input_json_delta",
-					PartialJson: &chosenChoice.Delta.ToolCalls[0].Function.Arguments,
-				}
-			} else {
-				reasoning := chosenChoice.Delta.GetReasoningContent()
-				textContent := chosenChoice.Delta.GetContentString()
-				if reasoning != "" || textContent != "" {
-					if reasoning != "" {
-						if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeThinking {
-							//info.ClaudeConvertInfo.Index++
-							claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-								Index: &info.ClaudeConvertInfo.Index,
-								Type:  "content_block_start",
-								ContentBlock: &dto.ClaudeMediaMessage{
-									Type:     "thinking",
-									Thinking: common.GetPointer[string](""),
-								},
-							})
-						}
-						info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeThinking
-						// text delta
-						claudeResponse.Delta = &dto.ClaudeMediaMessage{
-							Type:     "thinking_delta",
-							Thinking: &reasoning,
-						}
-					} else {
-						if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeText {
-							if info.LastMessagesType == relaycommon.LastMessageTypeThinking || info.LastMessagesType == relaycommon.LastMessageTypeTools {
-								claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-								info.ClaudeConvertInfo.Index++
-							}
-							claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-								Index: &info.ClaudeConvertInfo.Index,
-								Type:  "content_block_start",
-								ContentBlock: &dto.ClaudeMediaMessage{
-									Type: "text",
-									Text: common.GetPointer[string](""),
-								},
-							})
-						}
-						info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeText
-						// text delta
-						claudeResponse.Delta = &dto.ClaudeMediaMessage{
-							Type: "text_delta",
-							Text: common.GetPointer[string](textContent),
-						}
-					}
-				} else {
-					isEmpty = true
-				}
-			}
-			claudeResponse.Index = &info.ClaudeConvertInfo.Index
-			if !isEmpty {
-				claudeResponses = append(claudeResponses, &claudeResponse)
+		}
+		stopReason := stopReasonOpenAI2Claude(info.FinishReason)
+		if info.ClaudeConvertInfo.LastMessagesType == relaycommon.LastMessageTypeTools && stopReason == "end_turn" {
+			// 部分上游调用工具时仍返回 stop，Claude 客户端依赖 tool_use 执行工具
+			stopReason = "tool_use"
+		}
+		claudeUsage := &dto.ClaudeUsage{}
+		if oaiUsage := info.ClaudeConvertInfo.Usage; oaiUsage != nil {
+			claudeUsage = &dto.ClaudeUsage{
+				InputTokens:              oaiUsage.PromptTokens,
+				OutputTokens:             oaiUsage.CompletionTokens,
+				CacheCreationInputTokens: oaiUsage.PromptTokensDetails.CachedCreationTokens,
+				CacheReadInputTokens:     oaiUsage.PromptTokensDetails.CachedTokens,
 			}
 		}
+		claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
+			Type:  "message_delta",
+			Usage: claudeUsage,
+			Delta: &dto.ClaudeMediaMessage{
+				StopReason: common.GetPointer[string](stopReason),
+			},
+		})
+		claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
+			Type: "message_stop",
+		})
 	}
+	return claudeResponses
+}
 
+// streamDeltaOpenAI2Claude 将一个 delta 转换为内容块事件，顺序为思考、文本、工具调用
+func streamDeltaOpenAI2Claude(delta *dto.ChatCompletionsStreamResponseChoiceDelta, info *relaycommon.RelayInfo) []*dto.ClaudeResponse {
+	var claudeResponses []*dto.ClaudeResponse
+	if reasoning := delta.GetReasoningContent(); reasoning != "" {
+		if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeThinking {
+			claudeResponses = append(claudeResponses, startContentBlock(info, relaycommon.LastMessageTypeThinking, &dto.ClaudeMediaMessage{
+				Type:     "thinking",
+				Thinking: common.GetPointer[string](""),
+			})...)
+		}
+		claudeResponses = append(claudeResponses, generateContentBlockDelta(info, &dto.ClaudeMediaMessage{
+			Type:     "thinking_delta",
+			Thinking: &reasoning,
+		}))
+	}
+	if textContent := delta.GetContentString(); textContent != "" {
+		if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeText {
+			claudeResponses = append(claudeResponses, startContentBlock(info, relaycommon.LastMessageTypeText, &dto.ClaudeMediaMessage{
+				Type: "text",
+				Text: common.GetPointer[string](""),
+			})...)
+		}
+		claudeResponses = append(claudeResponses, generateContentBlockDelta(info, &dto.ClaudeMediaMessage{
+			Type: "text_delta",
+			Text: &textContent,
+		}))
+	}
+	for _, toolCall := range delta.ToolCalls {
+		toolCallIndex := 0
+		if toolCall.Index != nil {
+			toolCallIndex = *toolCall.Index
+		}
+		// 序号变化或出现新的 ID 时视为新的工具调用
+		if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeTools ||
+			toolCallIndex != info.ClaudeConvertInfo.ToolCallIndex ||
+			(toolCall.ID != "" && toolCall.ID != info.ClaudeConvertInfo.ToolCallId) {
+			info.ClaudeConvertInfo.ToolCallIndex = toolCallIndex
+			info.ClaudeConvertInfo.ToolCallId = toolCall.ID
+			claudeResponses = append(claudeResponses, startContentBlock(info, relaycommon.LastMessageTypeTools, &dto.ClaudeMediaMessage{
+				Id:    toolCall.ID,
+				Type:  "tool_use",
+				Name:  toolCall.Function.Name,
+				Input: map[string]interface{}{},
+			})...)
+		}
+		if toolCall.Function.Arguments != "" {
+			arguments := toolCall.Function.Arguments
+			claudeResponses = append(claudeResponses, generateContentBlockDelta(info, &dto.ClaudeMediaMessage{
+				Type:        "input_json_delta",
+				PartialJson: &arguments,
+			}))
+		}
+	}
 	return claudeResponses
 }
 
@@ -409,32 +477,47 @@ func ResponseOpenAI2Claude(openAIResponse *dto.OpenAITextResponse, info *relayco
 	}
 	for _, choice := range openAIResponse.Choices {
 		stopReason = stopReasonOpenAI2Claude(choice.FinishReason)
-		if choice.FinishReason == "tool_calls" {
-			for _, toolUse := range choice.Message.ParseToolCalls() {
-				claudeContent := dto.ClaudeMediaMessage{}
-				claudeContent.Type = "tool_use"
-				claudeContent.Id = toolUse.ID
-				claudeContent.Name = toolUse.Function.Name
-				var mapParams map[string]interface{}
-				if err := common.Unmarshal([]byte(toolUse.Function.Arguments), &mapParams); err == nil {
-					claudeContent.Input = mapParams
-				} else {
-					claudeContent.Input = toolUse.Function.Arguments
-				}
-				contents = append(contents, claudeContent)
-			}
-		} else {
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			contents = append(contents, dto.ClaudeMediaMessage{
+				Type:     "thinking",
+				Thinking: common.GetPointer[string](reasoning),
+			})
+		}
+		toolCalls := choice.Message.ParseToolCalls()
+		if text := choice.Message.StringContent(); text != "" || len(toolCalls) == 0 {
 			claudeContent := dto.ClaudeMediaMessage{}
 			claudeContent.Type = "text"
-			claudeContent.SetText(choice.Message.StringContent())
+			claudeContent.SetText(text)
 			contents = append(contents, claudeContent)
 		}
+		for _, toolUse := range toolCalls {
+			claudeContent := dto.ClaudeMediaMessage{}
+			claudeContent.Type = "tool_use"
+			claudeContent.Id = toolUse.ID
+			claudeContent.Name = toolUse.Function.Name
+			var mapParams map[string]interface{}
+			if err := common.Unmarshal([]byte(toolUse.Function.Arguments), &mapParams); err == nil {
+				claudeContent.Input = mapParams
+			} else {
+				claudeContent.Input = toolUse.Function.Arguments
+			}
+			contents = append(contents, claudeContent)
+		}
+		if len(toolCalls) > 0 {
+			stopReason = "tool_use"
+		}
 	}
 	claudeResponse.Content = contents
 	claudeResponse.StopReason = stopReason
 	claudeResponse.Usage = &dto.ClaudeUsage{
-		InputTokens:  openAIResponse.PromptTokens,
-		OutputTokens: openAIResponse.CompletionTokens,
+		InputTokens:              openAIResponse.PromptTokens,
+		OutputTokens:             openAIResponse.CompletionTokens,
+		CacheCreationInputTokens: openAIResponse.PromptTokensDetails.CachedCreationTokens,
+		CacheReadInputTokens:     openAIResponse.PromptTokensDetails.CachedTokens,
 	}
 
 	return claudeResponse
@@ -442,7 +525,7 @@ func ResponseOpenAI2Claude(openAIResponse *dto.OpenAITextResponse, info *relayco
 
 func stopReasonOpenAI2Claude(reason string) string {
 	switch reason {
-	case "stop":
+	case "", "stop":
 		return "end_turn"
 	case "stop_sequence":
 		return "stop_sequence"
@@ -452,6 +535,8 @@ func stopReasonOpenAI2Claude(reason string) string {
 		return "max_tokens"
 	case "tool_calls":
 		return "tool_use"
+	case "content_filter":
+		return "refusal"
 	default:
 		return reason
 	}
@@ -471,6 +556,10 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 		Stream: info.IsStream,
 	}
 
+	// Gemini 的 functionCall 可能没有 id，按函数名依次与 functionResponse 配对
+	pendingCallIds := make(map[string][]string)
+	callCount := 0
+
 	// 转换 messages
 	var messages []dto.Message
 	for _, content := range geminiRequest.Contents {
@@ -482,6 +571,10 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 		var mediaContents []dto.MediaContent
 		var toolCalls []dto.ToolCallRequest
 		for _, part := range content.Parts {
+			if part.Thought {
+				// 历史中的思考内容不回传给上游
+				continue
+			}
 			if part.Text != "" {
 				mediaContent := dto.MediaContent{
 					Type: "text",
@@ -489,15 +582,7 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 				}
 				mediaContents = append(mediaContents, mediaContent)
 			} else if part.InlineData != nil {
-				mediaContent := dto.MediaContent{
-					Type: "image_url",
-					ImageUrl: &dto.MessageImageUrl{
-						Url:      fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data),
-						Detail:   "auto",
-						MimeType: part.InlineData.MimeType,
-					},
-				}
-				mediaContents = append(mediaContents, mediaContent)
+				mediaContents = append(mediaContents, geminiInlineDataToMediaContent(part.InlineData))
 			} else if part.FileData != nil {
 				mediaContent := dto.MediaContent{
 					Type: "image_url",
@@ -510,20 +595,40 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 				mediaContents = append(mediaContents, mediaContent)
 			} else if part.FunctionCall != nil {
 				// 处理 Gemini 的工具调用
+				callCount++
+				callId := part.FunctionCall.Id
+				if callId == "" {
+					callId = fmt.Sprintf("call_%d", callCount)
+				}
+				name := part.FunctionCall.FunctionName
+				pendingCallIds[name] = append(pendingCallIds[name], callId)
 				toolCall := dto.ToolCallRequest{
-					ID:   fmt.Sprintf("call_%d", len(toolCalls)+1), // 生成唯一ID
+					ID:   callId,
 					Type: "function",
 					Function: dto.FunctionRequest{
-						Name:      part.FunctionCall.FunctionName,
+						Name:      name,
 						Arguments: toJSONString(part.FunctionCall.Arguments),
 					},
 				}
 				toolCalls = append(toolCalls, toolCall)
 			} else if part.FunctionResponse != nil {
 				// 处理 Gemini 的工具响应，创建单独的 tool 消息
+				name := part.FunctionResponse.Name
+				callId := part.FunctionResponse.Id
+				if ids := pendingCallIds[name]; len(ids) > 0 {
+					if callId == "" {
+						callId = ids[0]
+					}
+					pendingCallIds[name] = ids[1:]
+				}
+				if callId == "" {
+					callCount++
+					callId = fmt.Sprintf("call_%d", callCount)
+				}
 				toolMessage := dto.Message{
 					Role:       "tool",
-					ToolCallId: fmt.Sprintf("call_%d", len(toolCalls)), // 使用对应的调用ID
+					Name:       &name,
+					ToolCallId: callId,
 				}
 				toolMessage.SetStringContent(toJSONString(part.FunctionResponse.Response))
 				messages = append(messages, toolMessage)
@@ -532,8 +637,11 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 
 		// 设置消息内容
 		if len(toolCalls) > 0 {
-			// 如果有工具调用，设置工具调用
+			// 如果有工具调用，设置工具调用，同时保留文本
 			message.SetToolCalls(toolCalls)
+			if text := extractTextFromGeminiParts(content.Parts); text != "" {
+				message.SetStringContent(text)
+			}
 		} else if len(mediaContents) == 1 && mediaContents[0].Type == "text" {
 			// 如果只有一个文本内容，直接设置字符串
 			message.Content = mediaContents[0].Text
@@ -550,50 +658,108 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 
 	openaiRequest.Messages = messages
 
-	if geminiRequest.GenerationConfig.Temperature != nil {
-		openaiRequest.Temperature = geminiRequest.GenerationConfig.Temperature
+	generationConfig := geminiRequest.GenerationConfig
+	if generationConfig.Temperature != nil {
+		openaiRequest.Temperature = generationConfig.Temperature
 	}
-	if geminiRequest.GenerationConfig.TopP > 0 {
-		openaiRequest.TopP = geminiRequest.GenerationConfig.TopP
+	if generationConfig.TopP > 0 {
+		openaiRequest.TopP = generationConfig.TopP
 	}
-	if geminiRequest.GenerationConfig.TopK > 0 {
-		openaiRequest.TopK = int(geminiRequest.GenerationConfig.TopK)
+	if generationConfig.TopK > 0 {
+		openaiRequest.TopK = int(generationConfig.TopK)
 	}
-	if geminiRequest.GenerationConfig.MaxOutputTokens > 0 {
-		openaiRequest.MaxTokens = geminiRequest.GenerationConfig.MaxOutputTokens
+	if generationConfig.MaxOutputTokens > 0 {
+		openaiRequest.MaxTokens = generationConfig.MaxOutputTokens
 	}
 	// gemini stop sequences 最多 5 个，openai stop 最多 4 个
-	if len(geminiRequest.GenerationConfig.StopSequences) > 0 {
-		openaiRequest.Stop = geminiRequest.GenerationConfig.StopSequences[:4]
+	if len(generationConfig.StopSequences) > 0 {
+		openaiRequest.Stop = generationConfig.StopSequences[:min(len(generationConfig.StopSequences), 4)]
+	}
+	if generationConfig.CandidateCount > 0 {
+		openaiRequest.N = generationConfig.CandidateCount
 	}
-	if geminiRequest.GenerationConfig.CandidateCount > 0 {
-		openaiRequest.N = geminiRequest.GenerationConfig.CandidateCount
+	if generationConfig.PresencePenalty != nil {
+		openaiRequest.PresencePenalty = float64(*generationConfig.PresencePenalty)
+	}
+	if generationConfig.FrequencyPenalty != nil {
+		openaiRequest.FrequencyPenalty = float64(*generationConfig.FrequencyPenalty)
+	}
+	if generationConfig.Seed != 0 {
+		openaiRequest.Seed = float64(generationConfig.Seed)
+	}
+
+	// JSON 模式，responseJsonSchema 为标准 JSON Schema，responseSchema 为 OpenAPI 子集
+	if generationConfig.ResponseMimeType == "application/json" {
+		var schema any
+		if len(generationConfig.ResponseJsonSchema) > 0 {
+			_ = common.Unmarshal(generationConfig.ResponseJsonSchema, &schema)
+		} else if generationConfig.ResponseSchema != nil {
+			schema = normalizeGeminiSchema(generationConfig.ResponseSchema)
+		}
+		if schema != nil {
+			jsonSchema, err := common.Marshal(dto.FormatJsonSchema{
+				Name:   "response",
+				Schema: schema,
+			})
+			if err != nil {
+				return nil, err
+			}
+			openaiRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_schema", JsonSchema: jsonSchema}
+		} else {
+			openaiRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+		}
+	}
+
+	// thinkingConfig 只映射到支持 reasoning_effort 的上游
+	if thinkingConfig := generationConfig.ThinkingConfig; thinkingConfig != nil &&
+		(info.ChannelType == constant.ChannelTypeAnthropic || isOpenAIReasoningModel(info.UpstreamModelName)) {
+		if thinkingConfig.ThinkingBudget == nil || *thinkingConfig.ThinkingBudget < 0 {
+			openaiRequest.ReasoningEffort = "medium"
+		} else if *thinkingConfig.ThinkingBudget > 0 {
+			openaiRequest.ReasoningEffort = thinkingBudgetToReasoningEffort(*thinkingConfig.ThinkingBudget)
+		}
 	}
 
 	// 转换工具调用
 	if len(geminiRequest.GetTools()) > 0 {
 		var tools []dto.ToolCallRequest
 		for _, tool := range geminiRequest.GetTools() {
-			if tool.FunctionDeclarations != nil {
-				// 将 Gemini 的 FunctionDeclarations 转换为 OpenAI 的 ToolCallRequest
-				functionDeclarations, ok := tool.FunctionDeclarations.([]dto.FunctionRequest)
-				if ok {
-					for _, function := range functionDeclarations {
-						openAITool := dto.ToolCallRequest{
-							Type: "function",
-							Function: dto.FunctionRequest{
-								Name:        function.Name,
-								Description: function.Description,
-								Parameters:  function.Parameters,
-							},
-						}
-						tools = append(tools, openAITool)
-					}
+			if tool.GoogleSearch != nil || tool.GoogleSearchRetrieval != nil {
+				openaiRequest.WebSearchOptions = &dto.WebSearchOptions{}
+			}
+			if tool.FunctionDeclarations == nil {
+				continue
+			}
+			// 将 Gemini 的 FunctionDeclarations 转换为 OpenAI 的 ToolCallRequest
+			functionDeclarations, err := common.Any2Type[[]map[string]any](tool.FunctionDeclarations)
+			if err != nil {
+				return nil, fmt.Errorf("invalid function declarations: %w", err)
+			}
+			for _, function := range functionDeclarations {
+				name, _ := function["name"].(string)
+				description, _ := function["description"].(string)
+				parameters := function["parametersJsonSchema"]
+				if parameters == nil && function["parameters"] != nil {
+					parameters = normalizeGeminiSchema(function["parameters"])
 				}
+				if parameters == nil {
+					parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+				}
+				tools = append(tools, dto.ToolCallRequest{
+					Type: "function",
+					Function: dto.FunctionRequest{
+						Name:        name,
+						Description: description,
+						Parameters:  parameters,
+					},
+				})
 			}
 		}
 		if len(tools) > 0 {
 			openaiRequest.Tools = tools
+			if toolConfig := geminiRequest.ToolConfig; toolConfig != nil && toolConfig.FunctionCallingConfig != nil {
+				openaiRequest.ToolChoice = geminiFunctionCallingConfigToToolChoice(toolConfig.FunctionCallingConfig)
+			}
 		}
 	}
 
@@ -610,6 +776,87 @@ func GeminiToOpenAIRequest(geminiRequest *dto.GeminiChatRequest, info *relaycomm
 	return openaiRequest, nil
 }
 
+func geminiInlineDataToMediaContent(inlineData *dto.GeminiInlineData) dto.MediaContent {
+	mimeType := strings.ToLower(inlineData.MimeType)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return dto.MediaContent{
+			Type: dto.ContentTypeImageURL,
+			ImageUrl: &dto.MessageImageUrl{
+				Url:      fmt.Sprintf("data:%s;base64,%s", inlineData.MimeType, inlineData.Data),
+				Detail:   "auto",
+				MimeType: inlineData.MimeType,
+			},
+		}
+	case strings.HasPrefix(mimeType, "audio/"):
+		format := strings.TrimPrefix(mimeType, "audio/")
+		if format == "mpeg" {
+			format = "mp3"
+		}
+		return dto.MediaContent{
+			Type: dto.ContentTypeInputAudio,
+			InputAudio: &dto.MessageInputAudio{
+				Data:   inlineData.Data,
+				Format: format,
+			},
+		}
+	default:
+		return dto.MediaContent{
+			Type: dto.ContentTypeFile,
+			File: &dto.MessageFile{
+				FileData: fmt.Sprintf("data:%s;base64,%s", inlineData.MimeType, inlineData.Data),
+			},
+		}
+	}
+}
+
+// normalizeGeminiSchema 将 Gemini OpenAPI 风格的 schema 转为 JSON Schema，类型名转小写并去掉 Gemini 专有字段
+func normalizeGeminiSchema(schema any) any {
+	switch v := schema.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, value := range v {
+			switch key {
+			case "propertyOrdering":
+				continue
+			case "type":
+				if typeName, ok := value.(string); ok {
+					result[key] = strings.ToLower(typeName)
+					continue
+				}
+			}
+			result[key] = normalizeGeminiSchema(value)
+		}
+		return result
+	case []any:
+		result := make([]any, 0, len(v))
+		for _, item := range v {
+			result = append(result, normalizeGeminiSchema(item))
+		}
+		return result
+	default:
+		return schema
+	}
+}
+
+func geminiFunctionCallingConfigToToolChoice(config *dto.FunctionCallingConfig) any {
+	switch strings.ToUpper(string(config.Mode)) {
+	case "NONE":
+		return "none"
+	case "ANY":
+		if len(config.AllowedFunctionNames) == 1 {
+			return map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": config.AllowedFunctionNames[0]},
+			}
+		}
+		return "required"
+	case "AUTO":
+		return "auto"
+	}
+	return nil
+}
+
 func convertGeminiRoleToOpenAI(geminiRole string) string {
 	switch geminiRole {
 	case "user":
@@ -626,22 +873,59 @@ func convertGeminiRoleToOpenAI(geminiRole string) string {
 func extractTextFromGeminiParts(parts []dto.GeminiPart) string {
 	var texts []string
 	for _, part := range parts {
-		if part.Text != "" {
+		if part.Text != "" && !part.Thought {
 			texts = append(texts, part.Text)
 		}
 	}
 	return strings.Join(texts, "\n")
 }
 
+func finishReasonOpenAI2Gemini(reason string) string {
+	switch reason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+func usageOpenAI2Gemini(usage *dto.Usage) dto.GeminiUsageMetadata {
+	reasoningTokens := usage.CompletionTokenDetails.ReasoningTokens
+	return dto.GeminiUsageMetadata{
+		PromptTokenCount:        usage.PromptTokens,
+		CandidatesTokenCount:    usage.CompletionTokens - reasoningTokens,
+		ThoughtsTokenCount:      reasoningTokens,
+		TotalTokenCount:         usage.PromptTokens + usage.CompletionTokens,
+		CachedContentTokenCount: usage.PromptTokensDetails.CachedTokens,
+	}
+}
+
+func toolCallToGeminiPart(toolCall dto.ToolCallResponse) dto.GeminiPart {
+	// 解析参数
+	var args map[string]interface{}
+	if toolCall.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+			args = map[string]interface{}{"arguments": toolCall.Function.Arguments}
+		}
+	} else {
+		args = make(map[string]interface{})
+	}
+	return dto.GeminiPart{
+		FunctionCall: &dto.FunctionCall{
+			Id:           toolCall.ID,
+			FunctionName: toolCall.Function.Name,
+			Arguments:    args,
+		},
+	}
+}
+
 // ResponseOpenAI2Gemini 将 OpenAI 响应转换为 Gemini 格式
 func ResponseOpenAI2Gemini(openAIResponse *dto.OpenAITextResponse, info *relaycommon.RelayInfo) *dto.GeminiChatResponse {
 	geminiResponse := &dto.GeminiChatResponse{
-		Candidates: make([]dto.GeminiChatCandidate, 0, len(openAIResponse.Choices)),
-		UsageMetadata: dto.GeminiUsageMetadata{
-			PromptTokenCount:     openAIResponse.PromptTokens,
-			CandidatesTokenCount: openAIResponse.CompletionTokens,
-			TotalTokenCount:      openAIResponse.PromptTokens + openAIResponse.CompletionTokens,
-		},
+		Candidates:    make([]dto.GeminiChatCandidate, 0, len(openAIResponse.Choices)),
+		UsageMetadata: usageOpenAI2Gemini(&openAIResponse.Usage),
 	}
 
 	for _, choice := range openAIResponse.Choices {
@@ -651,19 +935,7 @@ func ResponseOpenAI2Gemini(openAIResponse *dto.OpenAITextResponse, info *relayco
 		}
 
 		// 设置结束原因
-		var finishReason string
-		switch choice.FinishReason {
-		case "stop":
-			finishReason = "STOP"
-		case "length":
-			finishReason = "MAX_TOKENS"
-		case "content_filter":
-			finishReason = "SAFETY"
-		case "tool_calls":
-			finishReason = "STOP"
-		default:
-			finishReason = "STOP"
-		}
+		finishReason := finishReasonOpenAI2Gemini(choice.FinishReason)
 		candidate.FinishReason = &finishReason
 
 		// 转换消息内容
@@ -672,37 +944,21 @@ func ResponseOpenAI2Gemini(openAIResponse *dto.OpenAITextResponse, info *relayco
 			Parts: make([]dto.GeminiPart, 0),
 		}
 
-		// 处理工具调用
-		toolCalls := choice.Message.ParseToolCalls()
-		if len(toolCalls) > 0 {
-			for _, toolCall := range toolCalls {
-				// 解析参数
-				var args map[string]interface{}
-				if toolCall.Function.Arguments != "" {
-					if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
-						args = map[string]interface{}{"arguments": toolCall.Function.Arguments}
-					}
-				} else {
-					args = make(map[string]interface{})
-				}
-
-				part := dto.GeminiPart{
-					FunctionCall: &dto.FunctionCall{
-						FunctionName: toolCall.Function.Name,
-						Arguments:    args,
-					},
-				}
-				content.Parts = append(content.Parts, part)
-			}
-		} else {
-			// 处理文本内容
-			textContent := choice.Message.StringContent()
-			if textContent != "" {
-				part := dto.GeminiPart{
-					Text: textContent,
-				}
-				content.Parts = append(content.Parts, part)
-			}
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			content.Parts = append(content.Parts, dto.GeminiPart{Text: reasoning, Thought: true})
+		}
+		if textContent := choice.Message.StringContent(); textContent != "" {
+			content.Parts = append(content.Parts, dto.GeminiPart{Text: textContent})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			content.Parts = append(content.Parts, toolCallToGeminiPart(dto.ToolCallResponse{
+				ID:       toolCall.ID,
+				Function: dto.FunctionResponse{Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments},
+			}))
 		}
 
 		candidate.Content = content
@@ -713,24 +969,8 @@ func ResponseOpenAI2Gemini(openAIResponse *dto.OpenAITextResponse, info *relayco
 }
 
 // StreamResponseOpenAI2Gemini 将 OpenAI 流式响应转换为 Gemini 格式
+// 工具调用的参数分片先累积，在结束时作为完整的 functionCall 输出
 func StreamResponseOpenAI2Gemini(openAIResponse *dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo) *dto.GeminiChatResponse {
-	// 检查是否有实际内容或结束标志
-	hasContent := false
-	hasFinishReason := false
-	for _, choice := range openAIResponse.Choices {
-		if len(choice.Delta.GetContentString()) > 0 || (choice.Delta.ToolCalls != nil && len(choice.Delta.ToolCalls) > 0) {
-			hasContent = true
-		}
-		if choice.FinishReason != nil {
-			hasFinishReason = true
-		}
-	}
-
-	// 如果没有实际内容且没有结束标志，跳过。主要针对 openai 流响应开头的空数据
-	if !hasContent && !hasFinishReason {
-		return nil
-	}
-
 	geminiResponse := &dto.GeminiChatResponse{
 		Candidates: make([]dto.GeminiChatCandidate, 0, len(openAIResponse.Choices)),
 		UsageMetadata: dto.GeminiUsageMetadata{
@@ -740,71 +980,104 @@ func StreamResponseOpenAI2Gemini(openAIResponse *dto.ChatCompletionsStreamRespon
 		},
 	}
 
+	hasContent := false
 	for _, choice := range openAIResponse.Choices {
 		candidate := dto.GeminiChatCandidate{
 			Index:         int64(choice.Index),
 			SafetyRatings: []dto.GeminiChatSafetyRating{},
+			Content: dto.GeminiChatContent{
+				Role:  "model",
+				Parts: make([]dto.GeminiPart, 0),
+			},
 		}
-
-		// 设置结束原因
-		if choice.FinishReason != nil {
-			var finishReason string
-			switch *choice.FinishReason {
-			case "stop":
-				finishReason = "STOP"
-			case "length":
-				finishReason = "MAX_TOKENS"
-			case "content_filter":
-				finishReason = "SAFETY"
-			case "tool_calls":
-				finishReason = "STOP"
-			default:
-				finishReason = "STOP"
-			}
+		if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+			candidate.Content.Parts = append(candidate.Content.Parts, dto.GeminiPart{Text: reasoning, Thought: true})
+		}
+		if textContent := choice.Delta.GetContentString(); textContent != "" {
+			candidate.Content.Parts = append(candidate.Content.Parts, dto.GeminiPart{Text: textContent})
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			appendPendingToolCall(info, toolCall)
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			candidate.Content.Parts = append(candidate.Content.Parts, flushPendingToolCalls(info)...)
+			finishReason := finishReasonOpenAI2Gemini(*choice.FinishReason)
 			candidate.FinishReason = &finishReason
 		}
-
-		// 转换消息内容
-		content := dto.GeminiChatContent{
-			Role:  "model",
-			Parts: make([]dto.GeminiPart, 0),
+		if len(candidate.Content.Parts) > 0 || candidate.FinishReason != nil {
+			hasContent = true
+			geminiResponse.Candidates = append(geminiResponse.Candidates, candidate)
 		}
+	}
 
-		// 处理工具调用
-		if choice.Delta.ToolCalls != nil {
-			for _, toolCall := range choice.Delta.ToolCalls {
-				// 解析参数
-				var args map[string]interface{}
-				if toolCall.Function.Arguments != "" {
-					if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
-						args = map[string]interface{}{"arguments": toolCall.Function.Arguments}
-					}
-				} else {
-					args = make(map[string]interface{})
-				}
+	// 如果没有实际内容且没有结束标志，跳过。主要针对 openai 流响应开头的空数据
+	if !hasContent {
+		return nil
+	}
+	return geminiResponse
+}
 
-				part := dto.GeminiPart{
-					FunctionCall: &dto.FunctionCall{
-						FunctionName: toolCall.Function.Name,
-						Arguments:    args,
-					},
-				}
-				content.Parts = append(content.Parts, part)
-			}
-		} else {
-			// 处理文本内容
-			textContent := choice.Delta.GetContentString()
-			if textContent != "" {
-				part := dto.GeminiPart{
-					Text: textContent,
-				}
-				content.Parts = append(content.Parts, part)
-			}
+// FinalStreamResponseOpenAI2Gemini 处理最后一个流响应，输出未结束的工具调用与完整的 usage
+func FinalStreamResponseOpenAI2Gemini(openAIResponse *dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo, usage *dto.Usage) *dto.GeminiChatResponse {
+	geminiResponse := StreamResponseOpenAI2Gemini(openAIResponse, info)
+	if geminiResponse == nil {
+		geminiResponse = &dto.GeminiChatResponse{
+			Candidates: make([]dto.GeminiChatCandidate, 0),
 		}
+	}
+	if parts := flushPendingToolCalls(info); len(parts) > 0 {
+		finishReason := "STOP"
+		geminiResponse.Candidates = append(geminiResponse.Candidates, dto.GeminiChatCandidate{
+			Content:       dto.GeminiChatContent{Role: "model", Parts: parts},
+			FinishReason:  &finishReason,
+			SafetyRatings: []dto.GeminiChatSafetyRating{},
+		})
+	}
+	if usage != nil {
+		geminiResponse.UsageMetadata = usageOpenAI2Gemini(usage)
+	}
+	return geminiResponse
+}
 
-		candidate.Content = content
-		geminiResponse.Candidates = append(geminiResponse.Candidates, candidate)
+func appendPendingToolCall(info *relaycommon.RelayInfo, toolCall dto.ToolCallResponse) {
+	if info.GeminiConvertInfo == nil {
+		info.GeminiConvertInfo = &relaycommon.GeminiConvertInfo{}
+	}
+	pending := info.GeminiConvertInfo.PendingToolCalls
+	index := len(pending)
+	if toolCall.Index != nil {
+		index = *toolCall.Index
+	} else if toolCall.ID == "" && len(pending) > 0 {
+		index = len(pending) - 1
+	}
+	// 同一序号出现新的 ID 时视为新的调用（部分上游每个调用都从 0 开始编号）
+	if index < len(pending) && toolCall.ID != "" && pending[index].ID != "" && pending[index].ID != toolCall.ID {
+		index = len(pending)
+	}
+	for len(pending) <= index {
+		pending = append(pending, dto.ToolCallResponse{})
 	}
+	if toolCall.ID != "" {
+		pending[index].ID = toolCall.ID
+	}
+	if toolCall.Function.Name != "" {
+		pending[index].Function.Name = toolCall.Function.Name
+	}
+	pending[index].Function.Arguments += toolCall.Function.Arguments
+	info.GeminiConvertInfo.PendingToolCalls = pending
+}
 
-	return geminiResponse
+func flushPendingToolCalls(info *relaycommon.RelayInfo) []dto.GeminiPart {
+	if info.GeminiConvertInfo == nil {
+		return nil
+	}
+	var parts []dto.GeminiPart
+	for _, toolCall := range info.GeminiConvertInfo.PendingToolCalls {
+		if toolCall.Function.Name == "" {
+			continue
+		}
+		parts = append(parts, toolCallToGeminiPart(toolCall))
+	}
+	info.GeminiConvertInfo.PendingToolCalls = nil
+	return parts
 }
@@ -0,0 +1,609 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+// ResponsesToOpenAIRequest 将 Responses API 请求转换为 Chat Completions 请求，用于不支持 Responses API 的渠道
+func ResponsesToOpenAIRequest(responsesRequest *dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
+	if responsesRequest.PreviousResponseID != "" {
+		return nil, errors.New("previous_response_id is not supported by this channel")
+	}
+	openAIRequest := &dto.GeneralOpenAIRequest{
+		Model:     responsesRequest.Model,
+		Stream:    responsesRequest.Stream,
+		MaxTokens: responsesRequest.MaxOutputTokens,
+		// temperature 为 0 时同样需要传递，用指针区分未设置
+		Temperature: responsesRequest.Temperature,
+		TopP:        responsesRequest.TopP,
+		User:        responsesRequest.User,
+	}
+	if responsesRequest.Stream && info.SupportStreamOptions {
+		openAIRequest.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+	}
+	if responsesRequest.Reasoning != nil && responsesRequest.Reasoning.Effort != "" {
+		openAIRequest.ReasoningEffort = responsesRequest.Reasoning.Effort
+	}
+	if len(responsesRequest.ParallelToolCalls) > 0 {
+		var parallel bool
+		if err := common.Unmarshal(responsesRequest.ParallelToolCalls, &parallel); err == nil {
+			openAIRequest.ParallelTooCalls = &parallel
+		}
+	}
+
+	if instructions := responsesInstructions(responsesRequest); instructions != "" {
+		openAIRequest.Messages = append(openAIRequest.Messages, dto.Message{Role: "system", Content: instructions})
+	}
+	messages, err := responsesInputToMessages(responsesRequest.Input)
+	if err != nil {
+		return nil, err
+	}
+	openAIRequest.Messages = append(openAIRequest.Messages, messages...)
+
+	// 仅转换 function 工具，内置工具（web_search、file_search 等）无法在 Chat 渠道上执行
+	for _, tool := range responsesRequest.GetToolsMap() {
+		if common.Interface2String(tool["type"]) != "function" {
+			continue
+		}
+		openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCallRequest{
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:        common.Interface2String(tool["name"]),
+				Description: common.Interface2String(tool["description"]),
+				Parameters:  tool["parameters"],
+			},
+		})
+	}
+	if len(openAIRequest.Tools) > 0 && len(responsesRequest.ToolChoice) > 0 {
+		openAIRequest.ToolChoice = responsesToolChoiceToOpenAI(responsesRequest.ToolChoice)
+	}
+
+	if len(responsesRequest.Text) > 0 {
+		var text struct {
+			Format *struct {
+				Type        string `json:"type"`
+				Name        string `json:"name"`
+				Description string `json:"description"`
+				Schema      any    `json:"schema"`
+				Strict      any    `json:"strict"`
+			} `json:"format"`
+		}
+		if err := common.Unmarshal(responsesRequest.Text, &text); err == nil && text.Format != nil {
+			switch text.Format.Type {
+			case "json_schema":
+				schema := dto.FormatJsonSchema{
+					Name:        text.Format.Name,
+					Description: text.Format.Description,
+					Schema:      text.Format.Schema,
+				}
+				if text.Format.Strict != nil {
+					schema.Strict, _ = common.Marshal(text.Format.Strict)
+				}
+				schemaJSON, err := common.Marshal(schema)
+				if err != nil {
+					return nil, fmt.Errorf("failed to marshal json schema: %w", err)
+				}
+				openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_schema", JsonSchema: schemaJSON}
+			case "json_object":
+				openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+			}
+		}
+	}
+	return openAIRequest, nil
+}
+
+func responsesInstructions(responsesRequest *dto.OpenAIResponsesRequest) string {
+	if len(responsesRequest.Instructions) == 0 {
+		return ""
+	}
+	var instructions string
+	if err := common.Unmarshal(responsesRequest.Instructions, &instructions); err != nil {
+		return ""
+	}
+	return instructions
+}
+
+func responsesInputToMessages(input []byte) ([]dto.Message, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	if common.GetJsonType(input) == "string" {
+		var text string
+		if err := common.Unmarshal(input, &text); err != nil {
+			return nil, err
+		}
+		return []dto.Message{{Role: "user", Content: text}}, nil
+	}
+	var items []map[string]any
+	if err := common.Unmarshal(input, &items); err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+
+	messages := make([]dto.Message, 0, len(items))
+	// 推理摘要附加到下一条 assistant 消息的 reasoning_content
+	pendingReasoning := ""
+	for _, item := range items {
+		itemType := common.Interface2String(item["type"])
+		if itemType == "" && item["role"] != nil {
+			itemType = dto.ResponsesOutputItemMessage
+		}
+		switch itemType {
+		case dto.ResponsesOutputItemMessage:
+			role := common.Interface2String(item["role"])
+			if role == "developer" {
+				role = "system"
+			}
+			message := dto.Message{Role: role}
+			switch content := item["content"].(type) {
+			case string:
+				message.SetStringContent(content)
+			case []any:
+				message.SetMediaContent(responsesContentToMediaContent(content))
+				if text, ok := textOnlyMediaContent(message.ParseContent()); ok {
+					message.SetStringContent(text)
+				}
+			}
+			if role == "assistant" && pendingReasoning != "" {
+				message.ReasoningContent = pendingReasoning
+				pendingReasoning = ""
+			}
+			messages = append(messages, message)
+		case dto.ResponsesOutputItemFunctionCall:
+			toolCall := dto.ToolCallRequest{
+				ID:   common.Interface2String(item["call_id"]),
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      common.Interface2String(item["name"]),
+					Arguments: common.Interface2String(item["arguments"]),
+				},
+			}
+			// 连续的 function_call 合并到同一条 assistant 消息
+			if last := len(messages) - 1; last >= 0 && messages[last].Role == "assistant" {
+				messages[last].SetToolCalls(append(messages[last].ParseToolCalls(), toolCall))
+				continue
+			}
+			message := dto.Message{Role: "assistant"}
+			message.SetToolCalls([]dto.ToolCallRequest{toolCall})
+			if pendingReasoning != "" {
+				message.ReasoningContent = pendingReasoning
+				pendingReasoning = ""
+			}
+			messages = append(messages, message)
+		case "function_call_output":
+			message := dto.Message{
+				Role:       "tool",
+				ToolCallId: common.Interface2String(item["call_id"]),
+			}
+			switch output := item["output"].(type) {
+			case string:
+				message.SetStringContent(output)
+			case []any:
+				text, _ := textOnlyMediaContent(responsesContentToMediaContent(output))
+				message.SetStringContent(text)
+			default:
+				message.SetStringContent(toJSONString(output))
+			}
+			messages = append(messages, message)
+		case dto.ResponsesOutputItemReasoning:
+			summaries, _ := item["summary"].([]any)
+			for _, summary := range summaries {
+				if summaryMap, ok := summary.(map[string]any); ok {
+					pendingReasoning += common.Interface2String(summaryMap["text"])
+				}
+			}
+		}
+	}
+	return messages, nil
+}
+
+func responsesContentToMediaContent(parts []any) []dto.MediaContent {
+	mediaContents := make([]dto.MediaContent, 0, len(parts))
+	for _, part := range parts {
+		partMap, ok := part.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch common.Interface2String(partMap["type"]) {
+		case "input_text", "output_text", "text", "refusal":
+			text := common.Interface2String(partMap["text"])
+			if text == "" {
+				text = common.Interface2String(partMap["refusal"])
+			}
+			mediaContents = append(mediaContents, dto.MediaContent{Type: dto.ContentTypeText, Text: text})
+		case "input_image":
+			var imageUrl string
+			switch v := partMap["image_url"].(type) {
+			case string:
+				imageUrl = v
+			case map[string]any:
+				imageUrl = common.Interface2String(v["url"])
+			}
+			if imageUrl == "" {
+				continue
+			}
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeImageURL,
+				ImageUrl: &dto.MessageImageUrl{
+					Url:    imageUrl,
+					Detail: common.Interface2String(partMap["detail"]),
+				},
+			})
+		case "input_file":
+			file := &dto.MessageFile{
+				FileName: common.Interface2String(partMap["filename"]),
+				FileData: common.Interface2String(partMap["file_data"]),
+				FileId:   common.Interface2String(partMap["file_id"]),
+			}
+			if file.FileData == "" && file.FileId == "" {
+				continue
+			}
+			mediaContents = append(mediaContents, dto.MediaContent{Type: dto.ContentTypeFile, File: file})
+		}
+	}
+	return mediaContents
+}
+
+// textOnlyMediaContent 内容全部为文本时合并为字符串，兼容不支持数组内容的渠道
+func textOnlyMediaContent(contents []dto.MediaContent) (string, bool) {
+	var builder strings.Builder
+	for _, content := range contents {
+		if content.Type != dto.ContentTypeText {
+			return "", false
+		}
+		builder.WriteString(content.Text)
+	}
+	return builder.String(), true
+}
+
+func responsesToolChoiceToOpenAI(toolChoice []byte) any {
+	if common.GetJsonType(toolChoice) == "string" {
+		var choice string
+		_ = common.Unmarshal(toolChoice, &choice)
+		return choice
+	}
+	var choice map[string]any
+	if err := common.Unmarshal(toolChoice, &choice); err != nil {
+		return nil
+	}
+	if common.Interface2String(choice["type"]) == "function" {
+		return map[string]any{
+			"type":     "function",
+			"function": map[string]any{"name": common.Interface2String(choice["name"])},
+		}
+	}
+	return "auto"
+}
+
+// responsesUsage 补充 Responses API 使用的 input_tokens/output_tokens 字段
+func responsesUsage(usage *dto.Usage) *dto.Usage {
+	if usage == nil {
+		return nil
+	}
+	responsesUsage := *usage
+	responsesUsage.InputTokens = usage.PromptTokens
+	responsesUsage.OutputTokens = usage.CompletionTokens
+	if responsesUsage.TotalTokens == 0 {
+		responsesUsage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	responsesUsage.InputTokensDetails = &dto.InputTokenDetails{
+		CachedTokens: usage.PromptTokensDetails.CachedTokens,
+	}
+	return &responsesUsage
+}
+
+func newResponsesResponse(info *relaycommon.RelayInfo, status string) *dto.OpenAIResponsesResponse {
+	response := &dto.OpenAIResponsesResponse{
+		ID:                info.ResponsesConvertInfo.ResponseId,
+		Object:            "response",
+		CreatedAt:         int(info.ResponsesConvertInfo.CreatedAt),
+		Status:            status,
+		Model:             info.UpstreamModelName,
+		Output:            make([]dto.ResponsesOutput, 0),
+		ParallelToolCalls: true,
+		ToolChoice:        "auto",
+		Tools:             make([]map[string]any, 0),
+		Truncation:        "disabled",
+	}
+	if request, ok := info.Request.(*dto.OpenAIResponsesRequest); ok {
+		response.Instructions = responsesInstructions(request)
+		response.MaxOutputTokens = int(request.MaxOutputTokens)
+		if request.Temperature != nil {
+			response.Temperature = *request.Temperature
+		}
+		response.TopP = request.TopP
+		response.Reasoning = request.Reasoning
+		response.Metadata = request.Metadata
+		if tools := request.GetToolsMap(); tools != nil {
+			response.Tools = tools
+		}
+	}
+	return response
+}
+
+func setResponsesFinishStatus(response *dto.OpenAIResponsesResponse, finishReason string) {
+	if finishReason == "length" {
+		response.Status = "incomplete"
+		response.IncompleteDetails = &dto.IncompleteDetails{Reason: "max_output_tokens"}
+		return
+	}
+	response.Status = "completed"
+}
+
+// ResponseOpenAI2Responses 将 Chat Completions 非流式响应转换为 Responses API 响应
+func ResponseOpenAI2Responses(openAIResponse *dto.OpenAITextResponse, info *relaycommon.RelayInfo, usage *dto.Usage) *dto.OpenAIResponsesResponse {
+	response := newResponsesResponse(info, "completed")
+	if openAIResponse.Model != "" {
+		response.Model = openAIResponse.Model
+	}
+	finishReason := ""
+	if len(openAIResponse.Choices) > 0 {
+		choice := openAIResponse.Choices[0]
+		finishReason = choice.FinishReason
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:    dto.ResponsesOutputItemReasoning,
+				ID:      "rs_" + common.GetUUID(),
+				Summary: []dto.ResponsesOutputContent{{Type: "summary_text", Text: reasoning}},
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:    dto.ResponsesOutputItemMessage,
+				ID:      "msg_" + common.GetUUID(),
+				Status:  "completed",
+				Role:    "assistant",
+				Content: []dto.ResponsesOutputContent{{Type: "output_text", Text: text, Annotations: []any{}}},
+			})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:      dto.ResponsesOutputItemFunctionCall,
+				ID:        "fc_" + common.GetUUID(),
+				Status:    "completed",
+				CallId:    toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+	}
+	setResponsesFinishStatus(response, finishReason)
+	if usage == nil {
+		usage = &openAIResponse.Usage
+	}
+	response.Usage = responsesUsage(usage)
+	return response
+}
+
+func newResponsesStreamEvent(info *relaycommon.RelayInfo, eventType string) *dto.ResponsesStreamResponse {
+	event := &dto.ResponsesStreamResponse{
+		Type:           eventType,
+		SequenceNumber: info.ResponsesConvertInfo.SequenceNumber,
+	}
+	info.ResponsesConvertInfo.SequenceNumber++
+	return event
+}
+
+func startResponsesStream(info *relaycommon.RelayInfo) []*dto.ResponsesStreamResponse {
+	state := info.ResponsesConvertInfo
+	if state.Started {
+		return nil
+	}
+	state.Started = true
+	created := newResponsesStreamEvent(info, "response.created")
+	created.Response = newResponsesResponse(info, "in_progress")
+	inProgress := newResponsesStreamEvent(info, "response.in_progress")
+	inProgress.Response = created.Response
+	return []*dto.ResponsesStreamResponse{created, inProgress}
+}
+
+// openResponsesItem 结束当前输出项并开始一个新的输出项
+func openResponsesItem(info *relaycommon.RelayInfo, item dto.ResponsesOutput) []*dto.ResponsesStreamResponse {
+	state := info.ResponsesConvertInfo
+	events := closeResponsesItem(info)
+	state.Output = append(state.Output, item)
+	state.OpenIndex = len(state.Output) - 1
+
+	added := newResponsesStreamEvent(info, dto.ResponsesOutputTypeItemAdded)
+	added.OutputIndex = common.GetPointer(state.OpenIndex)
+	added.Item = copyResponsesOutput(item)
+	events = append(events, added)
+	switch item.Type {
+	case dto.ResponsesOutputItemMessage:
+		partAdded := newResponsesStreamEvent(info, "response.content_part.added")
+		partAdded.ItemId = item.ID
+		partAdded.OutputIndex = common.GetPointer(state.OpenIndex)
+		partAdded.ContentIndex = common.GetPointer(0)
+		partAdded.Part = &dto.ResponsesOutputContent{Type: "output_text", Annotations: []any{}}
+		events = append(events, partAdded)
+	case dto.ResponsesOutputItemReasoning:
+		partAdded := newResponsesStreamEvent(info, "response.reasoning_summary_part.added")
+		partAdded.ItemId = item.ID
+		partAdded.OutputIndex = common.GetPointer(state.OpenIndex)
+		partAdded.SummaryIndex = common.GetPointer(0)
+		partAdded.Part = &dto.ResponsesOutputContent{Type: "summary_text"}
+		events = append(events, partAdded)
+	}
+	return events
+}
+
+func closeResponsesItem(info *relaycommon.RelayInfo) []*dto.ResponsesStreamResponse {
+	state := info.ResponsesConvertInfo
+	if state.OpenIndex < 0 || state.OpenIndex >= len(state.Output) {
+		return nil
+	}
+	index := state.OpenIndex
+	state.OpenIndex = -1
+	item := &state.Output[index]
+	events := make([]*dto.ResponsesStreamResponse, 0, 4)
+	switch item.Type {
+	case dto.ResponsesOutputItemMessage:
+		item.Status = "completed"
+		textDone := newResponsesStreamEvent(info, "response.output_text.done")
+		textDone.ItemId = item.ID
+		textDone.OutputIndex = common.GetPointer(index)
+		textDone.ContentIndex = common.GetPointer(0)
+		textDone.Text = item.Content[0].Text
+		partDone := newResponsesStreamEvent(info, "response.content_part.done")
+		partDone.ItemId = item.ID
+		partDone.OutputIndex = common.GetPointer(index)
+		partDone.ContentIndex = common.GetPointer(0)
+		partDone.Part = common.GetPointer(item.Content[0])
+		events = append(events, textDone, partDone)
+	case dto.ResponsesOutputItemReasoning:
+		textDone := newResponsesStreamEvent(info, "response.reasoning_summary_text.done")
+		textDone.ItemId = item.ID
+		textDone.OutputIndex = common.GetPointer(index)
+		textDone.SummaryIndex = common.GetPointer(0)
+		textDone.Text = item.Summary[0].Text
+		partDone := newResponsesStreamEvent(info, "response.reasoning_summary_part.done")
+		partDone.ItemId = item.ID
+		partDone.OutputIndex = common.GetPointer(index)
+		partDone.SummaryIndex = common.GetPointer(0)
+		partDone.Part = common.GetPointer(item.Summary[0])
+		events = append(events, textDone, partDone)
+	case dto.ResponsesOutputItemFunctionCall:
+		item.Status = "completed"
+		argumentsDone := newResponsesStreamEvent(info, "response.function_call_arguments.done")
+		argumentsDone.ItemId = item.ID
+		argumentsDone.OutputIndex = common.GetPointer(index)
+		argumentsDone.Arguments = item.Arguments
+		events = append(events, argumentsDone)
+	}
+	itemDone := newResponsesStreamEvent(info, dto.ResponsesOutputTypeItemDone)
+	itemDone.OutputIndex = common.GetPointer(index)
+	itemDone.Item = copyResponsesOutput(*item)
+	return append(events, itemDone)
+}
+
+// copyResponsesOutput 复制输出项，避免事件序列化前被后续增量修改
+func copyResponsesOutput(item dto.ResponsesOutput) *dto.ResponsesOutput {
+	item.Content = append([]dto.ResponsesOutputContent(nil), item.Content...)
+	item.Summary = append([]dto.ResponsesOutputContent(nil), item.Summary...)
+	return &item
+}
+
+func currentResponsesItem(info *relaycommon.RelayInfo, itemType string) *dto.ResponsesOutput {
+	state := info.ResponsesConvertInfo
+	if state.OpenIndex < 0 || state.Output[state.OpenIndex].Type != itemType {
+		return nil
+	}
+	return &state.Output[state.OpenIndex]
+}
+
+// StreamResponseOpenAI2Responses 将 Chat Completions 流式响应块转换为 Responses API 流式事件
+func StreamResponseOpenAI2Responses(openAIResponse *dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo) []*dto.ResponsesStreamResponse {
+	state := info.ResponsesConvertInfo
+	events := startResponsesStream(info)
+	if len(openAIResponse.Choices) == 0 {
+		return events
+	}
+	choice := openAIResponse.Choices[0]
+
+	if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+		item := currentResponsesItem(info, dto.ResponsesOutputItemReasoning)
+		if item == nil {
+			events = append(events, openResponsesItem(info, dto.ResponsesOutput{
+				Type:    dto.ResponsesOutputItemReasoning,
+				ID:      "rs_" + common.GetUUID(),
+				Summary: []dto.ResponsesOutputContent{{Type: "summary_text"}},
+			})...)
+			item = currentResponsesItem(info, dto.ResponsesOutputItemReasoning)
+		}
+		item.Summary[0].Text += reasoning
+		delta := newResponsesStreamEvent(info, "response.reasoning_summary_text.delta")
+		delta.ItemId = item.ID
+		delta.OutputIndex = common.GetPointer(state.OpenIndex)
+		delta.SummaryIndex = common.GetPointer(0)
+		delta.Delta = reasoning
+		events = append(events, delta)
+	}
+
+	if content := choice.Delta.GetContentString(); content != "" {
+		item := currentResponsesItem(info, dto.ResponsesOutputItemMessage)
+		if item == nil {
+			events = append(events, openResponsesItem(info, dto.ResponsesOutput{
+				Type:    dto.ResponsesOutputItemMessage,
+				ID:      "msg_" + common.GetUUID(),
+				Status:  "in_progress",
+				Role:    "assistant",
+				Content: []dto.ResponsesOutputContent{{Type: "output_text", Annotations: []any{}}},
+			})...)
+			item = currentResponsesItem(info, dto.ResponsesOutputItemMessage)
+		}
+		item.Content[0].Text += content
+		delta := newResponsesStreamEvent(info, "response.output_text.delta")
+		delta.ItemId = item.ID
+		delta.OutputIndex = common.GetPointer(state.OpenIndex)
+		delta.ContentIndex = common.GetPointer(0)
+		delta.Delta = content
+		events = append(events, delta)
+	}
+
+	for _, toolCall := range choice.Delta.ToolCalls {
+		toolIndex := 0
+		if toolCall.Index != nil {
+			toolIndex = *toolCall.Index
+		}
+		outputIndex, ok := state.ToolCallIndex[toolIndex]
+		// 部分渠道所有工具调用的 index 都为 0，通过 id 区分
+		if !ok || (toolCall.ID != "" && state.Output[outputIndex].CallId != toolCall.ID) {
+			events = append(events, openResponsesItem(info, dto.ResponsesOutput{
+				Type:   dto.ResponsesOutputItemFunctionCall,
+				ID:     "fc_" + common.GetUUID(),
+				Status: "in_progress",
+				CallId: toolCall.ID,
+				Name:   toolCall.Function.Name,
+			})...)
+			outputIndex = state.OpenIndex
+			state.ToolCallIndex[toolIndex] = outputIndex
+		}
+		if toolCall.Function.Arguments == "" {
+			continue
+		}
+		item := &state.Output[outputIndex]
+		item.Arguments += toolCall.Function.Arguments
+		delta := newResponsesStreamEvent(info, "response.function_call_arguments.delta")
+		delta.ItemId = item.ID
+		delta.OutputIndex = common.GetPointer(outputIndex)
+		delta.Delta = toolCall.Function.Arguments
+		events = append(events, delta)
+	}
+
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		state.StopReason = *choice.FinishReason
+	}
+	return events
+}
+
+// FinishResponsesStream 结束所有输出项并生成 response.completed 事件
+func FinishResponsesStream(info *relaycommon.RelayInfo, usage *dto.Usage) []*dto.ResponsesStreamResponse {
+	state := info.ResponsesConvertInfo
+	if state.Completed {
+		return nil
+	}
+	state.Completed = true
+	events := startResponsesStream(info)
+	events = append(events, closeResponsesItem(info)...)
+
+	response := newResponsesResponse(info, "completed")
+	response.Output = append(response.Output, state.Output...)
+	setResponsesFinishStatus(response, state.StopReason)
+	response.Usage = responsesUsage(usage)
+	eventType := "response.completed"
+	if response.Status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	completed := newResponsesStreamEvent(info, eventType)
+	completed.Response = response
+	return append(events, completed)
+}
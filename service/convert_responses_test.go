@@ -0,0 +1,177 @@
+package service_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+)
+
+func newResponsesConvertInfo() *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "gpt-4o"},
+		ResponsesConvertInfo: &relaycommon.ResponsesConvertInfo{
+			ResponseId:    "resp_test",
+			OpenIndex:     -1,
+			ToolCallIndex: make(map[int]int),
+		},
+	}
+}
+
+func TestResponsesInputItemsToChatMessages(t *testing.T) {
+	body := `{"model":"gpt-4o","instructions":"be brief","input":[
+		{"role":"developer","content":"use metric units"},
+		{"type":"message","role":"user","content":[{"type":"input_text","text":"weather in "},{"type":"input_text","text":"Paris?"}]},
+		{"type":"message","role":"user","content":[{"type":"input_text","text":"and this?"},{"type":"input_image","image_url":"https://example.com/a.png","detail":"low"}]},
+		{"type":"reasoning","summary":[{"type":"summary_text","text":"need the tool"}]},
+		{"type":"function_call","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Paris\"}"},
+		{"type":"function_call","call_id":"call_2","name":"get_time","arguments":"{}"},
+		{"type":"function_call_output","call_id":"call_1","output":"18C"},
+		{"type":"function_call_output","call_id":"call_2","output":{"time":"10:00"}},
+		{"type":"message","role":"assistant","content":[{"type":"output_text","text":"18C at 10:00"}]}
+	],"tools":[{"type":"function","name":"get_weather","parameters":{"type":"object"}},{"type":"web_search"}],
+	"tool_choice":{"type":"function","name":"get_weather"}}`
+	var request dto.OpenAIResponsesRequest
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		t.Fatal(err)
+	}
+	openAIRequest, err := service.ResponsesToOpenAIRequest(&request, &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := openAIRequest.Messages
+	roles := make([]string, len(messages))
+	for i, message := range messages {
+		roles[i] = message.Role
+	}
+	if got := strings.Join(roles, ","); got != "system,system,user,user,assistant,tool,tool,assistant" {
+		t.Fatalf("roles = %s", got)
+	}
+	if messages[0].StringContent() != "be brief" || messages[1].StringContent() != "use metric units" {
+		t.Fatalf("system messages = %q, %q", messages[0].StringContent(), messages[1].StringContent())
+	}
+	// 纯文本内容合并为字符串，含图片时保留数组
+	if !messages[2].IsStringContent() || messages[2].StringContent() != "weather in Paris?" {
+		t.Fatalf("text-only user content = %+v", messages[2].Content)
+	}
+	if parts := messages[3].ParseContent(); len(parts) != 2 || parts[1].Type != dto.ContentTypeImageURL ||
+		parts[1].GetImageMedia().Url != "https://example.com/a.png" || parts[1].GetImageMedia().Detail != "low" {
+		t.Fatalf("image user content = %+v", parts)
+	}
+	// 连续的 function_call 合并为一条 assistant 消息，推理摘要附加到该消息
+	toolCalls := messages[4].ParseToolCalls()
+	if len(toolCalls) != 2 || toolCalls[0].ID != "call_1" || toolCalls[0].Function.Arguments != `{"city":"Paris"}` ||
+		toolCalls[1].Function.Name != "get_time" || messages[4].ReasoningContent != "need the tool" {
+		t.Fatalf("assistant tool calls = %+v, reasoning = %q", toolCalls, messages[4].ReasoningContent)
+	}
+	if messages[5].ToolCallId != "call_1" || messages[5].StringContent() != "18C" ||
+		messages[6].ToolCallId != "call_2" || messages[6].StringContent() != `{"time":"10:00"}` {
+		t.Fatalf("tool outputs = %+v, %+v", messages[5], messages[6])
+	}
+	if messages[7].StringContent() != "18C at 10:00" {
+		t.Fatalf("assistant text = %q", messages[7].StringContent())
+	}
+
+	// 内置工具无法在 Chat 渠道执行，只转换 function 工具
+	if len(openAIRequest.Tools) != 1 || openAIRequest.Tools[0].Function.Name != "get_weather" {
+		t.Fatalf("tools = %+v", openAIRequest.Tools)
+	}
+	toolChoice, _ := json.Marshal(openAIRequest.ToolChoice)
+	if string(toolChoice) != `{"function":{"name":"get_weather"},"type":"function"}` {
+		t.Fatalf("tool_choice = %s", toolChoice)
+	}
+}
+
+func TestResponsesToChatRejectsPreviousResponseId(t *testing.T) {
+	request := &dto.OpenAIResponsesRequest{Model: "gpt-4o", PreviousResponseID: "resp_1"}
+	if _, err := service.ResponsesToOpenAIRequest(request, &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}); err == nil {
+		t.Fatal("previous_response_id must be resolved before converting to chat")
+	}
+}
+
+func TestResponseOpenAI2ResponsesToolCalls(t *testing.T) {
+	var openAIResponse dto.OpenAITextResponse
+	body := `{"model":"gpt-4o","choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant",
+		"content":"checking","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]}}],
+		"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`
+	if err := json.Unmarshal([]byte(body), &openAIResponse); err != nil {
+		t.Fatal(err)
+	}
+	response := service.ResponseOpenAI2Responses(&openAIResponse, newResponsesConvertInfo(), nil)
+
+	if response.Status != "completed" || len(response.Output) != 2 {
+		t.Fatalf("response = %+v", response)
+	}
+	if message := response.Output[0]; message.Type != dto.ResponsesOutputItemMessage || message.Content[0].Text != "checking" {
+		t.Fatalf("message output = %+v", message)
+	}
+	if call := response.Output[1]; call.Type != dto.ResponsesOutputItemFunctionCall || call.CallId != "call_1" ||
+		call.Name != "get_weather" || call.Arguments != `{"city":"Paris"}` {
+		t.Fatalf("function_call output = %+v", call)
+	}
+	if response.Usage.InputTokens != 10 || response.Usage.OutputTokens != 5 {
+		t.Fatalf("usage = %+v", response.Usage)
+	}
+}
+
+func TestStreamResponseOpenAI2ResponsesEventSequence(t *testing.T) {
+	info := newResponsesConvertInfo()
+	chunks := []string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\""}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":":\"Paris\"}"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	}
+	var events []*dto.ResponsesStreamResponse
+	for _, chunk := range chunks {
+		var streamResponse dto.ChatCompletionsStreamResponse
+		if err := common.UnmarshalJsonStr(chunk, &streamResponse); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, service.StreamResponseOpenAI2Responses(&streamResponse, info)...)
+	}
+	events = append(events, service.FinishResponsesStream(info, &dto.Usage{PromptTokens: 10, CompletionTokens: 7})...)
+
+	want := []string{
+		"response.created", "response.in_progress",
+		"response.output_item.added", "response.content_part.added", "response.output_text.delta",
+		"response.output_text.delta",
+		"response.output_text.done", "response.content_part.done", "response.output_item.done",
+		"response.output_item.added", "response.function_call_arguments.delta",
+		"response.function_call_arguments.delta",
+		"response.function_call_arguments.done", "response.output_item.done",
+		"response.completed",
+	}
+	eventTypes := make([]string, len(events))
+	for i, event := range events {
+		eventTypes[i] = event.Type
+		if event.SequenceNumber != i {
+			t.Fatalf("event %d (%s) has sequence_number %d", i, event.Type, event.SequenceNumber)
+		}
+	}
+	if strings.Join(eventTypes, ",") != strings.Join(want, ",") {
+		t.Fatalf("events =\n%s\nwant\n%s", strings.Join(eventTypes, "\n"), strings.Join(want, "\n"))
+	}
+
+	if done := events[6]; done.Text != "Hello" || *done.OutputIndex != 0 {
+		t.Fatalf("output_text.done = %+v", done)
+	}
+	if done := events[12]; done.Arguments != `{"city":"Paris"}` || *done.OutputIndex != 1 {
+		t.Fatalf("function_call_arguments.done = %+v", done)
+	}
+	completed := events[len(events)-1].Response
+	if completed.Status != "completed" || len(completed.Output) != 2 ||
+		completed.Output[1].CallId != "call_1" || completed.Output[1].Status != "completed" || completed.Usage.OutputTokens != 7 {
+		t.Fatalf("completed response = %+v", completed)
+	}
+	// 结束后重复调用不再产生事件
+	if extra := service.FinishResponsesStream(info, nil); len(extra) != 0 {
+		t.Fatalf("finish twice produced %d events", len(extra))
+	}
+}
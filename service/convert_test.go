@@ -0,0 +1,230 @@
+package service_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel/gemini"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// go test ./service -run Convert -update 重新生成 testdata/convert 下的 golden 文件
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+func readFixture[T any](t *testing.T, name string) T {
+	t.Helper()
+	var v T
+	data, err := os.ReadFile(filepath.Join("testdata", "convert", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid fixture %s: %v", name, err)
+	}
+	return v
+}
+
+func assertGolden(t *testing.T, name string, got any) {
+	t.Helper()
+	data, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, '\n')
+	path := filepath.Join("testdata", "convert", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("missing golden file %s, run with -update: %v", name, err)
+	}
+	if !bytes.Equal(want, data) {
+		t.Errorf("%s mismatch\n--- want\n%s\n--- got\n%s", name, want, data)
+	}
+}
+
+func newClaudeRelayInfo(upstreamModel string) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		RelayFormat:       types.RelayFormatClaude,
+		OriginModelName:   upstreamModel,
+		PromptTokens:      50,
+		ClaudeConvertInfo: &relaycommon.ClaudeConvertInfo{LastMessagesType: relaycommon.LastMessageTypeNone},
+		ChannelMeta:       &relaycommon.ChannelMeta{UpstreamModelName: upstreamModel, ChannelType: constant.ChannelTypeOpenAI},
+	}
+}
+
+// streamOpenAI2Claude 按 OpenAI 流处理的方式转换：最后一个响应在结束时与 usage 一起处理
+func streamOpenAI2Claude(chunks []dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo) []*dto.ClaudeResponse {
+	var events []*dto.ClaudeResponse
+	for i := range chunks[:len(chunks)-1] {
+		info.SendResponseCount++
+		if chunks[i].Usage != nil {
+			info.ClaudeConvertInfo.Usage = chunks[i].Usage
+		}
+		events = append(events, service.StreamResponseOpenAI2Claude(&chunks[i], info)...)
+	}
+	last := chunks[len(chunks)-1]
+	info.ClaudeConvertInfo.Done = true
+	info.ClaudeConvertInfo.Usage = last.Usage
+	return append(events, service.StreamResponseOpenAI2Claude(&last, info)...)
+}
+
+func TestConvertClaudeToOpenAIRequest(t *testing.T) {
+	request := readFixture[dto.ClaudeRequest](t, "claude_request.json")
+	openAIRequest, err := service.ClaudeToOpenAIRequest(request, newClaudeRelayInfo("gpt-4o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "claude_request.openai.golden.json", openAIRequest)
+}
+
+func TestConvertClaudeToGeminiRequest(t *testing.T) {
+	request := readFixture[dto.ClaudeRequest](t, "claude_request.json")
+	request.Thinking = &dto.Thinking{Type: "enabled", BudgetTokens: common.GetPointer(2048)}
+	constant.GeminiVisionMaxImageNum = 16
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/messages", nil)
+	info := newClaudeRelayInfo("gemini-2.5-flash")
+	info.ChannelMeta.ChannelType = constant.ChannelTypeGemini
+	geminiRequest, err := (&gemini.Adaptor{}).ConvertClaudeRequest(c, info, &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "claude_request.gemini.golden.json", geminiRequest)
+}
+
+func TestConvertOpenAIResponseToClaude(t *testing.T) {
+	response := readFixture[dto.OpenAITextResponse](t, "openai_response_tool_calls.json")
+	assertGolden(t, "openai_response_tool_calls.claude.golden.json", service.ResponseOpenAI2Claude(&response, newClaudeRelayInfo("gpt-4o")))
+}
+
+func TestConvertOpenAIStreamToClaude(t *testing.T) {
+	chunks := readFixture[[]dto.ChatCompletionsStreamResponse](t, "openai_stream_tool_calls.json")
+	events := streamOpenAI2Claude(chunks, newClaudeRelayInfo("gpt-4o"))
+	assertGolden(t, "openai_stream_tool_calls.claude.golden.json", events)
+
+	// 工具参数分片需要以 input_json_delta 依次输出到对应的 tool_use 块
+	var partialJson []string
+	for _, event := range events {
+		if event.Type == "content_block_delta" && event.Delta != nil && event.Delta.Type == "input_json_delta" {
+			partialJson = append(partialJson, *event.Delta.PartialJson)
+		}
+	}
+	want := []string{`{"city":`, `"Paris"}`, `{"city":"Berlin"}`}
+	if len(partialJson) != len(want) {
+		t.Fatalf("input_json_delta = %q, want %q", partialJson, want)
+	}
+	for i := range want {
+		if partialJson[i] != want[i] {
+			t.Fatalf("input_json_delta = %q, want %q", partialJson, want)
+		}
+	}
+}
+
+func newGeminiRelayInfo(upstreamModel string) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		RelayFormat:       types.RelayFormatGemini,
+		OriginModelName:   upstreamModel,
+		PromptTokens:      40,
+		GeminiConvertInfo: &relaycommon.GeminiConvertInfo{},
+		ChannelMeta:       &relaycommon.ChannelMeta{UpstreamModelName: upstreamModel, ChannelType: constant.ChannelTypeOpenAI},
+	}
+}
+
+// streamOpenAI2Gemini 按 OpenAI 流处理的方式转换：没有内容的响应不输出，最后一个响应携带完整的 usage
+func streamOpenAI2Gemini(chunks []dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo) []*dto.GeminiChatResponse {
+	var responses []*dto.GeminiChatResponse
+	for i := range chunks[:len(chunks)-1] {
+		info.SendResponseCount++
+		if response := service.StreamResponseOpenAI2Gemini(&chunks[i], info); response != nil {
+			responses = append(responses, response)
+		}
+	}
+	last := chunks[len(chunks)-1]
+	return append(responses, service.FinalStreamResponseOpenAI2Gemini(&last, info, last.Usage))
+}
+
+func TestConvertGeminiToOpenAIRequest(t *testing.T) {
+	request := readFixture[dto.GeminiChatRequest](t, "gemini_request.json")
+	info := newGeminiRelayInfo("o4-mini")
+	info.IsStream = true
+	openAIRequest, err := service.GeminiToOpenAIRequest(&request, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "gemini_request.openai.golden.json", openAIRequest)
+}
+
+func TestConvertOpenAIResponseToGemini(t *testing.T) {
+	response := readFixture[dto.OpenAITextResponse](t, "openai_response_tool_calls.json")
+	assertGolden(t, "openai_response_tool_calls.gemini.golden.json", service.ResponseOpenAI2Gemini(&response, newGeminiRelayInfo("gpt-4o")))
+}
+
+func TestConvertOpenAIStreamToGemini(t *testing.T) {
+	chunks := readFixture[[]dto.ChatCompletionsStreamResponse](t, "openai_stream_tool_calls.json")
+	assertGolden(t, "openai_stream_tool_calls.gemini.golden.json", streamOpenAI2Gemini(chunks, newGeminiRelayInfo("gpt-4o")))
+}
+
+func TestConvertOpenAIStreamToGeminiFlushesToolCallsAtEnd(t *testing.T) {
+	chunks := readFixture[[]dto.ChatCompletionsStreamResponse](t, "openai_stream_unfinished_tool_call.json")
+	responses := streamOpenAI2Gemini(chunks, newGeminiRelayInfo("gpt-4o"))
+	assertGolden(t, "openai_stream_unfinished_tool_call.gemini.golden.json", responses)
+
+	// 上游没有返回 finish_reason 时，累积的工具调用在最后一个响应中输出，并带上完整的 usageMetadata
+	final := responses[len(responses)-1]
+	if len(final.Candidates) != 1 || len(final.Candidates[0].Content.Parts) != 1 || final.Candidates[0].Content.Parts[0].FunctionCall == nil {
+		t.Fatalf("final response should carry the functionCall: %+v", final)
+	}
+	if final.UsageMetadata.PromptTokenCount != 40 || final.UsageMetadata.CandidatesTokenCount != 12 ||
+		final.UsageMetadata.TotalTokenCount != 52 || final.UsageMetadata.CachedContentTokenCount != 8 {
+		t.Fatalf("unexpected usageMetadata: %+v", final.UsageMetadata)
+	}
+}
+
+func TestConvertResponsesToOpenAIRequestTemperature(t *testing.T) {
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	for body, want := range map[string]string{
+		// 显式的 temperature 0 需要保留
+		`{"model":"gpt-4o","input":"hi","temperature":0}`:   `0`,
+		`{"model":"gpt-4o","input":"hi","temperature":0.7}`: `0.7`,
+		`{"model":"gpt-4o","input":"hi"}`:                   ``,
+	} {
+		var request dto.OpenAIResponsesRequest
+		if err := json.Unmarshal([]byte(body), &request); err != nil {
+			t.Fatal(err)
+		}
+		openAIRequest, err := service.ResponsesToOpenAIRequest(&request, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(openAIRequest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Temperature json.RawMessage `json:"temperature"`
+		}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got.Temperature) != want {
+			t.Errorf("%s: temperature = %q, want %q", body, got.Temperature, want)
+		}
+	}
+}
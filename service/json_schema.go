@@ -0,0 +1,390 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+// JsonSchemaPrompt 生成结构化输出模拟使用的系统提示，schema 为空时只要求输出合法 JSON
+func JsonSchemaPrompt(format *dto.FormatJsonSchema) string {
+	var sb strings.Builder
+	sb.WriteString("You must respond with a single valid JSON value only, without markdown code fences or any explanation.")
+	if format == nil || format.Schema == nil {
+		return sb.String()
+	}
+	schema, err := common.Marshal(format.Schema)
+	if err != nil {
+		return sb.String()
+	}
+	sb.WriteString(" The JSON must strictly conform to the following JSON Schema")
+	if format.Name != "" {
+		sb.WriteString(fmt.Sprintf(" named \"%s\"", format.Name))
+	}
+	if format.Description != "" {
+		sb.WriteString(" (" + format.Description + ")")
+	}
+	sb.WriteString(":\n")
+	sb.Write(schema)
+	return sb.String()
+}
+
+// JsonSchemaRepairPrompt 输出未通过校验时要求模型修正的提示
+func JsonSchemaRepairPrompt(err error) string {
+	return fmt.Sprintf("Your previous response is invalid: %s. Respond again with only the corrected JSON.", err.Error())
+}
+
+// ParseJsonOutput 从模型输出中提取 JSON，兼容 markdown 代码块及前后多余的文字，返回解析结果与规范化后的文本
+func ParseJsonOutput(content string) (any, string, error) {
+	text := strings.TrimSpace(content)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```")
+		if index := strings.IndexByte(text, '\n'); index >= 0 {
+			text = text[index+1:]
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+	}
+	var value any
+	if err := common.UnmarshalJsonStr(text, &value); err == nil {
+		return value, text, nil
+	}
+	start := strings.IndexAny(text, "{[")
+	if start >= 0 {
+		end := strings.LastIndexAny(text, "}]")
+		if end > start {
+			candidate := text[start : end+1]
+			if err := common.UnmarshalJsonStr(candidate, &value); err == nil {
+				return value, candidate, nil
+			}
+		}
+	}
+	if text == "" {
+		return nil, "", fmt.Errorf("response is empty, expected JSON")
+	}
+	return nil, "", fmt.Errorf("response is not valid JSON")
+}
+
+// ValidateJsonSchema 按 JSON Schema 校验数据，支持 type、enum、const、properties、required、
+// additionalProperties、items、anyOf/oneOf/allOf、$ref（#/$defs、#/definitions）及常用的长度和数值约束
+func ValidateJsonSchema(schema any, value any) error {
+	root, ok := schema.(map[string]any)
+	if !ok {
+		return nil
+	}
+	state := &jsonSchemaState{root: root, visited: make(map[string]bool)}
+	return validateJsonSchema(state, root, value, "$")
+}
+
+// maxJsonSchemaDepth 校验的最大嵌套层数，避免客户端传入的 schema 导致栈溢出
+const maxJsonSchemaDepth = 64
+
+type jsonSchemaState struct {
+	root    map[string]any
+	depth   int
+	visited map[string]bool // $ref + 数据路径，同一位置再次解析同一个引用说明引用成环
+}
+
+func validateJsonSchema(state *jsonSchemaState, schema map[string]any, value any, path string) error {
+	state.depth++
+	defer func() { state.depth-- }()
+	if state.depth > maxJsonSchemaDepth {
+		return fmt.Errorf("%s: schema nesting exceeds %d levels", path, maxJsonSchemaDepth)
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		key := ref + " " + path
+		if state.visited[key] {
+			return fmt.Errorf("%s: circular $ref %s", path, ref)
+		}
+		resolved, err := resolveJsonSchemaRef(state.root, ref)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+		state.visited[key] = true
+		defer delete(state.visited, key)
+		return validateJsonSchema(state, resolved, value, path)
+	}
+
+	if types := jsonSchemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if jsonTypeMatches(t, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(value))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, candidate := range enum {
+			if jsonValueEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the allowed enum values", path)
+		}
+	}
+	if constValue, ok := schema["const"]; ok && !jsonValueEqual(constValue, value) {
+		return fmt.Errorf("%s: value does not match const", path)
+	}
+
+	if subSchemas, ok := schema["allOf"].([]any); ok {
+		for _, sub := range subSchemas {
+			if subSchema, ok := sub.(map[string]any); ok {
+				if err := validateJsonSchema(state, subSchema, value, path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, keyword := range []string{"anyOf", "oneOf"} {
+		subSchemas, ok := schema[keyword].([]any)
+		if !ok {
+			continue
+		}
+		matched := 0
+		var firstErr error
+		for _, sub := range subSchemas {
+			subSchema, ok := sub.(map[string]any)
+			if !ok {
+				continue
+			}
+			if err := validateJsonSchema(state, subSchema, value, path); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			matched++
+		}
+		if matched == 0 && firstErr != nil {
+			return fmt.Errorf("%s: value does not match any schema in %s (%s)", path, keyword, firstErr.Error())
+		}
+		if keyword == "oneOf" && matched > 1 {
+			return fmt.Errorf("%s: value matches more than one schema in oneOf", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return validateJsonObject(state, schema, v, path)
+	case []any:
+		return validateJsonArray(state, schema, v, path)
+	case string:
+		length := len([]rune(v))
+		if limit, ok := jsonSchemaNumber(schema["minLength"]); ok && float64(length) < limit {
+			return fmt.Errorf("%s: string is shorter than %v", path, limit)
+		}
+		if limit, ok := jsonSchemaNumber(schema["maxLength"]); ok && float64(length) > limit {
+			return fmt.Errorf("%s: string is longer than %v", path, limit)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				return fmt.Errorf("%s: string does not match pattern %s", path, pattern)
+			}
+		}
+	case float64:
+		if limit, ok := jsonSchemaNumber(schema["minimum"]); ok && v < limit {
+			return fmt.Errorf("%s: must be >= %v", path, limit)
+		}
+		if limit, ok := jsonSchemaNumber(schema["maximum"]); ok && v > limit {
+			return fmt.Errorf("%s: must be <= %v", path, limit)
+		}
+		if limit, ok := jsonSchemaNumber(schema["exclusiveMinimum"]); ok && v <= limit {
+			return fmt.Errorf("%s: must be > %v", path, limit)
+		}
+		if limit, ok := jsonSchemaNumber(schema["exclusiveMaximum"]); ok && v >= limit {
+			return fmt.Errorf("%s: must be < %v", path, limit)
+		}
+		if multiple, ok := jsonSchemaNumber(schema["multipleOf"]); ok && multiple > 0 {
+			quotient := v / multiple
+			if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+				return fmt.Errorf("%s: must be a multiple of %v", path, multiple)
+			}
+		}
+	}
+	return nil
+}
+
+func validateJsonObject(state *jsonSchemaState, schema map[string]any, value map[string]any, path string) error {
+	if required, ok := schema["required"].([]any); ok {
+		for _, item := range required {
+			if key, ok := item.(string); ok {
+				if _, exists := value[key]; !exists {
+					return fmt.Errorf("%s: missing required property \"%s\"", path, key)
+				}
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		propertyPath := path + "." + key
+		if propertySchema, ok := properties[key].(map[string]any); ok {
+			if err := validateJsonSchema(state, propertySchema, value[key], propertyPath); err != nil {
+				return err
+			}
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: additional property \"%s\" is not allowed", path, key)
+			}
+		case map[string]any:
+			if err := validateJsonSchema(state, additional, value[key], propertyPath); err != nil {
+				return err
+			}
+		}
+	}
+	if limit, ok := jsonSchemaNumber(schema["minProperties"]); ok && float64(len(value)) < limit {
+		return fmt.Errorf("%s: must have at least %v properties", path, limit)
+	}
+	if limit, ok := jsonSchemaNumber(schema["maxProperties"]); ok && float64(len(value)) > limit {
+		return fmt.Errorf("%s: must have at most %v properties", path, limit)
+	}
+	return nil
+}
+
+func validateJsonArray(state *jsonSchemaState, schema map[string]any, value []any, path string) error {
+	if limit, ok := jsonSchemaNumber(schema["minItems"]); ok && float64(len(value)) < limit {
+		return fmt.Errorf("%s: must have at least %v items", path, limit)
+	}
+	if limit, ok := jsonSchemaNumber(schema["maxItems"]); ok && float64(len(value)) > limit {
+		return fmt.Errorf("%s: must have at most %v items", path, limit)
+	}
+	if itemSchema, ok := schema["items"].(map[string]any); ok {
+		for i, item := range value {
+			if err := validateJsonSchema(state, itemSchema, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+		for i := range value {
+			for j := i + 1; j < len(value); j++ {
+				if jsonValueEqual(value[i], value[j]) {
+					return fmt.Errorf("%s: items must be unique", path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func resolveJsonSchemaRef(root map[string]any, ref string) (map[string]any, error) {
+	if ref == "#" {
+		return root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %s", ref)
+	}
+	var current any = root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		node, ok := current.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %s", ref)
+		}
+		current = node[part]
+	}
+	resolved, ok := current.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unresolvable $ref %s", ref)
+	}
+	return resolved, nil
+}
+
+func jsonSchemaTypes(t any) []string {
+	switch v := t.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		types := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func jsonTypeMatches(t string, value any) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		v, ok := value.(float64)
+		return ok && v == math.Trunc(v)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func jsonTypeName(value any) string {
+	switch v := value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func jsonSchemaNumber(v any) (float64, bool) {
+	n, ok := v.(float64)
+	return n, ok
+}
+
+func jsonValueEqual(a any, b any) bool {
+	aJson, err := common.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bJson, err := common.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(aJson) == string(bJson)
+}
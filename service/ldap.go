@@ -0,0 +1,173 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var ErrLDAPInvalidCredentials = errors.New("用户名或密码错误")
+
+type LDAPUser struct {
+	DN          string
+	Username    string
+	Email       string
+	DisplayName string
+	Groups      []string
+}
+
+// MappedGroup 按 GroupMapping 返回第一个匹配的用户分组，DN 比较忽略大小写
+func (u *LDAPUser) MappedGroup(mapping map[string]string) string {
+	for _, groupDN := range u.Groups {
+		for mappedDN, group := range mapping {
+			if strings.EqualFold(strings.TrimSpace(mappedDN), strings.TrimSpace(groupDN)) {
+				return group
+			}
+		}
+	}
+	return ""
+}
+
+func dialLDAP(settings *system_setting.LDAPSettings) (*ldap.Conn, error) {
+	parsed, err := url.Parse(settings.Url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url: %w", err)
+	}
+	timeout := time.Duration(settings.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         parsed.Hostname(),
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+	}
+	conn, err := ldap.DialURL(settings.Url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	if settings.StartTLS && parsed.Scheme != "ldaps" {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// AuthenticateLDAP 使用服务账号搜索用户，再以用户 DN 和密码绑定校验身份
+func AuthenticateLDAP(username string, password string) (*LDAPUser, error) {
+	settings := system_setting.GetLDAPSettings()
+	if settings.Url == "" || settings.BaseDN == "" {
+		return nil, errors.New("LDAP 未正确配置")
+	}
+	// 空密码会被部分服务器视为匿名绑定而直接成功，必须拒绝
+	if username == "" || password == "" {
+		return nil, ErrLDAPInvalidCredentials
+	}
+	conn, err := dialLDAP(settings)
+	if err != nil {
+		common.SysLog("failed to connect ldap server: " + err.Error())
+		return nil, errors.New("无法连接至 LDAP 服务器，请稍后重试！")
+	}
+	defer conn.Close()
+
+	if settings.BindDN != "" {
+		err = conn.Bind(settings.BindDN, settings.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		common.SysLog("ldap service account bind failed: " + err.Error())
+		return nil, errors.New("LDAP 服务账号绑定失败，请检查设置！")
+	}
+
+	attributes := []string{"dn", settings.UsernameAttribute, settings.EmailAttribute, settings.DisplayNameAttribute}
+	if settings.GroupAttribute != "" {
+		attributes = append(attributes, settings.GroupAttribute)
+	}
+	filter := strings.ReplaceAll(settings.UserFilter, "%s", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		settings.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter, attributes, nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, errors.New("LDAP 用户过滤条件匹配到多个用户，请检查设置！")
+		}
+		common.SysLog("ldap search failed: " + err.Error())
+		return nil, errors.New("LDAP 搜索用户失败，请检查设置！")
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrLDAPInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err = conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLDAPInvalidCredentials
+		}
+		common.SysLog("ldap user bind failed: " + err.Error())
+		return nil, errors.New("LDAP 用户认证失败，请稍后重试！")
+	}
+
+	ldapUser := &LDAPUser{
+		DN:          entry.DN,
+		Username:    entry.GetAttributeValue(settings.UsernameAttribute),
+		Email:       entry.GetAttributeValue(settings.EmailAttribute),
+		DisplayName: entry.GetAttributeValue(settings.DisplayNameAttribute),
+	}
+	if ldapUser.Username == "" {
+		ldapUser.Username = username
+	}
+	if settings.GroupAttribute != "" {
+		ldapUser.Groups = entry.GetAttributeValues(settings.GroupAttribute)
+	}
+	if settings.GroupSearchFilter != "" {
+		groups, err := searchLDAPGroups(conn, settings, entry.DN)
+		if err != nil {
+			// 组搜索失败不影响登录，仅记录日志
+			common.SysLog("ldap group search failed: " + err.Error())
+		} else {
+			ldapUser.Groups = append(ldapUser.Groups, groups...)
+		}
+	}
+	return ldapUser, nil
+}
+
+func searchLDAPGroups(conn *ldap.Conn, settings *system_setting.LDAPSettings, userDN string) ([]string, error) {
+	// 服务账号重新绑定，用户账号可能没有搜索组的权限
+	var err error
+	if settings.BindDN != "" {
+		err = conn.Bind(settings.BindDN, settings.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return nil, err
+	}
+	filter := strings.ReplaceAll(settings.GroupSearchFilter, "%s", ldap.EscapeFilter(userDN))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		settings.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, []string{"dn"}, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
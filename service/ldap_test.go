@@ -0,0 +1,338 @@
+package service_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testBaseDN      = "dc=example,dc=com"
+	testServiceDN   = "cn=service,dc=example,dc=com"
+	testServicePass = "service-secret"
+	testAliceDN     = "uid=alice,ou=people,dc=example,dc=com"
+	testStaffDN     = "cn=staff,ou=groups,dc=example,dc=com"
+	testAdminsDN    = "cn=admins,ou=groups,dc=example,dc=com"
+)
+
+type fakeLDAPEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// fakeLDAPServer 进程内的最小 LDAP 服务，支持简单绑定与 and/or/等值/存在过滤条件的搜索，
+// 只有以服务账号绑定时才允许搜索
+type fakeLDAPServer struct {
+	listener net.Listener
+	entries  []fakeLDAPEntry
+
+	mu      sync.Mutex
+	binds   []string
+	filters []string
+}
+
+func startFakeLDAPServer(t *testing.T, entries ...fakeLDAPEntry) *fakeLDAPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeLDAPServer{listener: listener, entries: entries}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeLDAPServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *fakeLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	boundDN := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name, _ := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			s.mu.Lock()
+			s.binds = append(s.binds, name)
+			s.mu.Unlock()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if name == "" && password == "" {
+				code, boundDN = ldap.LDAPResultSuccess, ""
+			} else if entry := s.find(name); entry != nil && entry.password == password {
+				code, boundDN = ldap.LDAPResultSuccess, entry.dn
+			}
+			_, _ = conn.Write(ldapResult(messageID, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			s.mu.Lock()
+			s.filters = append(s.filters, filter)
+			s.mu.Unlock()
+			if boundDN != testServiceDN {
+				_, _ = conn.Write(ldapResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights).Bytes())
+				continue
+			}
+			s.search(conn, messageID, op)
+		default:
+			return
+		}
+	}
+}
+
+func (s *fakeLDAPServer) find(dn string) *fakeLDAPEntry {
+	for i := range s.entries {
+		if strings.EqualFold(s.entries[i].dn, dn) {
+			return &s.entries[i]
+		}
+	}
+	return nil
+}
+
+func (s *fakeLDAPServer) search(conn net.Conn, messageID int64, op *ber.Packet) {
+	baseDN, _ := op.Children[0].Value.(string)
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	var attributes []string
+	for _, attribute := range op.Children[7].Children {
+		attributes = append(attributes, attribute.Value.(string))
+	}
+	sent := 0
+	for _, entry := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.dn), strings.ToLower(baseDN)) || !matchLDAPFilter(entry, op.Children[6]) {
+			continue
+		}
+		if sizeLimit > 0 && int64(sent) >= sizeLimit {
+			_, _ = conn.Write(ldapResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded).Bytes())
+			return
+		}
+		_, _ = conn.Write(ldapSearchEntry(messageID, entry, attributes).Bytes())
+		sent++
+	}
+	_, _ = conn.Write(ldapResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+}
+
+func matchLDAPFilter(entry fakeLDAPEntry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchLDAPFilter(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchLDAPFilter(entry, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterEqualityMatch:
+		attribute, _ := filter.Children[0].Value.(string)
+		value, _ := filter.Children[1].Value.(string)
+		for _, v := range entryAttribute(entry, attribute) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(entryAttribute(entry, filter.Data.String())) > 0
+	}
+	return false
+}
+
+func entryAttribute(entry fakeLDAPEntry, name string) []string {
+	for attribute, values := range entry.attributes {
+		if strings.EqualFold(attribute, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func ldapEnvelope(messageID int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	packet.AppendChild(op)
+	return packet
+}
+
+func ldapResult(messageID int64, tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return ldapEnvelope(messageID, op)
+}
+
+func ldapSearchEntry(messageID int64, entry fakeLDAPEntry, attributes []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "objectName"))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for _, name := range attributes {
+		values := entryAttribute(entry, name)
+		if len(values) == 0 {
+			continue
+		}
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		}
+		attribute.AppendChild(set)
+		list.AppendChild(attribute)
+	}
+	op.AppendChild(list)
+	return ldapEnvelope(messageID, op)
+}
+
+func testLDAPEntries() []fakeLDAPEntry {
+	return []fakeLDAPEntry{
+		{dn: testServiceDN, password: testServicePass},
+		{dn: testAliceDN, password: "alice-secret", attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"alice"},
+			"mail":        {"alice@example.com"},
+			"cn":          {"Alice"},
+			"memberOf":    {testStaffDN},
+		}},
+		{dn: "uid=bob,ou=people,dc=example,dc=com", password: "bob-secret", attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"bob"},
+		}},
+		{dn: "uid=carol,ou=people,dc=example,dc=com", password: "carol-secret", attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"carol"},
+		}},
+		{dn: testAdminsDN, attributes: map[string][]string{
+			"objectClass": {"groupOfNames"},
+			"member":      {testAliceDN},
+		}},
+	}
+}
+
+func useLDAPSettings(t *testing.T, server *fakeLDAPServer) *system_setting.LDAPSettings {
+	t.Helper()
+	settings := system_setting.GetLDAPSettings()
+	saved := *settings
+	t.Cleanup(func() {
+		*settings = saved
+	})
+	settings.Url = server.url()
+	settings.BindDN = testServiceDN
+	settings.BindPassword = testServicePass
+	settings.BaseDN = testBaseDN
+	settings.UserFilter = "(&(objectClass=person)(uid=%s))"
+	settings.GroupAttribute = "memberOf"
+	settings.GroupSearchFilter = "(&(objectClass=groupOfNames)(member=%s))"
+	settings.TimeoutSeconds = 2
+	return settings
+}
+
+func TestAuthenticateLDAPWithGroupMapping(t *testing.T) {
+	server := startFakeLDAPServer(t, testLDAPEntries()...)
+	useLDAPSettings(t, server)
+
+	user, err := service.AuthenticateLDAP("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DN != testAliceDN || user.Username != "alice" || user.Email != "alice@example.com" || user.DisplayName != "Alice" {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+	// memberOf 属性与组搜索的结果合并
+	if strings.Join(user.Groups, ";") != testStaffDN+";"+testAdminsDN {
+		t.Fatalf("unexpected groups: %q", user.Groups)
+	}
+	// 服务账号搜索用户，用户绑定校验密码，再以服务账号重新绑定搜索组
+	server.mu.Lock()
+	binds := strings.Join(server.binds, ";")
+	server.mu.Unlock()
+	if binds != testServiceDN+";"+testAliceDN+";"+testServiceDN {
+		t.Fatalf("unexpected binds: %s", binds)
+	}
+
+	// 组 DN 比较忽略大小写，未映射的组跳过
+	if group := user.MappedGroup(map[string]string{"CN=Admins,OU=Groups,DC=example,DC=com": "vip"}); group != "vip" {
+		t.Fatalf("mapped group = %q", group)
+	}
+	if group := user.MappedGroup(map[string]string{"cn=other,ou=groups,dc=example,dc=com": "vip"}); group != "" {
+		t.Fatalf("mapped group = %q", group)
+	}
+}
+
+func TestAuthenticateLDAPRejectsInvalidCredentials(t *testing.T) {
+	server := startFakeLDAPServer(t, testLDAPEntries()...)
+	useLDAPSettings(t, server)
+
+	cases := map[string][2]string{
+		"wrong password": {"alice", "wrong"},
+		"unknown user":   {"nobody", "secret"},
+		"empty password": {"alice", ""},
+		// 登录名中的过滤条件特殊字符被转义，不能匹配到其他用户
+		"filter injection": {"*", "alice-secret"},
+	}
+	for name, credentials := range cases {
+		if _, err := service.AuthenticateLDAP(credentials[0], credentials[1]); !errors.Is(err, service.ErrLDAPInvalidCredentials) {
+			t.Errorf("%s: expected invalid credentials, got %v", name, err)
+		}
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for _, filter := range server.filters {
+		if strings.Contains(filter, "(uid=*)") {
+			t.Fatalf("login name should be escaped in filter %s", filter)
+		}
+	}
+}
+
+func TestAuthenticateLDAPRejectsAmbiguousFilter(t *testing.T) {
+	server := startFakeLDAPServer(t, testLDAPEntries()...)
+	settings := useLDAPSettings(t, server)
+	settings.UserFilter = "(objectClass=person)"
+
+	_, err := service.AuthenticateLDAP("alice", "alice-secret")
+	if err == nil || !strings.Contains(err.Error(), "多个用户") {
+		t.Fatalf("expected ambiguous filter error, got %v", err)
+	}
+}
+
+func TestAuthenticateLDAPServiceAccountBindFailure(t *testing.T) {
+	server := startFakeLDAPServer(t, testLDAPEntries()...)
+	settings := useLDAPSettings(t, server)
+	settings.BindPassword = "wrong"
+
+	_, err := service.AuthenticateLDAP("alice", "alice-secret")
+	if err == nil || errors.Is(err, service.ErrLDAPInvalidCredentials) || !strings.Contains(err.Error(), "服务账号") {
+		t.Fatalf("expected service account bind error, got %v", err)
+	}
+}
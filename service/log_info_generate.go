@@ -42,6 +42,21 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 	other["model_price"] = modelPrice
 	other["user_group_ratio"] = userGroupRatio
 	other["frt"] = float64(relayInfo.FirstResponseTime.UnixMilli() - relayInfo.StartTime.UnixMilli())
+	if common.GetContextKeyBool(ctx, constant.ContextKeySessionAffinityHit) {
+		other["session_affinity"] = true
+	}
+	if relayInfo.PriceData.PriceTier > 0 {
+		other["price_tier"] = relayInfo.PriceData.PriceTier
+	}
+	if relayInfo.PriceData.PriceVersion > 0 {
+		other["price_version"] = relayInfo.PriceData.PriceVersion
+	}
+	if relayInfo.PriceData.PriceOverride != nil {
+		other["price_override"] = relayInfo.PriceData.PriceOverride
+	}
+	if relayInfo.PriceData.PricingWindow != nil {
+		other["pricing_window"] = relayInfo.PriceData.PricingWindow
+	}
 	if relayInfo.ReasoningEffort != "" {
 		other["reasoning_effort"] = relayInfo.ReasoningEffort
 	}
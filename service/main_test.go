@@ -0,0 +1,31 @@
+package service_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+)
+
+// setupTestDB 使用内存 SQLite 初始化数据库并完成迁移，测试结束后关闭
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("SQL_DSN", "")
+	savedPath, savedMaster, savedRedis, savedDB, savedLogDB := common.SQLitePath, common.IsMasterNode, common.RedisEnabled, model.DB, model.LOG_DB
+	common.SQLitePath = fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	common.IsMasterNode = true
+	common.RedisEnabled = false
+	if err := model.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	model.LOG_DB = model.DB
+	t.Cleanup(func() {
+		if sqlDB, err := model.DB.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+		common.SQLitePath, common.IsMasterNode, common.RedisEnabled = savedPath, savedMaster, savedRedis
+		model.DB, model.LOG_DB = savedDB, savedLogDB
+	})
+}
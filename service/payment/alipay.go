@@ -0,0 +1,231 @@
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// AlipayProvider 支付宝直连（电脑网站支付），使用 RSA2 签名
+type AlipayProvider struct{}
+
+func init() {
+	Register(&AlipayProvider{})
+}
+
+var alipayLocation = time.FixedZone("CST", 8*3600)
+
+func (p *AlipayProvider) Name() string {
+	return ProviderAlipayDirect
+}
+
+func (p *AlipayProvider) Enabled() bool {
+	return setting.AlipayAppId != "" && setting.AlipayPrivateKey != "" && setting.AlipayPublicKey != "" && setting.AlipayGateway != ""
+}
+
+// alipayContent 按参数名排序拼接待签名字符串，忽略 sign 与空值
+func alipayContent(values url.Values, excludes ...string) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if key == "sign" || values.Get(key) == "" {
+			continue
+		}
+		skip := false
+		for _, exclude := range excludes {
+			if key == exclude {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+values.Get(key))
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (p *AlipayProvider) signedParams(method string, bizContent map[string]any, notifyUrl string, returnUrl string) (url.Values, error) {
+	biz, err := json.Marshal(bizContent)
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	values.Set("app_id", setting.AlipayAppId)
+	values.Set("method", method)
+	values.Set("format", "JSON")
+	values.Set("charset", "utf-8")
+	values.Set("sign_type", "RSA2")
+	values.Set("timestamp", time.Now().In(alipayLocation).Format("2006-01-02 15:04:05"))
+	values.Set("version", "1.0")
+	values.Set("biz_content", string(biz))
+	if notifyUrl != "" {
+		values.Set("notify_url", notifyUrl)
+	}
+	if returnUrl != "" {
+		values.Set("return_url", returnUrl)
+	}
+	key, err := parsePrivateKey(setting.AlipayPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := signSHA256WithRSA(key, alipayContent(values))
+	if err != nil {
+		return nil, err
+	}
+	values.Set("sign", sign)
+	return values, nil
+}
+
+// call 调用支付宝开放接口并验证应答签名，返回 {method}_response 节点内容
+func (p *AlipayProvider) call(method string, bizContent map[string]any) (map[string]any, error) {
+	values, err := p.signedParams(method, bizContent, "", "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.PostForm(setting.AlipayGateway, values)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("支付宝接口返回异常: %s", string(body))
+	}
+	nodeName := strings.ReplaceAll(method, ".", "_") + "_response"
+	node, ok := raw[nodeName]
+	if !ok {
+		return nil, fmt.Errorf("支付宝接口返回异常: %s", string(body))
+	}
+	var sign string
+	if err := json.Unmarshal(raw["sign"], &sign); err == nil && sign != "" {
+		publicKey, err := parsePublicKey(setting.AlipayPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifySHA256WithRSA(publicKey, string(node), sign); err != nil {
+			return nil, errors.New("支付宝应答签名验证失败")
+		}
+	}
+	var data map[string]any
+	if err := json.Unmarshal(node, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (p *AlipayProvider) CreateOrder(req *CreateOrderRequest) (*CreateOrderResult, error) {
+	values, err := p.signedParams("alipay.trade.page.pay", map[string]any{
+		"out_trade_no": req.TradeNo,
+		"total_amount": strconv.FormatFloat(req.Money, 'f', 2, 64),
+		"subject":      req.Subject,
+		"product_code": "FAST_INSTANT_TRADE_PAY",
+	}, req.NotifyUrl, req.ReturnUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &CreateOrderResult{PayLink: setting.AlipayGateway + "?" + values.Encode()}, nil
+}
+
+func (p *AlipayProvider) VerifyNotify(r *http.Request) (*NotifyResult, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	values := r.PostForm
+	if len(values) == 0 {
+		values = r.Form
+	}
+	publicKey, err := parsePublicKey(setting.AlipayPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySHA256WithRSA(publicKey, alipayContent(values, "sign_type"), values.Get("sign")); err != nil {
+		return nil, errors.New("支付宝回调签名验证失败")
+	}
+	if values.Get("app_id") != setting.AlipayAppId {
+		return nil, errors.New("支付宝回调 app_id 不匹配")
+	}
+	result := &NotifyResult{
+		TradeNo:         values.Get("out_trade_no"),
+		ProviderTradeNo: values.Get("trade_no"),
+		Raw:             values,
+	}
+	result.Money, _ = strconv.ParseFloat(values.Get("total_amount"), 64)
+	result.Status = alipayTradeStatus(values.Get("trade_status"))
+	return result, nil
+}
+
+func alipayTradeStatus(status string) OrderStatus {
+	switch status {
+	case "TRADE_SUCCESS", "TRADE_FINISHED":
+		return OrderStatusPaid
+	case "TRADE_CLOSED":
+		return OrderStatusClosed
+	}
+	return OrderStatusPending
+}
+
+func (p *AlipayProvider) AckNotify(w http.ResponseWriter, err error) {
+	if err != nil {
+		_, _ = w.Write([]byte("fail"))
+		return
+	}
+	_, _ = w.Write([]byte("success"))
+}
+
+func (p *AlipayProvider) QueryOrder(topUp *model.TopUp) (*QueryResult, error) {
+	data, err := p.call("alipay.trade.query", map[string]any{"out_trade_no": topUp.TradeNo})
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprint(data["code"]) != "10000" {
+		// 用户未扫码登录前支付宝侧不存在交易
+		if fmt.Sprint(data["sub_code"]) == "ACQ.TRADE_NOT_EXIST" {
+			return &QueryResult{Status: OrderStatusPending}, nil
+		}
+		return nil, fmt.Errorf("支付宝查询失败: %v", data["sub_msg"])
+	}
+	result := &QueryResult{
+		Status:          alipayTradeStatus(fmt.Sprint(data["trade_status"])),
+		ProviderTradeNo: fmt.Sprint(data["trade_no"]),
+	}
+	result.Money, _ = strconv.ParseFloat(fmt.Sprint(data["total_amount"]), 64)
+	return result, nil
+}
+
+func (p *AlipayProvider) Refund(req *RefundRequest) error {
+	biz := map[string]any{
+		"out_trade_no":   req.TopUp.TradeNo,
+		"refund_amount":  strconv.FormatFloat(req.Money, 'f', 2, 64),
+		"out_request_no": req.RefundNo,
+	}
+	if req.Reason != "" {
+		biz["refund_reason"] = req.Reason
+	}
+	data, err := p.call("alipay.trade.refund", biz)
+	if err != nil {
+		return err
+	}
+	if fmt.Sprint(data["code"]) != "10000" {
+		return fmt.Errorf("支付宝退款失败: %v", data["sub_msg"])
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/Calcium-Ion/go-epay/epay"
+)
+
+type EpayProvider struct{}
+
+func init() {
+	Register(&EpayProvider{})
+}
+
+func (p *EpayProvider) Name() string {
+	return ProviderEpay
+}
+
+func (p *EpayProvider) Enabled() bool {
+	return operation_setting.PayAddress != "" && operation_setting.EpayId != "" && operation_setting.EpayKey != ""
+}
+
+func (p *EpayProvider) client() (*epay.Client, error) {
+	return epay.NewClient(&epay.Config{
+		PartnerID: operation_setting.EpayId,
+		Key:       operation_setting.EpayKey,
+	}, operation_setting.PayAddress)
+}
+
+func (p *EpayProvider) CreateOrder(req *CreateOrderRequest) (*CreateOrderResult, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	notifyUrl, err := url.Parse(req.NotifyUrl)
+	if err != nil {
+		return nil, err
+	}
+	returnUrl, err := url.Parse(req.ReturnUrl)
+	if err != nil {
+		return nil, err
+	}
+	uri, params, err := client.Purchase(&epay.PurchaseArgs{
+		Type:           req.PayMethod,
+		ServiceTradeNo: req.TradeNo,
+		Name:           req.Subject,
+		Money:          strconv.FormatFloat(req.Money, 'f', 2, 64),
+		Device:         epay.PC,
+		NotifyUrl:      notifyUrl,
+		ReturnUrl:      returnUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CreateOrderResult{PayLink: uri, Params: params}, nil
+}
+
+func (p *EpayProvider) VerifyNotify(r *http.Request) (*NotifyResult, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	params := make(map[string]string, len(r.Form))
+	for key := range r.Form {
+		params[key] = r.Form.Get(key)
+	}
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	verifyInfo, err := client.Verify(params)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyInfo.VerifyStatus {
+		return nil, errors.New("易支付回调签名验证失败")
+	}
+	result := &NotifyResult{
+		TradeNo:         verifyInfo.ServiceTradeNo,
+		ProviderTradeNo: verifyInfo.TradeNo,
+		Raw:             verifyInfo,
+	}
+	result.Money, _ = strconv.ParseFloat(verifyInfo.Money, 64)
+	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
+		result.Status = OrderStatusPaid
+	}
+	return result, nil
+}
+
+func (p *EpayProvider) AckNotify(w http.ResponseWriter, err error) {
+	if err != nil {
+		_, _ = w.Write([]byte("fail"))
+		return
+	}
+	_, _ = w.Write([]byte("success"))
+}
+
+// api 调用易支付商户接口（api.php），返回 code 不为 1 时报错
+func (p *EpayProvider) api(method string, act string, params url.Values) (map[string]any, error) {
+	u, err := url.Parse(operation_setting.PayAddress)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api.php"
+	params.Set("act", act)
+	params.Set("pid", operation_setting.EpayId)
+	params.Set("key", operation_setting.EpayKey)
+	var resp *http.Response
+	if method == http.MethodGet {
+		u.RawQuery = params.Encode()
+		resp, err = httpClient.Get(u.String())
+	} else {
+		resp, err = httpClient.PostForm(u.String(), params)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("易支付接口返回异常: %s", string(body))
+	}
+	if fmt.Sprint(data["code"]) != "1" {
+		return data, fmt.Errorf("易支付接口错误: %v", data["msg"])
+	}
+	return data, nil
+}
+
+func (p *EpayProvider) QueryOrder(topUp *model.TopUp) (*QueryResult, error) {
+	data, err := p.api(http.MethodGet, "order", url.Values{"out_trade_no": {topUp.TradeNo}})
+	if err != nil {
+		if data != nil {
+			// 用户尚未支付时部分易支付返回订单不存在
+			return &QueryResult{Status: OrderStatusPending}, nil
+		}
+		return nil, err
+	}
+	result := &QueryResult{
+		Status:          OrderStatusPending,
+		ProviderTradeNo: fmt.Sprint(data["trade_no"]),
+	}
+	result.Money, _ = strconv.ParseFloat(fmt.Sprint(data["money"]), 64)
+	if fmt.Sprint(data["status"]) == "1" {
+		result.Status = OrderStatusPaid
+	}
+	return result, nil
+}
+
+func (p *EpayProvider) Refund(req *RefundRequest) error {
+	_, err := p.api(http.MethodPost, "refund", url.Values{
+		"out_trade_no": {req.TopUp.TradeNo},
+		"money":        {strconv.FormatFloat(req.Money, 'f', 2, 64)},
+	})
+	return err
+}
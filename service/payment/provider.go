@@ -0,0 +1,236 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+)
+
+const (
+	ProviderEpay         = "epay"
+	ProviderStripe       = "stripe"
+	ProviderAlipayDirect = "alipay_direct"
+	ProviderWechatNative = "wechat_native"
+)
+
+// OrderStatus 第三方支付订单状态
+type OrderStatus string
+
+const (
+	OrderStatusPending OrderStatus = "pending"
+	OrderStatusPaid    OrderStatus = "paid"
+	OrderStatusClosed  OrderStatus = "closed"
+)
+
+type CreateOrderRequest struct {
+	TradeNo string
+	Subject string
+	// Money 实付金额，Amount 为充值数量（Stripe 按数量计价）
+	Money      float64
+	Amount     int64
+	PayMethod  string
+	Email      string
+	CustomerId string
+	NotifyUrl  string
+	ReturnUrl  string
+	ClientIp   string
+}
+
+type CreateOrderResult struct {
+	// PayLink 跳转支付链接，Params 不为空时需以表单提交到 PayLink
+	PayLink string
+	Params  map[string]string
+	// QRCode 扫码支付内容，例如微信 Native 的 code_url
+	QRCode          string
+	ProviderTradeNo string
+}
+
+type NotifyResult struct {
+	TradeNo         string
+	ProviderTradeNo string
+	// Status 为空表示该通知与充值订单无关
+	Status     OrderStatus
+	Money      float64
+	CustomerId string
+	// Raw 提供方的原始通知对象，例如 Stripe Event
+	Raw any
+}
+
+type QueryResult struct {
+	Status          OrderStatus
+	ProviderTradeNo string
+	Money           float64
+}
+
+type RefundRequest struct {
+	TopUp    *model.TopUp
+	RefundNo string
+	Money    float64
+	Reason   string
+}
+
+// PaymentProvider 支付提供方，负责下单、验证回调、查询与退款，订单状态流转由本包统一处理
+type PaymentProvider interface {
+	Name() string
+	Enabled() bool
+	CreateOrder(req *CreateOrderRequest) (*CreateOrderResult, error)
+	// VerifyNotify 验证回调签名并解析通知
+	VerifyNotify(r *http.Request) (*NotifyResult, error)
+	// AckNotify 按提供方要求的格式应答回调，err 不为空表示处理失败
+	AckNotify(w http.ResponseWriter, err error)
+	QueryOrder(topUp *model.TopUp) (*QueryResult, error)
+	Refund(req *RefundRequest) error
+}
+
+var (
+	providersLock sync.RWMutex
+	providers     = map[string]PaymentProvider{}
+)
+
+func Register(provider PaymentProvider) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	providers[provider.Name()] = provider
+}
+
+func GetProvider(name string) (PaymentProvider, error) {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("不支持的支付方式: %s", name)
+	}
+	if !provider.Enabled() {
+		return nil, fmt.Errorf("支付方式 %s 未配置", name)
+	}
+	return provider, nil
+}
+
+func IsEnabled(name string) bool {
+	_, err := GetProvider(name)
+	return err == nil
+}
+
+// ApplyNotify 根据回调结果推进订单状态
+func ApplyNotify(result *NotifyResult) error {
+	if result == nil || result.TradeNo == "" {
+		return nil
+	}
+	switch result.Status {
+	case OrderStatusPaid:
+		topUp := model.GetTopUpByTradeNo(result.TradeNo)
+		if topUp == nil {
+			return errors.New("充值订单不存在")
+		}
+		// Stripe 订单的 Money 不是实付金额，其余提供方需核对金额
+		if result.Money > 0 && topUp.GetProvider() != ProviderStripe && math.Abs(result.Money-topUp.Money) > 0.01 {
+			return fmt.Errorf("支付金额 %.2f 与订单金额 %.2f 不符", result.Money, topUp.Money)
+		}
+		return model.CompleteTopUp(result.TradeNo, result.ProviderTradeNo, result.CustomerId)
+	case OrderStatusClosed:
+		topUp := model.GetTopUpByTradeNo(result.TradeNo)
+		if topUp == nil {
+			return errors.New("充值订单不存在")
+		}
+		// 全额退款后支付宝也会推送交易关闭，已完成或已退款的订单视为已处理
+		if topUp.Status != common.TopUpStatusPending {
+			return nil
+		}
+		return model.UpdateTopUpStatus(result.TradeNo, common.TopUpStatusExpired)
+	}
+	return nil
+}
+
+// SyncOrder 主动查询第三方订单并同步本地订单状态
+func SyncOrder(topUp *model.TopUp) (OrderStatus, error) {
+	provider, err := GetProvider(topUp.GetProvider())
+	if err != nil {
+		return "", err
+	}
+	result, err := provider.QueryOrder(topUp)
+	if err != nil {
+		return "", err
+	}
+	err = ApplyNotify(&NotifyResult{
+		TradeNo:         topUp.TradeNo,
+		ProviderTradeNo: result.ProviderTradeNo,
+		Status:          result.Status,
+		Money:           result.Money,
+	})
+	return result.Status, err
+}
+
+// RefundOrder 向第三方发起退款，成功后扣回对应额度
+func RefundOrder(tradeNo string, money float64, reason string) (int, error) {
+	topUp := model.GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		return 0, errors.New("充值订单不存在")
+	}
+	if topUp.Status != common.TopUpStatusSuccess {
+		return 0, errors.New("只有已完成的订单可以退款")
+	}
+	if money <= 0 || money > topUp.Money-topUp.RefundMoney+0.000001 {
+		return 0, errors.New("退款金额无效")
+	}
+	provider, err := GetProvider(topUp.GetProvider())
+	if err != nil {
+		return 0, err
+	}
+	err = provider.Refund(&RefundRequest{
+		TopUp:    topUp,
+		RefundNo: fmt.Sprintf("%sR%d", topUp.TradeNo, time.Now().Unix()),
+		Money:    money,
+		Reason:   reason,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return model.RefundTopUp(tradeNo, money)
+}
+
+const (
+	// reconcileMinAge 下单后等待回调的时间，之后才开始主动查询
+	reconcileMinAge = 2 * time.Minute
+	// reconcileMaxAge 超过该时间仍未支付的订单置为过期
+	reconcileMaxAge = 24 * time.Hour
+	reconcileBatch  = 100
+)
+
+func reconcilePendingTopUps() {
+	now := time.Now()
+	topUps, err := model.GetPendingTopUps(now.Add(-reconcileMaxAge-time.Hour).Unix(), now.Add(-reconcileMinAge).Unix(), reconcileBatch)
+	if err != nil {
+		common.SysLog("failed to query pending top ups: " + err.Error())
+		return
+	}
+	for _, topUp := range topUps {
+		status, err := SyncOrder(topUp)
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to reconcile top up %s: %s", topUp.TradeNo, err.Error()))
+		}
+		if status == OrderStatusPaid || status == OrderStatusClosed {
+			continue
+		}
+		if now.Sub(time.Unix(topUp.CreateTime, 0)) > reconcileMaxAge {
+			if err := model.UpdateTopUpStatus(topUp.TradeNo, common.TopUpStatusExpired); err != nil {
+				common.SysLog(fmt.Sprintf("failed to expire top up %s: %s", topUp.TradeNo, err.Error()))
+			}
+		}
+	}
+}
+
+// AutoReconcileTopUps 定期查询待支付订单，弥补丢失的支付回调，仅在主节点运行
+func AutoReconcileTopUps() {
+	for {
+		if common.IsMasterNode {
+			reconcilePendingTopUps()
+		}
+		time.Sleep(5 * time.Minute)
+	}
+}
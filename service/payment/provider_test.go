@@ -0,0 +1,54 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTopUpDB(t *testing.T, topUps ...*model.TopUp) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.TopUp{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, topUp := range topUps {
+		if err := db.Create(topUp).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := model.DB
+	t.Cleanup(func() {
+		model.DB = saved
+	})
+	model.DB = db
+}
+
+func TestApplyNotifyClosed(t *testing.T) {
+	setupTopUpDB(t,
+		&model.TopUp{TradeNo: "pending", Provider: ProviderAlipayDirect, Status: common.TopUpStatusPending},
+		&model.TopUp{TradeNo: "success", Provider: ProviderAlipayDirect, Status: common.TopUpStatusSuccess},
+		&model.TopUp{TradeNo: "refunded", Provider: ProviderAlipayDirect, Status: common.TopUpStatusRefunded},
+	)
+	want := map[string]string{
+		"pending": common.TopUpStatusExpired,
+		// 退款后的交易关闭通知需要应答成功，且不改变订单状态
+		"success":  common.TopUpStatusSuccess,
+		"refunded": common.TopUpStatusRefunded,
+	}
+	for tradeNo, status := range want {
+		if err := ApplyNotify(&NotifyResult{TradeNo: tradeNo, Status: OrderStatusClosed}); err != nil {
+			t.Fatalf("%s: %v", tradeNo, err)
+		}
+		if got := model.GetTopUpByTradeNo(tradeNo).Status; got != status {
+			t.Fatalf("%s: status = %s, want %s", tradeNo, got, status)
+		}
+	}
+}
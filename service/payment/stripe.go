@@ -0,0 +1,170 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/refund"
+	"github.com/stripe/stripe-go/v81/webhook"
+)
+
+type StripeProvider struct{}
+
+func init() {
+	Register(&StripeProvider{})
+}
+
+func (p *StripeProvider) Name() string {
+	return ProviderStripe
+}
+
+func (p *StripeProvider) Enabled() bool {
+	return setting.StripeApiSecret != "" && setting.StripeWebhookSecret != "" && setting.StripePriceId != ""
+}
+
+// SetupStripeKey 校验并设置 Stripe API 密钥
+func SetupStripeKey() error {
+	if !strings.HasPrefix(setting.StripeApiSecret, "sk_") && !strings.HasPrefix(setting.StripeApiSecret, "rk_") {
+		return fmt.Errorf("无效的Stripe API密钥")
+	}
+	stripe.Key = setting.StripeApiSecret
+	return nil
+}
+
+func (p *StripeProvider) CreateOrder(req *CreateOrderRequest) (*CreateOrderResult, error) {
+	if err := SetupStripeKey(); err != nil {
+		return nil, err
+	}
+	params := &stripe.CheckoutSessionParams{
+		ClientReferenceID: stripe.String(req.TradeNo),
+		SuccessURL:        stripe.String(req.ReturnUrl),
+		CancelURL:         stripe.String(system_setting.ServerAddress + "/topup"),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(setting.StripePriceId),
+				Quantity: stripe.Int64(req.Amount),
+			},
+		},
+		Mode:                stripe.String(string(stripe.CheckoutSessionModePayment)),
+		AllowPromotionCodes: stripe.Bool(setting.StripePromotionCodesEnabled),
+	}
+	if req.CustomerId == "" {
+		if req.Email != "" {
+			params.CustomerEmail = stripe.String(req.Email)
+		}
+		params.CustomerCreation = stripe.String(string(stripe.CheckoutSessionCustomerCreationAlways))
+	} else {
+		params.Customer = stripe.String(req.CustomerId)
+	}
+	result, err := session.New(params)
+	if err != nil {
+		return nil, err
+	}
+	return &CreateOrderResult{PayLink: result.URL, ProviderTradeNo: result.ID}, nil
+}
+
+// VerifyNotify 验证 Stripe Webhook 签名，仅 Checkout 支付事件会返回订单状态，其余事件通过 Raw 交由调用方处理
+func (p *StripeProvider) VerifyNotify(r *http.Request) (*NotifyResult, error) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	event, err := webhook.ConstructEventWithOptions(payload, r.Header.Get("Stripe-Signature"), setting.StripeWebhookSecret, webhook.ConstructEventOptions{
+		IgnoreAPIVersionMismatch: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &NotifyResult{Raw: event}
+	if event.Type != stripe.EventTypeCheckoutSessionCompleted && event.Type != stripe.EventTypeCheckoutSessionExpired {
+		return result, nil
+	}
+	if event.GetObjectValue("mode") != string(stripe.CheckoutSessionModePayment) {
+		return result, nil
+	}
+	result.TradeNo = event.GetObjectValue("client_reference_id")
+	result.ProviderTradeNo = event.GetObjectValue("id")
+	result.CustomerId = event.GetObjectValue("customer")
+	switch event.GetObjectValue("status") {
+	case string(stripe.CheckoutSessionStatusComplete):
+		if event.GetObjectValue("payment_status") != string(stripe.CheckoutSessionPaymentStatusUnpaid) {
+			result.Status = OrderStatusPaid
+		}
+	case string(stripe.CheckoutSessionStatusExpired):
+		result.Status = OrderStatusClosed
+	}
+	return result, nil
+}
+
+func (p *StripeProvider) AckNotify(w http.ResponseWriter, err error) {
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *StripeProvider) QueryOrder(topUp *model.TopUp) (*QueryResult, error) {
+	if topUp.ProviderTradeNo == "" {
+		return nil, errors.New("订单缺少 Stripe Checkout 会话")
+	}
+	if err := SetupStripeKey(); err != nil {
+		return nil, err
+	}
+	s, err := session.Get(topUp.ProviderTradeNo, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := &QueryResult{
+		Status:          OrderStatusPending,
+		ProviderTradeNo: s.ID,
+		Money:           float64(s.AmountTotal) / 100,
+	}
+	switch {
+	case s.Status == stripe.CheckoutSessionStatusComplete && s.PaymentStatus != stripe.CheckoutSessionPaymentStatusUnpaid:
+		result.Status = OrderStatusPaid
+	case s.Status == stripe.CheckoutSessionStatusExpired:
+		result.Status = OrderStatusClosed
+	}
+	return result, nil
+}
+
+// Refund 按退款金额占订单金额的比例退还实际支付的款项
+func (p *StripeProvider) Refund(req *RefundRequest) error {
+	if req.TopUp.ProviderTradeNo == "" {
+		return errors.New("订单缺少 Stripe Checkout 会话")
+	}
+	if err := SetupStripeKey(); err != nil {
+		return err
+	}
+	s, err := session.Get(req.TopUp.ProviderTradeNo, nil)
+	if err != nil {
+		return err
+	}
+	if s.PaymentIntent == nil {
+		return errors.New("Stripe 订单未支付")
+	}
+	amount := int64(math.Round(float64(s.AmountTotal) * req.Money / req.TopUp.Money))
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(s.PaymentIntent.ID),
+		Amount:        stripe.Int64(amount),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	}
+	params.SetIdempotencyKey(req.RefundNo)
+	params.AddMetadata("trade_no", req.TopUp.TradeNo)
+	if req.Reason != "" {
+		params.AddMetadata("reason", req.Reason)
+	}
+	_, err = refund.New(params)
+	return err
+}
@@ -0,0 +1,262 @@
+package payment
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+const wechatPayBaseUrl = "https://api.mch.weixin.qq.com"
+
+// WechatPayProvider 微信支付 Native 扫码支付（APIv3），使用微信支付公钥验签
+type WechatPayProvider struct{}
+
+func init() {
+	Register(&WechatPayProvider{})
+}
+
+func (p *WechatPayProvider) Name() string {
+	return ProviderWechatNative
+}
+
+func (p *WechatPayProvider) Enabled() bool {
+	return setting.WechatPayMchId != "" && setting.WechatPayAppId != "" && setting.WechatPayApiV3Key != "" &&
+		setting.WechatPayPrivateKey != "" && setting.WechatPayCertSerialNo != "" && setting.WechatPayPublicKey != ""
+}
+
+func toFen(money float64) int64 {
+	return int64(math.Round(money * 100))
+}
+
+// request 发送带 APIv3 签名的请求，非 2xx 应答返回错误
+func (p *WechatPayProvider) request(method string, path string, payload any) ([]byte, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	key, err := parsePrivateKey(setting.WechatPayPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := common.GetRandomString(32)
+	signature, err := signSHA256WithRSA(key, method+"\n"+path+"\n"+timestamp+"\n"+nonce+"\n"+string(body)+"\n")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, wechatPayBaseUrl+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf(`WECHATPAY2-SHA256-RSA2048 mchid="%s",nonce_str="%s",signature="%s",timestamp="%s",serial_no="%s"`,
+		setting.WechatPayMchId, nonce, signature, timestamp, setting.WechatPayCertSerialNo))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return respBody, fmt.Errorf("微信支付接口错误(%d): %s", resp.StatusCode, string(respBody))
+	}
+	if err := p.verifySignature(resp.Header, respBody); err != nil {
+		return nil, err
+	}
+	return respBody, nil
+}
+
+// verifySignature 使用微信支付公钥验证应答或通知签名
+func (p *WechatPayProvider) verifySignature(header http.Header, body []byte) error {
+	serial := header.Get("Wechatpay-Serial")
+	if setting.WechatPayPublicKeyId != "" && serial != setting.WechatPayPublicKeyId {
+		return fmt.Errorf("微信支付公钥 ID 不匹配: %s", serial)
+	}
+	timestamp := header.Get("Wechatpay-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || math.Abs(float64(time.Now().Unix()-ts)) > 300 {
+		return errors.New("微信支付签名时间戳无效")
+	}
+	publicKey, err := parsePublicKey(setting.WechatPayPublicKey)
+	if err != nil {
+		return err
+	}
+	message := timestamp + "\n" + header.Get("Wechatpay-Nonce") + "\n" + string(body) + "\n"
+	if err := verifySHA256WithRSA(publicKey, message, header.Get("Wechatpay-Signature")); err != nil {
+		return errors.New("微信支付签名验证失败")
+	}
+	return nil
+}
+
+type wechatPayTransaction struct {
+	OutTradeNo    string `json:"out_trade_no"`
+	TransactionId string `json:"transaction_id"`
+	TradeState    string `json:"trade_state"`
+	Amount        struct {
+		Total int64 `json:"total"`
+	} `json:"amount"`
+}
+
+func (t *wechatPayTransaction) status() OrderStatus {
+	switch t.TradeState {
+	case "SUCCESS", "REFUND":
+		return OrderStatusPaid
+	case "CLOSED", "REVOKED", "PAYERROR":
+		return OrderStatusClosed
+	}
+	return OrderStatusPending
+}
+
+func (p *WechatPayProvider) CreateOrder(req *CreateOrderRequest) (*CreateOrderResult, error) {
+	body, err := p.request(http.MethodPost, "/v3/pay/transactions/native", map[string]any{
+		"appid":        setting.WechatPayAppId,
+		"mchid":        setting.WechatPayMchId,
+		"description":  req.Subject,
+		"out_trade_no": req.TradeNo,
+		"notify_url":   req.NotifyUrl,
+		"amount": map[string]any{
+			"total":    toFen(req.Money),
+			"currency": "CNY",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		CodeUrl string `json:"code_url"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	return &CreateOrderResult{QRCode: data.CodeUrl}, nil
+}
+
+func (p *WechatPayProvider) VerifyNotify(r *http.Request) (*NotifyResult, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.verifySignature(r.Header, body); err != nil {
+		return nil, err
+	}
+	var notify struct {
+		EventType string `json:"event_type"`
+		Resource  struct {
+			Algorithm      string `json:"algorithm"`
+			Ciphertext     string `json:"ciphertext"`
+			AssociatedData string `json:"associated_data"`
+			Nonce          string `json:"nonce"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &notify); err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(notify.Resource.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher([]byte(setting.WechatPayApiV3Key))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, []byte(notify.Resource.Nonce), ciphertext, []byte(notify.Resource.AssociatedData))
+	if err != nil {
+		return nil, errors.New("微信支付通知解密失败")
+	}
+	var transaction wechatPayTransaction
+	if err := json.Unmarshal(plaintext, &transaction); err != nil {
+		return nil, err
+	}
+	return &NotifyResult{
+		TradeNo:         transaction.OutTradeNo,
+		ProviderTradeNo: transaction.TransactionId,
+		Status:          transaction.status(),
+		Money:           float64(transaction.Amount.Total) / 100,
+		Raw:             transaction,
+	}, nil
+}
+
+func (p *WechatPayProvider) AckNotify(w http.ResponseWriter, err error) {
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"code": "FAIL", "message": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *WechatPayProvider) QueryOrder(topUp *model.TopUp) (*QueryResult, error) {
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(topUp.TradeNo) + "?mchid=" + url.QueryEscape(setting.WechatPayMchId)
+	body, err := p.request(http.MethodGet, path, nil)
+	if err != nil {
+		if bytes.Contains(body, []byte("ORDER_NOT_EXIST")) {
+			return &QueryResult{Status: OrderStatusPending}, nil
+		}
+		return nil, err
+	}
+	var transaction wechatPayTransaction
+	if err := json.Unmarshal(body, &transaction); err != nil {
+		return nil, err
+	}
+	return &QueryResult{
+		Status:          transaction.status(),
+		ProviderTradeNo: transaction.TransactionId,
+		Money:           float64(transaction.Amount.Total) / 100,
+	}, nil
+}
+
+func (p *WechatPayProvider) Refund(req *RefundRequest) error {
+	payload := map[string]any{
+		"out_trade_no":  req.TopUp.TradeNo,
+		"out_refund_no": req.RefundNo,
+		"amount": map[string]any{
+			"refund":   toFen(req.Money),
+			"total":    toFen(req.TopUp.Money),
+			"currency": "CNY",
+		},
+	}
+	if req.Reason != "" {
+		payload["reason"] = req.Reason
+	}
+	body, err := p.request(http.MethodPost, "/v3/refund/domestic/refunds", payload)
+	if err != nil {
+		return err
+	}
+	var data struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+	if data.Status == "CLOSED" || data.Status == "ABNORMAL" {
+		return fmt.Errorf("微信支付退款失败: %s", data.Status)
+	}
+	return nil
+}
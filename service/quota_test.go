@@ -0,0 +1,155 @@
+package service_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const tieredAudioModel = "tiered-audio-test"
+
+// setupTieredAudioModel 为测试模型配置两档倍率：1000 token 以内 1/2，超出后 2/3
+func setupTieredAudioModel(t *testing.T) {
+	t.Helper()
+	saved := ratio_setting.TieredRatio2JSONString()
+	t.Cleanup(func() { _ = ratio_setting.UpdateTieredRatioByJSONString(saved) })
+	err := ratio_setting.UpdateTieredRatioByJSONString(`{"` + tieredAudioModel + `":[` +
+		`{"max_prompt_tokens":1000,"model_ratio":1,"completion_ratio":2},` +
+		`{"max_prompt_tokens":0,"model_ratio":2,"completion_ratio":3}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTieredAudioRelayInfo 预扣费阶段按较少的预估输入选中了第一档
+func newTieredAudioRelayInfo(t *testing.T) *relaycommon.RelayInfo {
+	t.Helper()
+	user := &model.User{Username: "tier-user", Password: "password123", Status: common.UserStatusEnabled, Quota: 100000}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &relaycommon.RelayInfo{
+		UserId:          user.Id,
+		OriginModelName: tieredAudioModel,
+		IsPlayground:    true,
+		ChannelMeta:     &relaycommon.ChannelMeta{ChannelId: 1, UpstreamModelName: tieredAudioModel},
+		PriceData: types.PriceData{
+			ModelRatio:      1,
+			CompletionRatio: 2,
+			PriceTier:       1,
+			GroupRatioInfo:  types.GroupRatioInfo{GroupRatio: 1},
+		},
+	}
+}
+
+func newConsumeTestContext() *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("POST", "/v1/audio/speech", nil)
+	return ctx
+}
+
+func lastConsumeLog(t *testing.T) model.Log {
+	t.Helper()
+	var log model.Log
+	if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).Order("id desc").First(&log).Error; err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
+
+func TestPostAudioConsumeQuotaAppliesPriceTier(t *testing.T) {
+	setupTestDB(t)
+	setupTieredAudioModel(t)
+	relayInfo := newTieredAudioRelayInfo(t)
+	ctx := newConsumeTestContext()
+
+	usage := &dto.Usage{
+		PromptTokens:           2000,
+		CompletionTokens:       100,
+		TotalTokens:            2100,
+		PromptTokensDetails:    dto.InputTokenDetails{TextTokens: 2000},
+		CompletionTokenDetails: dto.OutputTokenDetails{TextTokens: 100},
+	}
+	service.PostAudioConsumeQuota(ctx, relayInfo, usage, "")
+
+	// 实际输入超过 1000，按第二档计费：(2000 + 100*3) * 2
+	if log := lastConsumeLog(t); log.Quota != 4600 {
+		t.Fatalf("quota = %d, want 4600", log.Quota)
+	}
+	if relayInfo.PriceData.PriceTier != 2 || relayInfo.PriceData.ModelRatio != 2 || relayInfo.PriceData.CompletionRatio != 3 {
+		t.Fatalf("price data = %+v, want tier 2 ratios", relayInfo.PriceData)
+	}
+}
+
+func TestPostAudioConsumeQuotaKeepsTierWithinLimit(t *testing.T) {
+	setupTestDB(t)
+	setupTieredAudioModel(t)
+	relayInfo := newTieredAudioRelayInfo(t)
+	ctx := newConsumeTestContext()
+
+	usage := &dto.Usage{
+		PromptTokens:           800,
+		CompletionTokens:       100,
+		TotalTokens:            900,
+		PromptTokensDetails:    dto.InputTokenDetails{TextTokens: 800},
+		CompletionTokenDetails: dto.OutputTokenDetails{TextTokens: 100},
+	}
+	service.PostAudioConsumeQuota(ctx, relayInfo, usage, "")
+
+	// (800 + 100*2) * 1
+	if log := lastConsumeLog(t); log.Quota != 1000 {
+		t.Fatalf("quota = %d, want 1000", log.Quota)
+	}
+}
+
+func TestPostWssConsumeQuotaAppliesPriceTier(t *testing.T) {
+	setupTestDB(t)
+	setupTieredAudioModel(t)
+	relayInfo := newTieredAudioRelayInfo(t)
+	ctx := newConsumeTestContext()
+
+	usage := &dto.RealtimeUsage{
+		InputTokens:        1500,
+		OutputTokens:       200,
+		TotalTokens:        1700,
+		InputTokenDetails:  dto.InputTokenDetails{TextTokens: 1500},
+		OutputTokenDetails: dto.OutputTokenDetails{TextTokens: 200},
+	}
+	service.PostWssConsumeQuota(ctx, relayInfo, tieredAudioModel, usage, "")
+
+	// (1500 + 200*3) * 2
+	if log := lastConsumeLog(t); log.Quota != 4200 {
+		t.Fatalf("quota = %d, want 4200", log.Quota)
+	}
+}
+
+func TestPostAudioConsumeQuotaRecordsUpstreamCost(t *testing.T) {
+	setupTestDB(t)
+	setupTieredAudioModel(t)
+	relayInfo := newTieredAudioRelayInfo(t)
+	relayInfo.ChannelSetting.UpstreamCost = &dto.UpstreamCostSettings{Multiplier: 0.5}
+	ctx := newConsumeTestContext()
+
+	usage := &dto.Usage{
+		PromptTokens:           2000,
+		CompletionTokens:       100,
+		TotalTokens:            2100,
+		PromptTokensDetails:    dto.InputTokenDetails{TextTokens: 2000},
+		CompletionTokenDetails: dto.OutputTokenDetails{TextTokens: 100},
+	}
+	service.PostAudioConsumeQuota(ctx, relayInfo, usage, "")
+
+	// 成本同样按第二档估算：0.5 * 2 * (2000 + 100*3)
+	if log := lastConsumeLog(t); log.UpstreamCost != 2300 {
+		t.Fatalf("upstream cost = %d, want 2300", log.UpstreamCost)
+	}
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/tidwall/gjson"
+)
+
+// ServerToolRatioPrefix 网关侧工具的调用次数记录在 PriceData.OtherRatios 中，键为前缀加工具名
+const ServerToolRatioPrefix = "server_tool:"
+
+const defaultServerToolTimeout = 30 * time.Second
+
+// ServerToolDefinition 生成提供给模型的函数定义
+func ServerToolDefinition(provider *operation_setting.ServerToolProvider) dto.ToolCallRequest {
+	parameters := provider.Parameters
+	if parameters == nil {
+		switch provider.Type {
+		case operation_setting.ServerToolTypeSearch:
+			parameters = map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query": map[string]any{"type": "string", "description": "The search query"},
+				},
+				"required": []string{"query"},
+			}
+		case operation_setting.ServerToolTypeCode:
+			parameters = map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"language": map[string]any{"type": "string", "description": "Programming language, e.g. python"},
+					"code":     map[string]any{"type": "string", "description": "The code to execute"},
+				},
+				"required": []string{"code"},
+			}
+		default:
+			parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+	}
+	description := provider.Description
+	if description == "" {
+		switch provider.Type {
+		case operation_setting.ServerToolTypeSearch:
+			description = "Search the web and return relevant results."
+		case operation_setting.ServerToolTypeCode:
+			description = "Execute code in a sandbox and return its output."
+		}
+	}
+	return dto.ToolCallRequest{
+		Type: "function",
+		Function: dto.FunctionRequest{
+			Name:        provider.Name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+}
+
+// ExecuteServerTool 执行一次网关侧工具调用，返回回传给模型的文本
+func ExecuteServerTool(ctx context.Context, provider *operation_setting.ServerToolProvider, arguments string) (string, error) {
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	if !gjson.Valid(arguments) {
+		return "", errors.New("tool arguments is not valid JSON")
+	}
+	timeout := defaultServerToolTimeout
+	if provider.Timeout > 0 {
+		timeout = time.Duration(provider.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var output string
+	var err error
+	switch provider.Type {
+	case operation_setting.ServerToolTypeStub:
+		output = provider.StubOutput
+		if output == "" {
+			output = arguments
+		}
+	case operation_setting.ServerToolTypeMcp:
+		output, err = callMcpTool(ctx, provider, arguments)
+	case operation_setting.ServerToolTypeSearch, operation_setting.ServerToolTypeCode, operation_setting.ServerToolTypeHttp:
+		var body []byte
+		body, err = postServerTool(ctx, provider, []byte(arguments), nil)
+		output = string(body)
+	default:
+		err = fmt.Errorf("unsupported server tool type %s", provider.Type)
+	}
+	if err != nil {
+		return "", err
+	}
+	if maxSize := operation_setting.GetServerToolSetting().MaxOutputSize; maxSize > 0 && len(output) > maxSize {
+		output = output[:maxSize] + "\n...(truncated)"
+	}
+	return output, nil
+}
+
+func postServerTool(ctx context.Context, provider *operation_setting.ServerToolProvider, body []byte, headers map[string]string) ([]byte, error) {
+	responseBody, _, err := doServerToolRequest(ctx, provider, body, headers)
+	return responseBody, err
+}
+
+func doServerToolRequest(ctx context.Context, provider *operation_setting.ServerToolProvider, body []byte, headers map[string]string) ([]byte, http.Header, error) {
+	if provider.Url == "" {
+		return nil, nil, errors.New("server tool url is empty")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.Url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range provider.Headers {
+		req.Header.Set(key, value)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("server tool returned status %d: %s", resp.StatusCode, string(responseBody))
+	}
+	return responseBody, resp.Header, nil
+}
+
+// callMcpTool 通过 MCP Streamable HTTP 调用工具：initialize → notifications/initialized → tools/call
+func callMcpTool(ctx context.Context, provider *operation_setting.ServerToolProvider, arguments string) (string, error) {
+	headers := map[string]string{"Accept": "application/json, text/event-stream"}
+	initialize := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"new-api","version":"%s"}}}`, common.Version)
+	body, header, err := doServerToolRequest(ctx, provider, []byte(initialize), headers)
+	if err != nil {
+		return "", err
+	}
+	if result := mcpResult(body, 1); result.Get("error").Exists() {
+		return "", fmt.Errorf("mcp initialize failed: %s", result.Get("error.message").String())
+	}
+	if sessionId := header.Get("Mcp-Session-Id"); sessionId != "" {
+		headers["Mcp-Session-Id"] = sessionId
+	}
+	if _, _, err = doServerToolRequest(ctx, provider, []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`), headers); err != nil {
+		return "", err
+	}
+
+	toolName := provider.McpTool
+	if toolName == "" {
+		toolName = provider.Name
+	}
+	name, _ := common.Marshal(toolName)
+	call := fmt.Sprintf(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":%s,"arguments":%s}}`, name, arguments)
+	body, _, err = doServerToolRequest(ctx, provider, []byte(call), headers)
+	if err != nil {
+		return "", err
+	}
+	response := mcpResult(body, 2)
+	if response.Get("error").Exists() {
+		return "", fmt.Errorf("mcp tools/call failed: %s", response.Get("error.message").String())
+	}
+	var sb strings.Builder
+	for _, content := range response.Get("result.content").Array() {
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		if content.Get("type").String() == "text" {
+			sb.WriteString(content.Get("text").String())
+		} else {
+			sb.WriteString(content.Raw)
+		}
+	}
+	if response.Get("result.isError").Bool() {
+		return "Error: " + sb.String(), nil
+	}
+	return sb.String(), nil
+}
+
+// mcpResult 从 JSON 或 SSE 格式的响应中找出指定 id 的 JSON-RPC 响应
+func mcpResult(body []byte, id int64) gjson.Result {
+	if result := gjson.ParseBytes(body); result.IsObject() {
+		return result
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		result := gjson.Parse(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		if result.Get("id").Int() == id {
+			return result
+		}
+	}
+	return gjson.Result{}
+}
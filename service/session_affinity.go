@@ -0,0 +1,187 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// sessionAffinityStore is used when Redis is disabled
+var (
+	sessionAffinityStore       sync.Map
+	sessionAffinityCleanupOnce sync.Once
+)
+
+// SessionAffinity 会话固定的渠道与多密钥渠道的密钥下标
+type SessionAffinity struct {
+	ChannelId int
+	KeyIndex  int
+	ExpiresAt time.Time
+}
+
+func startSessionAffinityCleanupTask() {
+	gopool.Go(func() {
+		for {
+			time.Sleep(10 * time.Minute)
+			now := time.Now()
+			sessionAffinityStore.Range(func(key, value interface{}) bool {
+				if affinity, ok := value.(SessionAffinity); ok && now.After(affinity.ExpiresAt) {
+					sessionAffinityStore.Delete(key)
+				}
+				return true
+			})
+		}
+	})
+}
+
+// GetSessionAffinityKey 根据会话请求头、user 字段或对话前缀生成会话粘性的存储键，
+// 键按用户、分组与模型区分，无法识别会话时返回空字符串
+func GetSessionAffinityKey(c *gin.Context, group string, modelName string) string {
+	settings := model_setting.GetSessionAffinitySettings()
+	if !settings.Enabled {
+		return ""
+	}
+	session := ""
+	if settings.Header != "" {
+		session = strings.TrimSpace(c.GetHeader(settings.Header))
+	}
+	if session == "" && (settings.UseUserField || settings.UsePromptPrefix) && strings.HasPrefix(c.ContentType(), "application/json") {
+		body, err := common.GetRequestBody(c)
+		if err == nil {
+			if settings.UseUserField {
+				if user := gjson.GetBytes(body, "user").String(); user != "" {
+					session = "user:" + user
+				}
+			}
+			if session == "" && settings.UsePromptPrefix {
+				if prefix := getConversationPrefix(body, settings.GetPrefixMessages()); prefix != "" {
+					session = "prefix:" + prefix
+				}
+			}
+		}
+	}
+	if session == "" {
+		return ""
+	}
+	userId := common.GetContextKeyInt(c, constant.ContextKeyUserId)
+	return fmt.Sprintf("session_affinity:%d:%s:%s:%s", userId, group, modelName, common.Sha1([]byte(session)))
+}
+
+// getConversationPrefix 取系统提示词与前 n 条消息，兼容 OpenAI / Claude / Responses / Gemini 格式
+func getConversationPrefix(body []byte, n int) string {
+	var builder strings.Builder
+	for _, path := range []string{"system", "instructions", "systemInstruction", "system_instruction"} {
+		if value := gjson.GetBytes(body, path); value.Exists() {
+			builder.WriteString(value.Raw)
+		}
+	}
+	for _, path := range []string{"messages", "input", "contents"} {
+		value := gjson.GetBytes(body, path)
+		if !value.Exists() {
+			continue
+		}
+		if !value.IsArray() {
+			builder.WriteString(value.Raw)
+			break
+		}
+		for i, item := range value.Array() {
+			if i >= n {
+				break
+			}
+			builder.WriteString(item.Raw)
+		}
+		break
+	}
+	return builder.String()
+}
+
+// GetSessionAffinity 查询会话固定的渠道
+func GetSessionAffinity(key string) (SessionAffinity, bool) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil || value == "" {
+			return SessionAffinity{}, false
+		}
+		channelId, keyIndex, ok := strings.Cut(value, ":")
+		if !ok {
+			return SessionAffinity{}, false
+		}
+		affinity := SessionAffinity{}
+		affinity.ChannelId, err = strconv.Atoi(channelId)
+		if err != nil {
+			return SessionAffinity{}, false
+		}
+		affinity.KeyIndex, _ = strconv.Atoi(keyIndex)
+		return affinity, true
+	}
+	value, ok := sessionAffinityStore.Load(key)
+	if !ok {
+		return SessionAffinity{}, false
+	}
+	affinity := value.(SessionAffinity)
+	if time.Now().After(affinity.ExpiresAt) {
+		sessionAffinityStore.Delete(key)
+		return SessionAffinity{}, false
+	}
+	return affinity, true
+}
+
+// SetSessionAffinity 记录会话固定的渠道，已存在时续期
+func SetSessionAffinity(key string, channelId int, keyIndex int) error {
+	ttl := model_setting.GetSessionAffinitySettings().GetTTL()
+	if common.RedisEnabled {
+		return common.RedisSet(key, fmt.Sprintf("%d:%d", channelId, keyIndex), ttl)
+	}
+	sessionAffinityCleanupOnce.Do(startSessionAffinityCleanupTask)
+	sessionAffinityStore.Store(key, SessionAffinity{ChannelId: channelId, KeyIndex: keyIndex, ExpiresAt: time.Now().Add(ttl)})
+	return nil
+}
+
+// DeleteSessionAffinity 解除会话固定
+func DeleteSessionAffinity(key string) error {
+	if common.RedisEnabled {
+		return common.RedisDel(key)
+	}
+	sessionAffinityStore.Delete(key)
+	return nil
+}
+
+// RecordSessionAffinity 请求成功后将会话固定到本次使用的渠道与密钥
+func RecordSessionAffinity(c *gin.Context) {
+	key := common.GetContextKeyString(c, constant.ContextKeySessionAffinityKey)
+	if key == "" {
+		return
+	}
+	channelId := common.GetContextKeyInt(c, constant.ContextKeyChannelId)
+	keyIndex := 0
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		keyIndex = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	if err := SetSessionAffinity(key, channelId, keyIndex); err != nil {
+		common.SysError("failed to save session affinity: " + err.Error())
+	}
+}
+
+// ReleaseSessionAffinity 固定的渠道请求失败时解除绑定，后续请求重新选择渠道
+func ReleaseSessionAffinity(c *gin.Context, channelId int) {
+	key := common.GetContextKeyString(c, constant.ContextKeySessionAffinityKey)
+	if key == "" {
+		return
+	}
+	affinity, ok := GetSessionAffinity(key)
+	if !ok || affinity.ChannelId != channelId {
+		return
+	}
+	if err := DeleteSessionAffinity(key); err != nil {
+		common.SysError("failed to delete session affinity: " + err.Error())
+	}
+}
@@ -0,0 +1,132 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useSessionAffinitySettings 开启会话粘性并在测试结束后恢复原配置，存储使用内存
+func useSessionAffinitySettings(t *testing.T, settings model_setting.SessionAffinitySettings) {
+	t.Helper()
+	current := model_setting.GetSessionAffinitySettings()
+	saved := *current
+	savedRedis := common.RedisEnabled
+	t.Cleanup(func() {
+		*current = saved
+		common.RedisEnabled = savedRedis
+	})
+	*current = settings
+	common.RedisEnabled = false
+}
+
+func newSessionAffinityContext(userId int, header string, body string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		c.Request.Header.Set("X-Session-Id", header)
+	}
+	common.SetContextKey(c, constant.ContextKeyUserId, userId)
+	return c
+}
+
+func TestGetSessionAffinityKeyOrder(t *testing.T) {
+	useSessionAffinitySettings(t, model_setting.SessionAffinitySettings{
+		Enabled: true, Header: "X-Session-Id", UseUserField: true, UsePromptPrefix: true, PrefixMessages: 1,
+	})
+	const withUser = `{"user":"alice","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`
+	const withoutUser = `{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`
+	key := func(header string, body string) string {
+		return service.GetSessionAffinityKey(newSessionAffinityContext(1, header, body), "default", "gpt-4o")
+	}
+
+	headerKey := key("session-1", withUser)
+	userKey := key("", withUser)
+	prefixKey := key("", withoutUser)
+	if headerKey == "" || userKey == "" || prefixKey == "" {
+		t.Fatalf("keys should not be empty: %q %q %q", headerKey, userKey, prefixKey)
+	}
+	// 请求头优先于 user 字段，user 字段优先于对话前缀
+	if headerKey != key("session-1", withoutUser) || headerKey == userKey {
+		t.Fatal("session header should take precedence over the user field")
+	}
+	if userKey != key("", `{"user":"alice","messages":[{"role":"user","content":"other"}]}`) || userKey == prefixKey {
+		t.Fatal("user field should take precedence over the prompt prefix")
+	}
+	// 前缀只取前 N 条消息
+	if prefixKey != key("", `{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"changed"}]}`) ||
+		prefixKey == key("", `{"messages":[{"role":"user","content":"bye"}]}`) {
+		t.Fatal("prefix hash should only depend on the first messages")
+	}
+	if !strings.HasPrefix(headerKey, "session_affinity:1:default:gpt-4o:") {
+		t.Fatalf("key = %q", headerKey)
+	}
+	// 不同用户、分组与模型互不影响
+	if headerKey == service.GetSessionAffinityKey(newSessionAffinityContext(2, "session-1", withUser), "default", "gpt-4o") ||
+		headerKey == service.GetSessionAffinityKey(newSessionAffinityContext(1, "session-1", withUser), "vip", "gpt-4o") ||
+		headerKey == service.GetSessionAffinityKey(newSessionAffinityContext(1, "session-1", withUser), "default", "gpt-4o-mini") {
+		t.Fatal("keys should differ by user, group and model")
+	}
+
+	// 关闭前缀后无法识别会话，关闭功能时一律不固定
+	model_setting.GetSessionAffinitySettings().UsePromptPrefix = false
+	if got := key("", withoutUser); got != "" {
+		t.Fatalf("key = %q, want empty without a session identifier", got)
+	}
+	model_setting.GetSessionAffinitySettings().Enabled = false
+	if got := key("session-1", withUser); got != "" {
+		t.Fatalf("key = %q, want empty when disabled", got)
+	}
+}
+
+func TestSessionAffinityExpires(t *testing.T) {
+	useSessionAffinitySettings(t, model_setting.SessionAffinitySettings{Enabled: true, TTLSeconds: 1})
+	const key = "session_affinity:test:expire"
+	if err := service.SetSessionAffinity(key, 7, 2); err != nil {
+		t.Fatal(err)
+	}
+	affinity, ok := service.GetSessionAffinity(key)
+	if !ok || affinity.ChannelId != 7 || affinity.KeyIndex != 2 {
+		t.Fatalf("affinity = %+v, %v, want channel 7 key 2", affinity, ok)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, ok := service.GetSessionAffinity(key); ok {
+		t.Fatal("affinity should expire after the TTL")
+	}
+}
+
+func TestRecordAndReleaseSessionAffinity(t *testing.T) {
+	useSessionAffinitySettings(t, model_setting.SessionAffinitySettings{Enabled: true, TTLSeconds: 60})
+	const key = "session_affinity:test:release"
+	t.Cleanup(func() { _ = service.DeleteSessionAffinity(key) })
+
+	c := newSessionAffinityContext(1, "", "")
+	common.SetContextKey(c, constant.ContextKeySessionAffinityKey, key)
+	common.SetContextKey(c, constant.ContextKeyChannelId, 7)
+	common.SetContextKey(c, constant.ContextKeyChannelIsMultiKey, true)
+	common.SetContextKey(c, constant.ContextKeyChannelMultiKeyIndex, 3)
+	service.RecordSessionAffinity(c)
+	if affinity, ok := service.GetSessionAffinity(key); !ok || affinity.ChannelId != 7 || affinity.KeyIndex != 3 {
+		t.Fatalf("affinity = %+v, %v, want channel 7 key 3", affinity, ok)
+	}
+
+	// 其他渠道失败时不影响已有绑定
+	service.ReleaseSessionAffinity(c, 8)
+	if _, ok := service.GetSessionAffinity(key); !ok {
+		t.Fatal("releasing another channel should keep the affinity")
+	}
+	service.ReleaseSessionAffinity(c, 7)
+	if _, ok := service.GetSessionAffinity(key); ok {
+		t.Fatal("affinity should be released after the pinned channel fails")
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -25,10 +26,10 @@ import (
 	"github.com/tiktoken-go/tokenizer/codec"
 )
 
-// tokenEncoderMap won't grow after initialization
+// defaultTokenEncoder is used when the configured default tokenizer can not be loaded
 var defaultTokenEncoder tokenizer.Codec
 
-// tokenEncoderMap is used to store token encoders for different models
+// tokenEncoderMap is used to store token encoders for OpenAI models without a configured tokenizer
 var tokenEncoderMap = make(map[string]tokenizer.Codec)
 
 // tokenEncoderMutex protects tokenEncoderMap for concurrent access
@@ -40,30 +41,52 @@ func InitTokenEncoders() {
 	common.SysLog("token encoders initialized")
 }
 
+// getDefaultTokenEncoder 返回配置的默认分词器，加载失败时使用 cl100k_base
+func getDefaultTokenEncoder() tokenizer.Codec {
+	if encoder, err := GetTokenizer(model_setting.GetTokenizerSettings().Default); err == nil {
+		return encoder
+	}
+	return defaultTokenEncoder
+}
+
 func getTokenEncoder(model string) tokenizer.Codec {
+	// 优先使用管理员配置的模型规则
+	if name := model_setting.GetTokenizerSettings().MatchTokenizer(model); name != "" {
+		if encoder, err := GetTokenizer(name); err == nil {
+			return encoder
+		}
+		return getDefaultTokenEncoder()
+	}
+
 	// First, try to get the encoder from cache with read lock
 	tokenEncoderMutex.RLock()
-	if encoder, exists := tokenEncoderMap[model]; exists {
-		tokenEncoderMutex.RUnlock()
+	encoder, exists := tokenEncoderMap[model]
+	tokenEncoderMutex.RUnlock()
+	if exists {
+		if encoder == nil {
+			return getDefaultTokenEncoder()
+		}
 		return encoder
 	}
-	tokenEncoderMutex.RUnlock()
 
 	// If not in cache, create new encoder with write lock
 	tokenEncoderMutex.Lock()
 	defer tokenEncoderMutex.Unlock()
 
 	// Double-check if another goroutine already created the encoder
-	if encoder, exists := tokenEncoderMap[model]; exists {
+	if encoder, exists = tokenEncoderMap[model]; exists {
+		if encoder == nil {
+			return getDefaultTokenEncoder()
+		}
 		return encoder
 	}
 
 	// Create new encoder
 	modelCodec, err := tokenizer.ForModel(tokenizer.Model(model))
 	if err != nil {
-		// Cache the default encoder for this model to avoid repeated failures
-		tokenEncoderMap[model] = defaultTokenEncoder
-		return defaultTokenEncoder
+		// Cache the failure to avoid repeated lookups, the default encoder is configurable
+		tokenEncoderMap[model] = nil
+		return getDefaultTokenEncoder()
 	}
 
 	// Cache the new encoder
@@ -466,6 +489,60 @@ func CountTokenClaudeTools(tools []dto.Tool, model string) (int, error) {
 	return tokenNum, nil
 }
 
+// CountTokenGeminiRequest 本地估算 Gemini 请求的输入 token 数，媒体按固定值计算
+func CountTokenGeminiRequest(request dto.GeminiChatRequest, model string) int {
+	tokenEncoder := getTokenEncoder(model)
+	tokenNum := 0
+
+	contents := request.Contents
+	if request.SystemInstructions != nil {
+		contents = append([]dto.GeminiChatContent{*request.SystemInstructions}, contents...)
+	}
+	for _, content := range contents {
+		for _, part := range content.Parts {
+			tokenNum += getTokenNum(tokenEncoder, part.Text)
+			mimeType := ""
+			if part.InlineData != nil {
+				mimeType = part.InlineData.MimeType
+			} else if part.FileData != nil {
+				mimeType = part.FileData.MimeType
+			}
+			if part.InlineData != nil || part.FileData != nil {
+				switch {
+				case strings.HasPrefix(mimeType, "image/"), strings.HasPrefix(mimeType, "audio/"):
+					tokenNum += 256
+				case strings.HasPrefix(mimeType, "video/"):
+					tokenNum += 4096 * 2
+				default:
+					tokenNum += 4096
+				}
+			}
+			if part.FunctionCall != nil {
+				tokenNum += getTokenNum(tokenEncoder, part.FunctionCall.FunctionName)
+				argsJSON, _ := common.Marshal(part.FunctionCall.Arguments)
+				tokenNum += getTokenNum(tokenEncoder, string(argsJSON))
+			}
+			if part.FunctionResponse != nil {
+				responseJSON, _ := common.Marshal(part.FunctionResponse)
+				tokenNum += getTokenNum(tokenEncoder, string(responseJSON))
+			}
+			if part.ExecutableCode != nil {
+				codeJSON, _ := common.Marshal(part.ExecutableCode)
+				tokenNum += getTokenNum(tokenEncoder, string(codeJSON))
+			}
+			if part.CodeExecutionResult != nil {
+				resultJSON, _ := common.Marshal(part.CodeExecutionResult)
+				tokenNum += getTokenNum(tokenEncoder, string(resultJSON))
+			}
+		}
+	}
+
+	if len(request.Tools) > 0 {
+		tokenNum += getTokenNum(tokenEncoder, string(request.Tools))
+	}
+	return tokenNum
+}
+
 func CountTokenRealtime(info *relaycommon.RelayInfo, request dto.RealtimeEvent, model string) (int, int, error) {
 	audioToken := 0
 	textToken := 0
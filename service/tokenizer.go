@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+const hfTokenizerPrefix = "hf:"
+
+type tokenizerEntry struct {
+	codec tokenizer.Codec
+	err   error
+}
+
+// tokenizerCache 按分词器名缓存，加载失败的结果同样缓存，避免重复读取磁盘
+var tokenizerCache = make(map[string]*tokenizerEntry)
+var tokenizerCacheLock sync.RWMutex
+
+// GetTokenizer 按名称获取分词器，首次使用时加载
+func GetTokenizer(name string) (tokenizer.Codec, error) {
+	tokenizerCacheLock.RLock()
+	entry, ok := tokenizerCache[name]
+	tokenizerCacheLock.RUnlock()
+	if ok {
+		return entry.codec, entry.err
+	}
+
+	tokenizerCacheLock.Lock()
+	defer tokenizerCacheLock.Unlock()
+	if entry, ok = tokenizerCache[name]; ok {
+		return entry.codec, entry.err
+	}
+	codec, err := loadTokenizer(name)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to load tokenizer %s: %s", name, err.Error()))
+	}
+	tokenizerCache[name] = &tokenizerEntry{codec: codec, err: err}
+	return codec, err
+}
+
+// ResetTokenizerCache 清空已加载的分词器，修改词表文件后调用
+func ResetTokenizerCache() {
+	tokenizerCacheLock.Lock()
+	defer tokenizerCacheLock.Unlock()
+	tokenizerCache = make(map[string]*tokenizerEntry)
+}
+
+func loadTokenizer(name string) (tokenizer.Codec, error) {
+	if !strings.HasPrefix(name, hfTokenizerPrefix) {
+		return tokenizer.Get(tokenizer.Encoding(name))
+	}
+	hfName := strings.TrimPrefix(name, hfTokenizerPrefix)
+	if hfName == "" || hfName != filepath.Base(hfName) || strings.HasPrefix(hfName, ".") {
+		return nil, fmt.Errorf("invalid tokenizer name %s", name)
+	}
+	dir := model_setting.GetTokenizerSettings().HuggingFaceDir
+	for _, path := range []string{
+		filepath.Join(dir, hfName, "tokenizer.json"),
+		filepath.Join(dir, hfName+".json"),
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return loadHfTokenizer(name, path)
+		}
+	}
+	return nil, errors.New("tokenizer.json not found in " + dir)
+}
+
+// TokenizeResult 分词调试结果
+type TokenizeResult struct {
+	Model     string   `json:"model"`
+	Tokenizer string   `json:"tokenizer"`
+	Count     int      `json:"count"`
+	Ids       []uint   `json:"ids"`
+	Tokens    []string `json:"tokens"`
+}
+
+// Tokenize 使用指定的分词器对文本分词，未指定时使用模型实际匹配到的分词器
+func Tokenize(model string, tokenizerName string, text string) (*TokenizeResult, error) {
+	var encoder tokenizer.Codec
+	if tokenizerName != "" {
+		var err error
+		encoder, err = GetTokenizer(tokenizerName)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		encoder = getTokenEncoder(model)
+	}
+	ids, tokens, err := encoder.Encode(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenizeResult{
+		Model:     model,
+		Tokenizer: encoder.GetName(),
+		Count:     len(ids),
+		Ids:       ids,
+		Tokens:    tokens,
+	}, nil
+}
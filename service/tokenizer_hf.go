@@ -0,0 +1,470 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/dlclark/regexp2"
+)
+
+// gpt2SplitPattern ByteLevel 预分词未指定 Split 规则时使用的 GPT-2 默认规则
+const gpt2SplitPattern = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+
+const metaspace = "▁"
+
+// hfTokenizer 从 HuggingFace tokenizer.json 加载的 BPE 分词器，实现 tokenizer.Codec
+// 支持 ByteLevel（GPT-2 / Llama 3 / Qwen / DeepSeek）与 Metaspace + byte fallback（Llama 2 / Gemma）两类，
+// 其余归一化与预分词规则忽略，结果用于估算
+type hfTokenizer struct {
+	name         string
+	vocab        map[string]uint
+	reverseVocab map[uint]string
+	mergeRanks   map[string]int
+	addedTokens  []string
+	splitRegexps []*regexp2.Regexp
+	byteLevel    bool
+	prependSpace bool
+	byteFallback bool
+	ignoreMerges bool
+	unkId        *uint
+	byteEncoder  map[byte]rune
+	byteDecoder  map[rune]byte
+}
+
+type hfTokenizerFile struct {
+	AddedTokens []struct {
+		Id      uint   `json:"id"`
+		Content string `json:"content"`
+	} `json:"added_tokens"`
+	Normalizer   json.RawMessage `json:"normalizer"`
+	PreTokenizer json.RawMessage `json:"pre_tokenizer"`
+	Decoder      json.RawMessage `json:"decoder"`
+	Model        struct {
+		Type         string          `json:"type"`
+		Vocab        map[string]uint `json:"vocab"`
+		Merges       json.RawMessage `json:"merges"`
+		ByteFallback bool            `json:"byte_fallback"`
+		IgnoreMerges bool            `json:"ignore_merges"`
+		UnkToken     *string         `json:"unk_token"`
+	} `json:"model"`
+}
+
+// hfComponent 归一化、预分词与解码配置中的单个组件
+type hfComponent struct {
+	Type    string `json:"type"`
+	Pattern struct {
+		Regex  string `json:"Regex"`
+		String string `json:"String"`
+	} `json:"pattern"`
+	Content        string `json:"content"`
+	Prepend        string `json:"prepend"`
+	PrependScheme  string `json:"prepend_scheme"`
+	AddPrefixSpace *bool  `json:"add_prefix_space"`
+	UseRegex       *bool  `json:"use_regex"`
+}
+
+// flattenHfComponents 展开 Sequence 组件
+func flattenHfComponents(raw json.RawMessage) []hfComponent {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var sequence struct {
+		Type          string            `json:"type"`
+		Normalizers   []json.RawMessage `json:"normalizers"`
+		PreTokenizers []json.RawMessage `json:"pretokenizers"`
+		Decoders      []json.RawMessage `json:"decoders"`
+	}
+	if err := common.Unmarshal(raw, &sequence); err != nil {
+		return nil
+	}
+	if sequence.Type != "Sequence" {
+		var component hfComponent
+		if err := common.Unmarshal(raw, &component); err != nil {
+			return nil
+		}
+		return []hfComponent{component}
+	}
+	var components []hfComponent
+	for _, children := range [][]json.RawMessage{sequence.Normalizers, sequence.PreTokenizers, sequence.Decoders} {
+		for _, child := range children {
+			components = append(components, flattenHfComponents(child)...)
+		}
+	}
+	return components
+}
+
+func loadHfTokenizer(name string, path string) (*hfTokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file hfTokenizerFile
+	if err = common.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid tokenizer.json: %w", err)
+	}
+	if file.Model.Type != "" && file.Model.Type != "BPE" {
+		return nil, fmt.Errorf("unsupported tokenizer model type %s, only BPE is supported", file.Model.Type)
+	}
+	if len(file.Model.Vocab) == 0 {
+		return nil, errors.New("tokenizer.json has empty vocab")
+	}
+
+	t := &hfTokenizer{
+		name:         name,
+		vocab:        file.Model.Vocab,
+		mergeRanks:   make(map[string]int),
+		byteFallback: file.Model.ByteFallback,
+		ignoreMerges: file.Model.IgnoreMerges,
+	}
+	if err = t.loadMerges(file.Model.Merges); err != nil {
+		return nil, err
+	}
+	for _, added := range file.AddedTokens {
+		if added.Content == "" {
+			continue
+		}
+		t.vocab[added.Content] = added.Id
+		t.addedTokens = append(t.addedTokens, added.Content)
+	}
+	// 优先匹配更长的特殊 token
+	sort.Slice(t.addedTokens, func(i, j int) bool {
+		return len(t.addedTokens[i]) > len(t.addedTokens[j])
+	})
+	if file.Model.UnkToken != nil {
+		if id, ok := t.vocab[*file.Model.UnkToken]; ok {
+			t.unkId = &id
+		}
+	}
+
+	useGPT2Regex := false
+	for _, component := range flattenHfComponents(file.PreTokenizer) {
+		switch component.Type {
+		case "ByteLevel":
+			t.byteLevel = true
+			if component.UseRegex == nil || *component.UseRegex {
+				useGPT2Regex = true
+			}
+		case "Split":
+			pattern := component.Pattern.Regex
+			if pattern == "" {
+				pattern = regexp2.Escape(component.Pattern.String)
+			}
+			splitRegexp, err := regexp2.Compile(pattern, regexp2.None)
+			if err != nil {
+				return nil, fmt.Errorf("invalid split pattern %q: %w", pattern, err)
+			}
+			t.splitRegexps = append(t.splitRegexps, splitRegexp)
+		case "Metaspace":
+			t.prependSpace = component.PrependScheme != "never" &&
+				(component.AddPrefixSpace == nil || *component.AddPrefixSpace)
+		}
+	}
+	for _, component := range flattenHfComponents(file.Normalizer) {
+		if component.Type == "Prepend" && component.Prepend == metaspace {
+			t.prependSpace = true
+		}
+	}
+	for _, component := range flattenHfComponents(file.Decoder) {
+		if component.Type == "ByteLevel" {
+			t.byteLevel = true
+		}
+	}
+	t.reverseVocab = make(map[uint]string, len(t.vocab))
+	for token, id := range t.vocab {
+		t.reverseVocab[id] = token
+	}
+	if useGPT2Regex {
+		t.splitRegexps = append(t.splitRegexps, regexp2.MustCompile(gpt2SplitPattern, regexp2.None))
+	}
+	if t.byteLevel {
+		t.byteEncoder, t.byteDecoder = bytesToUnicode()
+	}
+	return t, nil
+}
+
+// loadMerges merges 有 ["a b"] 与 [["a", "b"]] 两种格式
+func (t *hfTokenizer) loadMerges(raw json.RawMessage) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	var merges []string
+	if err := common.Unmarshal(raw, &merges); err == nil {
+		for rank, merge := range merges {
+			t.mergeRanks[merge] = rank
+		}
+		return nil
+	}
+	var pairs [][]string
+	if err := common.Unmarshal(raw, &pairs); err != nil {
+		return fmt.Errorf("invalid merges: %w", err)
+	}
+	for rank, pair := range pairs {
+		if len(pair) == 2 {
+			t.mergeRanks[pair[0]+" "+pair[1]] = rank
+		}
+	}
+	return nil
+}
+
+// bytesToUnicode GPT-2 的字节到可见字符映射
+func bytesToUnicode() (map[byte]rune, map[rune]byte) {
+	encoder := make(map[byte]rune, 256)
+	decoder := make(map[rune]byte, 256)
+	n := 0
+	for b := 0; b < 256; b++ {
+		r := rune(b)
+		if !((b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF)) {
+			r = rune(256 + n)
+			n++
+		}
+		encoder[byte(b)] = r
+		decoder[r] = byte(b)
+	}
+	return encoder, decoder
+}
+
+func (t *hfTokenizer) GetName() string {
+	return t.name
+}
+
+func (t *hfTokenizer) Count(input string) (int, error) {
+	count := 0
+	err := t.tokenize(input, func(uint, string) {
+		count++
+	})
+	return count, err
+}
+
+func (t *hfTokenizer) Encode(input string) ([]uint, []string, error) {
+	var ids []uint
+	var tokens []string
+	err := t.tokenize(input, func(id uint, token string) {
+		ids = append(ids, id)
+		tokens = append(tokens, token)
+	})
+	return ids, tokens, err
+}
+
+func (t *hfTokenizer) Decode(tokens []uint) (string, error) {
+	var out []byte
+	for _, id := range tokens {
+		token, ok := t.reverseVocab[id]
+		if !ok {
+			return "", fmt.Errorf("invalid token: %d", id)
+		}
+		switch {
+		case t.byteLevel:
+			for _, r := range token {
+				if b, ok := t.byteDecoder[r]; ok {
+					out = append(out, b)
+				} else {
+					out = utf8.AppendRune(out, r)
+				}
+			}
+		case t.byteFallback && len(token) == 6 && strings.HasPrefix(token, "<0x") && strings.HasSuffix(token, ">"):
+			b, err := strconv.ParseUint(token[3:5], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid byte token: %s", token)
+			}
+			out = append(out, byte(b))
+		default:
+			out = append(out, strings.ReplaceAll(token, metaspace, " ")...)
+		}
+	}
+	text := string(out)
+	if t.prependSpace {
+		text = strings.TrimPrefix(text, " ")
+	}
+	return text, nil
+}
+
+func (t *hfTokenizer) tokenize(input string, yield func(uint, string)) error {
+	for _, segment := range t.splitAddedTokens(input) {
+		if segment.added {
+			yield(t.vocab[segment.text], segment.text)
+			continue
+		}
+		pieces, err := t.preTokenize(segment.text)
+		if err != nil {
+			return err
+		}
+		for _, piece := range pieces {
+			t.encodePiece(piece, yield)
+		}
+	}
+	return nil
+}
+
+type hfSegment struct {
+	text  string
+	added bool
+}
+
+// splitAddedTokens 特殊 token 原样保留，不参与 BPE
+func (t *hfTokenizer) splitAddedTokens(input string) []hfSegment {
+	var segments []hfSegment
+	for input != "" {
+		index, token := -1, ""
+		for _, added := range t.addedTokens {
+			if i := strings.Index(input, added); i >= 0 && (index < 0 || i < index) {
+				index, token = i, added
+			}
+		}
+		if index < 0 {
+			segments = append(segments, hfSegment{text: input})
+			break
+		}
+		if index > 0 {
+			segments = append(segments, hfSegment{text: input[:index]})
+		}
+		segments = append(segments, hfSegment{text: token, added: true})
+		input = input[index+len(token):]
+	}
+	return segments
+}
+
+func (t *hfTokenizer) preTokenize(text string) ([]string, error) {
+	// Metaspace 类分词器忽略 Split 规则，统一按 ▁ 切分
+	if !t.byteLevel {
+		text = strings.ReplaceAll(text, " ", metaspace)
+		if t.prependSpace && !strings.HasPrefix(text, metaspace) {
+			text = metaspace + text
+		}
+		return splitMetaspace(text), nil
+	}
+	pieces := []string{text}
+	for _, splitRegexp := range t.splitRegexps {
+		var next []string
+		for _, piece := range pieces {
+			split, err := splitIsolated(splitRegexp, piece)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, split...)
+		}
+		pieces = next
+	}
+	if t.byteLevel {
+		for i, piece := range pieces {
+			var builder strings.Builder
+			for _, b := range []byte(piece) {
+				builder.WriteRune(t.byteEncoder[b])
+			}
+			pieces[i] = builder.String()
+		}
+	}
+	return pieces, nil
+}
+
+// splitIsolated 按正则切分，匹配部分与未匹配部分都保留为独立片段
+func splitIsolated(splitRegexp *regexp2.Regexp, text string) ([]string, error) {
+	runes := []rune(text)
+	var pieces []string
+	last := 0
+	match, err := splitRegexp.FindStringMatch(text)
+	for match != nil && err == nil {
+		if match.Index > last {
+			pieces = append(pieces, string(runes[last:match.Index]))
+		}
+		if match.Length > 0 {
+			pieces = append(pieces, match.String())
+		}
+		last = match.Index + match.Length
+		match, err = splitRegexp.FindNextMatch(match)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error matching: %w", err)
+	}
+	if last < len(runes) {
+		pieces = append(pieces, string(runes[last:]))
+	}
+	return pieces, nil
+}
+
+// splitMetaspace 在每个词首的 ▁ 前切分，避免对整段文本做 BPE
+func splitMetaspace(text string) []string {
+	var pieces []string
+	start := 0
+	previousIsSpace := false
+	for i, r := range text {
+		isSpace := string(r) == metaspace
+		if isSpace && !previousIsSpace && i > start {
+			pieces = append(pieces, text[start:i])
+			start = i
+		}
+		previousIsSpace = isSpace
+	}
+	if start < len(text) {
+		pieces = append(pieces, text[start:])
+	}
+	return pieces
+}
+
+// encodePiece 对单个片段执行 BPE 合并
+func (t *hfTokenizer) encodePiece(piece string, yield func(uint, string)) {
+	if piece == "" {
+		return
+	}
+	if t.ignoreMerges {
+		if id, ok := t.vocab[piece]; ok {
+			yield(id, piece)
+			return
+		}
+	}
+	symbols := make([]string, 0, len(piece))
+	for _, r := range piece {
+		symbols = append(symbols, string(r))
+	}
+	for len(symbols) > 1 {
+		minRank, minIndex := math.MaxInt, -1
+		for i := 0; i < len(symbols)-1; i++ {
+			if rank, ok := t.mergeRanks[symbols[i]+" "+symbols[i+1]]; ok && rank < minRank {
+				minRank, minIndex = rank, i
+			}
+		}
+		if minIndex < 0 {
+			break
+		}
+		symbols[minIndex] += symbols[minIndex+1]
+		symbols = append(symbols[:minIndex+1], symbols[minIndex+2:]...)
+	}
+	for _, symbol := range symbols {
+		if id, ok := t.vocab[symbol]; ok {
+			yield(id, symbol)
+			continue
+		}
+		// 与 HuggingFace 一致，只有全部字节都在词表中时才拆为字节 token，否则视为未知
+		if t.byteFallback && t.yieldBytes(symbol, yield) {
+			continue
+		}
+		if t.unkId != nil {
+			yield(*t.unkId, symbol)
+			continue
+		}
+		yield(0, symbol)
+	}
+}
+
+func (t *hfTokenizer) yieldBytes(symbol string, yield func(uint, string)) bool {
+	tokens := make([]string, 0, len(symbol))
+	for _, b := range []byte(symbol) {
+		token := fmt.Sprintf("<0x%02X>", b)
+		if _, ok := t.vocab[token]; !ok {
+			return false
+		}
+		tokens = append(tokens, token)
+	}
+	for _, token := range tokens {
+		yield(t.vocab[token], token)
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package service_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+func useTestTokenizers(t *testing.T) {
+	t.Helper()
+	setting := model_setting.GetTokenizerSettings()
+	saved := setting.HuggingFaceDir
+	t.Cleanup(func() {
+		setting.HuggingFaceDir = saved
+		service.ResetTokenizerCache()
+	})
+	setting.HuggingFaceDir = "testdata/tokenizers"
+	service.ResetTokenizerCache()
+}
+
+// 期望的 token id 按 HuggingFace tokenizers 对同一 tokenizer.json 的编码结果
+func TestHfTokenizerEncode(t *testing.T) {
+	useTestTokenizers(t)
+
+	cases := []struct {
+		tokenizer string
+		text      string
+		ids       []uint
+	}{
+		// 按合并优先级而不是从左到右合并
+		{"hf:bytelevel", "hello world!", []uint{17, 22, 7}},
+		// 连续空格中最后一个空格归入下一个词
+		{"hf:bytelevel", "hello  world", []uint{17, 8, 22}},
+		{"hf:bytelevel", "hello 2025", []uint{17, 8, 9, 10, 9, 11}},
+		{"hf:bytelevel", "é", []uint{23}},
+		{"hf:bytelevel", "hello<|end|>hello", []uint{17, 100, 17}},
+		{"hf:bytelevel", "", nil},
+		{"hf:metaspace", "hello world", []uint{17, 22}},
+		// 词表外的字符拆为字节 token
+		{"hf:metaspace", "hi 你", []uint{23, 4, 1, 2, 3}},
+		// 字节 token 不全时使用 <unk>
+		{"hf:metaspace", "hü", []uint{13, 0}},
+	}
+	for _, tc := range cases {
+		result, err := service.Tokenize("test-model", tc.tokenizer, tc.text)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tc.tokenizer, tc.text, err)
+		}
+		if !slices.Equal(result.Ids, tc.ids) || result.Count != len(tc.ids) {
+			t.Errorf("%s %q: ids = %v (%v), want %v", tc.tokenizer, tc.text, result.Ids, result.Tokens, tc.ids)
+		}
+	}
+}
+
+func TestHfTokenizerDecode(t *testing.T) {
+	useTestTokenizers(t)
+
+	for _, tc := range []struct {
+		tokenizer string
+		text      string
+	}{
+		{"hf:bytelevel", "hello  world!é"},
+		{"hf:metaspace", "hi 你"},
+	} {
+		encoder, err := service.GetTokenizer(tc.tokenizer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, _, err := encoder.Encode(tc.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text, err := encoder.Decode(ids); err != nil || text != tc.text {
+			t.Errorf("%s: decoded %q, %v, want %q", tc.tokenizer, text, err, tc.text)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+const (
+	toolCallOpenTag  = "<tool_call>"
+	toolCallCloseTag = "</tool_call>"
+)
+
+// ApplyToolCallEmulation 将 tools 转换为提示词模板，并把历史中的 tool_calls 与 tool 消息改写为普通文本，
+// 用于不支持原生函数调用的上游
+func ApplyToolCallEmulation(request *dto.GeneralOpenAIRequest) {
+	toolChoice := toolCallEmulationChoice(request.ToolChoice)
+	tools := request.Tools
+	parallel := request.ParallelTooCalls == nil || *request.ParallelTooCalls
+	request.Tools = nil
+	request.ToolChoice = nil
+	request.ParallelTooCalls = nil
+
+	toolNames := make(map[string]string)
+	messages := make([]dto.Message, 0, len(request.Messages))
+	for _, message := range request.Messages {
+		switch {
+		case message.Role == "assistant" && len(message.ToolCalls) > 0:
+			var sb strings.Builder
+			sb.WriteString(message.StringContent())
+			for _, toolCall := range message.ParseToolCalls() {
+				toolNames[toolCall.ID] = toolCall.Function.Name
+				if sb.Len() > 0 {
+					sb.WriteString("\n")
+				}
+				sb.WriteString(formatEmulatedToolCall(toolCall.Function.Name, toolCall.Function.Arguments))
+			}
+			messages = append(messages, dto.Message{Role: "assistant", Content: sb.String()})
+		case message.Role == "tool":
+			name := toolNames[message.ToolCallId]
+			if name == "" && message.Name != nil {
+				name = *message.Name
+			}
+			content := fmt.Sprintf("<tool_result name=\"%s\">\n%s\n</tool_result>", name, message.StringContent())
+			// 连续的多个工具结果合并为一条 user 消息，避免部分上游拒绝连续的同角色消息
+			if last := len(messages) - 1; last >= 0 && messages[last].Role == "user" && strings.HasPrefix(messages[last].StringContent(), "<tool_result") {
+				messages[last].SetStringContent(messages[last].StringContent() + "\n" + content)
+				continue
+			}
+			messages = append(messages, dto.Message{Role: "user", Content: content})
+		default:
+			messages = append(messages, message)
+		}
+	}
+	request.Messages = messages
+
+	if len(tools) == 0 || toolChoice == "none" {
+		return
+	}
+	request.AppendSystemPrompt(ToolCallEmulationPrompt(tools, toolChoice, parallel))
+}
+
+// ToolCallEmulationPrompt 生成描述可用工具及调用格式的系统提示
+// toolChoice 为 auto、required 或指定的函数名
+func ToolCallEmulationPrompt(tools []dto.ToolCallRequest, toolChoice string, parallel bool) string {
+	var sb strings.Builder
+	sb.WriteString("You have access to the following tools:\n\n")
+	for _, tool := range tools {
+		sb.WriteString("- " + tool.Function.Name)
+		if tool.Function.Description != "" {
+			sb.WriteString(": " + tool.Function.Description)
+		}
+		sb.WriteString("\n")
+		if tool.Function.Parameters != nil {
+			if parameters, err := common.Marshal(tool.Function.Parameters); err == nil {
+				sb.WriteString("  Parameters JSON Schema: " + string(parameters) + "\n")
+			}
+		}
+	}
+	sb.WriteString("\nTo call a tool, output a block in exactly this format:\n")
+	sb.WriteString(toolCallOpenTag + "\n{\"name\": \"<tool name>\", \"arguments\": {<arguments as JSON object>}}\n" + toolCallCloseTag + "\n")
+	if parallel {
+		sb.WriteString("You may output several such blocks to call multiple tools in parallel. ")
+	} else {
+		sb.WriteString("Call at most one tool per response. ")
+	}
+	sb.WriteString("After the tool call blocks, stop and wait: the results will be sent back to you inside <tool_result> blocks. ")
+	switch toolChoice {
+	case "auto", "":
+		sb.WriteString("If no tool is needed, answer the user directly without any tool call block.")
+	case "required":
+		sb.WriteString("You must call at least one tool in this response.")
+	default:
+		sb.WriteString(fmt.Sprintf("You must call the tool \"%s\" in this response.", toolChoice))
+	}
+	return sb.String()
+}
+
+func toolCallEmulationChoice(toolChoice any) string {
+	switch v := toolChoice.(type) {
+	case string:
+		return v
+	case map[string]any:
+		if function, ok := v["function"].(map[string]any); ok {
+			if name, ok := function["name"].(string); ok && name != "" {
+				return name
+			}
+		}
+	}
+	return "auto"
+}
+
+func formatEmulatedToolCall(name string, arguments string) string {
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	return fmt.Sprintf("%s\n{\"name\": %q, \"arguments\": %s}\n%s", toolCallOpenTag, name, arguments, toolCallCloseTag)
+}
+
+// ParseEmulatedToolCalls 从完整的模型输出中解析工具调用，返回剩余的文本内容
+func ParseEmulatedToolCalls(content string) (string, []dto.ToolCallResponse) {
+	parser := &ToolCallParser{}
+	text, toolCalls := parser.Feed(content)
+	restText, restToolCalls := parser.Finish()
+	toolCalls = append(toolCalls, restToolCalls...)
+	for i := range toolCalls {
+		toolCalls[i].Index = nil
+	}
+	return strings.TrimSpace(text + restText), toolCalls
+}
+
+// ToolCallParser 流式解析模型输出中的 <tool_call> 块，正文按原样输出，
+// 可能是标签开头的片段会暂存，直到能确定是否为工具调用
+type ToolCallParser struct {
+	pending string
+	buffer  strings.Builder
+	inside  bool
+	// 已解析出的工具调用数量，用作流式输出的 index
+	Count int
+}
+
+// Feed 输入一段增量文本，返回可以输出的正文以及已经完整的工具调用
+func (p *ToolCallParser) Feed(delta string) (string, []dto.ToolCallResponse) {
+	p.pending += delta
+	var text strings.Builder
+	var toolCalls []dto.ToolCallResponse
+	for {
+		if p.inside {
+			index := strings.Index(p.pending, toolCallCloseTag)
+			if index < 0 {
+				return text.String(), toolCalls
+			}
+			p.buffer.WriteString(p.pending[:index])
+			p.pending = p.pending[index+len(toolCallCloseTag):]
+			p.inside = false
+			if toolCall, ok := p.parseToolCall(p.buffer.String()); ok {
+				toolCalls = append(toolCalls, toolCall)
+			} else {
+				text.WriteString(toolCallOpenTag + p.buffer.String() + toolCallCloseTag)
+			}
+			p.buffer.Reset()
+			continue
+		}
+		index := strings.Index(p.pending, toolCallOpenTag)
+		if index >= 0 {
+			text.WriteString(p.pending[:index])
+			p.pending = p.pending[index+len(toolCallOpenTag):]
+			p.inside = true
+			continue
+		}
+		// 末尾可能是未完整的开始标签，暂不输出
+		keep := 0
+		for i := min(len(toolCallOpenTag)-1, len(p.pending)); i > 0; i-- {
+			if strings.HasSuffix(p.pending, toolCallOpenTag[:i]) {
+				keep = i
+				break
+			}
+		}
+		text.WriteString(p.pending[:len(p.pending)-keep])
+		p.pending = p.pending[len(p.pending)-keep:]
+		return text.String(), toolCalls
+	}
+}
+
+// Finish 输出结束时调用，未闭合的工具调用块能解析则作为调用返回，否则按正文输出
+func (p *ToolCallParser) Finish() (string, []dto.ToolCallResponse) {
+	defer func() {
+		p.pending = ""
+		p.inside = false
+		p.buffer.Reset()
+	}()
+	if !p.inside {
+		return p.pending, nil
+	}
+	body := p.buffer.String() + p.pending
+	if toolCall, ok := p.parseToolCall(body); ok {
+		return "", []dto.ToolCallResponse{toolCall}
+	}
+	return toolCallOpenTag + body, nil
+}
+
+func (p *ToolCallParser) parseToolCall(body string) (dto.ToolCallResponse, bool) {
+	value, _, err := ParseJsonOutput(body)
+	if err != nil {
+		return dto.ToolCallResponse{}, false
+	}
+	call, ok := value.(map[string]any)
+	if !ok {
+		return dto.ToolCallResponse{}, false
+	}
+	name, _ := call["name"].(string)
+	if name == "" {
+		return dto.ToolCallResponse{}, false
+	}
+	arguments := "{}"
+	switch args := call["arguments"].(type) {
+	case string:
+		arguments = args
+	case nil:
+	default:
+		if data, err := common.Marshal(args); err == nil {
+			arguments = string(data)
+		}
+	}
+	toolCall := dto.ToolCallResponse{
+		ID:   "call_" + common.GetUUID(),
+		Type: "function",
+		Function: dto.FunctionResponse{
+			Name:      name,
+			Arguments: arguments,
+		},
+	}
+	toolCall.SetIndex(p.Count)
+	p.Count++
+	return toolCall, true
+}
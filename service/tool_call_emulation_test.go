@@ -0,0 +1,136 @@
+package service_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/service"
+)
+
+// feedAll 依次输入每段增量，返回拼接的正文与全部工具调用
+func feedAll(parser *service.ToolCallParser, deltas ...string) (string, []dto.ToolCallResponse) {
+	var text strings.Builder
+	var toolCalls []dto.ToolCallResponse
+	for _, delta := range deltas {
+		t, calls := parser.Feed(delta)
+		text.WriteString(t)
+		toolCalls = append(toolCalls, calls...)
+	}
+	t, calls := parser.Finish()
+	text.WriteString(t)
+	return text.String(), append(toolCalls, calls...)
+}
+
+func TestToolCallParserSplitChunks(t *testing.T) {
+	parser := &service.ToolCallParser{}
+	// 开始标签、JSON 与结束标签都被拆到不同的分片中
+	text, held := parser.Feed("Let me check.<tool")
+	if text != "Let me check." || held != nil {
+		t.Fatalf("a possible open tag should be held back, got %q", text)
+	}
+	text, toolCalls := feedAll(parser, "_call>\n{\"name\": \"get_", "weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool", "_call>")
+	if text != "" || len(toolCalls) != 1 {
+		t.Fatalf("text = %q, tool calls = %+v", text, toolCalls)
+	}
+	call := toolCalls[0]
+	if call.Function.Name != "get_weather" || call.Function.Arguments != `{"city":"Paris"}` ||
+		call.Type != "function" || !strings.HasPrefix(call.ID, "call_") || call.Index == nil || *call.Index != 0 {
+		t.Fatalf("unexpected tool call: %+v", call)
+	}
+
+	// 看起来像标签开头但不是标签的文本原样输出
+	text, toolCalls = feedAll(&service.ToolCallParser{}, "if a <", "b then <tool", "s>")
+	if text != "if a <b then <tools>" || len(toolCalls) != 0 {
+		t.Fatalf("text = %q, tool calls = %+v", text, toolCalls)
+	}
+}
+
+func TestToolCallParserMultipleCalls(t *testing.T) {
+	content := "Checking both.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>\n" +
+		"<tool_call>\n{\"name\": \"get_time\", \"arguments\": \"{\\\"tz\\\":\\\"CET\\\"}\"}\n</tool_call>"
+	parser := &service.ToolCallParser{}
+	_, toolCalls := feedAll(parser, content)
+	if len(toolCalls) != 2 || parser.Count != 2 || *toolCalls[0].Index != 0 || *toolCalls[1].Index != 1 {
+		t.Fatalf("tool calls = %+v, want indexes 0 and 1", toolCalls)
+	}
+	if toolCalls[0].ID == toolCalls[1].ID {
+		t.Fatal("tool call ids should be unique")
+	}
+
+	// 非流式解析去掉 index，字符串形式的参数原样保留
+	text, toolCalls := service.ParseEmulatedToolCalls(content)
+	if text != "Checking both." || len(toolCalls) != 2 {
+		t.Fatalf("text = %q, tool calls = %+v", text, toolCalls)
+	}
+	if toolCalls[0].Index != nil || toolCalls[1].Function.Name != "get_time" || toolCalls[1].Function.Arguments != `{"tz":"CET"}` {
+		t.Fatalf("unexpected tool calls: %+v", toolCalls)
+	}
+}
+
+func TestToolCallParserMalformedBlocks(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantText  string
+		wantCalls int
+	}{
+		{"not json", "<tool_call>not json</tool_call>", "<tool_call>not json</tool_call>", 0},
+		{"missing name", `<tool_call>{"arguments": {}}</tool_call>`, `<tool_call>{"arguments": {}}</tool_call>`, 0},
+		{"unclosed valid", `<tool_call>{"name": "ping"}`, "", 1},
+		{"unclosed invalid", `done <tool_call>{"name": `, `done <tool_call>{"name": `, 0},
+		{"fenced json", "<tool_call>\n```json\n{\"name\": \"ping\"}\n```\n</tool_call>", "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := &service.ToolCallParser{}
+			text, toolCalls := feedAll(parser, tt.content)
+			if text != tt.wantText || len(toolCalls) != tt.wantCalls {
+				t.Fatalf("text = %q, tool calls = %+v, want %q and %d calls", text, toolCalls, tt.wantText, tt.wantCalls)
+			}
+			// 缺省参数补为空对象
+			if tt.wantCalls == 1 && toolCalls[0].Function.Arguments != "{}" {
+				t.Fatalf("arguments = %q, want {}", toolCalls[0].Function.Arguments)
+			}
+		})
+	}
+}
+
+func TestApplyToolCallEmulation(t *testing.T) {
+	name := "get_weather"
+	request := &dto.GeneralOpenAIRequest{
+		Messages: []dto.Message{
+			{Role: "user", Content: "weather in Paris and Rome?"},
+			{Role: "assistant", ToolCalls: []byte(`[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},` +
+				`{"id":"call_2","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Rome\"}"}}]`)},
+			{Role: "tool", ToolCallId: "call_1", Content: "21C"},
+			{Role: "tool", ToolCallId: "call_2", Name: &name, Content: "25C"},
+		},
+		Tools:      []dto.ToolCallRequest{{Type: "function", Function: dto.FunctionRequest{Name: "get_weather", Description: "Get the weather"}}},
+		ToolChoice: "required",
+	}
+	service.ApplyToolCallEmulation(request)
+
+	if request.Tools != nil || request.ToolChoice != nil {
+		t.Fatal("tools should be moved into the prompt")
+	}
+	if len(request.Messages) != 4 || request.Messages[0].Role != "system" {
+		t.Fatalf("messages = %+v, want system, user, assistant and merged tool results", request.Messages)
+	}
+	prompt := request.Messages[0].StringContent()
+	if !strings.Contains(prompt, "- get_weather: Get the weather") || !strings.Contains(prompt, "must call at least one tool") {
+		t.Fatalf("prompt = %q", prompt)
+	}
+	assistant := request.Messages[2]
+	if assistant.Role != "assistant" || strings.Count(assistant.StringContent(), "<tool_call>") != 2 {
+		t.Fatalf("assistant history = %q", assistant.StringContent())
+	}
+	// 历史调用改写后可被解析回原来的调用
+	if _, toolCalls := service.ParseEmulatedToolCalls(assistant.StringContent()); len(toolCalls) != 2 || toolCalls[1].Function.Arguments != `{"city":"Rome"}` {
+		t.Fatalf("rewritten history does not round trip: %+v", toolCalls)
+	}
+	results := request.Messages[3]
+	if results.Role != "user" || !strings.Contains(results.StringContent(), "<tool_result name=\"get_weather\">\n21C\n</tool_result>\n<tool_result") {
+		t.Fatalf("tool results = %q", results.StringContent())
+	}
+}
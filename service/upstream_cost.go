@@ -0,0 +1,100 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// UpstreamCostUsage 计算上游成本所需的用量，PromptTokens 不含缓存与音频
+type UpstreamCostUsage struct {
+	PromptTokens          int
+	CacheTokens           int
+	CacheCreationTokens   int
+	CompletionTokens      int
+	AudioPromptTokens     int
+	AudioCompletionTokens int
+	// OtherRatio 按次计费任务的计算参数乘积（如视频时长），0 视为 1
+	OtherRatio float64
+}
+
+const negativeMarginAlertInterval = time.Hour
+
+// negativeMarginAlerts 渠道+模型 -> 上次告警时间，避免每个请求都查询并通知 root 用户
+var negativeMarginAlerts sync.Map
+
+// CalculateUpstreamCost 按渠道的上游成本配置计算本次请求的成本（额度单位），渠道未配置时返回 false
+func CalculateUpstreamCost(relayInfo *relaycommon.RelayInfo, usage UpstreamCostUsage) (int, bool) {
+	if relayInfo.ChannelMeta == nil || relayInfo.ChannelSetting.UpstreamCost == nil {
+		return 0, false
+	}
+	costSetting := relayInfo.ChannelSetting.UpstreamCost
+	multiplier := costSetting.Multiplier
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+	modelName := relayInfo.UpstreamModelName
+	if modelName == "" {
+		modelName = relayInfo.OriginModelName
+	}
+	// 用户价格覆盖与时段系数只影响收费，成本按覆盖前的全局价格估算
+	basePrice := relayInfo.PriceData.BasePrice
+	otherRatio := usage.OtherRatio
+	if otherRatio <= 0 {
+		otherRatio = 1
+	}
+
+	if price, ok := costSetting.ModelPrice[modelName]; ok {
+		return int(math.Round(price * common.QuotaPerUnit * otherRatio)), true
+	}
+	modelRatio := basePrice.ModelRatio * multiplier
+	completionRatio := basePrice.CompletionRatio
+	if ratio, ok := costSetting.ModelRatio[modelName]; ok {
+		modelRatio = ratio
+	} else if basePrice.UsePrice {
+		return int(math.Round(basePrice.ModelPrice * common.QuotaPerUnit * multiplier * otherRatio)), true
+	}
+	if ratio, ok := costSetting.CompletionRatio[modelName]; ok {
+		completionRatio = ratio
+	}
+	cost := float64(usage.PromptTokens) +
+		float64(usage.CacheTokens)*basePrice.CacheRatio +
+		float64(usage.CacheCreationTokens)*basePrice.CacheCreationRatio +
+		float64(usage.CompletionTokens)*completionRatio
+	if usage.AudioPromptTokens > 0 || usage.AudioCompletionTokens > 0 {
+		audioRatio := ratio_setting.GetAudioRatio(relayInfo.OriginModelName)
+		audioCompletionRatio := ratio_setting.GetAudioCompletionRatio(relayInfo.OriginModelName)
+		cost += float64(usage.AudioPromptTokens)*audioRatio +
+			float64(usage.AudioCompletionTokens)*audioRatio*audioCompletionRatio
+	}
+	return int(math.Round(cost * modelRatio)), true
+}
+
+// CheckNegativeMargin 收费低于上游成本时通知 root 用户，同一渠道同一模型每小时最多一次
+func CheckNegativeMargin(relayInfo *relaycommon.RelayInfo, quota int, cost int) {
+	if cost <= 0 || quota >= cost {
+		return
+	}
+	key := fmt.Sprintf("%d_%s", relayInfo.ChannelId, relayInfo.OriginModelName)
+	now := time.Now()
+	if last, ok := negativeMarginAlerts.Load(key); ok && now.Sub(last.(time.Time)) < negativeMarginAlertInterval {
+		return
+	}
+	negativeMarginAlerts.Store(key, now)
+	subject := fmt.Sprintf("模型 %s 在渠道 #%d 亏损", relayInfo.OriginModelName, relayInfo.ChannelId)
+	content := fmt.Sprintf("模型 %s 通过渠道 #%d 服务时收费低于上游成本：分组 %s 收费 %s，上游成本 %s，请检查模型倍率或渠道成本配置",
+		relayInfo.OriginModelName, relayInfo.ChannelId, relayInfo.UsingGroup,
+		logger.LogQuota(quota), logger.LogQuota(cost))
+	gopool.Go(func() {
+		NotifyRootUser(fmt.Sprintf("%s_%s", dto.NotifyTypeNegativeMargin, key), subject, content)
+	})
+}
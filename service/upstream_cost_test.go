@@ -0,0 +1,90 @@
+package service_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+func newUpstreamCostRelayInfo(priceData types.PriceData) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		OriginModelName: "gpt-4o",
+		PriceData:       priceData,
+		ChannelMeta: &relaycommon.ChannelMeta{
+			UpstreamModelName: "gpt-4o",
+			ChannelSetting:    dto.ChannelSettings{UpstreamCost: &dto.UpstreamCostSettings{Multiplier: 0.5}},
+		},
+	}
+}
+
+func TestCalculateUpstreamCostIgnoresPriceOverride(t *testing.T) {
+	usage := service.UpstreamCostUsage{PromptTokens: 1000, CacheTokens: 200, CompletionTokens: 100}
+	base := types.BasePriceData{ModelRatio: 1.25, CompletionRatio: 4, CacheRatio: 0.5}
+	// 0.5 * 1.25 * (1000 + 200*0.5 + 100*4)
+	const want = 938
+
+	cases := map[string]types.PriceData{
+		"no override": {ModelRatio: 1.25, CompletionRatio: 4, CacheRatio: 0.5, BasePrice: base},
+		// 固定价格覆盖按次收费，成本仍按全局倍率估算
+		"fixed price override": {UsePrice: true, ModelPrice: 0.02, BasePrice: base,
+			PriceOverride: &types.PriceOverrideInfo{Type: types.PriceOverrideTypePrice, Value: 0.02}},
+		// 系数为 0 的覆盖对用户免费，但上游仍有成本
+		"zero ratio override": {ModelRatio: 0, CompletionRatio: 4, CacheRatio: 0.5, BasePrice: base,
+			PriceOverride: &types.PriceOverrideInfo{Type: types.PriceOverrideTypeRatio, Value: 0}},
+		"ratio override": {ModelRatio: 2.5, CompletionRatio: 4, CacheRatio: 0.5, BasePrice: base,
+			PriceOverride: &types.PriceOverrideInfo{Type: types.PriceOverrideTypeRatio, Value: 2}},
+		// 时段系数只影响收费，不计入上游成本
+		"pricing window": {ModelRatio: 0.625, CompletionRatio: 4, CacheRatio: 0.5, BasePrice: base,
+			PricingWindow: &types.PricingWindowInfo{Name: "night", Multiplier: 0.5}},
+		"ratio override in pricing window": {ModelRatio: 1.25, CompletionRatio: 4, CacheRatio: 0.5, BasePrice: base,
+			PriceOverride: &types.PriceOverrideInfo{Type: types.PriceOverrideTypeRatio, Value: 2},
+			PricingWindow: &types.PricingWindowInfo{Name: "night", Multiplier: 0.5}},
+	}
+	for name, priceData := range cases {
+		cost, tracked := service.CalculateUpstreamCost(newUpstreamCostRelayInfo(priceData), usage)
+		if !tracked || cost != want {
+			t.Errorf("%s: cost = %d, tracked = %t, want %d", name, cost, tracked, want)
+		}
+	}
+}
+
+func TestCalculateUpstreamCostForPriceModel(t *testing.T) {
+	priceData := types.PriceData{UsePrice: true, ModelPrice: 0, BasePrice: types.BasePriceData{UsePrice: true, ModelPrice: 0.04},
+		PriceOverride: &types.PriceOverrideInfo{Type: types.PriceOverrideTypeRatio, Value: 0}}
+	cost, tracked := service.CalculateUpstreamCost(newUpstreamCostRelayInfo(priceData), service.UpstreamCostUsage{PromptTokens: 10})
+	if want := int(0.04 * 0.5 * common.QuotaPerUnit); !tracked || cost != want {
+		t.Fatalf("cost = %d, tracked = %t, want %d", cost, tracked, want)
+	}
+}
+
+func TestCalculateUpstreamCostIncludesAudioTokens(t *testing.T) {
+	relayInfo := newUpstreamCostRelayInfo(types.PriceData{BasePrice: types.BasePriceData{ModelRatio: 2.5, CompletionRatio: 4}})
+	audioRatio := ratio_setting.GetAudioRatio("gpt-4o")
+	audioCompletionRatio := ratio_setting.GetAudioCompletionRatio("gpt-4o")
+	usage := service.UpstreamCostUsage{PromptTokens: 100, CompletionTokens: 10, AudioPromptTokens: 200, AudioCompletionTokens: 50}
+	cost, tracked := service.CalculateUpstreamCost(relayInfo, usage)
+	want := int(math.Round(0.5 * 2.5 * (100 + 10*4 + 200*audioRatio + 50*audioRatio*audioCompletionRatio)))
+	if !tracked || cost != want {
+		t.Fatalf("cost = %d, tracked = %t, want %d", cost, tracked, want)
+	}
+}
+
+func TestCalculateUpstreamCostScalesPerCallPrice(t *testing.T) {
+	relayInfo := newUpstreamCostRelayInfo(types.PriceData{BasePrice: types.BasePriceData{UsePrice: true, ModelPrice: 0.1}})
+	// 按次计费的任务按计算参数（如 8 秒视频）放大成本
+	cost, tracked := service.CalculateUpstreamCost(relayInfo, service.UpstreamCostUsage{OtherRatio: 8})
+	if want := int(math.Round(0.1 * 0.5 * 8 * common.QuotaPerUnit)); !tracked || cost != want {
+		t.Fatalf("cost = %d, tracked = %t, want %d", cost, tracked, want)
+	}
+	relayInfo.ChannelSetting.UpstreamCost.ModelPrice = map[string]float64{"gpt-4o": 0.02}
+	cost, tracked = service.CalculateUpstreamCost(relayInfo, service.UpstreamCostUsage{OtherRatio: 8})
+	if want := int(math.Round(0.02 * 8 * common.QuotaPerUnit)); !tracked || cost != want {
+		t.Fatalf("cost = %d, tracked = %t, want %d", cost, tracked, want)
+	}
+}
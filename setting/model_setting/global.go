@@ -6,11 +6,17 @@ import (
 
 type GlobalSettings struct {
 	PassThroughRequestEnabled bool `json:"pass_through_request_enabled"`
+	// 选中的渠道为 Anthropic / Gemini 原生渠道时，count_tokens 请求转发到上游获取精确计数
+	CountTokensForwardEnabled bool `json:"count_tokens_forward_enabled"`
+	// 按模型目录中的上下文长度、最大输出与图片输入能力拒绝不满足的请求
+	ModelCapabilityCheckEnabled bool `json:"model_capability_check_enabled"`
 }
 
 // 默认配置
 var defaultOpenaiSettings = GlobalSettings{
-	PassThroughRequestEnabled: false,
+	PassThroughRequestEnabled:   false,
+	CountTokensForwardEnabled:   false,
+	ModelCapabilityCheckEnabled: true,
 }
 
 // 全局实例
@@ -0,0 +1,32 @@
+package model_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ResponsesSettings Responses API 会话存储配置
+type ResponsesSettings struct {
+	// StoreEnabled 开启后网关保存 store 不为 false 的请求与响应，用于 previous_response_id 续接与查询。
+	// 会在数据库中保存对话内容，默认关闭，由管理员确认后开启
+	StoreEnabled bool `json:"store_enabled"`
+	// RetentionDays 响应保存天数，过期后自动清理
+	RetentionDays int `json:"retention_days"`
+	// MaxReplayDepth 本地续接时最多回放的历史轮数
+	MaxReplayDepth int `json:"max_replay_depth"`
+}
+
+// 默认配置
+var responsesSettings = ResponsesSettings{
+	StoreEnabled:   false,
+	RetentionDays:  30,
+	MaxReplayDepth: 100,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("responses", &responsesSettings)
+}
+
+func GetResponsesSettings() *ResponsesSettings {
+	return &responsesSettings
+}
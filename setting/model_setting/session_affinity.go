@@ -0,0 +1,55 @@
+package model_setting
+
+import (
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// SessionAffinitySettings 会话粘性配置，同一会话的请求固定到同一渠道和密钥，便于多轮对话命中上游提示词缓存
+type SessionAffinitySettings struct {
+	Enabled bool `json:"enabled"`
+	// Header 客户端传递会话标识的请求头，为空时不读取请求头
+	Header string `json:"header"`
+	// UseUserField 没有会话请求头时使用请求体中的 user 字段
+	UseUserField bool `json:"use_user_field"`
+	// UsePromptPrefix 以上都没有时使用对话前缀（系统提示词与前几条消息）的哈希
+	UsePromptPrefix bool `json:"use_prompt_prefix"`
+	// PrefixMessages 计算对话前缀哈希时使用的消息条数
+	PrefixMessages int `json:"prefix_messages"`
+	// TTLSeconds 会话绑定的有效期，每次请求成功后续期
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+// 默认配置
+var sessionAffinitySettings = SessionAffinitySettings{
+	Enabled:         false,
+	Header:          "X-Session-Id",
+	UseUserField:    true,
+	UsePromptPrefix: false,
+	PrefixMessages:  2,
+	TTLSeconds:      3600,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("session_affinity", &sessionAffinitySettings)
+}
+
+func GetSessionAffinitySettings() *SessionAffinitySettings {
+	return &sessionAffinitySettings
+}
+
+func (s *SessionAffinitySettings) GetTTL() time.Duration {
+	if s.TTLSeconds <= 0 {
+		return time.Hour
+	}
+	return time.Duration(s.TTLSeconds) * time.Second
+}
+
+func (s *SessionAffinitySettings) GetPrefixMessages() int {
+	if s.PrefixMessages <= 0 {
+		return 2
+	}
+	return s.PrefixMessages
+}
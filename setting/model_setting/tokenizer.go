@@ -0,0 +1,96 @@
+package model_setting
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// TokenizerSettings 本地估算 token 数使用的分词器
+// 分词器名可以是内置的 cl100k_base / o200k_base / p50k_base / r50k_base，
+// 或 hf:<name>，从 HuggingFaceDir 下读取 <name>/tokenizer.json 或 <name>.json。
+// hf: 分词器文件不随程序分发，需要管理员放入目录后自行添加规则，如 "qwen*": "hf:qwen"
+type TokenizerSettings struct {
+	// 未匹配到规则且无法按 OpenAI 模型名识别时使用的分词器
+	Default string `json:"default"`
+	// 模型名规则 → 分词器，规则支持 * 通配符，同时匹配多条时取最长的规则
+	ModelTokenizers map[string]string `json:"model_tokenizers"`
+	HuggingFaceDir  string            `json:"huggingface_dir"`
+}
+
+// 默认配置
+var defaultTokenizerSettings = TokenizerSettings{
+	Default: "cl100k_base",
+	ModelTokenizers: map[string]string{
+		"gpt-4o*":     "o200k_base",
+		"chatgpt-4o*": "o200k_base",
+		"gpt-4.1*":    "o200k_base",
+		"gpt-4.5*":    "o200k_base",
+		"gpt-5*":      "o200k_base",
+		"gpt-oss*":    "o200k_base",
+		"o1*":         "o200k_base",
+		"o3*":         "o200k_base",
+		"o4*":         "o200k_base",
+		"codex*":      "o200k_base",
+		"claude*":     "o200k_base",
+	},
+	HuggingFaceDir: "tokenizers",
+}
+
+// 全局实例
+var tokenizerSettings = defaultTokenizerSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("tokenizer", &tokenizerSettings)
+}
+
+func GetTokenizerSettings() *TokenizerSettings {
+	return &tokenizerSettings
+}
+
+// MatchTokenizer 返回模型对应的分词器名，未配置时返回空字符串。
+// 同时匹配多条规则时取最长的规则，长度相同时取通配符更少的，再按规则字典序，结果与遍历顺序无关
+func (s *TokenizerSettings) MatchTokenizer(model string) string {
+	model = strings.ToLower(model)
+	found, matched, tokenizerName := false, "", ""
+	for pattern, name := range s.ModelTokenizers {
+		if !matchWildcard(strings.ToLower(pattern), model) {
+			continue
+		}
+		if !found || morePreciseTokenizerPattern(pattern, matched) {
+			found, matched, tokenizerName = true, pattern, name
+		}
+	}
+	return tokenizerName
+}
+
+func morePreciseTokenizerPattern(pattern string, than string) bool {
+	if len(pattern) != len(than) {
+		return len(pattern) > len(than)
+	}
+	if a, b := strings.Count(pattern, "*"), strings.Count(than, "*"); a != b {
+		return a < b
+	}
+	return pattern < than
+}
+
+// matchWildcard 匹配带 * 通配符的规则，* 可匹配任意字符（包括 /）
+func matchWildcard(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		index := strings.Index(s, part)
+		if index < 0 {
+			return false
+		}
+		s = s[index+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
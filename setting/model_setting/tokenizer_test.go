@@ -0,0 +1,50 @@
+package model_setting
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchTokenizer(t *testing.T) {
+	settings := TokenizerSettings{ModelTokenizers: map[string]string{
+		"gpt-4o*":  "o200k_base",
+		"*llama*":  "hf:llama",
+		"qwen*":    "hf:qwen",
+		"qwen3*":   "hf:qwen3",
+		"*en-vl":   "hf:vl",
+		"*wen3*":   "hf:wen3",
+		"qwen-*":   "hf:qwen-dash",
+		"deepseek": "hf:deepseek",
+	}}
+	cases := map[string]string{
+		"GPT-4o-mini":          "o200k_base",
+		"meta-llama/llama-3.1": "hf:llama",
+		// "qwen3*" 与 "*wen3*" 等长，取通配符更少的
+		"qwen3-32b": "hf:qwen3",
+		"qwen-max":  "hf:qwen-dash",
+		// "*en-vl" 与 "qwen-*" 等长且通配符数量相同，按字典序取 "*en-vl"
+		"qwen-vl":  "hf:vl",
+		"deepseek": "hf:deepseek",
+		"claude":   "",
+	}
+	// 多次匹配，确保结果与 map 遍历顺序无关
+	for i := 0; i < 50; i++ {
+		for model, want := range cases {
+			if got := settings.MatchTokenizer(model); got != want {
+				t.Fatalf("MatchTokenizer(%q) = %q, want %q", model, got, want)
+			}
+		}
+	}
+}
+
+func TestDefaultTokenizersAreBundled(t *testing.T) {
+	// 默认配置只能使用内置的 tiktoken 编码，hf: 分词器文件需要管理员自行提供
+	if strings.HasPrefix(defaultTokenizerSettings.Default, "hf:") {
+		t.Fatalf("default tokenizer %q is not bundled", defaultTokenizerSettings.Default)
+	}
+	for pattern, name := range defaultTokenizerSettings.ModelTokenizers {
+		if strings.HasPrefix(name, "hf:") {
+			t.Errorf("default rule %q uses unbundled tokenizer %q", pattern, name)
+		}
+	}
+}
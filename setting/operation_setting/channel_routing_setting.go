@@ -0,0 +1,68 @@
+package operation_setting
+
+import (
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	ChannelSelectionWeightedRandom   = "weighted_random"   // 按权重随机
+	ChannelSelectionLeastConnections = "least_connections" // 同优先级中选择进行中请求最少的渠道
+)
+
+type ChannelRoutingSetting struct {
+	SelectionMode string `json:"selection_mode"`
+	// SaturationWaitMs 所有渠道并发已满时排队等待的最长时间（毫秒），0 表示直接失败
+	SaturationWaitMs int `json:"saturation_wait_ms"`
+	// QueueMaxSize 每个分组下每个模型排队等待的最大请求数，队列已满时直接失败
+	QueueMaxSize int `json:"queue_max_size"`
+	// GroupPriorities 用户分组或令牌分组的排队优先级，数值越大越先获得渠道，未配置的分组为 0
+	GroupPriorities map[string]int `json:"group_priorities"`
+}
+
+// 默认配置
+var channelRoutingSetting = ChannelRoutingSetting{
+	SelectionMode:    ChannelSelectionWeightedRandom,
+	SaturationWaitMs: 3000,
+	QueueMaxSize:     100,
+	GroupPriorities:  map[string]int{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_routing_setting", &channelRoutingSetting)
+}
+
+func GetChannelRoutingSetting() *ChannelRoutingSetting {
+	return &channelRoutingSetting
+}
+
+func (s *ChannelRoutingSetting) IsLeastConnections() bool {
+	return s.SelectionMode == ChannelSelectionLeastConnections
+}
+
+func (s *ChannelRoutingSetting) GetSaturationWait() time.Duration {
+	if s.SaturationWaitMs <= 0 {
+		return 0
+	}
+	return time.Duration(s.SaturationWaitMs) * time.Millisecond
+}
+
+func (s *ChannelRoutingSetting) GetQueueMaxSize() int {
+	if s.QueueMaxSize <= 0 {
+		return 100
+	}
+	return s.QueueMaxSize
+}
+
+// GetGroupPriority 取用户分组与令牌分组中配置的较高优先级
+func (s *ChannelRoutingSetting) GetGroupPriority(groups ...string) int {
+	priority, found := 0, false
+	for _, group := range groups {
+		if p, ok := s.GroupPriorities[group]; ok && (!found || p > priority) {
+			priority, found = p, true
+		}
+	}
+	return priority
+}
@@ -0,0 +1,38 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// InvoiceSetting 月度账单与发票展示所需的公司及税务信息
+type InvoiceSetting struct {
+	CompanyName    string `json:"company_name"`
+	CompanyAddress string `json:"company_address"`
+	CompanyEmail   string `json:"company_email"`
+	CompanyTaxId   string `json:"company_tax_id"`
+	// TaxName 如 VAT、GST、增值税
+	TaxName string `json:"tax_name"`
+	// TaxRate 税率，例如 0.06 表示 6%
+	TaxRate float64 `json:"tax_rate"`
+	// TaxInclusive 为 true 时充值金额已含税，否则在充值金额之上另计税额
+	TaxInclusive  bool   `json:"tax_inclusive"`
+	InvoicePrefix string `json:"invoice_prefix"`
+	FooterNote    string `json:"footer_note"`
+	// AutoGenerateEnabled 开启后每月初自动生成上月账单
+	AutoGenerateEnabled bool `json:"auto_generate_enabled"`
+}
+
+// 默认配置
+var invoiceSetting = InvoiceSetting{
+	TaxName:       "Tax",
+	TaxRate:       0,
+	TaxInclusive:  true,
+	InvoicePrefix: "INV",
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("invoice_setting", &invoiceSetting)
+}
+
+func GetInvoiceSetting() *InvoiceSetting {
+	return &invoiceSetting
+}
@@ -0,0 +1,75 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+const (
+	ServerToolTypeSearch = "search" // 搜索后端，POST {"query": "..."}
+	ServerToolTypeCode   = "code"   // 沙箱代码执行，POST {"language": "...", "code": "..."}
+	ServerToolTypeHttp   = "http"   // 通用 HTTP，POST 模型给出的参数
+	ServerToolTypeMcp    = "mcp"    // MCP 服务（Streamable HTTP），调用其中的一个工具
+	ServerToolTypeStub   = "stub"   // 返回固定内容，用于本地测试
+)
+
+// ServerToolProvider 管理员注册的网关侧工具，模型调用后由网关执行并把结果回传给模型
+type ServerToolProvider struct {
+	Name        string            `json:"name"` // 暴露给模型的工具名，客户端以 {"type": name} 声明
+	Type        string            `json:"type"`
+	Description string            `json:"description"`
+	Parameters  map[string]any    `json:"parameters,omitempty"` // 参数 JSON Schema，search / code 未配置时使用默认值
+	Url         string            `json:"url"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	McpTool     string            `json:"mcp_tool,omitempty"`    // MCP 服务端的工具名，默认与 Name 相同
+	StubOutput  string            `json:"stub_output,omitempty"` // stub 类型返回的内容，为空时原样返回参数
+	Timeout     int               `json:"timeout,omitempty"`     // 单次调用超时秒数，默认 30
+	Price       float64           `json:"price_per_thousand"`    // 每千次调用价格（美元），乘以分组倍率
+	AutoAttach  bool              `json:"auto_attach,omitempty"` // 无需客户端声明，自动提供给所有对话请求
+	Enabled     bool              `json:"enabled"`
+}
+
+type ServerToolSetting struct {
+	Enabled       bool                 `json:"enabled"`
+	MaxIterations int                  `json:"max_iterations"`  // 单个请求内模型调用工具的最大轮数
+	MaxOutputSize int                  `json:"max_output_size"` // 单次工具输出回传给模型的最大字节数
+	Providers     []ServerToolProvider `json:"providers"`
+}
+
+// 默认配置
+var serverToolSetting = ServerToolSetting{
+	Enabled:       false,
+	MaxIterations: 5,
+	MaxOutputSize: 32 * 1024,
+	Providers:     []ServerToolProvider{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("server_tool_setting", &serverToolSetting)
+}
+
+func GetServerToolSetting() *ServerToolSetting {
+	return &serverToolSetting
+}
+
+// GetServerToolProvider 按工具名获取已启用的工具，未开启或不存在时返回 nil
+func GetServerToolProvider(name string) *ServerToolProvider {
+	if !serverToolSetting.Enabled {
+		return nil
+	}
+	for i := range serverToolSetting.Providers {
+		provider := &serverToolSetting.Providers[i]
+		if provider.Enabled && provider.Name == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+// GetServerToolPricePerThousand 工具每千次调用的价格
+func GetServerToolPricePerThousand(name string) float64 {
+	for _, provider := range serverToolSetting.Providers {
+		if provider.Name == name {
+			return provider.Price
+		}
+	}
+	return 0
+}
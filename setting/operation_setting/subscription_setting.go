@@ -0,0 +1,32 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// SubscriptionSetting 订阅套餐相关配置
+type SubscriptionSetting struct {
+	Enabled bool `json:"enabled"`
+	// GracePeriodDays 续费失败或到期未续费后保留套餐权益的天数
+	GracePeriodDays int `json:"grace_period_days"`
+	// ProrationEnabled 开启后升级套餐时按当期剩余时间补发额度差
+	ProrationEnabled bool `json:"proration_enabled"`
+	// ManualModeEnabled 未配置 Stripe 时使用本地手动套餐：由管理员开通，系统按周期自动续发额度
+	ManualModeEnabled bool `json:"manual_mode_enabled"`
+	// PeriodDays 手动套餐的计费周期天数
+	PeriodDays int `json:"period_days"`
+}
+
+// 默认配置
+var subscriptionSetting = SubscriptionSetting{
+	GracePeriodDays:  3,
+	ProrationEnabled: true,
+	PeriodDays:       30,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("subscription_setting", &subscriptionSetting)
+}
+
+func GetSubscriptionSetting() *SubscriptionSetting {
+	return &subscriptionSetting
+}
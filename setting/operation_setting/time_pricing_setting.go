@@ -0,0 +1,242 @@
+package operation_setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// PricingWindow 按时段生效的价格系数，例如上游夜间优惠时段
+type PricingWindow struct {
+	Name       string   `json:"name"`
+	Timezone   string   `json:"timezone,omitempty"` // IANA 时区，为空时使用服务器时区
+	Days       []int    `json:"days,omitempty"`     // 生效的星期，0 为周日，为空表示每天
+	Start      string   `json:"start"`              // 开始时间 HH:MM
+	End        string   `json:"end"`                // 结束时间 HH:MM，不晚于开始时间表示跨越午夜
+	Models     []string `json:"models,omitempty"`   // 模型名或以 * 结尾的前缀，为空表示所有模型
+	Groups     []string `json:"groups,omitempty"`   // 为空表示所有分组
+	Multiplier float64  `json:"multiplier"`
+	Enabled    bool     `json:"enabled"`
+}
+
+type TimePricingSetting struct {
+	Enabled bool            `json:"enabled"`
+	Windows []PricingWindow `json:"windows"`
+}
+
+// 默认配置
+var timePricingSetting = TimePricingSetting{
+	Enabled: false,
+	Windows: []PricingWindow{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("time_pricing_setting", &timePricingSetting)
+}
+
+func GetTimePricingSetting() *TimePricingSetting {
+	return &timePricingSetting
+}
+
+// HH:MM -> 当天的分钟数，避免每次判断都重新解析
+var pricingClocks sync.Map
+
+func parseClock(value string) (int, error) {
+	if minute, ok := pricingClocks.Load(value); ok {
+		return minute.(int), nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	minute := t.Hour()*60 + t.Minute()
+	pricingClocks.Store(value, minute)
+	return minute, nil
+}
+
+// 时区名 -> *time.Location，避免每次判断都读取时区数据
+var pricingLocations sync.Map
+
+func (w *PricingWindow) location() *time.Location {
+	if w.Timezone == "" {
+		return time.Local
+	}
+	if loc, ok := pricingLocations.Load(w.Timezone); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	pricingLocations.Store(w.Timezone, loc)
+	return loc
+}
+
+// Validate 校验时段配置
+func (w *PricingWindow) Validate() error {
+	if _, err := parseClock(w.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(w.End); err != nil {
+		return err
+	}
+	if w.Timezone != "" {
+		if _, err := time.LoadLocation(w.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q", w.Timezone)
+		}
+	}
+	for _, day := range w.Days {
+		if day < 0 || day > 6 {
+			return fmt.Errorf("invalid weekday %d", day)
+		}
+	}
+	if w.Multiplier < 0 {
+		return fmt.Errorf("multiplier must not be negative")
+	}
+	return nil
+}
+
+// ValidatePricingWindows 校验时段配置的 JSON
+func ValidatePricingWindows(value string) error {
+	var windows []PricingWindow
+	if err := json.Unmarshal([]byte(value), &windows); err != nil {
+		return fmt.Errorf("invalid pricing windows: %w", err)
+	}
+	for i := range windows {
+		if err := windows[i].Validate(); err != nil {
+			return fmt.Errorf("pricing window %q: %w", windows[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// ActiveAt 时段在 now 是否生效，跨越午夜的时段以开始当天的星期为准
+func (w *PricingWindow) ActiveAt(now time.Time) bool {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return false
+	}
+	local := now.In(w.location())
+	minute := local.Hour()*60 + local.Minute()
+	day := int(local.Weekday())
+	if start < end {
+		return minute >= start && minute < end && w.matchDay(day)
+	}
+	if minute >= start {
+		return w.matchDay(day)
+	}
+	return minute < end && w.matchDay((day+6)%7)
+}
+
+func (w *PricingWindow) matchDay(day int) bool {
+	return len(w.Days) == 0 || slices.Contains(w.Days, day)
+}
+
+// Matches 时段是否适用于该模型和分组
+func (w *PricingWindow) Matches(modelName string, group string) bool {
+	if len(w.Groups) > 0 && !slices.Contains(w.Groups, group) {
+		return false
+	}
+	if len(w.Models) == 0 {
+		return true
+	}
+	for _, pattern := range w.Models {
+		if pattern == modelName {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(modelName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetActivePricingWindow 返回当前适用于该模型和分组的时段，多个时段重叠时取配置中的第一个
+func GetActivePricingWindow(modelName string, group string, now time.Time) *PricingWindow {
+	if !timePricingSetting.Enabled {
+		return nil
+	}
+	for i := range timePricingSetting.Windows {
+		window := &timePricingSetting.Windows[i]
+		if window.Enabled && window.Matches(modelName, group) && window.ActiveAt(now) {
+			return window
+		}
+	}
+	return nil
+}
+
+// GetActivePricingWindows 当前生效的全部时段
+func GetActivePricingWindows(now time.Time) []PricingWindow {
+	windows := make([]PricingWindow, 0)
+	if !timePricingSetting.Enabled {
+		return windows
+	}
+	for _, window := range timePricingSetting.Windows {
+		if window.Enabled && window.ActiveAt(now) {
+			windows = append(windows, window)
+		}
+	}
+	return windows
+}
+
+func activePricingWindowStates(t time.Time) []bool {
+	states := make([]bool, len(timePricingSetting.Windows))
+	for i := range timePricingSetting.Windows {
+		window := &timePricingSetting.Windows[i]
+		states[i] = window.Enabled && window.ActiveAt(t)
+	}
+	return states
+}
+
+// NextPricingWindowChange 下一次有时段开始或结束的时间，一周内没有变化时返回零值
+func NextPricingWindowChange(now time.Time) time.Time {
+	if !timePricingSetting.Enabled {
+		return time.Time{}
+	}
+	// 生效状态只会在某个时段的开始或结束时刻变化，按时间顺序检查一周内的这些时刻
+	limit := now.Add(7 * 24 * time.Hour)
+	boundaries := make([]time.Time, 0)
+	for i := range timePricingSetting.Windows {
+		window := &timePricingSetting.Windows[i]
+		if !window.Enabled {
+			continue
+		}
+		start, err := parseClock(window.Start)
+		if err != nil {
+			continue
+		}
+		end, err := parseClock(window.End)
+		if err != nil {
+			continue
+		}
+		local := now.In(window.location())
+		for day := 0; day <= 7; day++ {
+			for _, minute := range []int{start, end} {
+				t := time.Date(local.Year(), local.Month(), local.Day()+day, minute/60, minute%60, 0, 0, local.Location())
+				if t.After(now) && !t.After(limit) {
+					boundaries = append(boundaries, t)
+				}
+			}
+		}
+	}
+	slices.SortFunc(boundaries, func(a, b time.Time) int {
+		return a.Compare(b)
+	})
+	current := activePricingWindowStates(now)
+	for _, t := range boundaries {
+		if !slices.Equal(activePricingWindowStates(t), current) {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,54 @@
+package operation_setting
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func usePricingWindows(t *testing.T, windows ...PricingWindow) {
+	t.Helper()
+	saved := timePricingSetting
+	t.Cleanup(func() {
+		timePricingSetting = saved
+	})
+	timePricingSetting = TimePricingSetting{Enabled: true, Windows: windows}
+}
+
+// nextPricingWindowChangeByMinute 逐分钟向后查找一周，作为参照结果
+func nextPricingWindowChangeByMinute(now time.Time) time.Time {
+	current := activePricingWindowStates(now)
+	t := now.Truncate(time.Minute)
+	for i := 0; i < 7*24*60; i++ {
+		t = t.Add(time.Minute)
+		if !slices.Equal(activePricingWindowStates(t), current) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func TestNextPricingWindowChange(t *testing.T) {
+	usePricingWindows(t,
+		PricingWindow{Name: "night", Timezone: "Asia/Shanghai", Start: "22:30", End: "06:00", Multiplier: 0.5, Enabled: true},
+		PricingWindow{Name: "weekend", Timezone: "UTC", Days: []int{0, 6}, Start: "09:00", End: "18:00", Multiplier: 0.8, Enabled: true},
+		PricingWindow{Name: "disabled", Start: "12:00", End: "13:00", Multiplier: 2},
+	)
+	start := time.Date(2025, 3, 7, 0, 17, 42, 0, time.UTC)
+	for i := 0; i < 7*24*4; i++ {
+		now := start.Add(time.Duration(i) * 15 * time.Minute)
+		got := NextPricingWindowChange(now)
+		if want := nextPricingWindowChangeByMinute(now); !got.Equal(want) {
+			t.Fatalf("NextPricingWindowChange(%s) = %s, want %s", now, got, want)
+		}
+	}
+}
+
+func TestNextPricingWindowChangeWithoutChange(t *testing.T) {
+	// 全天生效且没有星期限制的时段不会变化
+	usePricingWindows(t, PricingWindow{Name: "always", Timezone: "UTC", Start: "00:00", End: "00:00", Multiplier: 0.9, Enabled: true})
+	now := time.Date(2025, 3, 7, 10, 0, 0, 0, time.UTC)
+	if !NextPricingWindowChange(now).IsZero() {
+		t.Fatalf("expected no change, got %s", NextPricingWindowChange(now))
+	}
+}
@@ -0,0 +1,10 @@
+package setting
+
+var AlipayAppId = ""
+
+// AlipayPrivateKey 应用私钥（PKCS1/PKCS8 PEM 或不带头尾的 Base64）
+var AlipayPrivateKey = ""
+
+// AlipayPublicKey 支付宝公钥，用于验证异步通知与接口返回
+var AlipayPublicKey = ""
+var AlipayGateway = "https://openapi.alipay.com/gateway.do"
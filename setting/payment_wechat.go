@@ -0,0 +1,15 @@
+package setting
+
+var WechatPayMchId = ""
+var WechatPayAppId = ""
+
+// WechatPayApiV3Key APIv3 密钥，用于解密支付通知
+var WechatPayApiV3Key = ""
+
+// WechatPayPrivateKey 商户 API 私钥（PEM），用于请求签名
+var WechatPayPrivateKey = ""
+var WechatPayCertSerialNo = ""
+
+// WechatPayPublicKeyId 与 WechatPayPublicKey 为微信支付公钥模式下的公钥 ID 与公钥，用于验证应答与通知签名
+var WechatPayPublicKeyId = ""
+var WechatPayPublicKey = ""
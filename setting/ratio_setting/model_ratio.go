@@ -364,6 +364,11 @@ func InitRatioSettings() {
 	audioCompletionRatioMapMutex.Lock()
 	audioCompletionRatioMap = defaultAudioCompletionRatio
 	audioCompletionRatioMapMutex.Unlock()
+
+	// initialize tieredRatioMap
+	tieredRatioMapMutex.Lock()
+	tieredRatioMap = defaultTieredRatio
+	tieredRatioMapMutex.Unlock()
 }
 
 func GetModelPriceMap() map[string]float64 {
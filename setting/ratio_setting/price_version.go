@@ -0,0 +1,68 @@
+package ratio_setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+// PriceTableKeys 价格版本可以包含的价格表，与 /api/option 中的配置项同名
+var PriceTableKeys = []string{
+	"ModelRatio",
+	"ModelPrice",
+	"CompletionRatio",
+	"CacheRatio",
+	"TieredRatio",
+	"ImageRatio",
+	"AudioRatio",
+	"AudioCompletionRatio",
+}
+
+// 当前生效的价格版本 ID，0 表示尚未启用价格版本
+var priceVersionId atomic.Int64
+
+func GetPriceVersionId() int {
+	return int(priceVersionId.Load())
+}
+
+func SetPriceVersionId(id int) {
+	priceVersionId.Store(int64(id))
+}
+
+func IsPriceTableKey(key string) bool {
+	for _, k := range PriceTableKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePriceTable 校验价格表内容，TieredRatio 为分档列表，其余为 模型 -> 数值
+func ValidatePriceTable(key string, value []byte) error {
+	if !IsPriceTableKey(key) {
+		return fmt.Errorf("不支持的价格表：%s", key)
+	}
+	if key == "TieredRatio" {
+		tiers := make(map[string][]PriceTier)
+		if err := json.Unmarshal(value, &tiers); err != nil {
+			return fmt.Errorf("%s 格式错误：%w", key, err)
+		}
+		for name, t := range tiers {
+			if err := checkPriceTiers(t); err != nil {
+				return fmt.Errorf("模型 %s 分档倍率配置错误：%w", name, err)
+			}
+		}
+		return nil
+	}
+	ratios := make(map[string]float64)
+	if err := json.Unmarshal(value, &ratios); err != nil {
+		return fmt.Errorf("%s 格式错误：%w", key, err)
+	}
+	for name, ratio := range ratios {
+		if ratio < 0 {
+			return fmt.Errorf("%s 中模型 %s 的值不能为负数", key, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package ratio_setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// PriceTier 按输入 token 数分档的倍率，输入 token 数不超过 MaxPromptTokens 时使用该档，
+// MaxPromptTokens 为 0 表示不设上限（最后一档）；补全、缓存倍率为 0 时沿用模型默认值
+type PriceTier struct {
+	MaxPromptTokens    int     `json:"max_prompt_tokens"`
+	ModelRatio         float64 `json:"model_ratio"`
+	CompletionRatio    float64 `json:"completion_ratio,omitempty"`
+	CacheRatio         float64 `json:"cache_ratio,omitempty"`
+	CacheCreationRatio float64 `json:"cache_creation_ratio,omitempty"`
+}
+
+var defaultTieredRatio = map[string][]PriceTier{
+	"gemini-2.5-pro": {
+		{MaxPromptTokens: 200000, ModelRatio: 0.625, CompletionRatio: 8, CacheRatio: 0.25},
+		{MaxPromptTokens: 0, ModelRatio: 1.25, CompletionRatio: 6, CacheRatio: 0.25},
+	},
+	"claude-sonnet-4-20250514": {
+		{MaxPromptTokens: 200000, ModelRatio: 1.5, CompletionRatio: 5, CacheRatio: 0.1, CacheCreationRatio: 1.25},
+		{MaxPromptTokens: 0, ModelRatio: 3, CompletionRatio: 3.75, CacheRatio: 0.1, CacheCreationRatio: 1.25},
+	},
+	"claude-sonnet-4-5-20250929": {
+		{MaxPromptTokens: 200000, ModelRatio: 1.5, CompletionRatio: 5, CacheRatio: 0.1, CacheCreationRatio: 1.25},
+		{MaxPromptTokens: 0, ModelRatio: 3, CompletionRatio: 3.75, CacheRatio: 0.1, CacheCreationRatio: 1.25},
+	},
+}
+
+var tieredRatioMap map[string][]PriceTier
+var tieredRatioMapMutex sync.RWMutex
+
+// TieredRatio2JSONString converts the tiered ratio map to a JSON string
+func TieredRatio2JSONString() string {
+	tieredRatioMapMutex.RLock()
+	defer tieredRatioMapMutex.RUnlock()
+	jsonBytes, err := json.Marshal(tieredRatioMap)
+	if err != nil {
+		common.SysLog("error marshalling tiered ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// UpdateTieredRatioByJSONString updates the tiered ratio map from a JSON string
+func UpdateTieredRatioByJSONString(jsonStr string) error {
+	newMap := make(map[string][]PriceTier)
+	if err := json.Unmarshal([]byte(jsonStr), &newMap); err != nil {
+		return err
+	}
+	for name, tiers := range newMap {
+		if err := checkPriceTiers(tiers); err != nil {
+			return fmt.Errorf("模型 %s 分档倍率配置错误：%w", name, err)
+		}
+	}
+	tieredRatioMapMutex.Lock()
+	tieredRatioMap = newMap
+	tieredRatioMapMutex.Unlock()
+	InvalidateExposedDataCache()
+	return nil
+}
+
+// checkPriceTiers 分档须按 max_prompt_tokens 递增排列，且只有最后一档可以不设上限
+func checkPriceTiers(tiers []PriceTier) error {
+	if len(tiers) == 0 {
+		return fmt.Errorf("至少需要一个分档")
+	}
+	for i, tier := range tiers {
+		if tier.ModelRatio < 0 || tier.CompletionRatio < 0 || tier.CacheRatio < 0 || tier.CacheCreationRatio < 0 {
+			return fmt.Errorf("第 %d 档倍率不能为负数", i+1)
+		}
+		if tier.MaxPromptTokens < 0 {
+			return fmt.Errorf("第 %d 档 max_prompt_tokens 不能为负数", i+1)
+		}
+		if tier.MaxPromptTokens == 0 && i != len(tiers)-1 {
+			return fmt.Errorf("只有最后一档可以不设上限")
+		}
+		if i > 0 && tier.MaxPromptTokens != 0 && tier.MaxPromptTokens <= tiers[i-1].MaxPromptTokens {
+			return fmt.Errorf("第 %d 档 max_prompt_tokens 必须大于上一档", i+1)
+		}
+	}
+	return nil
+}
+
+// GetTieredRatio returns the price tiers for a model
+func GetTieredRatio(name string) ([]PriceTier, bool) {
+	tieredRatioMapMutex.RLock()
+	defer tieredRatioMapMutex.RUnlock()
+	tiers, ok := tieredRatioMap[FormatMatchingModelName(name)]
+	return tiers, ok
+}
+
+// GetPriceTier 按输入 token 数选取分档，超过所有分档上限时使用最后一档
+func GetPriceTier(name string, promptTokens int) (PriceTier, int, bool) {
+	tiers, ok := GetTieredRatio(name)
+	if !ok || len(tiers) == 0 {
+		return PriceTier{}, 0, false
+	}
+	for i, tier := range tiers {
+		if tier.MaxPromptTokens == 0 || promptTokens <= tier.MaxPromptTokens {
+			return tier, i, true
+		}
+	}
+	return tiers[len(tiers)-1], len(tiers) - 1, true
+}
+
+func GetTieredRatioCopy() map[string][]PriceTier {
+	tieredRatioMapMutex.RLock()
+	defer tieredRatioMapMutex.RUnlock()
+	copyMap := make(map[string][]PriceTier, len(tieredRatioMap))
+	for k, v := range tieredRatioMap {
+		copyMap[k] = append([]PriceTier(nil), v...)
+	}
+	return copyMap
+}
+
+// Ratios 返回该档的模型、补全、缓存读取与缓存创建倍率，未配置的项沿用传入的默认值
+func (t PriceTier) Ratios(completionRatio, cacheRatio, cacheCreationRatio float64) (float64, float64, float64, float64) {
+	if t.CompletionRatio > 0 {
+		completionRatio = t.CompletionRatio
+	}
+	if t.CacheRatio > 0 {
+		cacheRatio = t.CacheRatio
+	}
+	if t.CacheCreationRatio > 0 {
+		cacheCreationRatio = t.CacheCreationRatio
+	}
+	return t.ModelRatio, completionRatio, cacheRatio, cacheCreationRatio
+}
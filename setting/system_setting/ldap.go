@@ -0,0 +1,48 @@
+package system_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type LDAPSettings struct {
+	Enabled bool `json:"enabled"`
+	// Url 形如 ldap://ldap.example.com:389 或 ldaps://ldap.example.com:636
+	Url                string `json:"url"`
+	StartTLS           bool   `json:"start_tls"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	// BindDN/BindPassword 为用于搜索用户的服务账号，留空则匿名搜索
+	BindDN       string `json:"bind_dn"`
+	BindPassword string `json:"bind_password"`
+	BaseDN       string `json:"base_dn"`
+	// UserFilter 中的 %s 会被替换为转义后的登录名
+	UserFilter           string `json:"user_filter"`
+	UsernameAttribute    string `json:"username_attribute"`
+	EmailAttribute       string `json:"email_attribute"`
+	DisplayNameAttribute string `json:"display_name_attribute"`
+	GroupAttribute       string `json:"group_attribute"`
+	// GroupSearchFilter 非空时额外在 BaseDN 下搜索用户所属组，%s 会被替换为用户 DN
+	GroupSearchFilter string `json:"group_search_filter"`
+	// GroupMapping 为 LDAP 组 DN 到用户分组的映射
+	GroupMapping   map[string]string `json:"group_mapping"`
+	DefaultGroup   string            `json:"default_group"`
+	AutoRegister   bool              `json:"auto_register"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+}
+
+var defaultLDAPSettings = LDAPSettings{
+	UserFilter:           "(uid=%s)",
+	UsernameAttribute:    "uid",
+	EmailAttribute:       "mail",
+	DisplayNameAttribute: "cn",
+	GroupAttribute:       "memberOf",
+	GroupMapping:         map[string]string{},
+	DefaultGroup:         "default",
+	AutoRegister:         true,
+	TimeoutSeconds:       5,
+}
+
+func init() {
+	config.GlobalConfig.Register("ldap", &defaultLDAPSettings)
+}
+
+func GetLDAPSettings() *LDAPSettings {
+	return &defaultLDAPSettings
+}
@@ -0,0 +1,25 @@
+package system_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type SCIMSettings struct {
+	Enabled bool `json:"enabled"`
+	// Token 为 IdP 调用 /scim/v2 时使用的 Bearer Token
+	Token string `json:"token"`
+	// DefaultGroup 为通过 SCIM 创建且未加入任何组的用户的默认分组
+	DefaultGroup string `json:"default_group"`
+}
+
+var defaultSCIMSettings = SCIMSettings{
+	Enabled:      false,
+	Token:        "",
+	DefaultGroup: "default",
+}
+
+func init() {
+	config.GlobalConfig.Register("scim", &defaultSCIMSettings)
+}
+
+func GetSCIMSettings() *SCIMSettings {
+	return &defaultSCIMSettings
+}
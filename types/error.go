@@ -39,6 +39,10 @@ const (
 	ErrorCodeInvalidRequest         ErrorCode = "invalid_request"
 	ErrorCodeSensitiveWordsDetected ErrorCode = "sensitive_words_detected"
 
+	// model catalogue limits
+	ErrorCodeContextLengthExceeded      ErrorCode = "context_length_exceeded"
+	ErrorCodeModelCapabilityUnsupported ErrorCode = "model_capability_unsupported"
+
 	// new api error
 	ErrorCodeCountTokenFailed   ErrorCode = "count_token_failed"
 	ErrorCodeModelPriceError    ErrorCode = "model_price_error"
@@ -47,6 +51,8 @@ const (
 	ErrorCodeDoRequestFailed    ErrorCode = "do_request_failed"
 	ErrorCodeGetChannelFailed   ErrorCode = "get_channel_failed"
 	ErrorCodeGenRelayInfoFailed ErrorCode = "gen_relay_info_failed"
+	ErrorCodeChannelsSaturated  ErrorCode = "channels_saturated"
+	ErrorCodeConcurrencyLimited ErrorCode = "concurrency_limited"
 
 	// channel error
 	ErrorCodeChannelNoAvailableKey        ErrorCode = "channel:no_available_key"
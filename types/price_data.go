@@ -19,11 +19,61 @@ type PriceData struct {
 	AudioRatio           float64
 	AudioCompletionRatio float64
 	OtherRatios          map[string]float64
+	PriceTier            int // 分档计价命中的档位，从 1 开始，0 表示未分档
+	PriceVersion         int // 计价时生效的价格版本 ID
+	PriceOverride        *PriceOverrideInfo
+	PricingWindow        *PricingWindowInfo
+	BasePrice            BasePriceData // 价格覆盖与时段系数生效前的全局价格，用于估算上游成本
 	UsePrice             bool
 	QuotaToPreConsume    int // 预消耗额度
 	GroupRatioInfo       GroupRatioInfo
 }
 
+// BasePriceData 全局配置的价格或倍率，命中分档时为分档倍率
+type BasePriceData struct {
+	UsePrice           bool
+	ModelPrice         float64
+	ModelRatio         float64
+	CompletionRatio    float64
+	CacheRatio         float64
+	CacheCreationRatio float64
+}
+
+const (
+	PriceOverrideTypePrice = "price" // 固定按次价格（美元）
+	PriceOverrideTypeRatio = "ratio" // 在全局价格或倍率上乘以系数
+)
+
+// PriceOverrideInfo 计价时命中的用户或令牌价格覆盖，记录在日志中便于核对账单
+type PriceOverrideInfo struct {
+	Id           int     `json:"id"`
+	TokenId      int     `json:"token_id,omitempty"`
+	ModelPattern string  `json:"model_pattern"`
+	Type         string  `json:"type"`
+	Value        float64 `json:"value"`
+}
+
+// RatioMultiplier 倍率系数，非系数类型的覆盖返回 1
+func (o *PriceOverrideInfo) RatioMultiplier() float64 {
+	if o == nil || o.Type != PriceOverrideTypeRatio {
+		return 1
+	}
+	return o.Value
+}
+
+// PricingWindowInfo 计价时命中的时段价格系数
+type PricingWindowInfo struct {
+	Name       string  `json:"name"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+func (w *PricingWindowInfo) RatioMultiplier() float64 {
+	if w == nil {
+		return 1
+	}
+	return w.Multiplier
+}
+
 type PerCallPriceData struct {
 	ModelPrice     float64
 	Quota          int
@@ -31,5 +81,5 @@ type PerCallPriceData struct {
 }
 
 func (p PriceData) ToSetting() string {
-	return fmt.Sprintf("ModelPrice: %f, ModelRatio: %f, CompletionRatio: %f, CacheRatio: %f, GroupRatio: %f, UsePrice: %t, CacheCreationRatio: %f, QuotaToPreConsume: %d, ImageRatio: %f, AudioRatio: %f, AudioCompletionRatio: %f", p.ModelPrice, p.ModelRatio, p.CompletionRatio, p.CacheRatio, p.GroupRatioInfo.GroupRatio, p.UsePrice, p.CacheCreationRatio, p.QuotaToPreConsume, p.ImageRatio, p.AudioRatio, p.AudioCompletionRatio)
+	return fmt.Sprintf("ModelPrice: %f, ModelRatio: %f, CompletionRatio: %f, CacheRatio: %f, GroupRatio: %f, UsePrice: %t, CacheCreationRatio: %f, QuotaToPreConsume: %d, ImageRatio: %f, AudioRatio: %f, AudioCompletionRatio: %f, PriceTier: %d", p.ModelPrice, p.ModelRatio, p.CompletionRatio, p.CacheRatio, p.GroupRatioInfo.GroupRatio, p.UsePrice, p.CacheCreationRatio, p.QuotaToPreConsume, p.ImageRatio, p.AudioRatio, p.AudioCompletionRatio, p.PriceTier)
 }
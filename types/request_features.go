@@ -0,0 +1,66 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RequestFeatures 从请求中识别出的能力需求，选择渠道时只考虑满足这些需求的渠道
+type RequestFeatures struct {
+	ImageInput   bool `json:"image_input,omitempty"`
+	AudioInput   bool `json:"audio_input,omitempty"`
+	Tools        bool `json:"tools,omitempty"`
+	JsonSchema   bool `json:"json_schema,omitempty"`
+	PromptTokens int  `json:"prompt_tokens,omitempty"`
+}
+
+func (f RequestFeatures) IsEmpty() bool {
+	return !f.ImageInput && !f.AudioInput && !f.Tools && !f.JsonSchema && f.PromptTokens == 0
+}
+
+// Within f 需要的能力都包含在 other 中，能满足 other 的渠道一定也能满足 f
+func (f RequestFeatures) Within(other RequestFeatures) bool {
+	return (!f.ImageInput || other.ImageInput) && (!f.AudioInput || other.AudioInput) &&
+		(!f.Tools || other.Tools) && (!f.JsonSchema || other.JsonSchema) && f.PromptTokens <= other.PromptTokens
+}
+
+func (f RequestFeatures) String() string {
+	parts := make([]string, 0, 5)
+	if f.ImageInput {
+		parts = append(parts, "图片输入")
+	}
+	if f.AudioInput {
+		parts = append(parts, "音频输入")
+	}
+	if f.Tools {
+		parts = append(parts, "函数调用")
+	}
+	if f.JsonSchema {
+		parts = append(parts, "json_schema")
+	}
+	if f.PromptTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d tokens 输入", f.PromptTokens))
+	}
+	return strings.Join(parts, "、")
+}
+
+// GetRequestFeatures 根据 TokenCountMeta 识别请求需要的能力
+func GetRequestFeatures(meta *TokenCountMeta) RequestFeatures {
+	features := RequestFeatures{}
+	if meta == nil {
+		return features
+	}
+	for _, file := range meta.Files {
+		if file == nil {
+			continue
+		}
+		switch file.FileType {
+		case FileTypeImage:
+			features.ImageInput = true
+		case FileTypeAudio:
+			features.AudioInput = true
+		}
+	}
+	features.Tools = meta.ToolsCount > 0
+	return features
+}
@@ -134,6 +134,12 @@ function renderType(type, t) {
           {t('错误')}
         </Tag>
       );
+    case 7:
+      return (
+        <Tag color='blue' shape='circle'>
+          {t('订阅')}
+        </Tag>
+      );
     default:
       return (
         <Tag color='grey' shape='circle'>
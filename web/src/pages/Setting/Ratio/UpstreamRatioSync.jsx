@@ -53,6 +53,10 @@ import {
 } from '@douyinfe/semi-illustrations';
 import ChannelSelectorModal from '../../../components/settings/ChannelSelectorModal';
 
+// 分档倍率的值为分档列表，以 JSON 展示
+const formatRatioValue = (value) =>
+  typeof value === 'object' ? JSON.stringify(value) : value;
+
 function ConflictConfirmModal({ t, visible, items, onOk, onCancel }) {
   const isMobile = useIsMobile();
   const columns = [
@@ -272,6 +276,7 @@ export default function UpstreamRatioSync(props) {
       CompletionRatio: JSON.parse(props.options.CompletionRatio || '{}'),
       CacheRatio: JSON.parse(props.options.CacheRatio || '{}'),
       ModelPrice: JSON.parse(props.options.ModelPrice || '{}'),
+      TieredRatio: JSON.parse(props.options.TieredRatio || '{}'),
     };
 
     const conflicts = [];
@@ -281,7 +286,8 @@ export default function UpstreamRatioSync(props) {
       if (
         currentRatios.ModelRatio[model] !== undefined ||
         currentRatios.CompletionRatio[model] !== undefined ||
-        currentRatios.CacheRatio[model] !== undefined
+        currentRatios.CacheRatio[model] !== undefined ||
+        currentRatios.TieredRatio[model] !== undefined
       )
         return 'ratio';
       return null;
@@ -345,7 +351,10 @@ export default function UpstreamRatioSync(props) {
         CompletionRatio: { ...currentRatios.CompletionRatio },
         CacheRatio: { ...currentRatios.CacheRatio },
         ModelPrice: { ...currentRatios.ModelPrice },
+        TieredRatio: { ...currentRatios.TieredRatio },
       };
+      // 分档倍率只在选择了上游分档时保存，避免覆盖本地配置
+      let tieredRatioChanged = false;
 
       Object.entries(resolutions).forEach(([model, ratios]) => {
         const selectedTypes = Object.keys(ratios);
@@ -356,6 +365,10 @@ export default function UpstreamRatioSync(props) {
           delete finalRatios.ModelRatio[model];
           delete finalRatios.CompletionRatio[model];
           delete finalRatios.CacheRatio[model];
+          if (finalRatios.TieredRatio[model] !== undefined) {
+            delete finalRatios.TieredRatio[model];
+            tieredRatioChanged = true;
+          }
         }
         if (hasRatio) {
           delete finalRatios.ModelPrice[model];
@@ -366,9 +379,18 @@ export default function UpstreamRatioSync(props) {
             .split('_')
             .map((word) => word.charAt(0).toUpperCase() + word.slice(1))
             .join('');
-          finalRatios[optionKey][model] = parseFloat(value);
+          if (ratioType === 'tiered_ratio') {
+            // 分档倍率为分档列表，原样保存
+            finalRatios[optionKey][model] = value;
+            tieredRatioChanged = true;
+          } else {
+            finalRatios[optionKey][model] = parseFloat(value);
+          }
         });
       });
+      if (!tieredRatioChanged) {
+        delete finalRatios.TieredRatio;
+      }
 
       setLoading(true);
       try {
@@ -479,6 +501,7 @@ export default function UpstreamRatioSync(props) {
               </Select.Option>
               <Select.Option value='cache_ratio'>{t('缓存倍率')}</Select.Option>
               <Select.Option value='model_price'>{t('固定价格')}</Select.Option>
+              <Select.Option value='tiered_ratio'>{t('分档倍率')}</Select.Option>
             </Select>
           </div>
         </div>
@@ -496,6 +519,7 @@ export default function UpstreamRatioSync(props) {
           'model_ratio',
           'completion_ratio',
           'cache_ratio',
+          'tiered_ratio',
         ].some((rt) => rt in ratioTypes);
         const billingConflict = hasPrice && hasOtherRatio;
 
@@ -576,6 +600,7 @@ export default function UpstreamRatioSync(props) {
             completion_ratio: t('补全倍率'),
             cache_ratio: t('缓存倍率'),
             model_price: t('固定价格'),
+            tiered_ratio: t('分档倍率'),
           };
           const baseTag = (
             <Tag color={stringToColor(text)} shape='circle'>
@@ -652,7 +677,9 @@ export default function UpstreamRatioSync(props) {
             color={text !== null && text !== undefined ? 'blue' : 'default'}
             shape='circle'
           >
-            {text !== null && text !== undefined ? text : t('未设置')}
+            {text !== null && text !== undefined
+              ? formatRatioValue(text)
+              : t('未设置')}
           </Tag>
         ),
       },
@@ -774,7 +801,7 @@ export default function UpstreamRatioSync(props) {
                     }
                   }}
                 >
-                  {upstreamVal}
+                  {formatRatioValue(upstreamVal)}
                 </Checkbox>
                 {!isConfident && (
                   <Tooltip
@@ -859,6 +886,7 @@ export default function UpstreamRatioSync(props) {
             CompletionRatio: JSON.parse(props.options.CompletionRatio || '{}'),
             CacheRatio: JSON.parse(props.options.CacheRatio || '{}'),
             ModelPrice: JSON.parse(props.options.ModelPrice || '{}'),
+            TieredRatio: JSON.parse(props.options.TieredRatio || '{}'),
           };
           await performSync(curRatios);
         }}